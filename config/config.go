@@ -0,0 +1,234 @@
+// Package config handles loading onedriver's optional configuration file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options holds user-configurable settings. All of the settings here are safe to
+// change while the filesystem is mounted - a running onedriver will reload
+// them when it receives a SIGHUP.
+type Options struct {
+	LogLevel        string   `json:"log,omitempty"`
+	PollInterval    int      `json:"pollInterval,omitempty"`    // seconds between delta polls
+	UploadLimit     uint64   `json:"uploadLimit,omitempty"`     // bytes/s, 0 means unlimited
+	DownloadLimit   uint64   `json:"downloadLimit,omitempty"`   // bytes/s, 0 means unlimited
+	Ignore          []string `json:"ignore,omitempty"`          // patterns of extra files that never exist
+	OfficeLinks     bool     `json:"officeLinks,omitempty"`     // add links to Office Online
+	ForceRmdir      bool     `json:"forceRmdir,omitempty"`      // rmdir deletes folders that aren't empty
+	HideSystemFiles bool     `json:"hideSystemFiles,omitempty"` // leave Windows' leftover files out of listings
+	BackupMode      bool     `json:"backupMode,omitempty"`      // drop content once it is uploaded
+
+	// gitignore-style patterns of paths to keep local only, like .onedriverignore
+	IgnorePatterns []string `json:"ignorePatterns,omitempty"`
+	// folders on the server to hide, nothing inside them is ever fetched
+	Exclude []string `json:"exclude,omitempty"`
+	// if set, only these folders on the server are shown
+	Include []string `json:"include,omitempty"`
+	// seconds to hold deletions back from the server, so they can be undone
+	DeleteDelay int `json:"deleteDelay,omitempty"`
+	// seconds a file has to go unchanged before it is uploaded
+	UploadDelay int `json:"uploadDelay,omitempty"`
+	// seconds unmounting waits for changes to reach the server, 30 by default
+	DrainTimeout int `json:"drainTimeout,omitempty"`
+	// seconds the drive's quota is cached for, 60 by default
+	QuotaInterval int `json:"quotaInterval,omitempty"`
+	// megabytes of memory onedriver may use before dropping file contents from
+	// memory, 0 for no limit
+	MemoryLimit uint64 `json:"memoryLimit,omitempty"`
+	// keep-local, keep-remote or keep-both, for files changed locally and on the
+	// server
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+	// name of the copies made by keep-both, see the README for placeholders
+	ConflictName string `json:"conflictName,omitempty"`
+	// log levels of the fuse, graph, cache and upload modules, overriding the
+	// global one
+	LogModules map[string]string `json:"logModules,omitempty"`
+}
+
+// Profile is a named filesystem that can be mounted with "onedriver mount
+// <name>". Options set in a profile override the top-level ones.
+type Profile struct {
+	Mountpoint string `json:"mountpoint"`
+	Account    string `json:"account,omitempty"`  // profiles with different accounts log in separately
+	Root       string `json:"root,omitempty"`     // folder on the server to mount, "/" by default
+	Drive      string `json:"drive,omitempty"`    // ID of a drive other than the account's OneDrive
+	CacheDir   string `json:"cacheDir,omitempty"` // defaults to one unique to the mountpoint
+	Options
+}
+
+// Config is the contents of the config file: the default options, plus any
+// number of profiles.
+type Config struct {
+	Options
+	// logging applies to everything onedriver mounts, so these can't be set per
+	// profile and only take effect on startup
+	LogFile     string `json:"logFile,omitempty"`     // log here instead of to stderr
+	LogMaxSize  int    `json:"logMaxSize,omitempty"`  // megabytes before the log is rotated
+	LogMaxFiles int    `json:"logMaxFiles,omitempty"` // rotated logs kept around
+	LogBackend  string `json:"logBackend,omitempty"`  // stderr, journald or syslog
+	LogPrivacy  bool   `json:"logPrivacy,omitempty"`  // hash file names and paths
+
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+}
+
+// Default returns the configuration used when no config file is present.
+func Default() *Config {
+	return &Config{Options: Options{PollInterval: 30}, LogMaxSize: 100, LogMaxFiles: 3}
+}
+
+// configDir is where onedriver keeps its config, following the XDG base
+// directory spec
+func configDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "onedriver")
+}
+
+// DefaultPath returns the location onedriver looks for its config file in by
+// default.
+func DefaultPath() string {
+	return filepath.Join(configDir(), "config.json")
+}
+
+// AccountAuthPath returns where the auth tokens of a named account are stored.
+func AccountAuthPath(account string) string {
+	return filepath.Join(configDir(), "accounts", account+".json")
+}
+
+// expandHome replaces a leading "~/" in a path with the user's home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// Load reads a config file. A missing config file is not an error, the default
+// configuration is returned instead. Settings omitted from the file keep their
+// default values.
+func Load(path string) (*Config, error) {
+	conf := Default()
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return conf, nil
+		}
+		return conf, err
+	}
+	if err = json.Unmarshal(contents, conf); err != nil {
+		return Default(), err
+	}
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = Default().PollInterval
+	}
+	if conf.LogMaxSize <= 0 {
+		conf.LogMaxSize = Default().LogMaxSize
+	}
+	conf.LogFile = expandHome(conf.LogFile)
+	for name, profile := range conf.Profiles {
+		if profile == nil || profile.Mountpoint == "" {
+			return Default(), fmt.Errorf("profile %q has no mountpoint", name)
+		}
+		profile.Mountpoint = expandHome(profile.Mountpoint)
+		profile.CacheDir = expandHome(profile.CacheDir)
+	}
+	return conf, nil
+}
+
+// Profile returns the named profile. Options the profile does not set itself are
+// filled in from the top-level config.
+func (c *Config) Profile(name string) (*Profile, error) {
+	profile, exists := c.Profiles[name]
+	if !exists {
+		return nil, fmt.Errorf("no profile named %q in config", name)
+	}
+	merged := *profile
+	merged.Options = c.Options
+	if profile.LogLevel != "" {
+		merged.LogLevel = profile.LogLevel
+	}
+	if profile.PollInterval > 0 {
+		merged.PollInterval = profile.PollInterval
+	}
+	if profile.UploadLimit > 0 {
+		merged.UploadLimit = profile.UploadLimit
+	}
+	if profile.DownloadLimit > 0 {
+		merged.DownloadLimit = profile.DownloadLimit
+	}
+	if profile.Ignore != nil {
+		merged.Ignore = profile.Ignore
+	}
+	if profile.IgnorePatterns != nil {
+		merged.IgnorePatterns = profile.IgnorePatterns
+	}
+	if profile.Exclude != nil {
+		merged.Exclude = profile.Exclude
+	}
+	if profile.Include != nil {
+		merged.Include = profile.Include
+	}
+	if profile.DeleteDelay > 0 {
+		merged.DeleteDelay = profile.DeleteDelay
+	}
+	if profile.UploadDelay > 0 {
+		merged.UploadDelay = profile.UploadDelay
+	}
+	if profile.DrainTimeout > 0 {
+		merged.DrainTimeout = profile.DrainTimeout
+	}
+	if profile.QuotaInterval > 0 {
+		merged.QuotaInterval = profile.QuotaInterval
+	}
+	if profile.MemoryLimit > 0 {
+		merged.MemoryLimit = profile.MemoryLimit
+	}
+	if profile.ConflictPolicy != "" {
+		merged.ConflictPolicy = profile.ConflictPolicy
+	}
+	if profile.ConflictName != "" {
+		merged.ConflictName = profile.ConflictName
+	}
+	if profile.LogModules != nil {
+		merged.LogModules = profile.LogModules
+	}
+	if profile.OfficeLinks {
+		merged.OfficeLinks = true
+	}
+	if profile.ForceRmdir {
+		merged.ForceRmdir = true
+	}
+	if profile.HideSystemFiles {
+		merged.HideSystemFiles = true
+	}
+	if profile.BackupMode {
+		merged.BackupMode = true
+	}
+	if merged.Root == "" {
+		merged.Root = "/"
+	}
+	return &merged, nil
+}
+
+// OptionsFor returns the options that apply to a profile, or the top-level
+// options if profile is "".
+func (c *Config) OptionsFor(profile string) (*Options, error) {
+	if profile == "" {
+		return &c.Options, nil
+	}
+	p, err := c.Profile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return &p.Options, nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// a missing config file should just give us the defaults
+func TestLoadMissing(t *testing.T) {
+	conf, err := Load("/this/path/does/not/exist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.PollInterval != Default().PollInterval {
+		t.Fatalf("Expected default poll interval, got %d\n", conf.PollInterval)
+	}
+}
+
+// settings present in the file override the defaults, everything else is left
+// alone
+func TestLoadPartial(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"log": "info", "uploadLimit": 1024, "ignore": ["/desktop.ini"]}`), 0644)
+
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.LogLevel != "info" || conf.UploadLimit != 1024 {
+		t.Fatalf("Config was not parsed correctly: %+v\n", conf)
+	}
+	if len(conf.Ignore) != 1 || conf.Ignore[0] != "/desktop.ini" {
+		t.Fatalf("Ignore list was not parsed correctly: %v\n", conf.Ignore)
+	}
+	if conf.PollInterval != Default().PollInterval {
+		t.Fatal("Poll interval should have kept its default value.")
+	}
+	if conf.LogMaxSize != Default().LogMaxSize || conf.LogMaxFiles != Default().LogMaxFiles {
+		t.Fatal("Log rotation settings should have kept their default values.")
+	}
+}
+
+// a malformed config should be reported as an error
+func TestLoadInvalid(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"log": `), 0644)
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("Invalid config did not return an error.")
+	}
+}
+
+// profiles inherit any options they don't set from the top level
+func TestProfile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{
+		"uploadLimit": 1024,
+		"pollInterval": 60,
+		"profiles": {
+			"work": {"mountpoint": "~/Work", "account": "work", "root": "/Projects", "pollInterval": 10}
+		}
+	}`), 0644)
+
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, err := conf.Profile("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.PollInterval != 10 || work.UploadLimit != 1024 {
+		t.Fatalf("Profile options were not merged correctly: %+v\n", work.Options)
+	}
+	if work.Root != "/Projects" || work.Account != "work" {
+		t.Fatalf("Profile was not parsed correctly: %+v\n", work)
+	}
+	if work.Mountpoint == "~/Work" || !filepath.IsAbs(work.Mountpoint) {
+		t.Fatalf("~ was not expanded in mountpoint: %s\n", work.Mountpoint)
+	}
+	if _, err = conf.Profile("personal"); err == nil {
+		t.Fatal("A missing profile did not return an error.")
+	}
+}
+
+// every profile needs to be mounted somewhere
+func TestProfileNoMountpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"profiles": {"work": {"account": "work"}}}`), 0644)
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("A profile without a mountpoint did not return an error.")
+	}
+}
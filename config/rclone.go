@@ -0,0 +1,133 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RcloneRemote is the part of an rclone remote's configuration onedriver can use
+type RcloneRemote struct {
+	Token        string // the remote's OAuth2 token, as JSON
+	DriveID      string
+	DriveType    string // personal, business or documentLibrary
+	ClientID     string // only set if the remote uses its own app registration
+	ClientSecret string
+}
+
+// DefaultRclonePath returns where rclone keeps its config file, honoring
+// $RCLONE_CONFIG like rclone itself does.
+func DefaultRclonePath() string {
+	if path := os.Getenv("RCLONE_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "rclone", "rclone.conf")
+}
+
+// LoadRclone reads the configuration of a OneDrive remote from an rclone config
+// file. Encrypted config files are not supported.
+func LoadRclone(path string, remote string) (*RcloneRemote, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	var section string
+	var found bool
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "RCLONE_ENCRYPT_") {
+			return nil, errors.New("rclone config is encrypted, " +
+				"decrypt it with \"rclone config encryption remove\" first")
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = line[1 : len(line)-1]
+			found = found || section == remote
+			continue
+		}
+		if section != remote {
+			continue
+		}
+		if eq := strings.Index(line, "="); eq > 0 {
+			values[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no remote named %q in %s", remote, path)
+	}
+	if values["type"] != "onedrive" {
+		return nil, fmt.Errorf("remote %q is not a OneDrive remote (type %q)",
+			remote, values["type"])
+	}
+	if values["token"] == "" {
+		return nil, fmt.Errorf("remote %q has no token, finish setting it up "+
+			"with \"rclone config\" first", remote)
+	}
+	return &RcloneRemote{
+		Token:        values["token"],
+		DriveID:      values["drive_id"],
+		DriveType:    values["drive_type"],
+		ClientID:     values["client_id"],
+		ClientSecret: values["client_secret"],
+	}, nil
+}
+
+// AddProfile adds a profile to a config file, creating the file if it does not
+// exist yet. It is an error for the profile to exist already. Everything else in
+// the file is kept as it is.
+func AddProfile(path string, name string, profile Profile) error {
+	contents := make(map[string]json.RawMessage)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(data, &contents); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	profiles := make(map[string]json.RawMessage)
+	if raw, exists := contents["profiles"]; exists {
+		if err = json.Unmarshal(raw, &profiles); err != nil {
+			return err
+		}
+	}
+	if _, exists := profiles[name]; exists {
+		return fmt.Errorf("profile %q already exists in %s", name, path)
+	}
+	if profiles[name], err = json.Marshal(profile); err != nil {
+		return err
+	}
+	if contents["profiles"], err = json.Marshal(profiles); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const rcloneConf = `[box]
+type = box
+token = {"access_token":"nope"}
+
+[work]
+type = onedrive
+client_id = my-app
+token = {"access_token":"access","refresh_token":"refresh","expiry":"2020-03-01T10:22:33Z"}
+drive_id = b!abcdef
+drive_type = business
+`
+
+// only the requested remote's settings should be picked up
+func TestLoadRclone(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rclone.conf")
+	ioutil.WriteFile(path, []byte(rcloneConf), 0600)
+
+	remote, err := LoadRclone(path, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.DriveID != "b!abcdef" || remote.DriveType != "business" ||
+		remote.ClientID != "my-app" || remote.Token[0] != '{' {
+		t.Fatalf("Remote was not parsed correctly: %+v\n", remote)
+	}
+	if _, err = LoadRclone(path, "box"); err == nil {
+		t.Fatal("Remote that isn't a OneDrive remote was loaded.")
+	}
+	if _, err = LoadRclone(path, "missing"); err == nil {
+		t.Fatal("Missing remote was loaded.")
+	}
+}
+
+// adding a profile should keep everything else in the config
+func TestAddProfile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_config")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"log": "info", "profiles": {"home": {"mountpoint": "/home"}}}`), 0644)
+
+	err := AddProfile(path, "work", Profile{Mountpoint: "/work", Account: "work", Drive: "b!abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.LogLevel != "info" || conf.Profiles["home"] == nil {
+		t.Fatalf("Existing config was not kept: %+v\n", conf)
+	}
+	if work := conf.Profiles["work"]; work == nil || work.Drive != "b!abcdef" {
+		t.Fatalf("Profile was not added: %+v\n", work)
+	}
+	if AddProfile(path, "work", Profile{Mountpoint: "/elsewhere"}) == nil {
+		t.Fatal("Existing profile was overwritten.")
+	}
+}
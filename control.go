@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jstaf/onedriver/graph"
+)
+
+// a command run against a mounted filesystem through its control socket
+type command struct {
+	method   string
+	needPath bool
+	help     string
+}
+
+var controlCommands = map[string]command{
+	"status":  {"Control.Status", false, "Show the state of a mounted filesystem."},
+	"pause":   {"Control.Pause", false, "Put uploads and syncing on hold."},
+	"resume":  {"Control.Resume", false, "Resume uploads and syncing after a pause."},
+	"pin":     {"Control.Pin", true, "Download a file and always keep it cached."},
+	"refresh": {"Control.Refresh", true, "Refetch a file or directory from the server."},
+	"evict":   {"Control.Evict", true, "Drop the locally cached content under a path."},
+	"hydrate": {"Control.Hydrate", true,
+		"Download everything under a path so it can be used offline."},
+	"flush": {"Control.Flush", false,
+		"Upload changes under a path now instead of after the upload delay."},
+	"undo": {"Control.Undo", false,
+		"Restore items deleted under a path that haven't been deleted on the server yet."},
+	"restore-version": {"Control.RestoreVersion", true,
+		"Roll a file back to a previous version (see .versions for IDs)."},
+	"share": {"Control.Share", true,
+		"Create a sharing link for a file or folder and print its URL."},
+	"permissions": {"Control.Permissions", true,
+		"List who a file or folder is shared with."},
+	"unshare": {"Control.Unshare", true, "Remove a permission from a file or folder."},
+	"invite":  {"Control.Invite", true, "Share a file or folder with someone by email."},
+	"open":    {"Control.WebURL", true, "Open a file or folder on the OneDrive website."},
+	"errors": {"Control.Errors", false,
+		"List the most recent sync errors (same as status --errors)."},
+	"manifest": {"Control.Manifest", false,
+		"List cached items with their sizes, hashes and IDs as JSON or CSV."},
+}
+
+// extraArgs are the arguments some commands take after the path
+type extraArgs struct {
+	usage string
+	min   int // how many of them are required
+	parse func(extra []string, args *graph.ControlArgs) error
+}
+
+var controlExtraArgs = map[string]extraArgs{
+	"restore-version": {"<version>", 1, func(extra []string, args *graph.ControlArgs) error {
+		args.Version = extra[0]
+		return nil
+	}},
+	"share": {"[view|edit] [anonymous|organization]", 0, func(extra []string, args *graph.ControlArgs) error {
+		if len(extra) > 0 {
+			args.LinkType = extra[0]
+		}
+		if len(extra) > 1 {
+			args.LinkScope = extra[1]
+		}
+		return nil
+	}},
+	"unshare": {"<permission id>", 1, func(extra []string, args *graph.ControlArgs) error {
+		args.PermissionID = extra[0]
+		return nil
+	}},
+	"manifest": {"[json|csv]", 0, func(extra []string, args *graph.ControlArgs) error {
+		if len(extra) > 0 {
+			args.Format = extra[0]
+		}
+		return nil
+	}},
+	"invite": {"<email> [read|write]", 1, func(extra []string, args *graph.ControlArgs) error {
+		args.Email = extra[0]
+		if len(extra) > 1 {
+			args.Role = extra[1]
+		}
+		return nil
+	}},
+}
+
+// findMount walks up from a local path until it finds a directory with a running
+// onedriver filesystem mounted on it. Returns the mountpoint and the path
+// relative to the root of the filesystem.
+func findMount(localPath string) (string, string, error) {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", "", err
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(graph.ControlSocketPath(dir)); err == nil {
+			rel, _ := filepath.Rel(dir, abs)
+			return dir, filepath.Join("/", rel), nil
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	return "", "", errors.New(localPath + " is not inside a mounted onedriver filesystem")
+}
+
+// runControlCommand runs a command against the filesystem containing the path
+// given as its argument (or the current directory if not). Returns the exit code.
+func runControlCommand(name string, args []string) int {
+	cmd := controlCommands[name]
+	target := "."
+	extra := controlExtraArgs[name]
+	if len(args) > 0 {
+		target = args[0]
+	} else if cmd.needPath {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace("Usage: onedriver "+name+" <path> "+extra.usage))
+		return 1
+	}
+	controlArgs := graph.ControlArgs{}
+	if extra.parse != nil {
+		if len(args)-1 < extra.min {
+			fmt.Fprintf(os.Stderr, "Usage: onedriver %s <path> %s\n", name, extra.usage)
+			return 1
+		}
+		if err := extra.parse(args[1:], &controlArgs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	mountpoint, path, err := findMount(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var reply interface{}
+	switch name {
+	case "status":
+		reply = &graph.CacheStats{}
+	case "errors":
+		reply = &graph.ErrorsReply{}
+	case "permissions":
+		reply = &graph.PermissionsReply{}
+	default:
+		reply = &graph.ControlReply{}
+	}
+	controlArgs.Path = path
+	err = graph.ControlCall(graph.ControlSocketPath(mountpoint), cmd.method,
+		controlArgs, reply)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if r, ok := reply.(*graph.ControlReply); ok && name == "open" {
+		if err = exec.Command("xdg-open", r.Message).Start(); err != nil {
+			// no browser to open it in, the link can still be copied by hand
+			fmt.Fprintln(os.Stderr, "Could not open browser:", err)
+			fmt.Println(r.Message)
+		}
+	} else if ok {
+		fmt.Println(r.Message)
+	} else if r, ok := reply.(*graph.ErrorsReply); ok {
+		for _, entry := range r.Errors {
+			fmt.Printf("%s  %-8s %s: %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"),
+				entry.Op, filepath.Join(mountpoint, entry.Path), entry.Message)
+		}
+	} else {
+		out, _ := json.MarshalIndent(reply, "", "  ")
+		fmt.Println(string(out))
+	}
+	return 0
+}
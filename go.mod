@@ -1,6 +1,7 @@
 module github.com/jstaf/onedriver
 
 require (
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/hanwen/go-fuse v0.0.0-20190111173210-425e8d5301f6
 	github.com/petermattis/goid v0.0.0-20180202154549-b0b1615b78e5 // indirect
 	github.com/sasha-s/go-deadlock v0.2.0
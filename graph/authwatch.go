@@ -0,0 +1,31 @@
+package graph
+
+import (
+	"time"
+)
+
+// how often a filesystem checks whether its login broke or was fixed
+const authCheckInterval = 10 * time.Second
+
+// watchAuth keeps an eye on the filesystem's login. Once the tokens can't be
+// renewed anymore, the filesystem keeps serving what it has cached, but refuses
+// all changes with EROFS and tells its listeners that the user needs to log in
+// again, instead of failing every operation with EREMOTEIO.
+func (fs *FuseFs) watchAuth() {
+	broken := false
+	for range time.Tick(authCheckInterval) {
+		broken = fs.checkAuth(broken)
+	}
+}
+
+// checkAuth notifies listeners if the login broke or was fixed since the last
+// check, and returns whether it is broken now
+func (fs *FuseFs) checkAuth(wasBroken bool) bool {
+	broken := !fs.Auth.Recover()
+	if broken && !wasBroken {
+		fs.items.listeners.syncError("/", "login", errAuthBroken)
+	} else if !broken && wasBroken {
+		fs.items.listeners.statusChanged("/", StatusSynced)
+	}
+	return broken
+}
@@ -0,0 +1,55 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// a broken login should leave the filesystem readable but refuse changes, until
+// new tokens show up
+func TestAuthBroken(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	dir, _ := ioutil.TempDir("", "onedriver_auth")
+	defer os.RemoveAll(dir)
+	auth := mock.auth()
+	auth.path = filepath.Join(dir, "auth_tokens.json")
+	failOnErr(t, auth.ToFile(auth.path))
+	fs := NewFSAt(auth, "/")
+	if _, status := fs.GetAttr("/Documents", nil); status != fuse.OK {
+		t.Fatal("Could not stat folder:", status)
+	}
+
+	atomic.StoreInt32(&auth.broken, 1)
+	if _, err := Get(ResourcePath("/"), auth.ForDrive("b!other")); err != errAuthBroken {
+		t.Fatalf("Requests should fail without being sent, got %v\n", err)
+	}
+	if !fs.checkAuth(false) || !fs.items.Stats().ReadOnly {
+		t.Fatal("The filesystem should be read-only.")
+	}
+	if _, status := fs.GetAttr("/Documents", nil); status != fuse.OK {
+		t.Fatal("Cached items should still be there:", status)
+	}
+	if status := fs.Mkdir("/Documents/new", 0755, nil); status != fuse.EROFS {
+		t.Fatal("Changes should be refused with EROFS, got", status)
+	}
+
+	// the same tokens are still broken, new ones fix it
+	if auth.Recover() {
+		t.Fatal("The old tokens should not count as new.")
+	}
+	fresh := mock.auth()
+	fresh.RefreshToken = "new-refresh-token"
+	failOnErr(t, fresh.ToFile(auth.path))
+	if fs.checkAuth(true) || fs.items.Stats().ReadOnly {
+		t.Fatal("The filesystem should have recovered.")
+	}
+	if status := fs.Mkdir("/Documents/new", 0755, nil); status != fuse.OK {
+		t.Fatal("Could not make changes after recovering:", status)
+	}
+}
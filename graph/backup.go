@@ -0,0 +1,32 @@
+package graph
+
+import "sync/atomic"
+
+// In backup mode, the filesystem is a place to drop files into rather than one
+// to work in, like on machines with too little disk to keep copies of what they
+// back up. Content written locally is dropped from memory and the disk cache as
+// soon as it is on the server, and only downloaded again if it is opened. Files
+// stay listed, with all their metadata.
+
+// SetBackupMode turns dropping content once it has been uploaded on or off
+func (c *Cache) SetBackupMode(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&c.backup, value)
+}
+
+func (c *Cache) backupMode() bool {
+	return atomic.LoadInt32(&c.backup) == 1
+}
+
+// dropUploaded drops the content of a file that was just uploaded. Files that
+// are still open keep theirs until they are evicted some other way.
+func (c *Cache) dropUploaded(item *DriveItem) {
+	if !c.dropContent(item) {
+		return
+	}
+	_, id := item.contentTarget()
+	c.uncacheContent(id)
+}
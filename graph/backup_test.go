@@ -0,0 +1,58 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// in backup mode, content should be dropped once it's uploaded, and fetched
+// back if the file is opened again
+func TestBackupMode(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_backup")
+	defer os.RemoveAll(dir)
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+	fs.items.content = store
+	fs.items.SetBackupMode(true)
+	fs.GetAttr("/Documents", nil)
+
+	file, status := fs.Create("/Documents/backup.txt", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	file.Write([]byte("backed up"), 0)
+	file.Release()
+	item, err := fs.items.Get("/Documents/backup.txt", nil)
+	failOnErr(t, err)
+	for i := 0; item.hydrated(); i++ {
+		if i > 100 {
+			t.Fatal("Content was not dropped after the upload.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if isLocalID(item.ID()) {
+		t.Fatal("File was dropped without being uploaded.")
+	}
+	if store.has(item.ID()) {
+		t.Error("Uploaded content was kept in the disk cache.")
+	}
+
+	file, status = fs.Open("/Documents/backup.txt", syscall.O_RDONLY, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not open file:", status)
+	}
+	buf := make([]byte, 64)
+	result, _ := file.Read(buf, 0)
+	if content, _ := result.Bytes(buf); string(content) != "backed up" {
+		t.Fatalf("Got \"%s\" after opening the file again.\n", content)
+	}
+	file.Release()
+}
@@ -0,0 +1,237 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// Uploading lots of tiny files (like when extracting an archive) is mostly spent
+// waiting on one request per file, or two for new ones. Small files are instead
+// uploaded in batches, with one request covering up to batchSize of them. New
+// files are created along with their content.
+
+// batchFileSize is the largest file uploaded as part of a batch. Content is
+// sent base64 encoded, and a whole batch has to stay under the server's limit
+// for the size of a request.
+const batchFileSize = 128 * 1024
+
+// batchSize is the most requests the server takes in one batch
+const batchSize = 20
+
+// batchWindow is how long an upload waits for others to be sent along with it
+const batchWindow = 100 * time.Millisecond
+
+// batchedUpload is the content of one file, waiting to be sent in a batch
+type batchedUpload struct {
+	resource string
+	content  []byte
+	done     chan batchResult
+}
+
+// batchResult is the server's response to one of the requests in a batch
+type batchResult struct {
+	body []byte
+	err  error
+}
+
+// uploadBatcher collects small uploads, and sends them once no more came in for
+// batchWindow, or there are enough for a batch
+type uploadBatcher struct {
+	mutex *mu.Mutex
+	auth  *Auth
+	queue []*batchedUpload
+	timer *time.Timer
+}
+
+func newUploadBatcher() *uploadBatcher {
+	return &uploadBatcher{mutex: &mu.Mutex{}}
+}
+
+// upload puts content at resource as part of a batch, and waits for the server's
+// response
+func (b *uploadBatcher) upload(resource string, content []byte, auth *Auth) ([]byte, error) {
+	u := &batchedUpload{
+		// requests in a batch go to the server as they are, unlike those sent
+		// through net/http
+		resource: auth.driveResource(resource),
+		content:  content,
+		done:     make(chan batchResult, 1),
+	}
+	b.mutex.Lock()
+	if len(b.queue) > 0 && b.auth.tokens() != auth.tokens() {
+		// batches are sent on behalf of a single account
+		b.sendLocked()
+	}
+	b.auth = auth
+	b.queue = append(b.queue, u)
+	if len(b.queue) >= batchSize {
+		b.sendLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(batchWindow, b.send)
+	} else {
+		b.timer.Reset(batchWindow)
+	}
+	b.mutex.Unlock()
+
+	result := <-u.done
+	return result.body, result.err
+}
+
+func (b *uploadBatcher) send() {
+	b.mutex.Lock()
+	b.sendLocked()
+	b.mutex.Unlock()
+}
+
+// sendLocked sends the queued uploads off as a batch, the mutex must be held
+func (b *uploadBatcher) sendLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.queue) == 0 {
+		return
+	}
+	go sendBatch(b.queue, b.auth)
+	b.queue = nil
+}
+
+type batchRequest struct {
+	ID      string            `json:"id"`
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+type batchResponse struct {
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// batchPayload returns the body of a batch request for uploads. Requests are
+// identified by their index.
+func batchPayload(uploads []*batchedUpload) []byte {
+	requests := make([]batchRequest, 0, len(uploads))
+	for i, u := range uploads {
+		requests = append(requests, batchRequest{
+			ID:      strconv.Itoa(i),
+			Method:  "PUT",
+			URL:     u.resource,
+			Headers: map[string]string{"Content-Type": "text/plain"},
+			// anything but JSON has to be base64 encoded
+			Body: base64.StdEncoding.EncodeToString(u.content),
+		})
+	}
+	payload, _ := json.Marshal(struct {
+		Requests []batchRequest `json:"requests"`
+	}{requests})
+	return payload
+}
+
+// batchResults splits the server's response to a batch of count requests into
+// the response to each of them
+func batchResults(body []byte, count int) []batchResult {
+	results := make([]batchResult, count)
+	for i := range results {
+		results[i].err = errors.New("no response to request in batch")
+	}
+	var batch struct {
+		Responses []batchResponse `json:"responses"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		for i := range results {
+			results[i].err = err
+		}
+		return results
+	}
+	for _, response := range batch.Responses {
+		i, err := strconv.Atoi(response.ID)
+		if err != nil || i < 0 || i >= count {
+			continue
+		}
+		if response.Status >= 400 {
+			var graphErr graphError
+			json.Unmarshal(response.Body, &graphErr)
+			results[i] = batchResult{err: errors.New(graphErr.Error.Code + ": " +
+				graphErr.Error.Message)}
+			continue
+		}
+		results[i] = batchResult{body: response.Body}
+	}
+	return results
+}
+
+// sendBatch uploads a batch of files and hands each upload its response
+func sendBatch(uploads []*batchedUpload, auth *Auth) {
+	log.WithFields(log.Fields{
+		"files": len(uploads),
+	}).Info("Uploading batch of small files.")
+	body, err := Post("/$batch", auth, bytes.NewReader(batchPayload(uploads)))
+	var results []batchResult
+	if err != nil {
+		results = make([]batchResult, len(uploads))
+		for i := range results {
+			results[i].err = err
+		}
+	} else {
+		results = batchResults(body, len(uploads))
+	}
+	for i, u := range uploads {
+		u.done <- results[i]
+	}
+}
+
+// uploadBatched uploads a small file as part of a batch. New files are created on
+// the server along with their content, without a request of their own for an ID.
+func (d *DriveItem) uploadBatched(batch *uploadBatcher, auth *Auth) error {
+	d.mutex.RLock()
+	id := d.IDInternal
+	name := d.NameInternal
+	var content []byte
+	if d.data != nil {
+		content = make([]byte, len(*d.data))
+		d.copyContentLocked(content)
+	}
+	d.mutex.RUnlock()
+
+	resource := "/me/drive/items/" + id + "/content"
+	if isLocalID(id) {
+		resource = fmt.Sprintf("/me/drive/items/%s:/%s:/content", d.parentTarget(),
+			url.PathEscape(name))
+	}
+	log.WithFields(log.Fields{
+		"path": d.Path(),
+		"size": len(content),
+	}).Trace("Using batched upload strategy (small file).")
+	body, err := batch.upload(resource, content, auth)
+	if err != nil && isLocalID(id) && strings.Contains(err.Error(), "nameAlreadyExists") {
+		// remoteID() sorts out who created it
+		return d.uploadSimple(auth)
+	}
+	if err == nil && isLocalID(id) {
+		d.mutex.RLock()
+		cache := d.cache
+		d.mutex.RUnlock()
+		_, err = cache.adopt(d, id, body)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err != nil {
+		d.hasChanges = true
+		return err
+	}
+	return d.unmarshalLocked(body)
+}
@@ -0,0 +1,44 @@
+package graph
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// content must make it into the batch encoded, responses must get back to the
+// request they belong to
+func TestBatchPayload(t *testing.T) {
+	uploads := []*batchedUpload{
+		{resource: "/me/drive/items/a/content", content: []byte("first")},
+		{resource: "/me/drive/items/b/content", content: []byte("second")},
+	}
+	var batch struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	failOnErr(t, json.Unmarshal(batchPayload(uploads), &batch))
+	if len(batch.Requests) != 2 {
+		t.Fatalf("Got %d requests in batch, wanted 2.\n", len(batch.Requests))
+	}
+	content, err := base64.StdEncoding.DecodeString(batch.Requests[1].Body)
+	failOnErr(t, err)
+	if batch.Requests[1].ID != "1" || batch.Requests[1].URL != uploads[1].resource ||
+		string(content) != "second" {
+		t.Fatalf("Request did not match upload: %+v\n", batch.Requests[1])
+	}
+
+	response := []byte(`{"responses": [
+		{"id": "1", "status": 409, "body": {"error": {"code": "nameAlreadyExists", "message": "taken"}}},
+		{"id": "0", "status": 201, "body": {"id": "item-a", "name": "a"}}
+	]}`)
+	results := batchResults(response, 3)
+	if results[0].err != nil || string(results[0].body) != `{"id": "item-a", "name": "a"}` {
+		t.Fatalf("Got wrong result for first request: %s %v\n", results[0].body, results[0].err)
+	}
+	if results[1].err == nil || results[1].err.Error() != "nameAlreadyExists: taken" {
+		t.Fatalf("Got wrong error for second request: %v\n", results[1].err)
+	}
+	if results[2].err == nil {
+		t.Fatal("Request without response did not fail.")
+	}
+}
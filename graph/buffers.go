@@ -0,0 +1,68 @@
+package graph
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Transfers copy file contents around a lot: snapshots for uploads, copies for
+// the disk cache, response bodies read from the network. Reusing the buffers
+// that are only needed for a moment keeps them from piling up for the garbage
+// collector under heavy I/O.
+
+// pooledBufferSize is the size of pooled buffers, enough for the largest upload
+// done in a single request
+const pooledBufferSize = 4 * 1024 * 1024
+
+var bufferPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, pooledBufferSize)
+	return &buf
+}}
+
+// readerPool holds the buffers bodies of unknown length are read into
+var readerPool = sync.Pool{New: func() interface{} {
+	return &bytes.Buffer{}
+}}
+
+// getBuffer returns a buffer of length size. Hand it back with putBuffer() once
+// nothing refers to it anymore.
+func getBuffer(size int) *[]byte {
+	if size > pooledBufferSize {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := bufferPool.Get().(*[]byte)
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// putBuffer returns a buffer from getBuffer() to the pool. Oversized ones are
+// left to the garbage collector instead of pinning memory.
+func putBuffer(buf *[]byte) {
+	if cap(*buf) != pooledBufferSize {
+		return
+	}
+	*buf = (*buf)[:0]
+	bufferPool.Put(buf)
+}
+
+// readBody reads a response body of the given length (-1 if unknown) in full.
+// Unlike ioutil.ReadAll(), the result is allocated once at its final size,
+// rather than grown (and copied) over and over while reading.
+func readBody(body io.Reader, length int64) ([]byte, error) {
+	if length >= 0 {
+		content := make([]byte, length)
+		n, err := io.ReadFull(body, content)
+		return content[:n], err
+	}
+	buf := readerPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	_, err := buf.ReadFrom(body)
+	content := make([]byte, buf.Len())
+	copy(content, buf.Bytes())
+	if buf.Cap() <= pooledBufferSize {
+		readerPool.Put(buf)
+	}
+	return content, err
+}
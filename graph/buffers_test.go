@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pooled buffers come back at the requested size, oversized ones stay out of
+// the pool
+func TestBufferPool(t *testing.T) {
+	buf := getBuffer(1024)
+	if len(*buf) != 1024 || cap(*buf) != pooledBufferSize {
+		t.Fatalf("Got a buffer of length %d and capacity %d.", len(*buf), cap(*buf))
+	}
+	putBuffer(buf)
+	if len(*buf) != 0 {
+		t.Error("Buffer was not emptied when returned to the pool.")
+	}
+
+	big := getBuffer(pooledBufferSize + 1)
+	if len(*big) != pooledBufferSize+1 {
+		t.Fatalf("Got a buffer of length %d.", len(*big))
+	}
+	putBuffer(big)
+	if len(*big) != pooledBufferSize+1 {
+		t.Error("Oversized buffer should not have been pooled.")
+	}
+}
+
+// bodies are read in full whether or not their length is known
+func TestReadBody(t *testing.T) {
+	content := bytes.Repeat([]byte("onedriver"), 1000)
+	for _, length := range []int64{int64(len(content)), -1} {
+		body, err := readBody(bytes.NewReader(content), length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, content) {
+			t.Errorf("Body of length %d did not match its content.", length)
+		}
+	}
+
+	// a body cut short is reported, along with what arrived
+	body, err := readBody(bytes.NewReader(content[:10]), int64(len(content)))
+	if err == nil || len(body) != 10 {
+		t.Errorf("Expected a short read, got %d bytes and error %v.", len(body), err)
+	}
+}
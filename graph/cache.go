@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mu "github.com/sasha-s/go-deadlock"
@@ -16,31 +17,90 @@ import (
 // that local changes can persist. Should be created using the NewCache()
 // constructor.
 type Cache struct {
-	metadata  sync.Map
-	root      string // the id of the filesystem's root item
-	auth      *Auth
-	deltaLink string
+	metadata     sync.Map
+	root         string // the id of the filesystem's root item
+	prefix       string // server path of the root item, "" if it's the drive's root
+	auth         *Auth
+	deltaLink    string
+	deltaFile    string // where deltaLink is checkpointed, "" if nowhere
+	pollInterval int64  // time.Duration between delta polls, accessed atomically
+	uploadDelay  int64  // time.Duration uploads wait after the last change, accessed atomically
+	paused       int32  // if 1, uploads and delta syncs are on hold
+	dryRun       int32  // if 1, changes are never sent to the server
+	backup       int32  // if 1, content is dropped once uploaded, see SetBackupMode()
+	listeners    *listeners
+	content      *contentStore // on-disk copies of file contents, nil if disabled
+	ignore       *ignoreRules
+	filter       *syncFilter
+	deleting     sync.Map     // IDs of items deleted locally but not yet on the server
+	user         atomic.Value // ID of the signed in user, see userID()
+	quota        *quotaCache
+	listed       *listedItems
+	watchdog     *memoryWatchdog
+	batch        *uploadBatcher
+	conflicts    *conflictPolicy
+	mkdirs       *mu.Mutex // held while creating items on the server ahead of their uploads
+	ids          *idMap
 }
 
 // NewCache creates a new Cache
 func NewCache(auth *Auth) *Cache {
-	cache := &Cache{
-		auth: auth,
-	}
+	return NewCacheAt(auth, "/")
+}
 
-	root, err := GetItem("/", auth)
+// NewCacheAt creates a new Cache with the folder at rootPath on the server as
+// its root
+func NewCacheAt(auth *Auth, rootPath string) *Cache {
+	cache, err := newCache(auth, rootPath)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"err": err,
+			"err":  err,
+			"root": rootPath,
 		}).Fatal("Could not fetch root item of filesystem!")
 	}
+	return cache
+}
+
+// newCache is NewCacheAt(), but returns an error instead of exiting if the root
+// can't be fetched
+func newCache(auth *Auth, rootPath string) (*Cache, error) {
+	rootPath = filepath.Clean("/" + rootPath)
+	root, err := GetItem(rootPath, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !root.IsDir() {
+		return nil, errors.New("root of filesystem must be a folder")
+	}
+	return newCacheWithRoot(auth, rootPath, root), nil
+}
+
+// newCacheWithRoot creates a new Cache with root, the item at rootPath on the
+// server, as its root
+func newCacheWithRoot(auth *Auth, rootPath string, root *DriveItem) *Cache {
+	cache := &Cache{
+		auth:         auth,
+		prefix:       strings.TrimSuffix(rootPath, "/"),
+		pollInterval: int64(30 * time.Second),
+		listeners:    newListeners(),
+		ignore:       newIgnoreRules(),
+		filter:       newSyncFilter(),
+		quota:        newQuotaCache(),
+		listed:       newListedItems(),
+		batch:        newUploadBatcher(),
+		conflicts:    newConflictPolicy(),
+		mkdirs:       &mu.Mutex{},
+		ids:          newIDMap(),
+	}
+	cache.watchdog = newMemoryWatchdog(cache)
+
 	root.cache = cache
 	cache.root = root.ID()
 	cache.InsertID(cache.root, root)
 
 	// using token=latest because we don't care about existing items - they'll
 	// be downloaded on-demand by the cache
-	cache.deltaLink = "/me/drive/root/delta?token=latest"
+	cache.deltaLink = latestDelta
 
 	// deltaloop is started manually
 	return cache
@@ -50,6 +110,12 @@ func NewCache(auth *Auth) *Cache {
 // nil if no item is found.
 func (c *Cache) GetID(id string) *DriveItem {
 	entry, exists := c.metadata.Load(id)
+	if !exists && isLocalID(id) {
+		// created on the server since, see idMap
+		if remoteID, ok := c.ids.resolve(id); ok {
+			entry, exists = c.metadata.Load(remoteID)
+		}
+	}
 	if !exists {
 		return nil
 	}
@@ -70,10 +136,11 @@ func (c *Cache) DeleteID(id string) {
 // only used for parsing
 type driveChildren struct {
 	Children []*DriveItem `json:"value"`
+	NextLink string       `json:"@odata.nextLink,omitempty"`
 }
 
-// GetChildrenID grabs all DriveItems that are the children of the given ID. If
-// items are not found, they are fetched.
+// GetChildrenID grabs all DriveItems that are the children of the given ID, by
+// their folded names (see foldName()). If items are not found, they are fetched.
 func (c *Cache) GetChildrenID(id string, auth *Auth) (map[string]*DriveItem, error) {
 	// fetch item and catch common errors
 	item := c.GetID(id)
@@ -96,53 +163,110 @@ func (c *Cache) GetChildrenID(id string, auth *Auth) (map[string]*DriveItem, err
 	// If item.children is not nil, it means we have the item's children
 	// already and can fetch them directly from the cache
 	if item.children != nil {
+		path := item.Path()
 		for _, id := range item.children {
 			child := c.GetID(id)
 			if child == nil {
 				// will be nil if deleted or never existed
 				continue
 			}
-			children[strings.ToLower(child.Name())] = child
+			if c.excluded(childPath(path, child.Name())) {
+				continue
+			}
+			children[foldName(child.Name())] = child
 		}
 		return children, nil
 	}
 
 	// check that we have a valid auth before proceeding
-	if auth == nil || auth.AccessToken == "" {
+	if auth == nil || auth.tokens().AccessToken == "" {
 		return nil, errors.New("Auth was nil/zero and children of \"" +
 			item.Path() +
 			"\" were not in cache. Could not fetch item as a result.")
 	}
 
 	// We haven't fetched the children for this item yet, get them from the
-	// server.
-	body, err := Get(ChildrenPathID(id), auth)
-	var fetched driveChildren
+	// server. Children of shortcuts come from the drive the shortcut points to.
+	drive, target := item.contentTarget()
+	fetched, err := getChildrenPages(target, item.ChildCount(), scopedAuth(auth, drive))
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(body, &fetched)
 
+	return c.storeChildren(item, fetched, drive, auth), nil
+}
+
+// isEmpty returns whether a folder has no children, fetching them if needed.
+// Children hidden by selective sync count too, since they would be deleted on
+// the server along with the folder.
+func (c *Cache) isEmpty(item *DriveItem, auth *Auth) (bool, error) {
+	if !item.IsDir() {
+		return true, nil
+	}
+	if _, err := c.GetChildrenID(item.ID(), auth); err != nil {
+		return false, err
+	}
+	item.mutex.RLock()
+	children := make([]string, len(item.children))
+	copy(children, item.children)
+	item.mutex.RUnlock()
+	for _, id := range children {
+		if c.GetID(id) != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// storeChildren caches the children of item fetched from drive, and returns
+// them by their folded names (minus those hidden by selective sync)
+func (c *Cache) storeChildren(item *DriveItem, fetched []*DriveItem, drive string, auth *Auth) map[string]*DriveItem {
+	path := item.Path()
+	remoteParent := c.remoteParent(item, drive)
+	readOnly := item.ReadOnly()
+	children := make(map[string]*DriveItem, len(fetched))
+	var shortcuts []*DriveItem
 	item.mutex.Lock()
-	item.children = make([]string, 0)
-	for _, child := range fetched.Children {
+	item.children = make([]string, 0, len(fetched))
+	item.childIndex = make(map[string]string, len(fetched))
+	for _, child := range fetched {
+		if _, deleting := c.deleting.Load(child.IDInternal); deleting {
+			continue
+		}
 		// initialize item and store in cache
 		child.mutex = &mu.RWMutex{}
+		child.cache = c
+		child.readOnly = readOnly
+		if drive != "" {
+			// the server's parent refers to the other drive
+			parent := remoteParent
+			child.drive, child.Parent = drive, &parent
+		}
+		if child.RemoteItem != nil {
+			shortcuts = append(shortcuts, child)
+		}
 		// we will always have an id after fetching from the server
 		c.metadata.Store(child.IDInternal, child)
 
-		// store in result map
-		children[strings.ToLower(child.Name())] = child
+		// store in result map, unless hidden by selective sync. Excluded items
+		// are still cached so they can show up again if the exclusion is lifted.
+		if !c.excluded(childPath(path, child.Name())) {
+			children[foldName(child.Name())] = child
+		}
 
 		// store id in parent item and increment parents subdirectory count
 		item.children = append(item.children, child.IDInternal)
+		item.indexChildLocked(child.NameInternal, child.IDInternal)
 		if child.IsDir() {
 			item.subdir++
 		}
 	}
 	item.mutex.Unlock()
 
-	return children, nil
+	for _, shortcut := range shortcuts {
+		c.checkAccess(shortcut, auth)
+	}
+	return children
 }
 
 // GetChildrenPath grabs all DriveItems that are the children of the resource at
@@ -166,19 +290,26 @@ func (c *Cache) Get(path string, auth *Auth) (*DriveItem, error) {
 
 	// from the root directory, traverse the chain of items till we reach our
 	// target ID.
-	path = strings.TrimSuffix(strings.ToLower(path), "/")
+	path = strings.TrimSuffix(path, "/")
 	split := strings.Split(path, "/")[1:] //omit leading "/"
 	var item *DriveItem
+	var prefetched map[int]chan prefetchedChildren
 	for i := 0; i < len(split); i++ {
+		if result, ok := prefetched[i]; ok {
+			c.usePrefetched(lastID, <-result, auth)
+		} else if prefetched == nil && i < len(split)-1 && !c.childrenCached(lastID) &&
+			auth != nil && auth.tokens().AccessToken != "" {
+			// the rest of the path isn't cached, fetch all of it at once
+			prefetched = c.prefetchPath(split, i, auth)
+		}
+
 		// fetches children
-		children, err := c.GetChildrenID(lastID, auth)
+		var err error // if we use ":=", item is shadowed
+		item, err = c.getChild(lastID, split[i], auth)
 		if err != nil {
 			return nil, err
 		}
-
-		var exists bool // if we use ":=", item is shadowed
-		item, exists = children[split[i]]
-		if !exists {
+		if item == nil {
 			// the item still doesn't exist after fetching from server. it
 			// doesn't exist
 			return nil, errors.New(strings.Join(split[:i+1], "/") +
@@ -191,54 +322,124 @@ func (c *Cache) Get(path string, auth *Auth) (*DriveItem, error) {
 
 // addToParent adds an object as a child of a parent
 func (c *Cache) setParent(item *DriveItem, parent *DriveItem) {
+	if parent == nil {
+		// items with a server ID still turn up once their parent's children
+		// are fetched
+		log.WithFields(log.Fields{
+			"id":   item.ID(),
+			"name": item.Name(),
+		}).Warn("Tried to give an item a parent that isn't cached.")
+		return
+	}
 	parent.mutex.Lock()
 	if item.IsDir() {
 		parent.subdir++
 	}
 	item.mutex.Lock()
 	parent.children = append(parent.children, item.IDInternal)
+	if parent.childIndex != nil {
+		parent.indexChildLocked(item.NameInternal, item.IDInternal)
+	}
 	item.Parent.ID = parent.IDInternal
+	parent.touchLocked()
 	parent.mutex.Unlock()
 	item.mutex.Unlock()
 }
 
-// removeParent removes a given item from its parent
+// removeParent removes a given item from its parent. A parent that isn't cached
+// (evicted, or not fetched yet when delta got to the item) has no children to
+// remove the item from.
 func (c *Cache) removeParent(item *DriveItem) {
-	if item != nil { // item can be nil in some scenarios
-		id := item.ID()
-		parent := c.GetID(item.Parent.ID)
-		parent.mutex.Lock()
-		for i, childID := range parent.children {
-			if childID == id {
-				parent.children = append(parent.children[:i], parent.children[i+1:]...)
-				break
-			}
-		}
-		if item.IsDir() {
-			parent.subdir--
+	if item == nil { // item can be nil in some scenarios
+		return
+	}
+	id, name := item.ID(), item.Name()
+	parent := c.parentOf(item)
+	if parent == nil {
+		log.WithFields(log.Fields{
+			"id":   id,
+			"name": name,
+		}).Debug("Parent of item is not cached, nothing to remove it from.")
+		return
+	}
+	parent.mutex.Lock()
+	parent.unindexChildLocked(name, id)
+	for i, childID := range parent.children {
+		if childID == id {
+			parent.children = append(parent.children[:i], parent.children[i+1:]...)
+			break
 		}
-		parent.mutex.Unlock()
 	}
+	if item.IsDir() && parent.subdir > 0 {
+		parent.subdir--
+	}
+	parent.touchLocked()
+	parent.mutex.Unlock()
 }
 
-// Delete an item from the cache by path
+// parentOf returns the cached parent of an item, looked up by its ID or, if it
+// isn't cached under that ID, by path. Returns nil if it isn't cached at all.
+func (c *Cache) parentOf(item *DriveItem) *DriveItem {
+	item.mutex.RLock()
+	if item.Parent == nil {
+		item.mutex.RUnlock()
+		return nil
+	}
+	parentID, parentPath := item.Parent.ID, item.Parent.Path
+	item.mutex.RUnlock()
+	if parent := c.GetID(parentID); parent != nil {
+		return parent
+	}
+	if parentPath == "" {
+		return nil
+	}
+	// empty auth, this is only a lookup
+	parent, _ := c.Get(filepath.Dir(item.Path()), &Auth{})
+	return parent
+}
+
+// Delete an item from the cache by path, along with anything cached inside it.
+// Deleting items that aren't cached does nothing.
 func (c *Cache) Delete(key string) {
-	key = strings.ToLower(key)
+	c.listed.clear()
 	// Uses empty auth, since we actually don't want to waste time fetching
 	// items that are only being fetched so they can be deleted.
 	item, err := c.Get(key, &Auth{})
-	if err != nil {
-		c.removeParent(item)
+	if err != nil || item == nil {
+		return
 	}
-	if item != nil {
-		c.metadata.Delete(item.ID())
+	c.removeParent(item)
+	c.purge(item)
+}
+
+// purge drops an item that was taken out of its parent from the cache, and its
+// content from the disk cache. Whatever is cached inside a folder goes with it,
+// and the folder forgets its children, so they are fetched again if it is ever
+// restored.
+func (c *Cache) purge(item *DriveItem) {
+	id := item.ID()
+	if item.IsDir() {
+		item.mutex.Lock()
+		childIDs := item.children
+		if childIDs != nil {
+			item.children = nil
+			item.childIndex = nil
+			item.subdir = 0
+		}
+		item.mutex.Unlock()
+		for _, childID := range childIDs {
+			if child := c.GetID(childID); child != nil {
+				c.purge(child)
+			}
+		}
 	}
+	c.metadata.Delete(id)
+	c.uncacheContent(id)
 }
 
 // Insert lets us manually insert an item to the cache (like if it was created
 // locally). Overwrites a cached item if present.
 func (c *Cache) Insert(key string, auth *Auth, item *DriveItem) error {
-	key = strings.ToLower(key)
 	parent, err := c.Get(filepath.Dir(key), auth)
 	if err != nil {
 		return err
@@ -250,9 +451,15 @@ func (c *Cache) Insert(key string, auth *Auth, item *DriveItem) error {
 		return errors.New("Parent of key was nil! Did we accidentally use an ID for the key?")
 	}
 
+	c.insertChild(parent, item)
+	return nil
+}
+
+// insertChild inserts an item into the cache as a child of parent, without
+// fetching anything
+func (c *Cache) insertChild(parent *DriveItem, item *DriveItem) {
 	c.setParent(item, parent)
 	c.metadata.Store(item.ID(), item)
-	return nil
 }
 
 // MoveID moves an item to a new ID name. Also responsible for handling the
@@ -267,53 +474,100 @@ func (c *Cache) MoveID(oldID string, newID string) error {
 			return errors.New("Could not get item: " + oldID)
 		}
 	}
+	// Cached under both IDs until it's done, so lookups by either ID or by path
+	// never miss it halfway through. Lookups by the old ID keep working after,
+	// for IDs the idMap knows about.
+	c.InsertID(newID, item)
 
-	// need to rename the child under the parent
-	parent := c.GetID(item.Parent.ID)
-	parent.mutex.Lock()
-	for i, child := range parent.children {
-		if child == oldID {
-			parent.children[i] = newID
-			break
+	// need to rename the child under the parent, unless it was taken out of it
+	if parent := c.GetID(item.Parent.ID); parent != nil {
+		parent.mutex.Lock()
+		for i, child := range parent.children {
+			if child == oldID {
+				parent.children[i] = newID
+				if parent.childIndex != nil {
+					parent.unindexChildLocked(item.Name(), oldID)
+					parent.indexChildLocked(item.Name(), newID)
+				}
+				break
+			}
 		}
+		parent.mutex.Unlock()
 	}
-	parent.mutex.Unlock()
 
 	item.mutex.Lock()
 	item.IDInternal = newID
+	children := make([]string, len(item.children))
+	copy(children, item.children)
 	item.mutex.Unlock()
+	for _, id := range children {
+		// folders get their server ID with things already in them
+		if child := c.GetID(id); child != nil {
+			child.mutex.Lock()
+			child.Parent.ID = newID
+			child.mutex.Unlock()
+		}
+	}
 
-	c.InsertID(newID, item)
 	c.DeleteID(oldID)
 	return nil
 }
 
-// Move an item to a new position
+// Move an item to a new position. Anything cached inside a moved folder comes
+// along as it is, only the paths change.
 func (c *Cache) Move(oldPath string, newPath string, auth *Auth) error {
 	item, err := c.Get(oldPath, auth)
 	if err != nil {
 		return err
 	}
+	newParent, err := c.Get(filepath.Dir(newPath), auth)
+	if err != nil {
+		return err
+	}
+	if existing, _ := c.Get(newPath, auth); existing != nil && existing != item {
+		// replaced, like on the server
+		c.removeParent(existing)
+		c.purge(existing)
+	}
 
-	// insert and THEN delete (to avoid corruption on failed insert)
+	// the item keeps its ID and cached contents, so nothing in a moved folder
+	// has to be fetched (or uploaded) again
+	c.listed.clear()
+	c.removeParent(item)
 	// item is being renamed, gotta rename the item's NameInternal field
 	if newBase := filepath.Base(newPath); filepath.Base(oldPath) != newBase {
 		item.SetName(newBase)
 	}
-	if err = c.Insert(newPath, auth, item); err != nil {
-		// insert failed, reinsert in old location
-		c.Insert(oldPath, auth, item)
-		return err
-	}
-	c.Delete(oldPath)
+	c.setParent(item, newParent)
+	c.rebase(item, newParent)
 	return nil
 }
 
+// rebase updates the path an item has of its parent, and does the same for
+// everything cached inside it
+func (c *Cache) rebase(item *DriveItem, parent *DriveItem) {
+	path := "/drive/root:" + strings.TrimSuffix(c.prefix+parent.Path(), "/")
+	item.mutex.Lock()
+	item.Parent.Path = path
+	children := make([]string, len(item.children))
+	copy(children, item.children)
+	item.mutex.Unlock()
+	for _, id := range children {
+		if child := c.GetID(id); child != nil {
+			c.rebase(child, item)
+		}
+	}
+}
+
 // deltaLoop should be called as a goroutine
 func (c *Cache) deltaLoop() {
 	log.Trace("Starting delta goroutine.")
 	for { // eva
 		// get deltas
+		if c.Paused() {
+			time.Sleep(c.PollInterval())
+			continue
+		}
 		log.Trace("Syncing deltas from server.")
 		for {
 			cont, err := c.pollDeltas(c.auth)
@@ -328,40 +582,366 @@ func (c *Cache) deltaLoop() {
 		log.Trace("Sync complete!")
 
 		// go to sleep until next poll interval
-		time.Sleep(30 * time.Second)
+		time.Sleep(c.PollInterval())
 	}
 }
 
-type deltaResponse struct {
-	NextLink  string      `json:"@odata.nextLink,omitempty"`
-	DeltaLink string      `json:"@odata.deltaLink,omitempty"`
-	Values    []DriveItem `json:"value,omitempty"`
+// PollInterval is how long the delta loop waits between checks for server-side
+// changes.
+func (c *Cache) PollInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.pollInterval))
+}
+
+// SetPollInterval changes the delta poll interval. Takes effect after the
+// current wait finishes.
+func (c *Cache) SetPollInterval(interval time.Duration) {
+	atomic.StoreInt64(&c.pollInterval, int64(interval))
+}
+
+// Paused returns whether uploads and delta syncs are currently on hold
+func (c *Cache) Paused() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// Pause puts uploads and server syncs on hold. Changes made while paused stay
+// local until Resume() is called.
+func (c *Cache) Pause() {
+	atomic.StoreInt32(&c.paused, 1)
+	log.Info("Uploads and syncs paused.")
+}
+
+// Resume undoes Pause() and starts uploading everything that changed in the
+// meantime.
+func (c *Cache) Resume() {
+	atomic.StoreInt32(&c.paused, 0)
+	log.Info("Uploads and syncs resumed.")
+	c.uploadChanges()
+}
+
+// uploadChanges starts uploading every item with changes that haven't been sent
+// to the server
+func (c *Cache) uploadChanges() {
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		item.mutex.Lock()
+		if item.writers == 0 && !c.DryRun() {
+			// items open for writing will get uploaded when they are closed
+			item.uploadLocked()
+		}
+		item.mutex.Unlock()
+		return true
+	})
+}
+
+// DryRun returns whether the cache is in dry-run mode
+func (c *Cache) DryRun() bool {
+	return atomic.LoadInt32(&c.dryRun) == 1
+}
+
+// SetDryRun turns dry-run mode on or off. In dry-run mode, all local changes are
+// accepted and kept in the cache, but nothing is ever sent to the server.
+// Requests that would have modified the server are logged instead.
+func (c *Cache) SetDryRun(dryRun bool) {
+	var val int32
+	if dryRun {
+		val = 1
+		log.Warn("Dry-run mode enabled, no changes will be sent to the server.")
+	}
+	atomic.StoreInt32(&c.dryRun, val)
+}
+
+// skipMutation checks if a request that would modify the server should be
+// skipped because of dry-run mode, and logs what would have been done if so.
+func (c *Cache) skipMutation(method string, path string, fields log.Fields) bool {
+	if !c.DryRun() {
+		return false
+	}
+	entry := log.WithFields(log.Fields{"method": method, "path": path})
+	if fields != nil {
+		entry = entry.WithFields(fields)
+	}
+	entry.Info("Dry run, skipping request.")
+	return true
+}
+
+// CacheStats is a summary of what is currently in the cache
+type CacheStats struct {
+	Items          int    `json:"items"`
+	Dirs           int    `json:"dirs"`
+	CachedFiles    int    `json:"cachedFiles"` // files with content held locally
+	CachedBytes    uint64 `json:"cachedBytes"`
+	OpenHandles    int    `json:"openHandles"`
+	PendingUploads int    `json:"pendingUploads"`
+	Paused         bool   `json:"paused"`
+	DryRun         bool   `json:"dryRun"`
+	ReadOnly       bool   `json:"readOnly"` // the login broke, see Auth.Broken()
+}
+
+// Stats summarizes the contents of the cache
+func (c *Cache) Stats() CacheStats {
+	stats := CacheStats{Paused: c.Paused(), DryRun: c.DryRun(), ReadOnly: c.auth.Broken()}
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		stats.Items++
+		if item.IsDir() {
+			stats.Dirs++
+		}
+		item.mutex.RLock()
+		if item.data != nil && len(*item.data) > 0 {
+			stats.CachedFiles++
+			stats.CachedBytes += uint64(len(*item.data))
+		}
+		stats.OpenHandles += item.openHandles
+		if item.changesPendingLocked() {
+			stats.PendingUploads++
+		}
+		item.mutex.RUnlock()
+		return true
+	})
+	return stats
+}
+
+// Refresh refetches an item's metadata from the server, discarding cached
+// content that has changed remotely. If the item is a directory that has already
+// had its children fetched, the children are refreshed too so that new or
+// deleted items show up, unless the folder's tags show nothing changed. Local-only
+// items and local changes are never thrown away.
+func (c *Cache) Refresh(path string, auth *Auth) error {
+	item, err := c.Get(path, auth)
+	if err != nil {
+		return err
+	}
+	id := item.ID()
+	if isLocalID(id) {
+		// nothing to refresh from, the server doesn't have this yet
+		return nil
+	}
+
+	body, err := Get("/me/drive/items/"+id, item.driveAuth(auth))
+	if err != nil {
+		return err
+	}
+	remote := &DriveItem{mutex: &mu.RWMutex{}}
+	if err = json.Unmarshal(body, remote); err != nil {
+		return err
+	}
+	if id == c.root {
+		// the root item's path is a special case that relies on it not having
+		// a parent
+		remote.Parent = item.Parent
+	}
+	item.mutex.RLock()
+	unchanged := listingUnchanged(item, remote)
+	item.mutex.RUnlock()
+	c.updateItem(item, remote, auth)
+	c.listed.clear()
+
+	item.mutex.RLock()
+	fetched := item.children != nil
+	item.mutex.RUnlock()
+	if !item.IsDir() || !fetched {
+		// unfetched children will be fetched fresh on next access anyways
+		return nil
+	}
+	if unchanged {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Trace("Folder is unchanged on the server, not refetching its children.")
+		return nil
+	}
+
+	drive, target := item.contentTarget()
+	children, err := getChildrenPages(target, item.ChildCount(), scopedAuth(auth, drive))
+	if err == nil {
+		c.refreshChildren(item, children, drive, auth)
+		return nil
+	}
+	// the folder's tags are no proof its children are up to date anymore
+	item.mutex.Lock()
+	item.ETag, item.CTag = "", ""
+	item.mutex.Unlock()
+	return err
+}
+
+// listingUnchanged returns true if the server's copy of a folder has the same
+// tags as cached, meaning none of its children were added, removed or changed.
+// Items without an eTag (cached before they were kept) always count as changed.
+func listingUnchanged(item *DriveItem, remote *DriveItem) bool {
+	if item.ETag == "" || item.ETag != remote.ETag {
+		return false
+	}
+	return item.CTag == "" || remote.CTag == "" || item.CTag == remote.CTag
+}
+
+// refreshChildren brings the cached children of item in line with a fresh
+// listing of them from the server
+func (c *Cache) refreshChildren(item *DriveItem, fetched []*DriveItem, drive string, auth *Auth) {
+	remoteParent := c.remoteParent(item, drive)
+	seen := make(map[string]bool)
+	for _, child := range fetched {
+		seen[child.IDInternal] = true
+		if _, deleting := c.deleting.Load(child.IDInternal); deleting {
+			continue
+		}
+		if existing := c.GetID(child.IDInternal); existing != nil {
+			child.mutex = &mu.RWMutex{}
+			c.updateItem(existing, child, auth)
+			continue
+		}
+		child.mutex = &mu.RWMutex{}
+		child.cache = c
+		child.readOnly = item.ReadOnly()
+		if drive != "" {
+			parent := remoteParent
+			child.drive, child.Parent = drive, &parent
+		}
+		c.InsertID(child.IDInternal, child)
+		c.setParent(child, item)
+	}
+
+	// remove anything that has since been deleted on the server
+	item.mutex.RLock()
+	childIDs := make([]string, len(item.children))
+	copy(childIDs, item.children)
+	item.mutex.RUnlock()
+	for _, childID := range childIDs {
+		if seen[childID] || isLocalID(childID) {
+			continue
+		}
+		child := c.GetID(childID)
+		if child == nil {
+			continue
+		}
+		child.mutex.RLock()
+		pending := child.changesPendingLocked()
+		child.mutex.RUnlock()
+		if pending {
+			// local changes are never thrown away
+			log.WithFields(log.Fields{
+				"path": child.Path(),
+			}).Warn("File with local changes is gone from the server, keeping it.")
+			continue
+		}
+		c.removeParent(child)
+		c.DeleteID(childID)
+	}
+}
+
+// Pin marks a file as pinned and fetches its content if needed. The content of
+// pinned items is never evicted.
+func (c *Cache) Pin(path string, auth *Auth) error {
+	item, err := c.Get(path, auth)
+	if err != nil {
+		return err
+	}
+	if item.IsDir() {
+		return errors.New("only files can be pinned")
+	}
+	item.mutex.Lock()
+	item.pinned = true
+	hydrated := item.data != nil
+	item.mutex.Unlock()
+	if hydrated {
+		return nil
+	}
+	return item.FetchContent(auth)
+}
+
+// Evict drops the locally cached content of a file, or of every cached file
+// underneath a directory, from memory and the disk cache. Metadata stays cached. Files that are open, pinned, or
+// have changes that have not been uploaded yet are skipped. Returns the number of
+// files evicted.
+func (c *Cache) Evict(path string, auth *Auth) (int, error) {
+	item, err := c.Get(path, auth)
+	if err != nil {
+		return 0, err
+	}
+	return c.evict(item), nil
+}
+
+func (c *Cache) evict(item *DriveItem) int {
+	if item.IsDir() {
+		item.mutex.RLock()
+		childIDs := make([]string, len(item.children))
+		copy(childIDs, item.children)
+		item.mutex.RUnlock()
+
+		evicted := 0
+		for _, id := range childIDs {
+			if child := c.GetID(id); child != nil {
+				evicted += c.evict(child)
+			}
+		}
+		return evicted
+	}
+
+	// files only in the disk cache are evicted from there
+	item.mutex.RLock()
+	evictable := item.evictableLocked()
+	item.mutex.RUnlock()
+	_, id := item.contentTarget()
+	onDisk := evictable && c.content != nil && c.content.has(id)
+	if !c.dropContent(item) && !onDisk {
+		return 0
+	}
+	c.uncacheContent(id)
+	item.notifyStatus()
+	return 1
+}
+
+// evictableLocked returns whether an item's content may be dropped: it isn't
+// open, pinned, or waiting to be uploaded
+func (d *DriveItem) evictableLocked() bool {
+	return !d.pinned && d.openHandles == 0 && !d.hasChanges &&
+		d.uploadSession == nil && !isLocalID(d.IDInternal)
+}
+
+// dropContent drops a file's content from memory, unless it is open, pinned, or
+// has changes that have not been uploaded yet. Returns whether it was dropped.
+func (c *Cache) dropContent(item *DriveItem) bool {
+	item.mutex.Lock()
+	defer item.mutex.Unlock()
+	if item.data == nil || !item.evictableLocked() {
+		return false
+	}
+	item.data = nil
+	item.diskID = ""
+	return true
 }
 
 // Polls the delta endpoint and return whether or not to continue polling
 func (c *Cache) pollDeltas(auth *Auth) (bool, error) {
-	resp, err := Get(c.deltaLink, auth)
+	body, err := getStream(c.deltaLink, auth)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"err": err,
 		}).Error("Could not fetch server deltas.")
 		return false, err
 	}
+	defer body.Close()
 
-	page := deltaResponse{}
-	json.Unmarshal(resp, &page)
-	for _, item := range page.Values {
-		c.applyDelta(item)
+	// items are applied as they come in, a page that fails halfway is simply
+	// fetched and applied again
+	next, delta, err := decodeDeltaPage(body, func(item *DriveItem) {
+		c.applyDelta(*item)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Could not read server deltas.")
+		return false, err
 	}
 
 	// If the server does not provide a `@odata.nextLink` item, it means we've
 	// reached the end of this polling cycle and should not continue until the
 	// next poll interval.
-	if page.NextLink != "" {
-		c.deltaLink = strings.TrimPrefix(page.NextLink, graphURL)
+	if next != "" {
+		c.deltaLink = strings.TrimPrefix(next, graphURL)
+		c.saveDeltaLink()
 		return true, nil
 	}
-	c.deltaLink = strings.TrimPrefix(page.DeltaLink, graphURL)
+	c.deltaLink = strings.TrimPrefix(delta, graphURL)
+	c.saveDeltaLink()
 	return false, nil
 }
 
@@ -370,6 +950,10 @@ func (c *Cache) applyDelta(item DriveItem) error {
 	log.WithFields(log.Fields{
 		"name": item.Name(),
 	}).Trace("Applying delta")
+	item.cache = c
+	if c.excluded(item.Path()) || c.ignored(item.Path(), item.IsDir()) {
+		return nil
+	}
 	//TODO stub
 	return nil
 }
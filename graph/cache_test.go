@@ -3,8 +3,15 @@ package graph
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
 )
 
 func TestRootGet(t *testing.T) {
@@ -49,7 +56,7 @@ func TestSubdirChildrenUpdate(t *testing.T) {
 
 	if _, exists := children["documents"]; exists {
 		log.Println("Documents directory found inside itself. " +
-			"Likely the cache did not traverse correctly.\n\nChildren:\n")
+			"Likely the cache did not traverse correctly.\n\nChildren:")
 		for key := range children {
 			fmt.Println(key)
 		}
@@ -68,3 +75,275 @@ func TestSamePointer(t *testing.T) {
 		t.Fatal("Item was nil!")
 	}
 }
+
+// paths in a cache rooted at a subfolder should be relative to that folder
+func TestSubfolderRoot(t *testing.T) {
+	cache := NewCacheAt(auth, "/Documents")
+	root, err := cache.Get("/", auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Path() != "/" {
+		t.Fatalf("Root path did not resolve correctly, got %s\n", root.Path())
+	}
+
+	item := NewDriveItem("subfolder_root.txt", 0644, root)
+	if err = cache.Insert("/subfolder_root.txt", auth, item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Path() != "/subfolder_root.txt" {
+		t.Fatalf("Path of new item is wrong, got %s\n", item.Path())
+	}
+
+	children, err := cache.GetChildrenPath("/", auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range children {
+		if cached, err := cache.Get(child.Path(), auth); err != nil || cached != child {
+			t.Fatalf("Could not find %s by its own path.\n", child.Path())
+		}
+	}
+}
+
+// moving a folder must keep everything cached in it, at the new paths
+func TestMoveRebase(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	var file *DriveItem
+	for _, path := range []string{"/a", "/b", "/a/x", "/a/x/f.txt"} {
+		parent, err := cache.Get(filepath.Dir(path), &Auth{})
+		failOnErr(t, err)
+		mode := uint32(fuse.S_IFDIR | 0755)
+		if path == "/a/x/f.txt" {
+			mode = 0644
+		}
+		file = NewDriveItem(filepath.Base(path), mode, parent)
+		failOnErr(t, cache.Insert(path, &Auth{}, file))
+	}
+
+	failOnErr(t, cache.Move("/a/x", "/b/y", &Auth{}))
+	if _, err := cache.Get("/a/x", &Auth{}); err == nil {
+		t.Fatal("Moved folder was still at its old path.")
+	}
+	moved, err := cache.Get("/b/y/f.txt", &Auth{})
+	failOnErr(t, err)
+	if moved != file || moved.Path() != "/b/y/f.txt" {
+		t.Fatalf("File in moved folder ended up at \"%s\".\n", moved.Path())
+	}
+}
+
+// folders only need their children refetched if the server's tags changed
+func TestListingUnchanged(t *testing.T) {
+	cached := &DriveItem{ETag: "a", CTag: "x"}
+	if !listingUnchanged(cached, &DriveItem{ETag: "a", CTag: "x"}) {
+		t.Fatal("Folder with the same tags should be unchanged.")
+	}
+	if !listingUnchanged(cached, &DriveItem{ETag: "a"}) {
+		t.Fatal("Missing cTags shouldn't count as a change.")
+	}
+	if listingUnchanged(cached, &DriveItem{ETag: "b", CTag: "x"}) ||
+		listingUnchanged(cached, &DriveItem{ETag: "a", CTag: "y"}) {
+		t.Fatal("Folder with different tags should be changed.")
+	}
+	if listingUnchanged(&DriveItem{}, &DriveItem{}) {
+		t.Fatal("Folder without an eTag should always be changed.")
+	}
+}
+
+// names keep their case, lookups ignore it
+func TestChildIndex(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	dir := NewDriveItem("Docs", fuse.S_IFDIR|0755, root)
+	failOnErr(t, cache.Insert("/Docs", &Auth{}, dir))
+	file := NewDriveItem("Report.TXT", 0644, dir)
+	failOnErr(t, cache.Insert("/Docs/Report.TXT", &Auth{}, file))
+
+	found, err := cache.Get("/docs/report.txt", &Auth{})
+	failOnErr(t, err)
+	if found != file || found.Name() != "Report.TXT" || found.Path() != "/Docs/Report.TXT" {
+		t.Fatalf("Case-insensitive lookup found \"%s\".\n", found.Path())
+	}
+	if _, err = cache.Get("/Docs/Missing.txt", &Auth{}); err == nil ||
+		!strings.Contains(err.Error(), "Docs/Missing.txt") {
+		t.Fatalf("Error should name the path as given: %v\n", err)
+	}
+
+	// renamed on the server
+	remote := NewDriveItem("Summary.txt", 0644, dir)
+	remote.Parent.ID = dir.ID()
+	cache.updateItem(file, remote, &Auth{})
+	if found, _ = cache.Get("/Docs/SUMMARY.txt", &Auth{}); found != file {
+		t.Fatal("Item renamed on the server couldn't be found by its new name.")
+	}
+	if found, _ = cache.Get("/Docs/Report.TXT", &Auth{}); found != nil {
+		t.Fatal("Item renamed on the server was still found by its old name.")
+	}
+}
+
+func TestPathTooLong(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	if cache.pathTooLong("/"+strings.Repeat("ü", maxNameLength), nil) {
+		t.Fatal("Names are limited in characters, not bytes.")
+	}
+	if !cache.pathTooLong("/"+strings.Repeat("a", maxNameLength+1), nil) {
+		t.Fatal("Name over the limit should be too long.")
+	}
+	name := strings.Repeat("a", 200)
+	if !cache.pathTooLong("/"+name+"/"+name, nil) {
+		t.Fatal("Path over the limit should be too long.")
+	}
+
+	// a folder can't be moved where the files inside it would be too deep
+	dir := NewDriveItem(name, fuse.S_IFDIR|0755, root)
+	failOnErr(t, cache.Insert("/"+name, &Auth{}, dir))
+	failOnErr(t, cache.Insert("/"+name+"/"+name, &Auth{}, NewDriveItem(name, 0644, dir)))
+	if cache.pathTooLong("/b", dir) {
+		t.Fatal("Folder should fit at a short path.")
+	}
+	if !cache.pathTooLong("/"+strings.Repeat("b", 250), dir) {
+		t.Fatal("Contents of a moved folder should count towards its path length.")
+	}
+}
+
+// changing only the case of a name mustn't be taken for replacing another item
+func TestMoveCaseOnly(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	file := NewDriveItem("readme.md", 0644, root)
+	failOnErr(t, cache.Insert("/readme.md", &Auth{}, file))
+	if !caseOnlyRename("/readme.md", "/README.md") || caseOnlyRename("/a/readme.md", "/README.md") {
+		t.Fatal("Case-only renames weren't told apart from other renames.")
+	}
+
+	failOnErr(t, cache.Move("/readme.md", "/README.md", &Auth{}))
+	moved, err := cache.Get("/readme.md", &Auth{})
+	failOnErr(t, err)
+	if moved != file || file.Name() != "README.md" {
+		t.Fatalf("Item ended up named \"%s\".\n", file.Name())
+	}
+	children, err := cache.GetChildrenPath("/", &Auth{})
+	failOnErr(t, err)
+	if len(children) != 1 {
+		t.Fatalf("Root should have exactly one child, has %d.\n", len(children))
+	}
+}
+
+// folders should show they changed when something in them did, and not go back
+// in time because the server didn't notice
+func TestDirModTime(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	dir := NewDriveItem("dir", fuse.S_IFDIR|0755, root)
+	failOnErr(t, cache.Insert("/dir", &Auth{}, dir))
+	old := time.Now().Add(-time.Hour)
+	touched := func() bool {
+		return time.Since(time.Unix(int64(dir.ModTime()), 0)) < time.Minute
+	}
+
+	dir.ModTimeInternal = &old
+	failOnErr(t, cache.Insert("/dir/file.txt", &Auth{}, NewDriveItem("file.txt", 0644, dir)))
+	if !touched() {
+		t.Fatal("Creating a file should have updated its folder's mtime.")
+	}
+	dir.ModTimeInternal = &old
+	failOnErr(t, cache.Move("/dir/file.txt", "/file.txt", &Auth{}))
+	if !touched() {
+		t.Fatal("Moving a file out should have updated its folder's mtime.")
+	}
+
+	remote := NewDriveItem("dir", fuse.S_IFDIR|0755, root)
+	remote.ModTimeInternal = &old
+	dir.updateMetadata(remote)
+	if !touched() {
+		t.Fatal("Folder's mtime went back to the server's older one.")
+	}
+}
+
+// deleting a folder should take it out of its parent and drop everything in it,
+// content included
+func TestDeletePurges(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_delete")
+	defer os.RemoveAll(dir)
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+	cache.content = store
+
+	folder := NewDriveItem("folder", fuse.S_IFDIR|0755, root)
+	failOnErr(t, cache.Insert("/folder", &Auth{}, folder))
+	file := NewDriveItem("file.txt", 0644, folder)
+	failOnErr(t, cache.Insert("/folder/file.txt", &Auth{}, file))
+	failOnErr(t, store.save(file.ID(), []byte("cached content")))
+
+	cache.Delete("/folder")
+	if root.ChildCount() != 0 {
+		t.Fatal("Deleted folder was still a child of its parent.")
+	}
+	if cache.GetID(folder.ID()) != nil || cache.GetID(file.ID()) != nil {
+		t.Fatal("Deleted items were still cached.")
+	}
+	if _, err := store.load(file.ID()); err == nil {
+		t.Fatal("Content of deleted file was still in the disk cache.")
+	}
+
+	// nothing there to delete
+	cache.Delete("/folder")
+	cache.Delete("/missing/file.txt")
+}
+
+// items whose parent isn't cached (anymore) shouldn't take the cache down
+func TestParentNotCached(t *testing.T) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	evicted := NewDriveItem("evicted", fuse.S_IFDIR|0755, root)
+	orphan := NewDriveItem("orphan.txt", 0644, evicted)
+	cache.InsertID(orphan.ID(), orphan)
+
+	cache.removeParent(orphan)
+	cache.setParent(orphan, nil)
+	cache.removeParent(NewDriveItem("no-parent.txt", 0644, nil))
+	cache.removeParent(nil)
+	if root.ChildCount() != 0 {
+		t.Fatal("Item ended up in the wrong folder.")
+	}
+}
+
+// refreshing a folder should drop children that are gone from the server, but
+// not ones with local changes that haven't been uploaded yet
+func TestRefreshKeepsLocalChanges(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	changed := mock.create(docs, "changed.txt", false)
+	gone := mock.create(docs, "gone.txt", false)
+	mock.mutex.Unlock()
+	cache := NewCache(mock.auth())
+	auth := mock.auth()
+	_, err := cache.GetChildrenPath("/Documents", auth)
+	failOnErr(t, err)
+
+	item, err := cache.Get("/Documents/changed.txt", auth)
+	failOnErr(t, err)
+	content := []byte("not uploaded yet")
+	item.mutex.Lock()
+	item.data = &content
+	item.hasChanges = true
+	item.mutex.Unlock()
+
+	mock.mutex.Lock()
+	mock.remove(changed)
+	mock.remove(gone)
+	mock.mutex.Unlock()
+	failOnErr(t, cache.Refresh("/Documents", auth))
+	if found, _ := cache.Get("/Documents/changed.txt", auth); found != item {
+		t.Error("File with local changes was dropped by refreshing its folder.")
+	}
+	if found, _ := cache.Get("/Documents/gone.txt", auth); found != nil {
+		t.Error("File deleted on the server is still there after refreshing.")
+	}
+}
@@ -0,0 +1,86 @@
+package graph
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clockTolerance is how far apart two timestamps can be and still be considered
+// the same. Date headers only have a resolution of a second and arrive a bit
+// late, so small differences from the server's clock are noise.
+const clockTolerance = 2 * time.Second
+
+// clockWarning is how far off the local clock needs to be for a warning
+const clockWarning = time.Minute
+
+// clockSkew tracks how far the server's clock is ahead of the local one, going
+// by the Date headers of its responses
+type clockSkew struct {
+	mutex  sync.RWMutex
+	offset time.Duration
+	warned bool
+}
+
+var serverClock = &clockSkew{}
+
+// observe updates the skew from the Date header of a response received just now
+func (c *clockSkew) observe(date string) {
+	if date == "" {
+		return
+	}
+	serverDate, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	offset := serverDate.Sub(time.Now())
+	if offset < clockTolerance && offset > -clockTolerance {
+		offset = 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.offset = offset
+	if offset == 0 {
+		c.warned = false
+	} else if !c.warned && (offset > clockWarning || offset < -clockWarning) {
+		c.warned = true
+		log.WithFields(log.Fields{
+			"skew": offset.Round(time.Second),
+		}).Warn("Local clock is off from the server's, correcting timestamps for it.")
+	}
+}
+
+// skew returns how far the server's clock is ahead of the local one
+func (c *clockSkew) skew() time.Duration {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.offset
+}
+
+// serverNow is the current time on the server's clock. Items are timestamped
+// with it, so that a bad local clock can't make them look newer or older than
+// they are once they reach the server.
+func serverNow() time.Time {
+	return time.Now().Add(serverClock.skew())
+}
+
+// serverTime corrects a timestamp taken off the local clock just now, like the
+// one "touch" sets. Any other timestamp was picked on purpose and is kept.
+func serverTime(t time.Time) time.Time {
+	if since := time.Since(t); since < clockTolerance && since > -clockTolerance {
+		return serverNow()
+	}
+	return t
+}
+
+// remoteNewer returns true if a remote timestamp is later than a local one by
+// more than the clocks can be trusted to agree on
+func remoteNewer(local *time.Time, remote *time.Time) bool {
+	if local == nil || remote == nil {
+		return false
+	}
+	return remote.Sub(*local) > clockTolerance
+}
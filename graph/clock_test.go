@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	defer serverClock.observe(time.Now().Format(http.TimeFormat))
+
+	serverClock.observe(time.Now().Add(time.Second).UTC().Format(http.TimeFormat))
+	if skew := serverClock.skew(); skew != 0 {
+		t.Fatalf("Skew within the clocks' tolerance should be ignored, got %s.", skew)
+	}
+
+	serverClock.observe(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if skew := serverClock.skew(); skew < time.Hour-clockTolerance || skew > time.Hour {
+		t.Fatalf("Wrong skew for a server an hour ahead: %s", skew)
+	}
+	if now := serverNow(); time.Until(now) < time.Hour-clockTolerance {
+		t.Fatalf("Server time should be corrected for skew: %s", now)
+	}
+	if corrected := serverTime(time.Now()); time.Until(corrected) < time.Hour-clockTolerance {
+		t.Fatalf("Timestamps of the local time should be corrected: %s", corrected)
+	}
+	picked := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if corrected := serverTime(picked); !corrected.Equal(picked) {
+		t.Fatalf("Timestamps that were picked on purpose should be kept: %s", corrected)
+	}
+}
+
+func TestRemoteNewer(t *testing.T) {
+	local := time.Now()
+	soon := local.Add(time.Second)
+	later := local.Add(time.Minute)
+	if remoteNewer(&local, &soon) {
+		t.Fatal("Timestamps within the clocks' tolerance aren't newer.")
+	}
+	if !remoteNewer(&local, &later) {
+		t.Fatal("A minute later should be newer.")
+	}
+	if remoteNewer(&later, &local) || remoteNewer(nil, &later) {
+		t.Fatal("Older or missing timestamps aren't newer.")
+	}
+}
@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A folder is listed once everything in it is known: its children have been
+// fetched, and so have those of every folder in it. Once the content of every
+// file in it is local too (in memory or in the disk cache), it is hydrated, and
+// can be used offline. Both are worked out from what is cached, without asking
+// the server, and show up in the "completeness" xattr of folders.
+
+// name of the xattr telling how much of a folder is cached
+const completenessXAttr = "completeness"
+
+const (
+	completenessPartial  = "partial"  // some folders in it haven't been listed
+	completenessListed   = "listed"   // everything in it is listed, not all content is local
+	completenessHydrated = "hydrated" // everything in it is local
+)
+
+// completeness tells how much of a folder is cached
+func (c *Cache) completeness(item *DriveItem) string {
+	listed, hydrated := c.complete(item)
+	switch {
+	case hydrated:
+		return completenessHydrated
+	case listed:
+		return completenessListed
+	}
+	return completenessPartial
+}
+
+// complete returns whether everything in an item is listed, and whether all of
+// its content is local. Folders hidden by selective sync don't count.
+func (c *Cache) complete(item *DriveItem) (bool, bool) {
+	if !item.IsDir() {
+		return true, c.contentLocal(item)
+	}
+	item.mutex.RLock()
+	if item.children == nil {
+		item.mutex.RUnlock()
+		return false, false
+	}
+	childIDs := make([]string, len(item.children))
+	copy(childIDs, item.children)
+	item.mutex.RUnlock()
+
+	path := item.Path()
+	hydrated := true
+	for _, id := range childIDs {
+		child := c.GetID(id)
+		if child == nil || c.excluded(childPath(path, child.Name())) {
+			continue
+		}
+		childListed, childHydrated := c.complete(child)
+		if !childListed {
+			return false, false
+		}
+		hydrated = hydrated && childHydrated
+	}
+	return true, hydrated
+}
+
+// contentLocal returns whether a file's content can be read without the server
+func (c *Cache) contentLocal(item *DriveItem) bool {
+	if item.hydrated() {
+		return true
+	}
+	_, id := item.contentTarget()
+	return c.diskCacheable(item) && !isLocalID(id) && c.content.has(id)
+}
+
+// diskCacheable returns whether a file's content can be used from the disk
+// cache, which takes hashes to check it against, see cachedContent()
+func (c *Cache) diskCacheable(item *DriveItem) bool {
+	item.mutex.RLock()
+	defer item.mutex.RUnlock()
+	return c.content != nil && item.FileInternal != nil && item.FileInternal.Hashes != nil
+}
+
+// Hydrate makes everything at or under a path available offline, by listing
+// every folder and downloading every file in it. Content goes to the disk cache
+// if there is one, so hydrating big folders doesn't fill up memory. Files that
+// fail to download don't stop the rest. Returns the number of files downloaded
+// and the first error.
+func (c *Cache) Hydrate(path string, auth *Auth) (int, error) {
+	item, err := c.Get(path, auth)
+	if err != nil {
+		return 0, err
+	}
+	return c.hydrate(item, auth)
+}
+
+func (c *Cache) hydrate(item *DriveItem, auth *Auth) (int, error) {
+	if !item.IsDir() {
+		if c.contentLocal(item) {
+			return 0, nil
+		}
+		if err := c.download(item, auth); err != nil {
+			log.WithFields(log.Fields{
+				"path": item.Path(),
+				"err":  err,
+			}).Warn("Could not download file for offline use.")
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	children, err := c.GetChildrenID(item.ID(), auth)
+	if err != nil {
+		return 0, err
+	}
+	downloaded := 0
+	var first error
+	for _, child := range children {
+		count, err := c.hydrate(child, auth)
+		downloaded += count
+		if first == nil {
+			first = err
+		}
+	}
+	return downloaded, first
+}
+
+// download fetches a file's content into the disk cache, or into memory if it
+// can't be used from there
+func (c *Cache) download(item *DriveItem, auth *Auth) error {
+	if !c.diskCacheable(item) {
+		return item.FetchContent(auth)
+	}
+	item.mutex.RLock()
+	malware := item.Malware != nil
+	item.mutex.RUnlock()
+	if malware {
+		return errMalware
+	}
+	drive, id := item.contentTarget()
+	body, err := Get("/me/drive/items/"+id+"/content", scopedAuth(auth, drive))
+	if err != nil {
+		return err
+	}
+	if !item.VerifyChecksum(body) {
+		return errors.New("checksum mismatch after downloading " + item.Path())
+	}
+	if !c.cacheContent(item, id, body) {
+		return errors.New("could not save " + item.Path() + " to the disk cache")
+	}
+	item.notifyStatus()
+	return nil
+}
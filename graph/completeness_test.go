@@ -0,0 +1,113 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// folders should tell how much of them is cached, and hydrating them should
+// make all of it local without keeping it in memory
+func TestHydrateCompleteness(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_hydrate")
+	defer os.RemoveAll(dir)
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	trip := mock.create(mock.child(mockRootID, "Documents"), "Trip", true)
+	mock.setContent(mock.create(trip, "tickets.pdf", false), []byte("tickets"))
+	sub := mock.create(trip, "Maps", true)
+	mock.setContent(mock.create(sub, "city.png", false), []byte("map"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+	fs.items.content = store
+
+	completeness := func() string {
+		value, status := fs.GetXAttr("/Documents/Trip", xattrPrefix+completenessXAttr, nil)
+		if status != fuse.OK {
+			t.Fatal("Could not read completeness:", status)
+		}
+		return string(value)
+	}
+	fs.GetAttr("/Documents/Trip", nil)
+	if state := completeness(); state != completenessPartial {
+		t.Fatalf("Unlisted folder should be %s, got %s.\n", completenessPartial, state)
+	}
+
+	count, err := fs.items.Hydrate("/Documents/Trip", fs.Auth)
+	failOnErr(t, err)
+	if count != 2 {
+		t.Fatalf("Expected 2 files to be downloaded, got %d.\n", count)
+	}
+	if state := completeness(); state != completenessHydrated {
+		t.Fatalf("Hydrated folder should be %s, got %s.\n", completenessHydrated, state)
+	}
+	tickets, err := fs.items.Get("/Documents/Trip/tickets.pdf", fs.Auth)
+	failOnErr(t, err)
+	if tickets.hydrated() {
+		t.Error("Hydrated content should only be in the disk cache.")
+	}
+	if count, err = fs.items.Hydrate("/Documents/Trip", fs.Auth); err != nil || count != 0 {
+		t.Errorf("Hydrating again should download nothing, got %d files (%v).", count, err)
+	}
+
+	store.remove(tickets.ID())
+	if state := completeness(); state != completenessListed {
+		t.Fatalf("Folder missing content should be %s, got %s.\n", completenessListed, state)
+	}
+	names, _ := fs.ListXAttr("/Documents/Trip", nil)
+	listed := false
+	for _, name := range names {
+		listed = listed || name == xattrPrefix+completenessXAttr
+	}
+	if !listed {
+		t.Error("Completeness is not listed:", names)
+	}
+}
+
+// evicting should drop content from the disk cache too, but keep files listed
+func TestEvictDiskContent(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_evict")
+	defer os.RemoveAll(dir)
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	trip := mock.create(mock.child(mockRootID, "Documents"), "Trip", true)
+	mock.setContent(mock.create(trip, "tickets.pdf", false), []byte("tickets"))
+	mock.setContent(mock.create(trip, "pinned.pdf", false), []byte("pinned"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+	fs.items.content = store
+
+	fs.GetAttr("/Documents/Trip", nil)
+	_, err = fs.items.Hydrate("/Documents/Trip", fs.Auth)
+	failOnErr(t, err)
+	failOnErr(t, fs.items.Pin("/Documents/Trip/pinned.pdf", fs.Auth))
+
+	count, err := fs.items.Evict("/Documents/Trip", fs.Auth)
+	failOnErr(t, err)
+	if count != 1 {
+		t.Fatalf("Expected 1 file to be evicted, got %d.\n", count)
+	}
+	tickets, err := fs.items.Get("/Documents/Trip/tickets.pdf", fs.Auth)
+	failOnErr(t, err)
+	if store.has(tickets.ID()) {
+		t.Error("Evicted content is still in the disk cache.")
+	}
+	pinned, err := fs.items.Get("/Documents/Trip/pinned.pdf", fs.Auth)
+	failOnErr(t, err)
+	if !store.has(pinned.ID()) {
+		t.Error("Pinned content was evicted from the disk cache.")
+	}
+	folder, err := fs.items.Get("/Documents/Trip", fs.Auth)
+	failOnErr(t, err)
+	if state := fs.items.completeness(folder); state != completenessListed {
+		t.Errorf("Evicted folder should still be %s, got %s.", completenessListed, state)
+	}
+}
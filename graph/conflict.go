@@ -0,0 +1,183 @@
+package graph
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// What to do with a file that changed both locally and on the server since it
+// was last synced
+const (
+	ConflictKeepLocal  = "keep-local"  // the local changes overwrite the server's
+	ConflictKeepRemote = "keep-remote" // the local changes are thrown away
+	ConflictKeepBoth   = "keep-both"   // the local changes go into a copy next to the file
+)
+
+// defaultConflictName is the name of conflict copies unless configured otherwise.
+// {name} and {ext} are the file's name and extension, {date} and {time} when the
+// conflict was found, {host} the machine's hostname.
+const defaultConflictName = "{name} (conflict {date} {time}){ext}"
+
+var errConflict = errors.New("file was changed both locally and on the server")
+
+// conflictPolicy is how conflicting changes are resolved
+type conflictPolicy struct {
+	mutex  *mu.RWMutex
+	policy string
+	name   string // naming template of conflict copies
+}
+
+func newConflictPolicy() *conflictPolicy {
+	return &conflictPolicy{
+		mutex:  &mu.RWMutex{},
+		policy: ConflictKeepLocal,
+		name:   defaultConflictName,
+	}
+}
+
+// SetConflictPolicy sets what to do with files that changed both locally and on
+// the server, and what copies of the local changes are called with keep-both.
+// Empty values are reset to the defaults, keep-local and defaultConflictName.
+func (c *Cache) SetConflictPolicy(policy string, name string) {
+	switch policy {
+	case ConflictKeepLocal, ConflictKeepRemote, ConflictKeepBoth:
+	case "":
+		policy = ConflictKeepLocal
+	default:
+		log.WithFields(log.Fields{
+			"policy": policy,
+		}).Warn("Unknown conflict policy, keeping local changes.")
+		policy = ConflictKeepLocal
+	}
+	if name == "" {
+		name = defaultConflictName
+	}
+	c.conflicts.mutex.Lock()
+	c.conflicts.policy, c.conflicts.name = policy, name
+	c.conflicts.mutex.Unlock()
+}
+
+// conflictName names the copy of a conflicting file, from a naming template
+func conflictName(template string, name string, found time.Time) string {
+	ext := filepath.Ext(name)
+	host, _ := os.Hostname()
+	copyName := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+		"{date}", found.Format("2006-01-02"),
+		"{time}", found.Format("150405"),
+		"{host}", host,
+	).Replace(template)
+	return strings.Replace(copyName, "/", "_", -1)
+}
+
+// resolveConflict deals with an item whose local changes are based on an older
+// version than remote, the server's current one
+func (c *Cache) resolveConflict(item *DriveItem, remote *DriveItem, auth *Auth) {
+	c.conflicts.mutex.RLock()
+	policy, template := c.conflicts.policy, c.conflicts.name
+	c.conflicts.mutex.RUnlock()
+	path := item.Path()
+	log.WithFields(log.Fields{
+		"path":   path,
+		"policy": policy,
+	}).Warn("File was changed both locally and on the server.")
+	c.listeners.syncError(path, "conflict", errConflict)
+
+	switch policy {
+	case ConflictKeepLocal:
+		// the next upload just goes over the server's version
+		item.mutex.Lock()
+		item.CTag = remote.CTag
+		item.mutex.Unlock()
+		return
+	case ConflictKeepBoth:
+		if err := c.saveConflictCopy(item, path, template, auth); err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+				"err":  err,
+			}).Error("Could not save conflict copy, keeping local changes.")
+			return
+		}
+	}
+	c.discardChanges(item, remote, auth)
+}
+
+// saveConflictCopy puts the local content of an item into a new file next to it
+func (c *Cache) saveConflictCopy(item *DriveItem, path string, template string, auth *Auth) error {
+	item.mutex.RLock()
+	if item.data == nil {
+		item.mutex.RUnlock()
+		return errors.New("no local content to save")
+	}
+	content := make([]byte, len(*item.data))
+	item.copyContentLocked(content)
+	mode := item.Mode()
+	item.mutex.RUnlock()
+
+	dir := filepath.Dir(path)
+	parent, err := c.Get(dir, auth)
+	if err != nil {
+		return err
+	}
+	name := uniqueName(conflictName(template, item.Name(), time.Now()), func(name string) bool {
+		existing, _ := c.Get(filepath.Join(dir, name), auth)
+		return existing != nil
+	})
+	conflicted := NewDriveItem(name, mode, parent)
+	conflicted.data = &content
+	conflicted.SizeInternal = uint64(len(content))
+	conflicted.hasChanges = true
+	if err = c.Insert(filepath.Join(dir, name), auth, conflicted); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path": path,
+		"copy": filepath.Join(dir, name),
+	}).Info("Saved local changes of conflicting file as a copy.")
+	// failed uploads are retried, the copy is safe either way
+	conflicted.Upload(auth)
+	return nil
+}
+
+// discardChanges throws away the local changes of an item in favor of remote
+func (c *Cache) discardChanges(item *DriveItem, remote *DriveItem, auth *Auth) {
+	item.mutex.Lock()
+	if item.uploading || item.uploadSession != nil {
+		item.mutex.Unlock()
+		log.WithFields(log.Fields{
+			"path": item.Path(),
+		}).Warn("Local changes of conflicting file are already being uploaded, keeping them.")
+		return
+	}
+	item.hasChanges = false
+	item.CTag = remote.CTag
+	item.SizeInternal = remote.SizeInternal
+	item.ModTimeInternal = remote.ModTimeInternal
+	open := item.openHandles > 0
+	if !open {
+		item.data = nil
+		item.diskID = ""
+	}
+	item.mutex.Unlock()
+
+	if open {
+		// open files need content to read from at all times
+		if err := item.FetchContent(auth); err != nil {
+			log.WithFields(log.Fields{
+				"path": item.Path(),
+				"err":  err,
+			}).Error("Could not fetch server's version of conflicting file.")
+			item.mutex.Lock()
+			item.hasChanges = true
+			item.mutex.Unlock()
+		}
+	}
+	item.notifyStatus()
+}
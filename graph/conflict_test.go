@@ -0,0 +1,55 @@
+package graph
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestConflictName(t *testing.T) {
+	found := time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+	name := conflictName(defaultConflictName, "report.docx", found)
+	if name != "report (conflict 2020-03-04 050607).docx" {
+		t.Fatalf("Wrong conflict copy name: %s", name)
+	}
+	if name = conflictName("{ext}/{name}", "notes", found); name != "_notes" {
+		t.Fatalf("Slashes should be kept out of conflict copy names: %s", name)
+	}
+}
+
+// only files with local changes based on an older version of the server's
+// content are in conflict
+func TestConflictDetected(t *testing.T) {
+	item := NewDriveItem("conflict.txt", fuse.S_IFREG|0644, nil)
+	item.CTag = "old"
+	remote := NewDriveItem("conflict.txt", fuse.S_IFREG|0644, nil)
+	remote.CTag = "new"
+	if item.updateMetadata(remote) {
+		t.Fatal("Item without local changes can't be in conflict.")
+	}
+	if item.CTag != "new" {
+		t.Fatalf("Item should have adopted the server's cTag: %s", item.CTag)
+	}
+
+	item.hasChanges = true
+	if item.updateMetadata(remote) {
+		t.Fatal("Item whose changes are based on the server's version can't be in conflict.")
+	}
+	remote.CTag = "newer"
+	if !item.updateMetadata(remote) {
+		t.Fatal("Item with local changes and a new server version should be in conflict.")
+	}
+
+	// without cTags, only a server version that is clearly newer is a conflict
+	item.CTag, remote.CTag = "", ""
+	later := item.ModTimeInternal.Add(time.Second)
+	remote.ModTimeInternal = &later
+	if item.updateMetadata(remote) {
+		t.Fatal("Timestamps within the clocks' tolerance shouldn't be a conflict.")
+	}
+	later = later.Add(time.Hour)
+	if !item.updateMetadata(remote) {
+		t.Fatal("Item with local changes and a newer server version should be in conflict.")
+	}
+}
@@ -0,0 +1,173 @@
+package graph
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how much space to leave free on the cache disk before taking new content again
+// after it filled up
+const contentReserve = 64 * 1024 * 1024
+
+var errCacheFull = errors.New("the disk holding onedriver's cache is full, " +
+	"new files will only be kept in memory until space is freed up")
+
+// contentStore keeps copies of file contents on disk, so files don't have to be
+// downloaded again once they are dropped from memory. If the disk fills up, the
+// store stops taking new content until enough space frees up again. Onedriver
+// keeps working in the meantime, content just isn't cached on disk. Content is
+// always written to a temporary file first, so a full disk never leaves a
+// truncated copy behind.
+type contentStore struct {
+	dir  string
+	full int32 // 1 while the disk is full
+}
+
+func newContentStore(dir string) (*contentStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &contentStore{dir: dir}, nil
+}
+
+func (s *contentStore) path(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// isFull returns whether the store has stopped taking new content
+func (s *contentStore) isFull() bool {
+	return atomic.LoadInt32(&s.full) == 1
+}
+
+// hasRoom checks if there is enough free space to store size more bytes
+func (s *contentStore) hasRoom(size int) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.dir, &st); err != nil {
+		return false
+	}
+	return st.Bavail*uint64(st.Bsize) > uint64(size)+contentReserve
+}
+
+// isDiskFull returns whether err was caused by running out of disk space or disk
+// quota
+func isDiskFull(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	if linkErr, ok := err.(*os.LinkError); ok {
+		err = linkErr.Err
+	}
+	return err == syscall.ENOSPC || err == syscall.EDQUOT
+}
+
+// load returns stored content, if any
+func (s *contentStore) load(id string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(id))
+}
+
+// open opens stored content for reading
+func (s *contentStore) open(id string) (*os.File, error) {
+	return os.Open(s.path(id))
+}
+
+// save stores content. Returns errCacheFull if the disk is (or just became) full.
+func (s *contentStore) save(id string, content []byte) error {
+	if s.isFull() {
+		if !s.hasRoom(len(content)) {
+			return errCacheFull
+		}
+		atomic.StoreInt32(&s.full, 0)
+		log.WithFields(log.Fields{
+			"dir": s.dir,
+		}).Info("Disk space freed up, caching content on disk again.")
+	}
+
+	tmp := s.path(id) + ".tmp"
+	err := ioutil.WriteFile(tmp, content, 0600)
+	if err == nil {
+		err = os.Rename(tmp, s.path(id))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		if isDiskFull(err) {
+			atomic.StoreInt32(&s.full, 1)
+			return errCacheFull
+		}
+		return err
+	}
+	return nil
+}
+
+// has returns whether there is stored content for id
+func (s *contentStore) has(id string) bool {
+	_, err := os.Stat(s.path(id))
+	return err == nil
+}
+
+// remove deletes stored content
+func (s *contentStore) remove(id string) {
+	os.Remove(s.path(id))
+}
+
+// cacheContent stores an item's content on disk if the disk cache is enabled.
+// Running out of space is reported to the cache's listeners the first time it
+// happens. Returns whether the content was stored.
+func (c *Cache) cacheContent(item *DriveItem, id string, content []byte) bool {
+	if c.content == nil || isLocalID(id) {
+		return false
+	}
+	wasFull := c.content.isFull()
+	err := c.content.save(id, content)
+	if err == errCacheFull {
+		if !wasFull {
+			log.WithFields(log.Fields{
+				"dir": c.content.dir,
+			}).Warn("Cache disk is full, no longer caching content on disk.")
+			c.listeners.syncError(item.Path(), "cache", err)
+		}
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"id":   id,
+			"path": item.Path(),
+			"err":  err,
+		}).Warn("Could not write content to disk cache.")
+	}
+	return err == nil
+}
+
+// cachedContent returns an item's content from the disk cache, but only if it
+// still matches the checksums the server reported for the item
+func (c *Cache) cachedContent(item *DriveItem, id string) ([]byte, bool) {
+	if c.content == nil {
+		return nil, false
+	}
+	item.mutex.RLock()
+	hasHashes := item.FileInternal != nil && item.FileInternal.Hashes != nil
+	item.mutex.RUnlock()
+	if !hasHashes {
+		// no way of telling if the content is stale
+		return nil, false
+	}
+	content, err := c.content.load(id)
+	if err != nil {
+		return nil, false
+	}
+	if !item.VerifyChecksum(content) {
+		c.content.remove(id)
+		return nil, false
+	}
+	return content, true
+}
+
+// uncacheContent drops an item's content from the disk cache
+func (c *Cache) uncacheContent(id string) {
+	if c.content != nil {
+		c.content.remove(id)
+	}
+}
@@ -0,0 +1,100 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestContentStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_content")
+	defer os.RemoveAll(dir)
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+
+	failOnErr(t, store.save("some-id", []byte("cached content")))
+	content, err := store.load("some-id")
+	failOnErr(t, err)
+	if string(content) != "cached content" {
+		t.Fatalf("Got different content back from store: \"%s\"\n", content)
+	}
+
+	store.remove("some-id")
+	if _, err = store.load("some-id"); err == nil {
+		t.Fatal("Content was still in store after removal.")
+	}
+}
+
+// a store that filled up should start taking content again once there's room
+func TestContentStoreRecovers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_content")
+	defer os.RemoveAll(dir)
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+
+	store.full = 1
+	if !store.hasRoom(1) {
+		t.Skip("Not enough free space in the temp directory to run this test.")
+	}
+	failOnErr(t, store.save("some-id", []byte("cached content")))
+	if store.isFull() {
+		t.Fatal("Store did not recover after space freed up.")
+	}
+}
+
+func TestIsDiskFull(t *testing.T) {
+	if !isDiskFull(&os.PathError{Op: "write", Path: "/tmp/x", Err: syscall.ENOSPC}) {
+		t.Fatal("ENOSPC was not detected as a full disk.")
+	}
+	if isDiskFull(&os.PathError{Op: "open", Path: "/tmp/x", Err: syscall.EACCES}) {
+		t.Fatal("EACCES was detected as a full disk.")
+	}
+}
+
+// reads of content matching the disk cache are served from the cache file, until
+// the content is changed
+func TestContentFd(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_content")
+	defer os.RemoveAll(dir)
+	store, err := newContentStore(dir)
+	failOnErr(t, err)
+	failOnErr(t, store.save("some-id", []byte("cached content")))
+
+	item := NewDriveItem("file.txt", 0644, nil)
+	item.cache = &Cache{content: store, listeners: newListeners()}
+	data := []byte("cached content")
+	item.data = &data
+	item.SizeInternal = uint64(len(data))
+	item.diskID = "some-id"
+	item.opened()
+
+	buf := make([]byte, 64)
+	if _, ok := item.contentFd(); !ok {
+		t.Fatal("Read was not served from the disk cache.")
+	}
+	result, status := item.Read(buf, 7)
+	if status != fuse.OK {
+		t.Fatal(status)
+	}
+	if content, _ := result.Bytes(buf); string(content) != "content" {
+		t.Fatalf("Got \"%s\" from the disk cache.\n", content)
+	}
+
+	item.cache.Pause() // there's no server to upload to
+	item.Write([]byte("written"), 0)
+	if _, ok := item.contentFd(); ok {
+		t.Fatal("Changed content was still read from the disk cache.")
+	}
+	result, _ = item.Read(buf, 0)
+	if content, _ := result.Bytes(buf); string(content) != "writtencontent" {
+		t.Fatalf("Got \"%s\" after changing the content.\n", content)
+	}
+
+	item.Release()
+	if item.contentFile != nil {
+		t.Fatal("Cache file was not closed once the last handle was released.")
+	}
+}
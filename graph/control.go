@@ -0,0 +1,374 @@
+package graph
+
+// The control socket lets other processes (the onedriver CLI, GUIs, scripts)
+// talk to a running filesystem. It speaks JSON-RPC 1.0 (as implemented by
+// net/rpc/jsonrpc), with every method exposed under the "Control" service, for
+// example {"method": "Control.Evict", "params": [{"path": "/Pictures"}], "id": 1}
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mountpointKey turns a mountpoint into a short, unique name that is safe to use
+// as a filename. The full path is hashed since unix socket paths have a hard
+// length limit.
+func mountpointKey(mountpoint string) string {
+	abs, _ := filepath.Abs(mountpoint)
+	sum := sha1.Sum([]byte(abs))
+	return fmt.Sprintf("%s-%x", filepath.Base(abs), sum[:4])
+}
+
+// ControlSocketPath returns the location of the control socket for the
+// filesystem mounted at mountpoint. Sockets are kept in $XDG_RUNTIME_DIR when
+// possible.
+func ControlSocketPath(mountpoint string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("onedriver-%d", os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "onedriver")
+	}
+	return filepath.Join(dir, mountpointKey(mountpoint)+".sock")
+}
+
+// DefaultCacheDir returns the directory used to store local state for the
+// filesystem mounted at mountpoint, under $XDG_CACHE_HOME.
+func DefaultCacheDir(mountpoint string) string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "onedriver", mountpointKey(mountpoint))
+}
+
+// ControlArgs are the arguments to a control command. Path is relative to the
+// root of the mounted filesystem.
+type ControlArgs struct {
+	Path      string `json:"path,omitempty"`
+	Version   string `json:"version,omitempty"`   // only used by RestoreVersion
+	LinkType  string `json:"linkType,omitempty"`  // only used by Share
+	LinkScope string `json:"linkScope,omitempty"` // only used by Share
+	// only used by Unshare
+	PermissionID string `json:"permissionId,omitempty"`
+	// only used by Invite
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"`
+	// only used by Manifest
+	Format string `json:"format,omitempty"`
+}
+
+// ControlReply is the result of a control command that doesn't return anything
+// more specific.
+type ControlReply struct {
+	Message string `json:"message,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// Control implements the commands available over the control socket. Each
+// exported method is callable as "Control.<Method>".
+type Control struct {
+	fs *FuseFs
+}
+
+// Status reports a summary of the filesystem's state
+func (c *Control) Status(args *ControlArgs, reply *CacheStats) error {
+	*reply = c.fs.items.Stats()
+	return nil
+}
+
+// ErrorsReply lists the most recent sync errors
+type ErrorsReply struct {
+	Errors []SyncErrorEntry `json:"errors"`
+}
+
+// Errors lists the most recent sync errors, from oldest to newest
+func (c *Control) Errors(args *ControlArgs, reply *ErrorsReply) error {
+	reply.Errors = c.fs.errors.all()
+	return nil
+}
+
+// Pause puts uploads and syncing with the server on hold
+func (c *Control) Pause(args *ControlArgs, reply *ControlReply) error {
+	c.fs.items.Pause()
+	reply.Message = "paused"
+	return nil
+}
+
+// Resume restarts uploads and syncing after a pause
+func (c *Control) Resume(args *ControlArgs, reply *ControlReply) error {
+	c.fs.items.Resume()
+	reply.Message = "resumed"
+	return nil
+}
+
+// Pin keeps a file's content cached locally
+func (c *Control) Pin(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	if err = c.fs.items.Pin(path, c.fs.Auth); err != nil {
+		return err
+	}
+	reply.Message = "pinned " + path
+	return nil
+}
+
+// Undo restores items deleted at or below a path whose deletion hasn't reached
+// the server yet
+func (c *Control) Undo(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	count, err := c.fs.Undo(path)
+	reply.Count = count
+	reply.Message = fmt.Sprintf("restored %d items", count)
+	return err
+}
+
+// Refresh refetches an item (and its children if it is a directory) from the
+// server
+func (c *Control) Refresh(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	if err = c.fs.items.Refresh(path, c.fs.Auth); err != nil {
+		return err
+	}
+	reply.Message = "refreshed " + path
+	return nil
+}
+
+// Evict drops the cached content at a path
+func (c *Control) Evict(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	count, err := c.fs.items.Evict(path, c.fs.Auth)
+	if err != nil {
+		return err
+	}
+	reply.Count = count
+	reply.Message = fmt.Sprintf("evicted %d files", count)
+	return nil
+}
+
+// Hydrate downloads everything at or under a path so it can be used offline
+func (c *Control) Hydrate(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	count, err := c.fs.items.Hydrate(path, c.fs.Auth)
+	reply.Count = count
+	reply.Message = fmt.Sprintf("downloaded %d files", count)
+	return err
+}
+
+// Flush starts uploading changes at or under a path without waiting for the
+// upload delay
+func (c *Control) Flush(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	count, err := c.fs.items.FlushUploads(path)
+	if err != nil {
+		return err
+	}
+	reply.Count = count
+	reply.Message = fmt.Sprintf("started uploading %d files", count)
+	return nil
+}
+
+// RestoreVersion rolls a file back to one of its previous versions
+func (c *Control) RestoreVersion(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	if args.Version == "" {
+		return errors.New("a version is required")
+	}
+	if err = c.fs.RestoreVersion(path, args.Version); err != nil {
+		return err
+	}
+	reply.Message = fmt.Sprintf("restored version %s of %s", args.Version, path)
+	return nil
+}
+
+// Share creates a sharing link for an item, the reply's message is its URL
+func (c *Control) Share(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	link, err := c.fs.Share(path, args.LinkType, args.LinkScope)
+	if err != nil {
+		return err
+	}
+	reply.Message = link.WebURL
+	return nil
+}
+
+// WebURL looks up the address of an item on the OneDrive website, the reply's
+// message is the URL
+func (c *Control) WebURL(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	reply.Message, err = c.fs.WebURL(path)
+	return err
+}
+
+// PermissionsReply lists who an item is shared with
+type PermissionsReply struct {
+	Permissions []Permission `json:"permissions"`
+}
+
+// Permissions lists who an item is shared with
+func (c *Control) Permissions(args *ControlArgs, reply *PermissionsReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	reply.Permissions, err = c.fs.Permissions(path)
+	return err
+}
+
+// Unshare removes one of an item's permissions
+func (c *Control) Unshare(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	if args.PermissionID == "" {
+		return errors.New("a permission ID is required")
+	}
+	if err = c.fs.Unshare(path, args.PermissionID); err != nil {
+		return err
+	}
+	reply.Message = fmt.Sprintf("removed permission %s from %s", args.PermissionID, path)
+	return nil
+}
+
+// Invite shares an item with someone by email address
+func (c *Control) Invite(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	permissions, err := c.fs.Invite(path, args.Email, args.Role)
+	if err != nil {
+		return err
+	}
+	reply.Count = len(permissions)
+	reply.Message = fmt.Sprintf("shared %s with %s", path, args.Email)
+	return nil
+}
+
+// Manifest lists every cached item under a path with its size, hashes and ID.
+// The reply's message is the manifest in the requested format (json or csv) and
+// its count the number of items.
+func (c *Control) Manifest(args *ControlArgs, reply *ControlReply) error {
+	path, err := controlPath(args)
+	if err != nil {
+		return err
+	}
+	var out strings.Builder
+	manifest, err := NewManifestWriter(&out, args.Format)
+	if err != nil {
+		return err
+	}
+	err = c.fs.items.WalkCached(path, func(entry ManifestEntry) error {
+		reply.Count++
+		return manifest.Write(entry)
+	})
+	if err == nil {
+		err = manifest.Flush()
+	}
+	reply.Message = strings.TrimSuffix(out.String(), "\n")
+	return err
+}
+
+// controlPath validates and normalizes the path argument of a command
+func controlPath(args *ControlArgs) (string, error) {
+	if args == nil || args.Path == "" {
+		return "", errors.New("a path is required")
+	}
+	path := leadingSlash(filepath.Clean(args.Path))
+	if strings.HasPrefix(path, "/..") {
+		return "", errors.New("path is outside of the filesystem: " + args.Path)
+	}
+	return path, nil
+}
+
+// ServeControl starts listening for commands on a unix socket at socketPath.
+// Fails if another live process is already listening on that socket.
+func (fs *FuseFs) ServeControl(socketPath string) error {
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return errors.New("another process is already listening on " + socketPath)
+	}
+	// a leftover socket from a process that didn't exit cleanly
+	os.Remove(socketPath)
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return err
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Control", &Control{fs: fs}); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	// nobody else gets to control our filesystem
+	os.Chmod(socketPath, 0600)
+
+	fs.mutex.Lock()
+	fs.control = listener
+	fs.mutex.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Debug("Control socket closed.")
+				return
+			}
+			go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	log.WithFields(log.Fields{"path": socketPath}).Info("Control socket listening.")
+	return nil
+}
+
+// ControlCall connects to the control socket of a running filesystem and runs a
+// single command, like "Control.Status".
+func ControlCall(socketPath string, method string, args interface{}, reply interface{}) error {
+	client, err := jsonrpc.Dial("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(method, args, reply)
+}
@@ -0,0 +1,237 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// start a control socket for the test filesystem at a throwaway location
+func startControl(t *testing.T) string {
+	dir, _ := ioutil.TempDir("", "onedriver_control")
+	socket := filepath.Join(dir, "control.sock")
+	failOnErr(t, testFs.ServeControl(socket))
+	return socket
+}
+
+// the control socket should only accept one server per socket
+func TestControlSocketExclusive(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+	if err := testFs.ServeControl(socket); err == nil {
+		t.Fatal("Started two control servers on the same socket.")
+	}
+}
+
+// can we fetch status via the socket
+func TestControlStatus(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	var status CacheStats
+	failOnErr(t, ControlCall(socket, "Control.Status", ControlArgs{}, &status))
+	if status.Items == 0 || status.Dirs == 0 {
+		t.Fatalf("Status reported an empty cache: %+v\n", status)
+	}
+}
+
+// changes made while paused should stay pending until resumed
+func TestControlPauseResume(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	var reply ControlReply
+	failOnErr(t, ControlCall(socket, "Control.Pause", ControlArgs{}, &reply))
+	fname := filepath.Join(TestDir, "paused_upload.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("written while paused"), 0644))
+
+	var status CacheStats
+	failOnErr(t, ControlCall(socket, "Control.Status", ControlArgs{}, &status))
+	if !status.Paused || status.PendingUploads == 0 {
+		t.Fatalf("Expected a pending upload while paused: %+v\n", status)
+	}
+	failOnErr(t, ControlCall(socket, "Control.Resume", ControlArgs{}, &reply))
+}
+
+// evicted content should be transparently refetched
+func TestControlEvict(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "evict_me.txt")
+	content := []byte("evict me")
+	failOnErr(t, ioutil.WriteFile(fname, content, 0644))
+
+	var reply ControlReply
+	err := ControlCall(socket, "Control.Evict", ControlArgs{Path: "/"}, &reply)
+	failOnErr(t, err)
+	read, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(read) != string(content) {
+		t.Fatalf("Content changed after eviction: \"%s\"\n", read)
+	}
+	os.Remove(fname)
+}
+
+// restoring a previous version should bring back its content
+func TestControlRestoreVersion(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "restore_version.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("first version"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+	failOnErr(t, ioutil.WriteFile(fname, []byte("second version"), 0644))
+	time.Sleep(5 * time.Second)
+
+	item, err := testFs.items.Get("/onedriver_tests/restore_version.txt", auth)
+	failOnErr(t, err)
+	versions, err := GetVersions(item.ID(), auth)
+	failOnErr(t, err)
+	if len(versions) < 2 {
+		t.Fatalf("Expected at least 2 versions of file, got %d.\n", len(versions))
+	}
+
+	var reply ControlReply
+	err = ControlCall(socket, "Control.RestoreVersion", ControlArgs{
+		Path:    "/onedriver_tests/restore_version.txt",
+		Version: versions[len(versions)-1].ID,
+	}, &reply)
+	failOnErr(t, err)
+	content, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(content) != "first version" {
+		t.Fatalf("Wrong content after restoring version: \"%s\"\n", content)
+	}
+}
+
+// sharing an item should return a link to it
+func TestControlShare(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "share_me.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("share me"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+
+	var reply ControlReply
+	err := ControlCall(socket, "Control.Share", ControlArgs{
+		Path: "/onedriver_tests/share_me.txt",
+	}, &reply)
+	failOnErr(t, err)
+	if !strings.HasPrefix(reply.Message, "https://") {
+		t.Fatalf("Expected a URL, got \"%s\"\n", reply.Message)
+	}
+}
+
+// a sharing link should show up in an item's permissions until it's removed
+func TestControlPermissions(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "permissions.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("who can see me"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+	path := "/onedriver_tests/permissions.txt"
+
+	var reply ControlReply
+	failOnErr(t, ControlCall(socket, "Control.Share", ControlArgs{Path: path}, &reply))
+	var permissions PermissionsReply
+	failOnErr(t, ControlCall(socket, "Control.Permissions", ControlArgs{Path: path}, &permissions))
+	var linkID string
+	for _, permission := range permissions.Permissions {
+		if permission.Link != nil && permission.Link.WebURL == reply.Message {
+			linkID = permission.ID
+		}
+	}
+	if linkID == "" {
+		t.Fatalf("Sharing link was not among the item's permissions: %+v\n", permissions)
+	}
+
+	err := ControlCall(socket, "Control.Unshare", ControlArgs{
+		Path:         path,
+		PermissionID: linkID,
+	}, &reply)
+	failOnErr(t, err)
+	failOnErr(t, ControlCall(socket, "Control.Permissions", ControlArgs{Path: path}, &permissions))
+	for _, permission := range permissions.Permissions {
+		if permission.ID == linkID {
+			t.Fatal("Permission was still there after being removed.")
+		}
+	}
+}
+
+// uploaded items should have an address on the OneDrive website
+func TestControlWebURL(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "web_url.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("open me in a browser"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+
+	var reply ControlReply
+	err := ControlCall(socket, "Control.WebURL", ControlArgs{
+		Path: "/onedriver_tests/web_url.txt",
+	}, &reply)
+	failOnErr(t, err)
+	if !strings.HasPrefix(reply.Message, "https://") {
+		t.Fatalf("Expected a URL, got \"%s\"\n", reply.Message)
+	}
+}
+
+// the manifest of the test directory should list a file that was just written
+func TestControlManifest(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+
+	fname := filepath.Join(TestDir, "manifest.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("in the manifest"), 0644))
+
+	var reply ControlReply
+	err := ControlCall(socket, "Control.Manifest", ControlArgs{
+		Path:   "/onedriver_tests",
+		Format: "csv",
+	}, &reply)
+	failOnErr(t, err)
+	if !strings.Contains(reply.Message, "/onedriver_tests/manifest.txt,") {
+		t.Fatalf("File was missing from manifest:\n%s\n", reply.Message)
+	}
+	if lines := strings.Count(reply.Message, "\n"); lines != reply.Count {
+		t.Fatalf("Manifest had %d entries, reply said %d.\n", lines, reply.Count)
+	}
+}
+
+// deletions held back by deleteDelay should be undoable until they reach the
+// server
+func TestControlUndo(t *testing.T) {
+	socket := startControl(t)
+	defer testFs.Close()
+	testFs.deletes.setDelay(time.Minute)
+	defer testFs.deletes.setDelay(0)
+
+	fname := filepath.Join(TestDir, "undo_me.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("undo me"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+	failOnErr(t, os.Remove(fname))
+	if _, err := os.Stat(fname); err == nil {
+		t.Fatal("Deleted file was still there.")
+	}
+	if _, err := GetItem("/onedriver_tests/undo_me.txt", auth); err != nil {
+		t.Fatal("File was deleted on the server before its grace period was over:", err)
+	}
+
+	var reply ControlReply
+	failOnErr(t, ControlCall(socket, "Control.Undo", ControlArgs{Path: "/onedriver_tests"}, &reply))
+	if reply.Count != 1 {
+		t.Fatalf("Expected 1 item to be restored, got %d\n", reply.Count)
+	}
+	content, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(content) != "undo me" {
+		t.Fatalf("Restored file had the wrong content: \"%s\"\n", content)
+	}
+}
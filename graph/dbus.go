@@ -0,0 +1,190 @@
+package graph
+
+// D-Bus integration for desktop tools like file manager plugins or shell
+// extensions. Each mounted filesystem owns a well-known name on the session bus
+// (see DBusName()) and exports a single object with the interface below. All
+// paths sent over D-Bus are absolute local paths (including the mountpoint).
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	dbusInterface = "com.github.jstaf.onedriver.Filesystem"
+	dbusPath      = dbus.ObjectPath("/com/github/jstaf/onedriver")
+)
+
+// DBusName returns the session bus name owned by the filesystem mounted at
+// mountpoint. All names start with "com.github.jstaf.onedriver." so clients can
+// find every running instance by listing bus names.
+func DBusName(mountpoint string) string {
+	abs, _ := filepath.Abs(mountpoint)
+	sum := sha1.Sum([]byte(abs))
+	return fmt.Sprintf("com.github.jstaf.onedriver.m%x", sum[:4])
+}
+
+// dbusService contains the methods callable over D-Bus
+type dbusService struct {
+	fs         *FuseFs
+	mountpoint string
+}
+
+// Mountpoint returns where the filesystem is mounted
+func (s *dbusService) Mountpoint() (string, *dbus.Error) {
+	return s.mountpoint, nil
+}
+
+// GetFileStatus returns the sync status of a single file or directory
+func (s *dbusService) GetFileStatus(path string) (string, *dbus.Error) {
+	if path != s.mountpoint && !strings.HasPrefix(path, s.mountpoint+"/") {
+		return "", dbus.MakeFailedError(fmt.Errorf("%s is not inside %s", path, s.mountpoint))
+	}
+	item, err := s.fs.items.Get(leadingSlash(strings.TrimPrefix(path, s.mountpoint)), s.fs.Auth)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return item.SyncStatus(), nil
+}
+
+// GetStats returns a summary of the filesystem's state, see CacheStats
+func (s *dbusService) GetStats() (map[string]dbus.Variant, *dbus.Error) {
+	stats := s.fs.items.Stats()
+	return map[string]dbus.Variant{
+		"items":          dbus.MakeVariant(uint32(stats.Items)),
+		"dirs":           dbus.MakeVariant(uint32(stats.Dirs)),
+		"cachedFiles":    dbus.MakeVariant(uint32(stats.CachedFiles)),
+		"cachedBytes":    dbus.MakeVariant(stats.CachedBytes),
+		"openHandles":    dbus.MakeVariant(uint32(stats.OpenHandles)),
+		"pendingUploads": dbus.MakeVariant(uint32(stats.PendingUploads)),
+		"paused":         dbus.MakeVariant(stats.Paused),
+		"readOnly":       dbus.MakeVariant(stats.ReadOnly),
+	}, nil
+}
+
+// GetErrors returns the most recent sync errors, from oldest to newest. Each
+// has a time (in RFC 3339 format), path, operation, code and message.
+func (s *dbusService) GetErrors() ([]map[string]string, *dbus.Error) {
+	entries := s.fs.errors.all()
+	errors := make([]map[string]string, 0, len(entries))
+	for _, entry := range entries {
+		errors = append(errors, map[string]string{
+			"time":      entry.Time.Format(time.RFC3339),
+			"path":      filepath.Join(s.mountpoint, entry.Path),
+			"operation": entry.Op,
+			"code":      entry.Code,
+			"message":   entry.Message,
+		})
+	}
+	return errors, nil
+}
+
+// Pause puts uploads and syncing on hold
+func (s *dbusService) Pause() *dbus.Error {
+	s.fs.items.Pause()
+	return nil
+}
+
+// Resume restarts uploads and syncing
+func (s *dbusService) Resume() *dbus.Error {
+	s.fs.items.Resume()
+	return nil
+}
+
+// dbusSignals relays sync events from the cache as D-Bus signals. Kept separate
+// from dbusService so that these methods are not exported over the bus.
+type dbusSignals struct {
+	conn       *dbus.Conn
+	mountpoint string
+}
+
+func (s *dbusSignals) StatusChanged(path string, status string) {
+	s.conn.Emit(dbusPath, dbusInterface+".FileStatusChanged",
+		filepath.Join(s.mountpoint, path), status)
+}
+
+func (s *dbusSignals) TransferProgress(path string, done uint64, total uint64) {
+	s.conn.Emit(dbusPath, dbusInterface+".TransferProgress",
+		filepath.Join(s.mountpoint, path), done, total)
+}
+
+func (s *dbusSignals) SyncError(path string, op string, err error) {
+	s.conn.Emit(dbusPath, dbusInterface+".SyncError",
+		filepath.Join(s.mountpoint, path), op, err.Error())
+}
+
+// ServeDBus exports the filesystem's sync state on the session bus and starts
+// emitting signals for sync events.
+func (fs *FuseFs) ServeDBus(mountpoint string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(mountpoint)
+
+	service := &dbusService{fs: fs, mountpoint: abs}
+	if err = conn.Export(service, dbusPath, dbusInterface); err != nil {
+		return err
+	}
+	node := &introspect.Node{
+		Name: string(dbusPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name:    dbusInterface,
+				Methods: introspect.Methods(service),
+				Signals: []introspect.Signal{
+					{Name: "FileStatusChanged", Args: []introspect.Arg{
+						{Name: "path", Type: "s"},
+						{Name: "status", Type: "s"},
+					}},
+					{Name: "TransferProgress", Args: []introspect.Arg{
+						{Name: "path", Type: "s"},
+						{Name: "done", Type: "t"},
+						{Name: "total", Type: "t"},
+					}},
+					{Name: "SyncError", Args: []introspect.Arg{
+						{Name: "path", Type: "s"},
+						{Name: "operation", Type: "s"},
+						{Name: "message", Type: "s"},
+					}},
+				},
+			},
+		},
+	}
+	err = conn.Export(introspect.NewIntrospectable(node), dbusPath,
+		"org.freedesktop.DBus.Introspectable")
+	if err != nil {
+		return err
+	}
+
+	name := DBusName(mountpoint)
+	reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("D-Bus name %s is already taken", name)
+	}
+
+	fs.items.AddListener(&dbusSignals{conn: conn, mountpoint: abs})
+	fs.mutex.Lock()
+	fs.dbusName = name
+	fs.mutex.Unlock()
+	log.WithFields(log.Fields{"name": name}).Info("Exported filesystem on D-Bus.")
+	return nil
+}
+
+// releaseDBusName gives up a name requested by ServeDBus()
+func releaseDBusName(name string) {
+	if conn, err := dbus.SessionBus(); err == nil {
+		conn.ReleaseName(name)
+	}
+}
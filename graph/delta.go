@@ -0,0 +1,143 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// decodeDeltaPage reads a page of deltas, handing each item to apply as soon as
+// it is decoded. The first sync of a big drive returns pages far too large to
+// comfortably hold in memory all at once. Returns the page's nextLink and
+// deltaLink, only one of which is set by the server.
+func decodeDeltaPage(r io.Reader, apply func(*DriveItem)) (string, string, error) {
+	var next, delta string
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return "", "", err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", "", err
+		}
+		switch token {
+		case "value":
+			if err = expectDelim(decoder, '['); err != nil {
+				return "", "", err
+			}
+			for decoder.More() {
+				item := &DriveItem{mutex: &mu.RWMutex{}}
+				if err = decoder.Decode(item); err != nil {
+					return "", "", err
+				}
+				apply(item)
+			}
+			err = expectDelim(decoder, ']')
+		case "@odata.nextLink":
+			err = decoder.Decode(&next)
+		case "@odata.deltaLink":
+			err = decoder.Decode(&delta)
+		default:
+			var skipped json.RawMessage
+			err = decoder.Decode(&skipped)
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return next, delta, expectDelim(decoder, '}')
+}
+
+// expectDelim reads the next token, which must be delim
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %s in delta page, got %v", delim, token)
+	}
+	return nil
+}
+
+// loadDeltaLink picks delta syncing up where it was left off, from the
+// checkpoint saved at path after every page
+func (c *Cache) loadDeltaLink(path string) error {
+	c.deltaFile = path
+	var link string
+	if err := loadJSON(path, &link); err != nil {
+		return err
+	}
+	if link != "" {
+		c.deltaLink = link
+	}
+	return nil
+}
+
+// saveDeltaLink checkpoints delta syncing, so a restart doesn't have to go
+// through pages that were already applied again
+func (c *Cache) saveDeltaLink() {
+	if c.deltaFile == "" {
+		return
+	}
+	if err := saveJSON(c.deltaFile, c.deltaLink); err != nil {
+		log.WithFields(log.Fields{
+			"path": c.deltaFile,
+			"err":  err,
+		}).Warn("Could not save delta link.")
+	}
+}
+
+// latestDelta is where delta syncing starts from, skipping everything that
+// already happened
+const latestDelta = "/me/drive/root/delta?token=latest"
+
+// revalidateDelta catches delta syncing up after a long time offline. The server
+// forgets old delta tokens and answers them with resyncRequired, in which case
+// syncing starts over from the drive's current state. The changes made in the
+// meantime are lost that way, so every folder that was listed is refreshed.
+func (c *Cache) revalidateDelta(auth *Auth) error {
+	for {
+		cont, err := c.pollDeltas(auth)
+		if err != nil {
+			if graphCode(err) != "resyncRequired" {
+				return err
+			}
+			log.Warn("Delta link expired while offline, refreshing cached folders.")
+			c.deltaLink = latestDelta
+			c.saveDeltaLink()
+			c.refreshListed(auth)
+			return nil
+		}
+		if !cont {
+			return nil
+		}
+	}
+}
+
+// refreshListed refreshes every folder whose children were fetched
+func (c *Cache) refreshListed(auth *Auth) {
+	var paths []string
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		item.mutex.RLock()
+		listed := item.children != nil
+		item.mutex.RUnlock()
+		if listed && item.IsDir() {
+			paths = append(paths, item.Path())
+		}
+		return true
+	})
+	for _, path := range paths {
+		if err := c.Refresh(path, auth); err != nil {
+			log.WithFields(log.Fields{
+				"path": path,
+				"err":  err,
+			}).Warn("Could not refresh folder.")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// items must be handed over one by one, wherever the links are in the page
+func TestDecodeDeltaPage(t *testing.T) {
+	page := `{
+		"@odata.context": "https://graph.microsoft.com/v1.0/$metadata",
+		"value": [
+			{"id": "a", "name": "a.txt", "size": 1},
+			{"id": "b", "name": "b", "folder": {"childCount": 0}}
+		],
+		"@odata.nextLink": "` + graphURL + `/me/drive/root/delta?token=next"
+	}`
+	var ids []string
+	next, delta, err := decodeDeltaPage(strings.NewReader(page), func(item *DriveItem) {
+		ids = append(ids, item.IDInternal)
+	})
+	failOnErr(t, err)
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b" {
+		t.Fatalf("Wrong items decoded: %v\n", ids)
+	}
+	if next != graphURL+"/me/drive/root/delta?token=next" || delta != "" {
+		t.Fatalf("Wrong links decoded: \"%s\", \"%s\"\n", next, delta)
+	}
+
+	_, _, err = decodeDeltaPage(strings.NewReader(`{"value": [{"id": "a"}`), func(*DriveItem) {})
+	if err == nil {
+		t.Fatal("Truncated page should fail to decode.")
+	}
+}
+
+func TestDeltaLinkCheckpoint(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_delta")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "delta.json")
+
+	cache := &Cache{}
+	failOnErr(t, cache.loadDeltaLink(path))
+	cache.deltaLink = "/me/drive/root/delta?token=saved"
+	cache.saveDeltaLink()
+
+	restarted := &Cache{deltaLink: "/me/drive/root/delta?token=latest"}
+	failOnErr(t, restarted.loadDeltaLink(path))
+	if restarted.deltaLink != cache.deltaLink {
+		t.Fatalf("Delta link was not restored: %s\n", restarted.deltaLink)
+	}
+}
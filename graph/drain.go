@@ -0,0 +1,85 @@
+package graph
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how long unmounting waits for changes to reach the server by default
+const defaultDrainTimeout = 30 * time.Second
+
+// how often draining checks what is left
+const drainPoll = 250 * time.Millisecond
+
+var errUnmounted = errors.New("unmounted before the change reached the server")
+
+// changesPendingLocked returns whether an item has changes that haven't made
+// it to the server yet, including ones being uploaded right now
+func (d *DriveItem) changesPendingLocked() bool {
+	return d.hasChanges || d.uploading || d.uploadSession != nil
+}
+
+// pendingChanges returns the paths of files with changes that haven't made it
+// to the server. Ignored files never get there, and don't count.
+func (c *Cache) pendingChanges() []string {
+	var paths []string
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		item.mutex.RLock()
+		pending := item.changesPendingLocked()
+		item.mutex.RUnlock()
+		if pending && !item.IsDir() {
+			if path := item.Path(); !c.ignored(path, false) {
+				paths = append(paths, path)
+			}
+		}
+		return true
+	})
+	return paths
+}
+
+// Drain sends every change still waiting for the server, and waits for them to
+// get there, for up to the drain timeout. Changes that don't make it in time are
+// saved to the retry queue, to be uploaded on the next mount. Meant to be
+// called once the filesystem has been unmounted, calls after the first one
+// wait for it and return.
+func (fs *FuseFs) Drain() {
+	fs.drained.Do(fs.drain)
+}
+
+func (fs *FuseFs) drain() {
+	if fs.items.DryRun() {
+		return
+	}
+	fs.items.FlushUploads("/")
+	fs.deletes.flush()
+	fs.mutex.RLock()
+	timeout := fs.drainTimeout
+	fs.mutex.RUnlock()
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	pending := fs.items.pendingChanges()
+	if len(pending) > 0 && !fs.items.Paused() {
+		log.WithFields(log.Fields{
+			"pending": len(pending),
+			"timeout": timeout,
+		}).Info("Waiting for changes to reach the server.")
+		for deadline := time.Now().Add(timeout); len(pending) > 0 && time.Now().Before(deadline); {
+			time.Sleep(drainPoll)
+			pending = fs.items.pendingChanges()
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	for _, path := range pending {
+		fs.retries.SyncError(path, retryOpUpload, errUnmounted)
+	}
+	log.WithFields(log.Fields{
+		"pending": len(pending),
+	}).Warn("Not all changes reached the server, they will be retried on the next mount.")
+}
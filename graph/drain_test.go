@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// changes that don't reach the server before the drain timeout should be saved
+// to be retried
+func TestDrain(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_drain")
+	defer os.RemoveAll(dir)
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	failOnErr(t, fs.UseCacheDir(dir))
+	fs.drainTimeout = 200 * time.Millisecond
+	fs.GetAttr("/Documents", nil)
+
+	startUpload(t, mock, fs, "/Documents/stuck.txt", "stuck")
+	if pending := fs.items.Stats().PendingUploads; pending != 1 {
+		mock.mutex.Unlock()
+		t.Fatalf("Expected 1 pending upload, got %d.\n", pending)
+	}
+	fs.Drain()
+	mock.mutex.Unlock()
+
+	q := newRetryQueue(fs)
+	failOnErr(t, q.load(filepath.Join(dir, "retries.json")))
+	entry, ok := q.entries[retryOpUpload+":/Documents/stuck.txt"]
+	if !ok {
+		t.Fatal("Upload that didn't finish in time was not saved:", q.entries)
+	}
+	content, err := fs.items.content.load(entry.Content)
+	failOnErr(t, err)
+	if string(content) != "stuck" {
+		t.Errorf("Saved content is \"%s\".", content)
+	}
+}
+
+// uploads flushed by draining should be waited for, even before they've had the
+// chance to really start
+func TestDrainWaitsForFlushed(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.drainTimeout = 5 * time.Second
+	fs.items.SetUploadDelay(time.Hour)
+	fs.GetAttr("/Documents", nil)
+
+	item := writeFile(t, fs, "/Documents/flushed.txt", "flushed")
+	fs.Drain()
+	if isLocalID(item.ID()) || len(fs.items.pendingChanges()) > 0 {
+		t.Fatal("Drain returned before the flushed upload was done.")
+	}
+	mock.mutex.Lock()
+	uploaded := mock.child(mock.child(mockRootID, "Documents").id, "flushed.txt")
+	mock.mutex.Unlock()
+	if uploaded == nil || string(uploaded.content) != "flushed" {
+		t.Error("Flushed file is not on the server.")
+	}
+}
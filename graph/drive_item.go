@@ -1,11 +1,11 @@
 package graph
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 	"math/rand"
 	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
@@ -18,8 +18,9 @@ import (
 // DriveItem's ID and its path)
 type DriveItemParent struct {
 	//TODO Path is technically available, but we shouldn't use it
-	Path string `json:"path,omitempty"`
-	ID   string `json:"id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	ID      string `json:"id,omitempty"`
+	DriveID string `json:"driveId,omitempty"`
 }
 
 // Folder is used for parsing only
@@ -29,7 +30,40 @@ type Folder struct {
 
 // File is used for parsing only
 type File struct {
-	MimeType string `json:"mimeType,omitempty"`
+	MimeType string  `json:"mimeType,omitempty"`
+	Hashes   *Hashes `json:"hashes,omitempty"`
+}
+
+// Hashes are the checksums the server computes for a file's content. Which
+// hashes are available depends on the type of account.
+type Hashes struct {
+	SHA1Hash     string `json:"sha1Hash,omitempty"`
+	QuickXorHash string `json:"quickXorHash,omitempty"`
+}
+
+// Photo is the photo facet of a file, from the photo's EXIF data
+type Photo struct {
+	TakenDateTime *time.Time `json:"takenDateTime,omitempty"`
+	CameraMake    string     `json:"cameraMake,omitempty"`
+	CameraModel   string     `json:"cameraModel,omitempty"`
+}
+
+// Image is the image facet of a file
+type Image struct {
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// Video is the video facet of a file
+type Video struct {
+	Width    int   `json:"width,omitempty"`
+	Height   int   `json:"height,omitempty"`
+	Duration int64 `json:"duration,omitempty"` // in milliseconds
+}
+
+// Malware is set on files the server has detected malware in
+type Malware struct {
+	Description string `json:"description,omitempty"`
 }
 
 // Deleted is used for detecting when items get deleted on the server
@@ -48,37 +82,67 @@ type DriveItem struct {
 	cache            *Cache
 	uploadSession    *UploadSession   // current upload session, or nil
 	data             *[]byte          // empty by default
+	diskID           string           // ID data is stored under in the disk cache, if the copy there matches
+	contentFile      *os.File         // the disk cache copy reads are spliced from, see contentFd()
+	regions          *regionLocks     // set up on the first write
 	hasChanges       bool             // used to trigger an upload on flush
+	openHandles      int              // number of open file handles
+	writers          int              // number of open file handles that can write
+	pinned           bool             // pinned items never have their content evicted
+	uploading        bool             // true while an upload is running
+	uploadTimer      *time.Timer      // pending delayed upload, see scheduleUploadLocked()
+	uploadFailed     bool             // if the last upload failed
+	discarded        bool             // saved over another file, or deleted while being created, see dropDiscarded()
+	hydrating        bool             // true while the content is being downloaded
 	IDInternal       string           `json:"id,omitempty"`
 	NameInternal     string           `json:"name,omitempty"`
 	SizeInternal     uint64           `json:"size,omitempty"`
 	ModTimeInternal  *time.Time       `json:"lastModifiedDatetime,omitempty"`
+	WebURLInternal   string           `json:"webUrl,omitempty"`
+	CTag             string           `json:"cTag,omitempty"` // changes with the content on the server
+	ETag             string           `json:"eTag,omitempty"` // changes with the metadata, and a folder's children
 	mode             uint32           // do not set manually
 	Parent           *DriveItemParent `json:"parentReference,omitempty"`
 	children         []string         // a slice of ids, nil when uninitialized
+	childIndex       map[string]string // ids of children by folded name, see foldName()
 	subdir           uint32           // used purely by NLink()
 	mutex            *mu.RWMutex
-	Folder           *Folder  `json:"folder,omitempty"`
-	FileInternal     *File    `json:"file,omitempty"`
-	Deleted          *Deleted `json:"deleted,omitempty"`
-	ConflictBehavior string   `json:"@microsoft.graph.conflictBehavior,omitempty"`
+	Folder           *Folder     `json:"folder,omitempty"`
+	FileInternal     *File       `json:"file,omitempty"`
+	Photo            *Photo      `json:"photo,omitempty"`
+	Image            *Image      `json:"image,omitempty"`
+	Video            *Video      `json:"video,omitempty"`
+	Malware          *Malware    `json:"malware,omitempty"`
+	RemoteItem       *RemoteItem `json:"remoteItem,omitempty"`
+	drive            string      // ID of the drive the item is in, if in a shortcut to another
+	readOnly         bool        // if the user may only view the item, see checkAccess()
+	Deleted          *Deleted    `json:"deleted,omitempty"`
+	ConflictBehavior string      `json:"@microsoft.graph.conflictBehavior,omitempty"`
 }
 
 // NewDriveItem initializes a new DriveItem
 func NewDriveItem(name string, mode uint32, parent *DriveItem) *DriveItem {
 	itemParent := &DriveItemParent{ID: "", Path: ""}
 	var cache *Cache
+	var drive string
 	if parent != nil {
-		itemParent.ID = parent.ID()
-		itemParent.Path = parent.Path()
-		
 		parent.mutex.RLock()
 		cache = parent.cache
 		parent.mutex.RUnlock()
+
+		// use the same format for the parent's path as the server does
+		var prefix string
+		if cache != nil {
+			prefix = cache.prefix
+		}
+		itemParent.ID = parent.ID()
+		itemParent.Path = "/drive/root:" + strings.TrimSuffix(prefix+parent.Path(), "/")
+		// items created inside a shortcut go in the drive it points to
+		drive, _ = parent.contentTarget()
 	}
 
 	var empty []byte
-	currentTime := time.Now()
+	currentTime := serverNow()
 	return &DriveItem{
 		File:            nodefs.NewDefaultFile(),
 		IDInternal:      localID(),
@@ -86,10 +150,12 @@ func NewDriveItem(name string, mode uint32, parent *DriveItem) *DriveItem {
 		cache:           cache, //TODO: find a way to do uploads without this field
 		Parent:          itemParent,
 		children:        make([]string, 0),
+		childIndex:      make(map[string]string),
 		mutex:           &mu.RWMutex{},
 		data:            &empty,
 		ModTimeInternal: &currentTime,
 		mode:            mode,
+		drive:           drive,
 	}
 }
 
@@ -137,79 +203,102 @@ func (d DriveItem) ID() string {
 	return d.IDInternal
 }
 
-// RemoteID uploads an empty file to obtain a Onedrive ID if it doesn't already
-// have one. This is necessary to avoid race conditions against uploads if the
-// file has not already been uploaded. You can use an empty Auth object if
+// RemoteID creates the item on the server to obtain a Onedrive ID if it doesn't
+// already have one, see Cache.remoteID(). You can use an empty Auth object if
 // you're sure that the item already has an ID or otherwise don't need to fetch
 // an ID (such as when deleting an item that is only local).
-//TODO: move this to cache methods, it's not needed here
 func (d *DriveItem) RemoteID(auth *Auth) (string, error) {
-	// copy the item so we can access it's ID without locking the item later
 	d.mutex.RLock()
-	cpy := *d
-	parentID := d.Parent.ID
+	cache := d.cache
 	d.mutex.RUnlock()
-
-	if cpy.IsDir() {
-		//TODO add checks for directory, perhaps retry the dir creation again
-		//server-side?
-		return cpy.IDInternal, nil
-	}
-
-	if isLocalID(cpy.IDInternal) && auth.AccessToken != "" {
-		uploadPath := fmt.Sprintf("/me/drive/items/%s:/%s:/content", parentID, cpy.Name())
-		resp, err := Put(uploadPath, auth, strings.NewReader(""))
-		if err != nil {
-			if strings.Contains(err.Error(), "nameAlreadyExists") {
-				// This likely got fired off just as an initial upload completed.
-				// Check both our local copy and the server.
-
-				// Do we have it (from another thread)?
-				if id := d.ID(); !isLocalID(id) {
-					return id, nil
-				}
-
-				// Does the server have it?
-				latest, err := GetItem(d.Path(), auth)
-				if err == nil {
-					// hooray!
-					err := d.cache.MoveID(cpy.IDInternal, latest.IDInternal)
-					return latest.IDInternal, err
-				}
-			}
-			// failed to obtain an ID, return whatever it was beforehand
-			return cpy.IDInternal, err
-		}
-
-		// we use a new DriveItem to unmarshal things into or it will fuck
-		// with the existing object (namely its size)
-		unsafe := NewDriveItem(cpy.Name(), 0644, nil)
-		err = json.Unmarshal(resp, unsafe)
-		if err != nil {
-			return cpy.IDInternal, err
-		}
-		// this is all we really wanted from this transaction
-		err = d.cache.MoveID(cpy.IDInternal, unsafe.IDInternal)
-		return unsafe.IDInternal, err
-	}
-	return cpy.IDInternal, nil
+	return cache.remoteID(d, auth)
 }
 
 // Path returns an item's full Path
 func (d DriveItem) Path() string {
 	// special case when it's the root item
-	if d.Parent.ID == "" && d.Name() == "root" {
+	if d.Parent.ID == "" && d.Name() == "root" ||
+		d.cache != nil && d.IDInternal == d.cache.root {
 		return "/"
 	}
 
-	// all paths come prefixed with "/drive/root:"
+	// all paths come prefixed with "/drive/root:", followed by the path of the
+	// filesystem's root if it isn't the root of the drive
 	prepath := strings.TrimPrefix(d.Parent.Path+"/"+d.Name(), "/drive/root:")
+	if d.cache != nil {
+		prepath = strings.TrimPrefix(prepath, d.cache.prefix)
+	}
 	return strings.Replace(prepath, "//", "/", -1)
 }
 
+// updateMetadata copies server-side metadata from a freshly fetched copy of the
+// same item. Items with local changes keep their size and modification time.
+// Cached content is dropped if it no longer matches the server's copy and is safe
+// to throw away. Returns true if the item has local changes, but the content on
+// the server changed since too.
+func (d *DriveItem) updateMetadata(remote *DriveItem) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.NameInternal = remote.NameInternal
+	if d.drive == "" {
+		// items in other drives are filed under the shortcut they're in
+		d.Parent = remote.Parent
+	}
+	d.Folder = remote.Folder
+	d.FileInternal = remote.FileInternal
+	d.WebURLInternal = remote.WebURLInternal
+	d.Photo = remote.Photo
+	d.Image = remote.Image
+	d.Video = remote.Video
+	d.Malware = remote.Malware
+	d.RemoteItem = remote.RemoteItem
+	d.ETag = remote.ETag
+	if d.hasChanges || d.uploadSession != nil {
+		if d.IsDir() {
+			return false
+		}
+		if d.CTag == "" || remote.CTag == "" {
+			// items cached before cTags were kept only have their timestamps
+			return remoteNewer(d.ModTimeInternal, remote.ModTimeInternal)
+		}
+		return d.CTag != remote.CTag
+	}
+	d.CTag = remote.CTag
+	modified := d.SizeInternal != remote.SizeInternal ||
+		d.ModTimeInternal == nil || remote.ModTimeInternal == nil ||
+		!d.ModTimeInternal.Equal(*remote.ModTimeInternal)
+	d.SizeInternal = remote.SizeInternal
+	if !d.IsDir() || !remoteNewer(remote.ModTimeInternal, d.ModTimeInternal) {
+		// the server doesn't bump folders for everything that changes in them,
+		// so folders never go back to an older time than they already have
+		d.ModTimeInternal = remote.ModTimeInternal
+	}
+	if modified && d.openHandles == 0 {
+		d.data = nil
+		d.diskID = ""
+	}
+	return false
+}
+
+// errMalware is returned when trying to download a file the server has detected
+// malware in
+var errMalware = errors.New("the server detected malware in this file, " +
+	"refusing to download it")
+
 // FetchContent fetches a DriveItem's content and initializes the .Data field.
+// Files the server has detected malware in are never downloaded.
 func (d *DriveItem) FetchContent(auth *Auth) error {
-	id, err := d.RemoteID(auth)
+	d.mutex.RLock()
+	malware := d.Malware != nil
+	cache := d.cache
+	d.mutex.RUnlock()
+	if malware {
+		return errMalware
+	}
+	if cache != nil && cache.watchdog.lowMemory() {
+		return errLowMemory
+	}
+	_, err := d.RemoteID(auth)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id": d.ID(),
@@ -218,39 +307,127 @@ func (d *DriveItem) FetchContent(auth *Auth) error {
 		}).Error("Could not obtain remote ID.")
 		return err
 	}
-	body, err := Get("/me/drive/items/"+id+"/content", auth)
-	if err != nil {
-		return err
+	d.mutex.Lock()
+	d.hydrating = true
+	d.mutex.Unlock()
+	d.notifyStatus()
+
+	// shortcuts are downloaded from what they point to
+	drive, id := d.contentTarget()
+	body, cached := []byte(nil), false
+	if cache != nil {
+		body, cached = cache.cachedContent(d, id)
+	}
+	if !cached {
+		body, err = Get("/me/drive/items/"+id+"/content", scopedAuth(auth, drive))
+		if err != nil {
+			d.mutex.Lock()
+			d.hydrating = false
+			d.mutex.Unlock()
+			d.notifyStatus()
+			return err
+		}
+		if cache != nil {
+			cached = cache.cacheContent(d, id, body)
+		}
 	}
 	d.mutex.Lock()
+	d.hydrating = false
 	d.data = &body
+	d.diskID = ""
+	if cached {
+		d.diskID = id
+	}
 	d.File = nodefs.NewDefaultFile()
 	d.mutex.Unlock()
+	d.notifyStatus()
 	return nil
 }
 
+// hydrated returns whether the item's content is in memory
+func (d *DriveItem) hydrated() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.data != nil
+}
+
+// discardContent empties the item, without its content having to be fetched
+// first
+func (d *DriveItem) discardContent() {
+	d.mutex.Lock()
+	oldSize := d.SizeInternal
+	empty := make([]byte, 0)
+	d.data = &empty
+	d.diskID = ""
+	d.SizeInternal = 0
+	d.File = nodefs.NewDefaultFile()
+	wasClean := !d.hasChanges
+	if oldSize > 0 {
+		d.hasChanges = true
+	}
+	quota := d.quotaLocked()
+	d.mutex.Unlock()
+	if quota != nil {
+		quota.addWritten(-int64(oldSize))
+	}
+
+	if wasClean && oldSize > 0 {
+		d.notifyStatus()
+	}
+}
+
 // Read from a DriveItem like a file
-func (d DriveItem) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
-	end := int(off) + int(len(buf))
-	if size := int(d.Size()); end > size {
-		// d.Size() called once for one fewer RLock
-		end = size
+func (d *DriveItem) Read(buf []byte, off int64) (fuse.ReadResult, fuse.Status) {
+	if off < 0 {
+		return nil, fuse.EINVAL
 	}
 	log.WithFields(log.Fields{
 		"id": d.ID(),
 		"path": d.Path(),
-		"bufsize": int64(end)-off,
+		"bufsize": len(buf),
 		"offset": off,
 	}).Trace("Read file")
-	
+
+	if fd, ok := d.contentFd(); ok {
+		size := int64(d.Size())
+		if off >= size {
+			return fuse.ReadResultData(buf[:0]), fuse.OK
+		}
+		n := int64(len(buf))
+		if off+n > size {
+			n = size - off
+		}
+		// a truncate since only makes this come back short
+		return fuse.ReadResultFd(fd, off, int(n)), fuse.OK
+	}
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
-	return fuse.ReadResultData((*d.data)[off:end]), fuse.OK
+	// the bounds are only known while locked, the file may have been truncated
+	if d.data == nil || off >= int64(len(*d.data)) {
+		return fuse.ReadResultData(buf[:0]), fuse.OK
+	}
+	end := off + int64(len(buf))
+	if size := int64(len(*d.data)); end > size {
+		end = size
+	}
+	if d.regions != nil {
+		defer d.regions.lock(int(off), int(end-off), false)()
+	}
+	// copied while locked, the reply is only sent once the locks are released
+	n := copy(buf, (*d.data)[off:end])
+	return fuse.ReadResultData(buf[:n]), fuse.OK
 }
 
 // Write to a DriveItem like a file. Note that changes are 100% local until
-// Flush() is called.
+// the last writable handle is released.
 func (d *DriveItem) Write(data []byte, off int64) (uint32, fuse.Status) {
+	return d.write(data, off, false)
+}
+
+// write writes data at off, or at the end of the file if appending. Appends go
+// wherever the end is once the item is locked, the offset the kernel picked may
+// be out of date.
+func (d *DriveItem) write(data []byte, off int64, appending bool) (uint32, fuse.Status) {
 	nWrite := len(data)
 	offset := int(off)
 	log.WithFields(log.Fields{
@@ -258,44 +435,186 @@ func (d *DriveItem) Write(data []byte, off int64) (uint32, fuse.Status) {
 		"path": d.Path(),
 		"bufsize": nWrite,
 		"offset": off,
+		"append": appending,
 	}).Tracef("Write file")
 
+	// writes inside the file don't hold up reads and writes of other parts of it
+	inPlace := !appending && d.writeInPlace(data, offset)
+
 	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	if offset+nWrite > int(d.SizeInternal)-1 {
-		// we've exceeded the file size, overwrite via append
-		*d.data = append((*d.data)[:offset], data...)
-	} else {
-		// writing inside the current file, overwrite in place
+	if d.regions == nil {
+		d.regions = &regionLocks{}
+	}
+	oldSize := d.SizeInternal
+	if appending {
+		offset = int(oldSize)
+	}
+	quota := d.quotaLocked()
+	if grow := offset + nWrite - int(oldSize); grow > 0 && quota != nil &&
+		!quota.room(uint64(grow)) {
+		d.mutex.Unlock()
+		return 0, fuse.Status(syscall.ENOSPC)
+	}
+	if d.data == nil {
+		// Open() fetches the content first, this should never happen
+		d.mutex.Unlock()
+		log.WithFields(log.Fields{
+			"id": d.ID(),
+			"path": d.Path(),
+		}).Error("Write to item whose content was never fetched.")
+		return 0, fuse.EIO
+	}
+	d.diskID = ""
+	if !inPlace {
+		if end := offset + nWrite; end > len(*d.data) {
+			// writing past the end of the file grows it
+			*d.data = resizeContent(*d.data, end)
+		}
 		copy((*d.data)[offset:], data)
 	}
-	// probably a better way to do this, but whatever
 	d.SizeInternal = uint64(len(*d.data))
+	grown := int64(d.SizeInternal) - int64(oldSize)
+	wasClean := !d.hasChanges
 	d.hasChanges = true
+	d.mutex.Unlock()
+	if quota != nil {
+		quota.addWritten(grown)
+	}
 
+	if wasClean {
+		d.notifyStatus()
+	}
 	return uint32(nWrite), fuse.OK
 }
 
-// Flush is called when a file descriptor is closed. This is responsible for all
-// uploads of file contents.
+// Flush is called every time a file descriptor on the item is closed, which can
+// happen several times per handle (for dup()ed descriptors) and before the last
+// writes to a shared mmap() came in. Nothing is uploaded here, that waits for
+// the last writable handle to be released. It only reports changes that can't
+// be uploaded, so close() fails for them.
 func (d *DriveItem) Flush() fuse.Status {
 	log.WithFields(log.Fields{"path": d.Path()}).Debug()
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.hasChanges && d.cache == nil {
+		log.WithFields(log.Fields{
+			"id": d.IDInternal,
+			"name": d.NameInternal,
+		}).Error("Driveitem cache ref cannot be nil!")
+		return fuse.ENODATA
+	}
+	return fuse.OK
+}
+
+// uploadLocked starts uploading the item if it changed. The item must be locked.
+func (d *DriveItem) uploadLocked() {
+	if !d.hasChanges || d.cache == nil {
+		return
+	}
+	if d.cache.Paused() {
+		// leave the item marked as changed, it gets uploaded on resume
+		return
+	}
+	if d.uploading {
+		// Two uploads at once could finish in either order, leaving the server
+		// with the older content. The running one starts the next when done.
+		return
+	}
+	if !d.scheduleUploadLocked() {
+		d.uploadNowLocked()
+	}
+}
+
+// uploadNowLocked starts uploading the item's changes without waiting for the
+// upload delay
+func (d *DriveItem) uploadNowLocked() {
+	// claimed before the goroutine starts, so nothing starts a second upload
+	// while this one is still hashing the content, see Upload()
+	d.claimUploadLocked()
+	// ensureID() is no longer used here to make upload dispatch even faster
+	// (since upload is using ensureID() internally)
+	go d.Upload(d.cache.auth)
+}
+
+// claimUploadLocked marks the item's changes as being uploaded, whoever calls it
+// has to run Upload() next. The item must be locked.
+func (d *DriveItem) claimUploadLocked() {
+	if d.uploadTimer != nil {
+		d.uploadTimer.Stop()
+		d.uploadTimer = nil
+	}
+	d.hasChanges = false
+	d.uploading = true
+}
+
+// Release is called when a read-only handle on the item is closed for good
+func (d *DriveItem) Release() {
+	d.release(false)
+}
+
+// release keeps track of open handles, and uploads the item's changes once the
+// last handle that can write is released. Not before: writes to a shared mmap()
+// can outlive the file descriptor, the kernel only writes the mapping's dirty
+// pages back when it is unmapped, just before the release.
+func (d *DriveItem) release(writable bool) {
+	d.mutex.Lock()
+	if d.openHandles > 0 {
+		d.openHandles--
+	}
+	if writable && d.writers > 0 {
+		d.writers--
+	}
+	if d.writers == 0 {
+		d.uploadLocked()
+	}
+	if d.openHandles == 0 && d.contentFile != nil {
+		// no reads can still be splicing from it
+		d.contentFile.Close()
+		d.contentFile = nil
+	}
+	d.mutex.Unlock()
+}
+
+// contentFd returns a file descriptor on the copy of the item's content in the
+// disk cache, if reads can be served from it. The kernel then splices the data
+// straight from the cache file instead of it being copied out of memory. The
+// file stays open until the last handle is released, even once the content
+// changed, as replies to earlier reads may still be spliced from it.
+func (d *DriveItem) contentFd() (uintptr, bool) {
+	d.mutex.RLock()
+	if d.diskID == "" || d.openHandles == 0 || d.cache == nil || d.cache.content == nil {
+		d.mutex.RUnlock()
+		return 0, false
+	}
+	if d.contentFile != nil {
+		fd := d.contentFile.Fd()
+		d.mutex.RUnlock()
+		return fd, true
+	}
+	d.mutex.RUnlock()
+
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
-	if d.hasChanges {
-		d.hasChanges = false
-		// ensureID() is no longer used here to make upload dispatch even faster
-		// (since upload is using ensureID() internally)
-		if d.cache == nil {
-			log.WithFields(log.Fields{
-				"id": d.ID(),
-				"name": d.Name(),
-			}).Error("Driveitem cache ref cannot be nil!")
-			return fuse.ENODATA
+	if d.diskID == "" || d.openHandles == 0 {
+		return 0, false
+	}
+	if d.contentFile == nil {
+		file, err := d.cache.content.open(d.diskID)
+		if err != nil {
+			// serve reads from memory instead
+			d.diskID = ""
+			return 0, false
 		}
-		go d.Upload(d.cache.auth)
+		d.contentFile = file
 	}
-	return fuse.OK
+	return d.contentFile.Fd(), true
+}
+
+// opened records that a new file handle was opened for this item
+func (d *DriveItem) opened() {
+	d.mutex.Lock()
+	d.openHandles++
+	d.mutex.Unlock()
 }
 
 // GetAttr returns a the DriveItem as a UNIX stat. Holds the read mutex for all
@@ -314,9 +633,20 @@ func (d DriveItem) GetAttr(out *fuse.Attr) fuse.Status {
 	return fuse.OK
 }
 
+// touchLocked sets the item's modification time to now, for folders whose
+// children were added, removed or renamed. The item must be locked.
+func (d *DriveItem) touchLocked() {
+	now := serverNow()
+	d.ModTimeInternal = &now
+}
+
 // Utimens sets the access/modify times of a file
 func (d *DriveItem) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
 	log.WithFields(log.Fields{"path": d.Path()}).Trace()
+	if mtime != nil {
+		corrected := serverTime(*mtime)
+		mtime = &corrected
+	}
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	d.ModTimeInternal = mtime
@@ -327,13 +657,34 @@ func (d *DriveItem) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
 func (d *DriveItem) Truncate(size uint64) fuse.Status {
 	log.WithFields(log.Fields{"path": d.Path()}).Debug()
 	d.mutex.Lock()
-	defer d.mutex.Unlock()
-	*d.data = (*d.data)[:size]
+	oldSize := d.SizeInternal
+	d.diskID = ""
+	*d.data = resizeContent(*d.data, int(size))
 	d.SizeInternal = size
+	wasClean := !d.hasChanges
 	d.hasChanges = true
+	quota := d.quotaLocked()
+	d.mutex.Unlock()
+	if quota != nil {
+		quota.addWritten(int64(size) - int64(oldSize))
+	}
+
+	if wasClean {
+		d.notifyStatus()
+	}
 	return fuse.OK
 }
 
+// resizeContent shrinks or grows content to size. Growing it fills the gap with
+// zeroes, like a file that was truncated to a larger size or written past its
+// end - even where the slice still holds bytes left over from before it shrank.
+func resizeContent(content []byte, size int) []byte {
+	if size <= len(content) {
+		return content[:size]
+	}
+	return append(content, make([]byte, size-len(content))...)
+}
+
 // IsDir returns if it is a directory (true) or file (false).
 func (d DriveItem) IsDir() bool {
 	// following statement returns 0 if the dir bit is not set
@@ -343,15 +694,27 @@ func (d DriveItem) IsDir() bool {
 // Mode returns the permissions/mode of the file.
 func (d DriveItem) Mode() uint32 {
 	if d.mode == 0 { // only 0 if fetched from Graph API
-		if d.FileInternal == nil { // nil if a folder
+		// nil if a folder, shortcuts have the facet of what they point to
+		if d.FileInternal == nil && (d.RemoteItem == nil || d.RemoteItem.FileInternal == nil) {
 			d.mode = fuse.S_IFDIR | 0755
 		} else {
 			d.mode = fuse.S_IFREG | 0644
 		}
 	}
+	if d.readOnly {
+		return d.mode &^ 0222
+	}
 	return d.mode
 }
 
+// ReadOnly returns whether the item was shared with the user with view-only
+// rights
+func (d DriveItem) ReadOnly() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.readOnly
+}
+
 // Chmod changes the mode of a file
 func (d *DriveItem) Chmod(perms uint32) fuse.Status {
 	log.WithFields(log.Fields{"path": d.Path()}).Debug()
@@ -371,12 +734,36 @@ func (d DriveItem) ModTime() uint64 {
 	return uint64(d.ModTimeInternal.Unix())
 }
 
+// WebURL returns the address of the item on the OneDrive website, or "" if it
+// hasn't been uploaded yet
+func (d DriveItem) WebURL() string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.WebURLInternal
+}
+
+// MimeType returns the MIME type reported by the server, or "" for folders
+func (d DriveItem) MimeType() string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.FileInternal == nil {
+		return ""
+	}
+	return d.FileInternal.MimeType
+}
+
 // NLink gives the number of hard links to an inode (or child count if a
 // directory)
 func (d DriveItem) NLink() uint32 {
 	if d.IsDir() {
 		d.mutex.RLock()
 		defer d.mutex.RUnlock()
+		if d.children == nil && d.Folder != nil {
+			// Children haven't been fetched. The server only tells us how many
+			// there are, not how many are folders, so go with the upper bound -
+			// "find" and friends skip subfolders if the link count is too low.
+			return 2 + d.Folder.ChildCount
+		}
 		// we precompute d.subdir due to mutex lock contention with NLink and
 		// other ops. d.subdir is modified by cache Insert/Delete and GetChildren.
 		return 2 + d.subdir
@@ -384,6 +771,24 @@ func (d DriveItem) NLink() uint32 {
 	return 1
 }
 
+// ChildCount returns how many children a folder has, without fetching them if
+// they aren't cached
+func (d DriveItem) ChildCount() uint32 {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.childCountLocked()
+}
+
+func (d DriveItem) childCountLocked() uint32 {
+	if d.children != nil {
+		return uint32(len(d.children))
+	}
+	if d.Folder != nil {
+		return d.Folder.ChildCount
+	}
+	return 0
+}
+
 // Size pretends that folders are 4096 bytes, even though they're 0 (since
 // they actually don't exist).
 func (d DriveItem) Size() uint64 {
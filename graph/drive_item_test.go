@@ -42,3 +42,90 @@ func TestIsDir(t *testing.T) {
 		t.Fatal("Intro to Onedrive.pdf not detected as a file")
 	}
 }
+
+// reads should stay within the content, whatever offset they ask for
+func TestReadBounds(t *testing.T) {
+	item := NewDriveItem("file.txt", 0644, nil)
+	data := []byte("some file content")
+	item.data = &data
+	item.SizeInternal = uint64(len(data))
+
+	read := func(off int64, size int) (string, fuse.Status) {
+		buf := make([]byte, size)
+		result, status := item.Read(buf, off)
+		if status != fuse.OK {
+			return "", status
+		}
+		content, _ := result.Bytes(buf)
+		return string(content), status
+	}
+	for _, test := range []struct {
+		off      int64
+		size     int
+		expected string
+	}{
+		{0, 4, "some"},
+		{10, 100, "content"},
+		{17, 10, ""},
+		{100, 10, ""},
+	} {
+		if content, _ := read(test.off, test.size); content != test.expected {
+			t.Errorf("Read %d bytes at %d got \"%s\", expected \"%s\"\n",
+				test.size, test.off, content, test.expected)
+		}
+	}
+	if _, status := read(-1, 10); status != fuse.EINVAL {
+		t.Error("Reading at a negative offset should fail with EINVAL, got", status)
+	}
+
+	// size no longer matching the content, like during a truncate
+	item.SizeInternal = 100
+	if content, _ := read(10, 100); content != "content" {
+		t.Errorf("Read past the content got \"%s\"\n", content)
+	}
+}
+
+// writes at and past the end of the content should grow it, with any gap filled
+// with zeroes
+func TestWriteBoundaries(t *testing.T) {
+	for _, test := range []struct {
+		off      int64
+		data     string
+		expected string
+	}{
+		{0, "SOME", "SOME file content"},
+		{13, "tent", "some file content"}, // ends right at the end
+		{16, "T", "some file contenT"},
+		{13, "tents", "some file contents"},
+		{17, "!", "some file content!"},
+		{19, "!", "some file content\x00\x00!"},
+	} {
+		item := NewDriveItem("file.txt", 0644, nil)
+		data := []byte("some file content")
+		item.data = &data
+		item.SizeInternal = uint64(len(data))
+		item.regions = &regionLocks{}
+		if n, status := item.Write([]byte(test.data), test.off); status != fuse.OK || int(n) != len(test.data) {
+			t.Fatalf("Write at %d failed: %s\n", test.off, status)
+		}
+		if string(*item.data) != test.expected || item.Size() != uint64(len(test.expected)) {
+			t.Errorf("Writing \"%s\" at %d got %q, expected %q\n",
+				test.data, test.off, *item.data, test.expected)
+		}
+	}
+
+	// bytes left over from before a truncate must not come back
+	item := NewDriveItem("file.txt", 0644, nil)
+	data := []byte("some file content")
+	item.data = &data
+	item.SizeInternal = uint64(len(data))
+	item.Truncate(4)
+	item.Write([]byte("!"), 10)
+	if string(*item.data) != "some\x00\x00\x00\x00\x00\x00!" {
+		t.Errorf("Got %q after writing past a truncated end.\n", *item.data)
+	}
+	item.Truncate(20)
+	if item.Size() != 20 || (*item.data)[19] != 0 {
+		t.Error("Truncating to a larger size should fill the file with zeroes.")
+	}
+}
@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/hanwen/go-fuse/fuse/pathfs"
+)
+
+// GetDrives lists the drives the signed in user has direct access to
+func GetDrives(auth *Auth) ([]Drive, error) {
+	body, err := Get("/me/drives", auth)
+	if err != nil {
+		return nil, err
+	}
+	var drives struct {
+		Values []Drive `json:"value"`
+	}
+	err = json.Unmarshal(body, &drives)
+	return drives.Values, err
+}
+
+// ForDrive returns auth for accessing a drive other than the user's own
+// OneDrive, like a SharePoint document library. Requests made with it for
+// resources under "/me/drive" go to that drive instead, so a Cache or FuseFs
+// created with it uses that drive. The tokens are shared with auth.
+func (a *Auth) ForDrive(driveID string) *Auth {
+	return &Auth{drive: driveID, account: a.tokens(), op: a.op}
+}
+
+// tokens returns the auth whose tokens are actually used to make requests
+func (a *Auth) tokens() *Auth {
+	if a.account != nil {
+		return a.account
+	}
+	return a
+}
+
+// driveResource rewrites a resource under "/me/drive" for the drive auth is
+// scoped to, if any
+func (a *Auth) driveResource(resource string) string {
+	if a.drive == "" {
+		return resource
+	}
+	if resource == "/me/drive" || strings.HasPrefix(resource, "/me/drive/") {
+		return "/drives/" + a.drive + strings.TrimPrefix(resource, "/me/drive")
+	}
+	return resource
+}
+
+// multiRoot is the root directory of a filesystem with several drives mounted
+// under it, see MountAll()
+type multiRoot struct {
+	nodefs.Node
+	names []string
+}
+
+func (r *multiRoot) GetAttr(out *fuse.Attr, file nodefs.File, context *fuse.Context) fuse.Status {
+	*out = *virtualAttr(true, 0, 0)
+	out.Nlink = uint32(2 + len(r.names))
+	return fuse.OK
+}
+
+func (r *multiRoot) OpenDir(context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	entries := make([]fuse.DirEntry, 0, len(r.names))
+	for _, name := range r.names {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: fuse.S_IFDIR | 0755})
+	}
+	return entries, fuse.OK
+}
+
+// MountAll mounts several filesystems under one mountpoint, each one as a
+// directory named after its key. The directories belong to separate drives, so
+// items can't be moved between them.
+func MountAll(mountpoint string, filesystems map[string]*FuseFs) (*fuse.Server, error) {
+	root := &multiRoot{Node: nodefs.NewDefaultNode()}
+	for name := range filesystems {
+		root.names = append(root.names, name)
+	}
+	sort.Strings(root.names)
+	server, conn, err := nodefs.MountRoot(mountpoint, root, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, fs := range filesystems {
+		status := conn.Mount(root.Inode(), name, pathfs.NewPathNodeFs(fs, nil).Root(), nil)
+		if !status.Ok() {
+			server.Unmount()
+			return nil, fmt.Errorf("could not mount %s: %s", name, status)
+		}
+	}
+	return server, nil
+}
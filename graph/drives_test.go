@@ -0,0 +1,23 @@
+package graph
+
+import "testing"
+
+// auth scoped to another drive should only rewrite requests for the user's drive
+func TestDriveResource(t *testing.T) {
+	auth := (&Auth{}).ForDrive("b!abc")
+	tests := map[string]string{
+		"/me/drive":                 "/drives/b!abc",
+		"/me/drive/root:/notes.txt": "/drives/b!abc/root:/notes.txt",
+		"/me/drive/items/1/content": "/drives/b!abc/items/1/content",
+		"/me/drives":                "/me/drives",
+		"/me":                       "/me",
+	}
+	for resource, expected := range tests {
+		if result := auth.driveResource(resource); result != expected {
+			t.Errorf("Expected %s to become %s, got %s\n", resource, expected, result)
+		}
+	}
+	if result := (&Auth{}).driveResource("/me/drive"); result != "/me/drive" {
+		t.Error("Unscoped auth rewrote a request:", result)
+	}
+}
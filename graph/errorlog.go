@@ -0,0 +1,115 @@
+package graph
+
+import (
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// how many sync errors the error log remembers
+const errorLogSize = 500
+
+// SyncErrorEntry is a sync error remembered by the error log
+type SyncErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Op      string    `json:"op"`
+	Code    string    `json:"code,omitempty"` // Graph's error code, if the server refused
+	Message string    `json:"message"`
+}
+
+// errorLog is a SyncListener that keeps the most recent sync errors in a ring
+// buffer, so it's possible to find out which files failed to upload long after
+// the fact. It is saved to the cache directory, so errors survive a restart.
+type errorLog struct {
+	mutex   *mu.Mutex
+	entries []SyncErrorEntry
+	next    int    // where the next entry goes once the buffer is full
+	path    string // where the log is saved, "" if it isn't
+}
+
+func newErrorLog() *errorLog {
+	return &errorLog{mutex: &mu.Mutex{}}
+}
+
+// load restores the log saved at path, and saves it there from now on
+func (e *errorLog) load(path string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.path = path
+	var entries []SyncErrorEntry
+	if err := loadJSON(path, &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		e.addLocked(entry)
+	}
+	return nil
+}
+
+// save persists the log, must be called with the mutex held
+func (e *errorLog) save() {
+	if e.path == "" {
+		return
+	}
+	if err := saveJSON(e.path, e.allLocked()); err != nil {
+		log.WithFields(log.Fields{
+			"path": e.path,
+			"err":  err,
+		}).Warn("Could not save error log.")
+	}
+}
+
+// addLocked adds an entry, overwriting the oldest one once the log is full. The
+// mutex must be held.
+func (e *errorLog) addLocked(entry SyncErrorEntry) {
+	if len(e.entries) < errorLogSize {
+		e.entries = append(e.entries, entry)
+		return
+	}
+	e.entries[e.next] = entry
+	e.next = (e.next + 1) % errorLogSize
+}
+
+// allLocked returns the entries from oldest to newest, the mutex must be held
+func (e *errorLog) allLocked() []SyncErrorEntry {
+	all := make([]SyncErrorEntry, 0, len(e.entries))
+	all = append(all, e.entries[e.next:]...)
+	return append(all, e.entries[:e.next]...)
+}
+
+// all returns the remembered sync errors, from oldest to newest
+func (e *errorLog) all() []SyncErrorEntry {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.allLocked()
+}
+
+// graphCode returns the Graph error code of an error made by responseError(), or
+// "" for any other error
+func graphCode(err error) string {
+	code := strings.SplitN(err.Error(), ": ", 2)[0]
+	if code == err.Error() || strings.ContainsAny(code, " /.") {
+		return ""
+	}
+	return code
+}
+
+func (e *errorLog) SyncError(path string, op string, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.addLocked(SyncErrorEntry{
+		Time:    time.Now(),
+		Path:    path,
+		Op:      op,
+		Code:    graphCode(err),
+		Message: err.Error(),
+	})
+	e.save()
+}
+
+func (e *errorLog) StatusChanged(path string, status string) {}
+
+func (e *errorLog) TransferProgress(path string, done uint64, total uint64) {}
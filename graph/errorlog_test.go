@@ -0,0 +1,51 @@
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the error log should only keep the newest errors, in order, across restarts
+func TestErrorLog(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_errors")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "errors.json")
+	errs := newErrorLog()
+	failOnErr(t, errs.load(path))
+	for i := 0; i < errorLogSize+10; i++ {
+		errs.SyncError(fmt.Sprintf("/file%d.txt", i), "upload",
+			errors.New("nameAlreadyExists: Name already exists"))
+	}
+
+	reloaded := newErrorLog()
+	failOnErr(t, reloaded.load(path))
+	for _, e := range []*errorLog{errs, reloaded} {
+		all := e.all()
+		if len(all) != errorLogSize {
+			t.Fatalf("Expected %d errors, got %d\n", errorLogSize, len(all))
+		}
+		if all[0].Path != "/file10.txt" || all[len(all)-1].Path != fmt.Sprintf("/file%d.txt", errorLogSize+9) {
+			t.Errorf("Errors out of order, from %s to %s\n", all[0].Path, all[len(all)-1].Path)
+		}
+		if all[0].Code != "nameAlreadyExists" || all[0].Op != "upload" {
+			t.Errorf("Wrong code or operation: %+v\n", all[0])
+		}
+	}
+}
+
+func TestGraphCode(t *testing.T) {
+	tests := map[string]string{
+		"quotaLimitReached: Insufficient Space Available":            "quotaLimitReached",
+		"Get https://graph.microsoft.com/v1.0/me: dial tcp: timeout": "",
+		"Cannot make a request with empty auth":                      "",
+	}
+	for message, expected := range tests {
+		if code := graphCode(errors.New(message)); code != expected {
+			t.Errorf("Code of %q was %q, expected %q\n", message, code, expected)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// Sync statuses reported for individual items
+const (
+	StatusCloud     = "cloud-only" // only metadata is cached, content is on the server
+	StatusHydrating = "hydrating"  // content is being downloaded
+	StatusSynced    = "synced"     // local content matches the server
+	StatusDirty     = "dirty"      // local changes have not been uploaded yet
+	StatusUploading = "uploading"  // an upload is in progress
+	StatusError     = "error"      // the last upload failed, see the retry queue
+)
+
+// SyncListener is notified of changes to the sync state of a filesystem. Paths
+// are relative to the root of the filesystem. Listeners are called synchronously
+// from whatever goroutine made the change, so they should return quickly.
+type SyncListener interface {
+	StatusChanged(path string, status string)
+	TransferProgress(path string, done uint64, total uint64)
+	SyncError(path string, op string, err error)
+}
+
+// listeners is the set of SyncListeners registered with a Cache
+type listeners struct {
+	mutex *mu.RWMutex
+	all   []SyncListener
+}
+
+func newListeners() *listeners {
+	return &listeners{mutex: &mu.RWMutex{}}
+}
+
+// AddListener registers a SyncListener to be notified of sync events
+func (c *Cache) AddListener(listener SyncListener) {
+	c.listeners.mutex.Lock()
+	c.listeners.all = append(c.listeners.all, listener)
+	c.listeners.mutex.Unlock()
+}
+
+func (l *listeners) statusChanged(path string, status string) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, listener := range l.all {
+		listener.StatusChanged(path, status)
+	}
+}
+
+func (l *listeners) transferProgress(path string, done uint64, total uint64) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, listener := range l.all {
+		listener.TransferProgress(path, done, total)
+	}
+}
+
+func (l *listeners) syncError(path string, op string, err error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for _, listener := range l.all {
+		listener.SyncError(path, op, err)
+	}
+}
+
+// SyncStatus returns the current sync status of an item
+func (d *DriveItem) SyncStatus() string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.syncStatusLocked()
+}
+
+// syncStatusLocked is SyncStatus, the mutex must be held. Folders have no
+// content, they are synced once they exist on the server.
+func (d *DriveItem) syncStatusLocked() string {
+	switch {
+	case d.uploading || d.uploadSession != nil:
+		return StatusUploading
+	case d.hydrating:
+		return StatusHydrating
+	case d.uploadFailed:
+		return StatusError
+	case d.hasChanges:
+		return StatusDirty
+	case d.Mode()&fuse.S_IFDIR != 0:
+		if isLocalID(d.IDInternal) {
+			return StatusDirty
+		}
+		return StatusSynced
+	case d.data == nil:
+		return StatusCloud
+	default:
+		return StatusSynced
+	}
+}
+
+// notifyStatus tells the cache's listeners about the item's current status. Must
+// not be called with the item's mutex held.
+func (d *DriveItem) notifyStatus() {
+	d.mutex.RLock()
+	cache := d.cache
+	d.mutex.RUnlock()
+	if cache != nil {
+		cache.listeners.statusChanged(d.Path(), d.SyncStatus())
+	}
+}
+
+// notifyError tells the cache's listeners that an operation on the item failed
+func (d *DriveItem) notifyError(op string, err error) {
+	d.mutex.RLock()
+	cache := d.cache
+	d.mutex.RUnlock()
+	if cache != nil {
+		cache.listeners.syncError(d.Path(), op, err)
+	}
+}
+
+// notifyProgress tells the cache's listeners how far along a transfer is
+func (d *DriveItem) notifyProgress(done uint64, total uint64) {
+	d.mutex.RLock()
+	cache := d.cache
+	d.mutex.RUnlock()
+	if cache != nil {
+		cache.listeners.transferProgress(d.Path(), done, total)
+	}
+}
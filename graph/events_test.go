@@ -0,0 +1,111 @@
+package graph
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeListener records the events it receives
+type fakeListener struct {
+	mutex    sync.Mutex
+	statuses map[string][]string
+	errors   map[string]error
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{
+		statuses: make(map[string][]string),
+		errors:   make(map[string]error),
+	}
+}
+
+func (l *fakeListener) StatusChanged(path string, status string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.statuses[path] = append(l.statuses[path], status)
+}
+
+func (l *fakeListener) TransferProgress(path string, done uint64, total uint64) {}
+
+func (l *fakeListener) SyncError(path string, op string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.errors[path] = err
+}
+
+// waitStatus waits for the last status reported for path to be status, and
+// returns every status reported for it so far
+func (l *fakeListener) waitStatus(t *testing.T, path string, status string) []string {
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		l.mutex.Lock()
+		statuses := append([]string{}, l.statuses[path]...)
+		l.mutex.Unlock()
+		if len(statuses) > 0 && statuses[len(statuses)-1] == status {
+			return statuses
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Status of %s never became %q, got %v\n", path, status, statuses)
+		}
+	}
+}
+
+func hasStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// listeners should see an upload start and finish
+func TestListenerUpload(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	listener := newFakeListener()
+	fs.items.AddListener(listener)
+
+	item := writeFile(t, fs, "/listened.txt", "listened")
+	statuses := listener.waitStatus(t, "/listened.txt", StatusSynced)
+	if !hasStatus(statuses, StatusUploading) {
+		t.Fatalf("Upload was never reported, got %v\n", statuses)
+	}
+	if status := item.SyncStatus(); status != StatusSynced {
+		t.Fatalf("Expected %q after the upload, got %q\n", StatusSynced, status)
+	}
+	listener.mutex.Lock()
+	err := listener.errors["/listened.txt"]
+	listener.mutex.Unlock()
+	if err != nil {
+		t.Fatal("Successful upload was reported as an error:", err)
+	}
+}
+
+// listeners should be told about failed uploads, and why they failed
+func TestListenerUploadError(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	listener := newFakeListener()
+	fs.items.AddListener(listener)
+	defer func() { graphTransport = http.DefaultTransport }()
+	failOnErr(t, InjectFaults("5xx=1"))
+
+	item := writeFile(t, fs, "/failed.txt", "failed")
+	statuses := listener.waitStatus(t, "/failed.txt", StatusError)
+	if !hasStatus(statuses, StatusUploading) {
+		t.Fatalf("Upload was never reported, got %v\n", statuses)
+	}
+	if status := item.SyncStatus(); status != StatusError {
+		t.Fatalf("Expected %q after the upload failed, got %q\n", StatusError, status)
+	}
+	listener.mutex.Lock()
+	err := listener.errors["/failed.txt"]
+	listener.mutex.Unlock()
+	if err == nil {
+		t.Fatal("Failed upload was not reported as an error.")
+	}
+}
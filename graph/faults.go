@@ -0,0 +1,197 @@
+package graph
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// faultRates is how often each kind of fault is injected, from 0 (never) to 1
+// (every request)
+type faultRates struct {
+	timeout  float64 // the request never reaches the server
+	throttle float64 // 429 Too Many Requests
+	server   float64 // 500, 502, 503 or 504
+	truncate float64 // the response body is cut off halfway through
+}
+
+// parseFaults reads a spec like "timeout=0.05,429=0.1,5xx=0.1,truncate=0.02".
+// An optional "seed=N" makes the faults injected the same on every run.
+func parseFaults(spec string) (faultRates, int64, error) {
+	var rates faultRates
+	var seed int64 = 1
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return rates, seed, fmt.Errorf("fault %q has no rate", field)
+		}
+		if parts[0] == "seed" {
+			var err error
+			if seed, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+				return rates, seed, fmt.Errorf("invalid seed %q", parts[1])
+			}
+			continue
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return rates, seed, fmt.Errorf("rate of %q must be between 0 and 1", parts[0])
+		}
+		switch parts[0] {
+		case "timeout":
+			rates.timeout = rate
+		case "429":
+			rates.throttle = rate
+		case "5xx":
+			rates.server = rate
+		case "truncate":
+			rates.truncate = rate
+		default:
+			return rates, seed, fmt.Errorf("unknown fault %q", parts[0])
+		}
+	}
+	return rates, seed, nil
+}
+
+// faultTimeout is what a request that timed out fails with
+type faultTimeout struct{}
+
+func (faultTimeout) Error() string   { return "injected fault: request timed out" }
+func (faultTimeout) Timeout() bool   { return true }
+func (faultTimeout) Temporary() bool { return true }
+
+// faultTransport makes requests fail every now and then, the way they do on a
+// bad connection or when the server is having a bad day
+type faultTransport struct {
+	next  http.RoundTripper
+	rates faultRates
+	mutex *mu.Mutex
+	rand  *rand.Rand
+}
+
+// roll returns true with the probability rate
+func (f *faultTransport) roll(rate float64) bool {
+	if rate == 0 {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.rand.Float64() < rate
+}
+
+// errorResponse is a failed response in the format of the real thing
+func errorResponse(request *http.Request, status int, code string) *http.Response {
+	e := exchange{
+		Status: status,
+		Header: map[string]string{"Content-Type": "application/json"},
+		Body:   fmt.Sprintf(`{"error":{"code":"%s","message":"Injected fault."}}`, code),
+	}
+	if status == http.StatusTooManyRequests {
+		e.Header["Retry-After"] = "1"
+	}
+	return e.response(request)
+}
+
+// the server errors and codes injected as 5xx faults
+var serverFaults = []struct {
+	status int
+	code   string
+}{
+	{http.StatusInternalServerError, "generalException"},
+	{http.StatusBadGateway, "badGateway"},
+	{http.StatusServiceUnavailable, "serviceNotAvailable"},
+	{http.StatusGatewayTimeout, "gatewayTimeout"},
+}
+
+func (f *faultTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	fields := log.Fields{"method": request.Method, "url": request.URL.Path}
+	switch {
+	case f.roll(f.rates.timeout):
+		log.WithFields(fields).Warn("Injecting timeout.")
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, faultTimeout{}
+	case f.roll(f.rates.throttle):
+		log.WithFields(fields).Warn("Injecting 429.")
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return errorResponse(request, http.StatusTooManyRequests, "activityLimitReached"), nil
+	case f.roll(f.rates.server):
+		f.mutex.Lock()
+		fault := serverFaults[f.rand.Intn(len(serverFaults))]
+		f.mutex.Unlock()
+		fields["status"] = fault.status
+		log.WithFields(fields).Warn("Injecting server error.")
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return errorResponse(request, fault.status, fault.code), nil
+	}
+
+	response, err := f.next.RoundTrip(request)
+	if err != nil || !f.roll(f.rates.truncate) {
+		return response, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(fields).Warn("Injecting truncated response body.")
+	// the length is left alone, so readers notice the body ending early
+	response.Body = ioutil.NopCloser(io.MultiReader(
+		bytes.NewReader(body[:len(body)/2]),
+		&errReader{io.ErrUnexpectedEOF},
+	))
+	return response, nil
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// InjectFaults makes requests to the server fail at the rates in spec (see
+// parseFaults), to see how onedriver copes with a flaky connection. Applies on
+// top of RecordTraffic() or ReplayTraffic() if those are in use.
+func InjectFaults(spec string) error {
+	rates, seed, err := parseFaults(spec)
+	if err != nil {
+		return err
+	}
+	if rates == (faultRates{}) {
+		return errors.New("no faults to inject")
+	}
+	graphTransport = &faultTransport{
+		next:  graphTransport,
+		rates: rates,
+		mutex: &mu.Mutex{},
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+	log.WithFields(log.Fields{
+		"timeout":  rates.timeout,
+		"429":      rates.throttle,
+		"5xx":      rates.server,
+		"truncate": rates.truncate,
+		"seed":     seed,
+	}).Warn("Injecting faults into requests to the server.")
+	return nil
+}
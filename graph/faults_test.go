@@ -0,0 +1,60 @@
+package graph
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseFaults(t *testing.T) {
+	rates, seed, err := parseFaults("timeout=0.05, 429=0.1,5xx=1,truncate=0,seed=42")
+	failOnErr(t, err)
+	expected := faultRates{timeout: 0.05, throttle: 0.1, server: 1}
+	if rates != expected || seed != 42 {
+		t.Fatalf("Parsed %+v (seed %d), expected %+v (seed 42)\n", rates, seed, expected)
+	}
+	for _, spec := range []string{"timeout", "timeout=2", "404=0.1", "seed=x"} {
+		if _, _, err := parseFaults(spec); err == nil {
+			t.Errorf("Spec %q should be invalid.\n", spec)
+		}
+	}
+}
+
+// each kind of fault should look to callers like the real thing
+func TestInjectFaults(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	auth := mock.auth()
+	defer func() { graphTransport = http.DefaultTransport }()
+
+	failOnErr(t, InjectFaults("timeout=1"))
+	_, err := Get(ResourcePath("/"), auth)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Expected a timeout, got %v\n", err)
+	}
+
+	graphTransport = http.DefaultTransport
+	failOnErr(t, InjectFaults("429=1"))
+	if _, err = Get(ResourcePath("/"), auth); err == nil ||
+		!strings.Contains(err.Error(), "activityLimitReached") {
+		t.Fatalf("Expected throttling, got %v\n", err)
+	}
+
+	graphTransport = http.DefaultTransport
+	failOnErr(t, InjectFaults("5xx=1"))
+	if _, err = Get(ResourcePath("/"), auth); err == nil {
+		t.Fatal("Expected a server error.")
+	}
+
+	graphTransport = http.DefaultTransport
+	failOnErr(t, InjectFaults("truncate=1"))
+	if _, err = GetItem("/", auth); err == nil {
+		t.Fatal("A truncated item should not parse.")
+	}
+
+	graphTransport = http.DefaultTransport
+	if err = InjectFaults("seed=3"); err == nil {
+		t.Fatal("Injecting no faults at all should be refused.")
+	}
+}
@@ -1,15 +1,22 @@
 package graph
 
 import (
+	"bytes"
 	"os"
+	"runtime"
+	"runtime/pprof"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/fuse"
+	"github.com/jstaf/onedriver/config"
+	"github.com/jstaf/onedriver/logger"
 	log "github.com/sirupsen/logrus"
 )
 
-// UnmountHandler should be used as goroutine that will handle sigint then exit gracefully
-func UnmountHandler(signal <-chan os.Signal, server *fuse.Server) {
+// UnmountHandler should be used as goroutine that will handle sigint then exit
+// gracefully. Several filesystems can be served by the same server, see
+// MountAll().
+func UnmountHandler(signal <-chan os.Signal, server *fuse.Server, filesystems ...*FuseFs) {
 	sig := <-signal // block until sigint
 
 	// signals don't automatically format well
@@ -29,7 +36,119 @@ func UnmountHandler(signal <-chan os.Signal, server *fuse.Server) {
 			"err": err,
 		}).Error("Failed to unmount filesystem cleanly!")
 	}
+	for _, fs := range filesystems {
+		fs.Drain()
+		fs.Close()
+	}
 
 	// convention when exiting via signal is 128 + signal value
 	os.Exit(128 + int(code))
 }
+
+// ReloadHandler should be used as a goroutine that rereads the config file and
+// applies it to the running filesystem every time a SIGHUP is received. If the
+// filesystem was mounted from a profile, that profile's options are used. A
+// non-empty logLevel is the one given on the command line, which takes
+// precedence over the config just like it does at startup.
+func ReloadHandler(signal <-chan os.Signal, fs *FuseFs, configPath string, profile string, logLevel string) {
+	for range signal {
+		log.WithFields(log.Fields{
+			"path": configPath,
+		}).Info("SIGHUP received, reloading configuration.")
+		conf, err := config.Load(configPath)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": configPath,
+				"err":  err,
+			}).Error("Could not reload configuration, keeping current settings.")
+			continue
+		}
+		opts, err := conf.OptionsFor(profile)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":    configPath,
+				"profile": profile,
+				"err":     err,
+			}).Error("Could not reload configuration, keeping current settings.")
+			continue
+		}
+		level := logger.GlobalLevel()
+		if logLevel != "" {
+			level = logger.StringToLevel(logLevel)
+		} else if opts.LogLevel != "" {
+			level = logger.StringToLevel(opts.LogLevel)
+		}
+		if err := logger.SetLevels(level, opts.LogModules); err != nil {
+			log.WithFields(log.Fields{
+				"path": configPath,
+				"err":  err,
+			}).Error("Invalid log levels, keeping the current ones.")
+		}
+		fs.ApplyConfig(opts)
+	}
+}
+
+// DumpHandler should be used as a goroutine that dumps the filesystem's
+// internal state to the log every time a SIGUSR1 is received.
+func DumpHandler(signal <-chan os.Signal, filesystems ...*FuseFs) {
+	for range signal {
+		log.Info("SIGUSR1 received, dumping state.")
+		for _, fs := range filesystems {
+			fs.DumpState()
+		}
+	}
+}
+
+// DumpState writes a summary of the cache contents, open file handles, pending
+// uploads and runtime stats to the log. Meant for debugging hangs or leaks in a
+// running filesystem that can't easily be attached to with a debugger.
+func (fs *FuseFs) DumpState() {
+	fs.items.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		item.mutex.RLock()
+		defer item.mutex.RUnlock()
+		if item.openHandles > 0 {
+			log.WithFields(log.Fields{
+				"id":      item.IDInternal,
+				"name":    item.NameInternal,
+				"handles": item.openHandles,
+			}).Info("Open file handle")
+		}
+		if item.hasChanges || item.uploadSession != nil {
+			log.WithFields(log.Fields{
+				"id":         item.IDInternal,
+				"name":       item.NameInternal,
+				"size":       item.SizeInternal,
+				"hasChanges": item.hasChanges,
+				"session":    item.uploadSession != nil,
+			}).Info("Pending upload")
+		}
+		return true
+	})
+
+	stats := fs.items.Stats()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	log.WithFields(log.Fields{
+		"items":       stats.Items,
+		"dirs":        stats.Dirs,
+		"files":       stats.Items - stats.Dirs,
+		"cachedFiles": stats.CachedFiles,
+		"cachedBytes": stats.CachedBytes,
+		"openHandles": stats.OpenHandles,
+		"pending":     stats.PendingUploads,
+		"retrying":    fs.retries.count(),
+		"clockSkew":   serverClock.skew(),
+		"paused":      stats.Paused,
+		"goroutines":  runtime.NumGoroutine(),
+		"heapAlloc":   mem.HeapAlloc,
+		"heapObjects": mem.HeapObjects,
+		"sys":         mem.Sys,
+		"numGC":       mem.NumGC,
+	}).Info("Filesystem state")
+
+	// full goroutine stacks are essential for tracking down deadlocks
+	stacks := &bytes.Buffer{}
+	pprof.Lookup("goroutine").WriteTo(stacks, 1)
+	log.Info("Goroutine dump:\n", stacks.String())
+}
@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jstaf/onedriver/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadWith writes a config file with the given contents and runs
+// ReloadHandler for a single SIGHUP
+func reloadWith(t *testing.T, fs *FuseFs, contents string, logLevel string) {
+	dir, _ := ioutil.TempDir("", "onedriver_reload")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	failOnErr(t, ioutil.WriteFile(path, []byte(contents), 0644))
+
+	signal := make(chan os.Signal, 1)
+	signal <- os.Interrupt
+	close(signal)
+	ReloadHandler(signal, fs, path, "", logLevel)
+}
+
+// a reload applies the log level from the config, unless one was given on the
+// command line
+func TestReloadLogLevel(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	previous := logger.GlobalLevel()
+	defer logger.SetLevels(previous, nil)
+
+	reloadWith(t, fs, `{"log": "warn"}`, "")
+	if level := logger.GlobalLevel(); level != log.WarnLevel {
+		t.Fatalf("Log level from the config was not applied, got %s\n", level)
+	}
+
+	reloadWith(t, fs, `{"log": "warn"}`, "error")
+	if level := logger.GlobalLevel(); level != log.ErrorLevel {
+		t.Fatalf("Log level from the command line was overridden by the config, "+
+			"got %s\n", level)
+	}
+}
@@ -3,31 +3,58 @@ package graph
 import (
 	"bytes"
 	"encoding/json"
+	"net"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/jstaf/onedriver/config"
+	mu "github.com/sasha-s/go-deadlock"
 	log "github.com/sirupsen/logrus"
 )
 
-// these files will never exist, and we should ignore them
-func ignore(path string) bool {
-	ignoredFiles := []string{
-		"/BDMV",
-		"/.Trash",
-		"/.Trash-1000",
-		"/.xdg-volume-info",
-		"/autorun.inf",
-		"/.localized",
-		"/.DS_Store",
-		"/._.",
-		"/.hidden",
-	}
-	for _, ignore := range ignoredFiles {
-		if path == ignore {
+// these files will never exist, and we should ignore them. Like the extra ones
+// from the user's config, they are gitignore-style patterns.
+var ignoredFiles = []string{
+	"/BDMV",
+	"/.Trash",
+	"/.Trash-1000",
+	"/.xdg-volume-info",
+	"/autorun.inf",
+	"/.localized",
+	"/.DS_Store",
+	"/._.",
+	"/.hidden",
+}
+
+// ignoredPatterns parses the builtin list of ignored files followed by extra
+// patterns, which can also re-include ignored files with "!pattern"
+func ignoredPatterns(extra []string) []ignorePattern {
+	patterns := make([]string, 0, len(ignoredFiles)+len(extra))
+	patterns = append(patterns, ignoredFiles...)
+	return parseIgnorePatterns("/", append(patterns, extra...))
+}
+
+// ignore checks a path against both the builtin list of ignored files and any
+// extra patterns from the user's config. Whether the path would be a file or a
+// folder isn't known, so patterns ending in "/" match either. Anything inside
+// an ignored folder is ignored too.
+func (fs *FuseFs) ignore(path string) bool {
+	fs.mutex.RLock()
+	patterns := fs.ignored
+	fs.mutex.RUnlock()
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" {
+			break
+		}
+		current += "/" + segment
+		if matchIgnorePatterns(patterns, current, true) {
 			return true
 		}
 	}
@@ -45,20 +72,143 @@ func leadingSlash(path string) string {
 type FuseFs struct {
 	pathfs.FileSystem
 	*Auth
-	items *Cache
+	items    *Cache
+	mutex    *mu.RWMutex
+	ignored  []ignorePattern       // files that never exist, see ignore()
+	control  net.Listener          // the control socket, if started
+	dbusName string                // the name owned on the session bus, if any
+	virtual  map[string]virtualDir // hidden virtual directories by path
+	bin      *recycleBin
+	deletes  *deleteQueue
+	retries  *retryQueue
+	errors   *errorLog
+	versions *versionsDirectory
+	// whether Office documents get links to Office Online next to them
+	officeLinks bool
+	// whether folders are removed even if there's something in them
+	forceRmdir bool
+	// whether files left behind by Windows are left out of directory listings
+	hideSystemFiles bool
+	// how long unmounting waits for changes to reach the server, 0 for the
+	// default
+	drainTimeout time.Duration
+	// makes sure changes are drained only once, see Drain()
+	drained sync.Once
 }
 
 // NewFS initializes a new Graph Filesystem to be used by go-fuse.
 // Each method is executed concurrently as a goroutine.
 func NewFS() *FuseFs {
-	auth := Authenticate()
-	cache := NewCache(auth)
+	return NewFSAt(Authenticate(), "/")
+}
+
+// NewFSAt initializes a new filesystem with the folder at root on the server as
+// its root directory
+func NewFSAt(auth *Auth, root string) *FuseFs {
+	return newFSWithCache(auth, NewCacheAt(auth, root))
+}
+
+// newFSWithCache initializes a new filesystem backed by cache
+func newFSWithCache(auth *Auth, cache *Cache) *FuseFs {
 	//go cache.deltaLoop() //TODO: disabled for now
-	return &FuseFs{
+	fs := &FuseFs{
 		FileSystem: pathfs.NewDefaultFileSystem(),
 		Auth:       auth,
 		items:      cache,
+		mutex:      &mu.RWMutex{},
+		ignored:    ignoredPatterns(nil),
+	}
+	fs.bin = newRecycleBin(fs)
+	fs.deletes = newDeleteQueue(fs)
+	fs.retries = newRetryQueue(fs)
+	go fs.retries.run()
+	go fs.watchAuth()
+	go fs.watchNetwork()
+	fs.errors = newErrorLog()
+	cache.AddListener(fs.errors)
+	// so writes that won't fit can be refused before anyone runs "df"
+	go cache.quota.get(auth)
+	fs.versions = newVersionsDir(fs)
+	fs.virtual = map[string]virtualDir{
+		recycleBinDir: fs.bin,
+		versionsDir:   fs.versions,
+		thumbnailsDir: newThumbnailsDir(fs),
+		searchDir:     newSearchDir(fs),
+		recentDir:     newRecentDir(fs),
+		specialDir:    newSpecialDir(fs),
+		sitesDir:      newSitesDir(fs),
+	}
+	return fs
+}
+
+// Close shuts down anything the filesystem was running in the background. Should
+// be called once the filesystem has been unmounted.
+// Uploads still waiting out their delay are started first.
+func (fs *FuseFs) Close() {
+	fs.items.FlushUploads("/")
+	fs.deletes.flush()
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.control != nil {
+		fs.control.Close()
+		fs.control = nil
 	}
+	if fs.dbusName != "" {
+		releaseDBusName(fs.dbusName)
+		fs.dbusName = ""
+	}
+}
+
+// ApplyConfig updates the settings of a running filesystem. Only settings that
+// are safe to change without remounting are part of the config.
+func (fs *FuseFs) ApplyConfig(opts *config.Options) {
+	fs.items.SetPollInterval(time.Duration(opts.PollInterval) * time.Second)
+	SetBandwidthLimits(opts.UploadLimit, opts.DownloadLimit)
+
+	ignored := ignoredPatterns(opts.Ignore)
+	fs.mutex.Lock()
+	fs.ignored = ignored
+	fs.forceRmdir = opts.ForceRmdir
+	fs.drainTimeout = time.Duration(opts.DrainTimeout) * time.Second
+	fs.mutex.Unlock()
+	fs.items.SetIgnorePatterns(opts.IgnorePatterns)
+	fs.items.SetExcluded(opts.Exclude)
+	fs.items.SetIncluded(opts.Include)
+	fs.SetOfficeLinks(opts.OfficeLinks)
+	fs.SetHideSystemFiles(opts.HideSystemFiles)
+	fs.items.SetBackupMode(opts.BackupMode)
+	fs.items.SetUploadDelay(time.Duration(opts.UploadDelay) * time.Second)
+	fs.deletes.setDelay(time.Duration(opts.DeleteDelay) * time.Second)
+	fs.items.SetQuotaInterval(time.Duration(opts.QuotaInterval) * time.Second)
+	fs.items.SetMemoryLimit(opts.MemoryLimit * 1024 * 1024)
+	fs.items.SetConflictPolicy(opts.ConflictPolicy, opts.ConflictName)
+}
+
+// UseCacheDir keeps the filesystem's local state in dir: copies of downloaded
+// and uploaded file contents (so they survive being evicted from memory), the
+// contents of the recycle bin, changes waiting to be retried, recent sync errors,
+// and how far delta syncing got.
+func (fs *FuseFs) UseCacheDir(dir string) error {
+	store, err := newContentStore(filepath.Join(dir, "content"))
+	if err != nil {
+		return err
+	}
+	fs.items.content = store
+	if err := fs.retries.load(filepath.Join(dir, "retries.json")); err != nil {
+		return err
+	}
+	if err := fs.errors.load(filepath.Join(dir, "errors.json")); err != nil {
+		return err
+	}
+	if err := fs.items.loadDeltaLink(filepath.Join(dir, "delta.json")); err != nil {
+		return err
+	}
+	return fs.bin.load(filepath.Join(dir, "recyclebin.json"))
+}
+
+// SetDryRun turns dry-run mode on or off, see Cache.SetDryRun()
+func (fs *FuseFs) SetDryRun(dryRun bool) {
+	fs.items.SetDryRun(dryRun)
 }
 
 // DriveQuota is used to parse the User's current storage quotas from the API
@@ -72,29 +222,30 @@ type DriveQuota struct {
 	Used      uint64 `json:"used"`
 }
 
-// Drive has some general information about the user's OneDrive
+// Drive has some general information about a OneDrive or SharePoint document
+// library
 // https://docs.microsoft.com/en-us/onedrive/developer/rest-api/resources/drive
 type Drive struct {
 	ID        string     `json:"id"`
-	DriveType string     `json:"driveType"` // personal or business
+	Name      string     `json:"name,omitempty"`
+	DriveType string     `json:"driveType"` // personal, business or documentLibrary
 	Quota     DriveQuota `json:"quota,omitempty"`
 }
 
 // StatFs returns information about the filesystem. Mainly useful for checking
 // quotas and storage limits.
-func (fs FuseFs) StatFs(name string) *fuse.StatfsOut {
-	log.WithFields(log.Fields{"path": leadingSlash(name)}).Debug()
-	resp, err := Get("/me/drive", fs.Auth)
+func (fs *FuseFs) StatFs(name string) *fuse.StatfsOut {
+	op := fs.newOp()
+	op.WithFields(log.Fields{"path": leadingSlash(name)}).Debug()
+	drive, err := fs.items.quota.get(op.auth)
 	if err != nil {
-		log.WithFields(log.Fields{
+		op.WithFields(log.Fields{
 			"err": err,
 		}).Error("Could not fetch filesystem details.")
 	}
-	drive := Drive{}
-	json.Unmarshal(resp, &drive)
 
 	if drive.DriveType == "personal" {
-		log.Warn("Personal OneDrive accounts do not show number of files, " +
+		op.Warn("Personal OneDrive accounts do not show number of files, " +
 			"inode counts reported by onedriver will be bogus.")
 	}
 
@@ -113,44 +264,141 @@ func (fs FuseFs) StatFs(name string) *fuse.StatfsOut {
 
 // GetAttr returns a stat structure for the specified file
 func (fs *FuseFs) GetAttr(name string, context *fuse.Context) (*fuse.Attr, fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	if ignore(name) {
+	if fs.ignore(name) {
 		return nil, fuse.ENOENT
 	}
+	if dir, path := fs.virtualPath(name); dir != nil {
+		return dir.GetAttr(path)
+	}
 
-	item, err := fs.items.Get(name, fs.Auth)
+	item := fs.items.listed.get(name)
+	var err error
+	if item == nil {
+		item, err = fs.items.Get(name, op.auth)
+	}
 	if err != nil || item == nil {
+		if doc := fs.officeLinkItem(name); doc != nil {
+			return officeLinkAttr(doc), fuse.OK
+		}
 		// this is where non-existent files are caught - called before any other
 		// method when accessing a file
 		return nil, fuse.ENOENT
 	}
-	log.WithFields(log.Fields{"path": name}).Trace()
+	op.WithFields(log.Fields{"path": name}).Trace()
 
 	attr := fuse.Attr{}
 	status := item.GetAttr(&attr)
+	if attr.Mode&0222 != 0 && fs.officeOwner(item) != nil {
+		// open in Office elsewhere, see officelocks.go
+		attr.Mode &^= 0222
+	}
 
 	return &attr, status
 }
 
 // Rename is used by mv operations (move, rename)
 func (fs *FuseFs) Rename(oldName string, newName string, context *fuse.Context) (code fuse.Status) {
+	op := fs.newOp()
 	oldName, newName = leadingSlash(oldName), leadingSlash(newName)
-	log.WithFields(log.Fields{
+	op.WithFields(log.Fields{
 		"path": oldName,
 		"dest": newName,
 	}).Debug()
 
+	oldDir, oldPath := fs.virtualPath(oldName)
+	if newDir, _ := fs.virtualPath(newName); newDir != nil || fs.items.excluded(newName) {
+		return fuse.EPERM
+	} else if oldDir != nil {
+		if mover, ok := oldDir.(virtualMover); ok {
+			return mover.MoveOut(oldPath, newName)
+		}
+		return fuse.EPERM
+	}
+
+	if fs.Auth.Broken() {
+		return fuse.EROFS
+	}
+	if fs.readOnly(filepath.Dir(oldName)) || fs.readOnly(filepath.Dir(newName)) {
+		return fuse.EACCES
+	}
+
+	// anything being replaced has to be gone before the item takes its place
+	fs.deletes.settle(newName)
+
 	// grab item being renamed
-	item, _ := fs.items.Get(oldName, fs.Auth)
-	id, err := item.RemoteID(fs.Auth)
+	item, code := fs.lookup(op, oldName)
+	if code != fuse.OK {
+		return code
+	}
+
+	// Items can't be moved between ignored and regular paths, since only one of
+	// the two is on the server. Returning EXDEV makes mv copy and delete instead.
+	ignored := fs.items.ignored(oldName, item.IsDir())
+	if ignored != fs.items.ignored(newName, item.IsDir()) {
+		return fuse.EXDEV
+	}
+	if !ignored && fs.items.pathTooLong(newName, item) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+
+	if ignored && isLocalID(item.ID()) ||
+		fs.items.skipMutation("PATCH", oldName, log.Fields{"dest": newName}) {
+		if err := fs.items.Move(oldName, newName, op.auth); err != nil {
+			op.WithFields(log.Fields{
+				"path": oldName,
+				"dest": newName,
+				"err":  err,
+			}).Error("Failed to rename local item")
+			return fuse.EIO
+		}
+		return fuse.OK
+	}
+
+	if code, done := fs.saveOver(op, item, oldName, newName); done {
+		return code
+	}
+	if code, done := fs.renameLocal(op, item, oldName, newName); done {
+		return code
+	}
+
+	// waits for the upload if the item is being created by one
+	id, err := item.RemoteID(op.auth)
+	if isLocalID(id) && err == nil {
+		// kept local, so it only has to be renamed in the cache
+		if code, done := fs.renameLocal(op, item, oldName, newName); done {
+			return code
+		}
+	}
 	if isLocalID(id) || err != nil {
-		// uploads will fail without an id
-		log.WithFields(log.Fields{
+		op.WithFields(log.Fields{
 			"id":   id,
 			"path": oldName,
 			"err":  err,
-		}).Error("ID of item to move cannot be local and we failed to obtain an ID.")
-		return fuse.EBADF
+		}).Error("Failed to obtain a server ID for item being moved.")
+		return fuse.EREMOTEIO
+	}
+
+	if caseOnlyRename(oldName, newName) {
+		if err := renameCase(item, id, op.auth, filepath.Base(newName)); err != nil {
+			op.WithFields(log.Fields{
+				"path": oldName,
+				"dest": newName,
+				"err":  err,
+			}).Error("Failed to change case of item's name on server.")
+			return fuse.EREMOTEIO
+		}
+		if err := fs.items.Move(oldName, newName, op.auth); err != nil {
+			op.WithFields(log.Fields{
+				"path": oldName,
+				"dest": newName,
+				"err":  err,
+			}).Error("Failed to rename local item")
+			return fuse.EIO
+		}
+		fs.retries.renamed(oldName, newName)
+		return fuse.OK
 	}
 
 	// start creating patch content for server
@@ -158,24 +406,38 @@ func (fs *FuseFs) Rename(oldName string, newName string, context *fuse.Context)
 
 	if newDir := filepath.Dir(newName); filepath.Dir(oldName) != newDir {
 		// we are moving the item, add the new parent ID to the patch
-		newParent, err := fs.items.Get(newDir, fs.Auth)
+		newParent, err := fs.items.Get(newDir, op.auth)
 		if err != nil {
-			log.WithFields(log.Fields{
+			op.WithFields(log.Fields{
 				"path": newDir,
 				"err":  err,
 			}).Errorf("Failed to fetch parent of item being moved.")
 			return fuse.EREMOTEIO
 		}
-		parentID, err := newParent.RemoteID(fs.Auth)
+		// folders made locally may not have made it to the server yet
+		parentID, err := fs.items.remoteFolder(newParent, op.auth)
+		if err == errKeptLocal {
+			// like moving to another filesystem, the move becomes a copy that
+			// is kept local
+			return fuse.EXDEV
+		}
 		if isLocalID(parentID) || err != nil {
-			log.WithFields(log.Fields{
+			op.WithFields(log.Fields{
 				"id":   parentID,
 				"path": newDir,
 				"err":  err,
-			}).Error("ID of destination folder cannot be local")
-			return fuse.EBADF
+			}).Error("Failed to obtain a server ID for destination folder.")
+			return fuse.EREMOTEIO
+		}
+		// items can't be moved between drives, like in and out of shortcuts
+		parentDrive, parentTarget := newParent.contentTarget()
+		item.mutex.RLock()
+		drive := item.drive
+		item.mutex.RUnlock()
+		if drive != parentDrive {
+			return fuse.EXDEV
 		}
-		patchContent.Parent = &DriveItemParent{ID: parentID}
+		patchContent.Parent = &DriveItemParent{ID: parentTarget}
 	}
 
 	if newBase := filepath.Base(newName); filepath.Base(oldName) != newBase {
@@ -187,44 +449,98 @@ func (fs *FuseFs) Rename(oldName string, newName string, context *fuse.Context)
 	// apply patch to server copy - note that we don't actually care about the
 	// response content
 	jsonPatch, _ := json.Marshal(patchContent)
-	_, err = Patch("/me/drive/items/"+id, fs.Auth, bytes.NewReader(jsonPatch))
-	if err != nil {
-		if strings.Contains(err.Error(), "resourceModified") {
-			// Wait a second, then retry the request. The Onedrive servers
-			// sometimes aren't quick enough here if the object has been
-			// recently created (<1 second ago).
-			time.Sleep(time.Second)
-			log.WithFields(log.Fields{
-				"path": oldName,
-				"dest": newName,
-				"err":  err,
-			}).Warn("Patch failed, retrying.")
-			_, err = Patch("/me/drive/items/"+id, fs.Auth, bytes.NewReader(jsonPatch))
-			if err != nil {
-				// if retrying the request failed to recover things, or the request
-				// failed due to another reason than the etag bug
-				log.WithFields(log.Fields{
-					"path": oldName,
-					"dest": newName,
-					"err":  err,
-				}).Warn("Second patch attempt failed, aborting op.")
-				return fuse.EREMOTEIO
-			}
-		}
+	_, err = Patch("/me/drive/items/"+id, item.driveAuth(op.auth), bytes.NewReader(jsonPatch))
+	if err != nil && strings.Contains(err.Error(), "resourceModified") {
+		// Wait a second, then retry the request. The Onedrive servers
+		// sometimes aren't quick enough here if the object has been
+		// recently created (<1 second ago).
+		time.Sleep(time.Second)
+		op.WithFields(log.Fields{
+			"path": oldName,
+			"dest": newName,
+			"err":  err,
+		}).Warn("Patch failed, retrying.")
+		_, err = Patch("/me/drive/items/"+id, item.driveAuth(op.auth), bytes.NewReader(jsonPatch))
+	}
+	if _, permanent := PermanentError(err); permanent {
+		op.WithFields(log.Fields{
+			"path": oldName,
+			"dest": newName,
+			"err":  err,
+		}).Warn("Patch failed, aborting op.")
+		return fuse.EREMOTEIO
 	}
 
 	// now rename local copy
-	if err := fs.items.Move(oldName, newName, fs.Auth); err != nil {
-		log.WithFields(log.Fields{
+	if err := fs.items.Move(oldName, newName, op.auth); err != nil {
+		op.WithFields(log.Fields{
 			"path": oldName,
 			"dest": newName,
 			"err":  err,
 		}).Error("Failed to rename local item")
 		return fuse.EIO
 	}
+	fs.retries.renamed(oldName, newName)
+	if err != nil {
+		// the server catches up once the rename is retried, see retryRename()
+		fs.retries.add(&retryEntry{Op: retryOpRename, Path: newName}, err)
+	}
 	return fuse.OK
 }
 
+// renameLocal renames items that don't exist on the server yet in the cache
+// alone. Whatever creates them there later on uses the new name and folder, so
+// nothing has to be uploaded just to rename them. Returns false if the item has
+// to be renamed on the server instead.
+func (fs *FuseFs) renameLocal(op operation, item *DriveItem, oldName string, newName string) (fuse.Status, bool) {
+	if !isLocalID(item.ID()) {
+		return fuse.OK, false
+	}
+	// wait for the item to be created if that is under way, see remoteID()
+	fs.items.mkdirs.Lock()
+	defer fs.items.mkdirs.Unlock()
+	if !isLocalID(item.ID()) {
+		return fuse.OK, false
+	}
+	if !item.IsDir() {
+		item.mutex.RLock()
+		uploading := item.uploading
+		item.mutex.RUnlock()
+		if uploading {
+			// the upload creates it under the old name
+			return fuse.OK, false
+		}
+	}
+
+	// items can't be moved between drives, like in and out of shortcuts
+	newParent, err := fs.items.Get(filepath.Dir(newName), op.auth)
+	if err != nil {
+		op.WithFields(log.Fields{
+			"path": filepath.Dir(newName),
+			"err":  err,
+		}).Error("Failed to fetch parent of item being moved.")
+		return fuse.EREMOTEIO, true
+	}
+	parentDrive, _ := newParent.contentTarget()
+	item.mutex.RLock()
+	drive := item.drive
+	item.mutex.RUnlock()
+	if drive != parentDrive {
+		return fuse.EXDEV, true
+	}
+
+	if err := fs.items.Move(oldName, newName, op.auth); err != nil {
+		op.WithFields(log.Fields{
+			"path": oldName,
+			"dest": newName,
+			"err":  err,
+		}).Error("Failed to rename local item")
+		return fuse.EIO, true
+	}
+	fs.retries.renamed(oldName, newName)
+	return fuse.OK, true
+}
+
 // Chown currently does nothing - it is not a valid option, since fuse is single-user anyways
 func (fs *FuseFs) Chown(name string, uid uint32, gid uint32, context *fuse.Context) (code fuse.Status) {
 	return fuse.ENOSYS
@@ -233,91 +549,156 @@ func (fs *FuseFs) Chown(name string, uid uint32, gid uint32, context *fuse.Conte
 // Chmod changes mode purely for convenience/compatibility - it has no effect on
 // server contents (onedrive has no notion of permissions).
 func (fs *FuseFs) Chmod(name string, mode uint32, context *fuse.Context) (code fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	item, _ := fs.items.Get(name, fs.Auth)
+	if dir, _ := fs.virtualPath(name); dir != nil {
+		return fuse.EPERM
+	}
+	item, code := fs.lookup(op, name)
+	if code != fuse.OK {
+		return code
+	}
 	return item.Chmod(mode)
 }
 
-// OpenDir returns a list of directory entries
+// OpenDir returns a list of directory entries. The version of go-fuse in use
+// reads all of them at once and serves readdir() from that list, so a listing
+// can't be handed out a page at a time - see getChildrenPages() for how
+// listing huge folders is kept quick instead.
 func (fs *FuseFs) OpenDir(name string, context *fuse.Context) (c []fuse.DirEntry, code fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, path := fs.virtualPath(name); dir != nil {
+		return dir.OpenDir(path)
+	}
 
-	children, err := fs.items.GetChildrenPath(name, fs.Auth)
+	children, err := fs.items.GetChildrenPath(name, op.auth)
 	if err != nil {
 		// not an item not found error (GetAttr() will always be called before
 		// OpenDir()), something has happened to our connection
-		log.WithFields(log.Fields{
+		op.WithFields(log.Fields{
 			"path": name,
 			"err":  err,
 		}).Error("Error during OpenDir()")
 		return nil, fuse.EREMOTEIO
 	}
 
+	// the stat of every child that usually follows is answered from this
+	fs.items.listed.add(name, children)
+	hide := fs.systemFilesHidden()
 	for _, child := range children {
+		if hide && isSystemFile(child.Name(), child.IsDir()) {
+			continue
+		}
 		entry := fuse.DirEntry{
 			Name: child.Name(),
 			Mode: child.Mode(),
 		}
 		c = append(c, entry)
 	}
+	for _, link := range fs.officeLinkEntries(children) {
+		if !hide || !isSystemFile(link.Name, false) {
+			c = append(c, link)
+		}
+	}
 
 	return c, fuse.OK
 }
 
+// Readlink returns where a symlink points to. Only virtual directories contain
+// symlinks.
+func (fs *FuseFs) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	name = leadingSlash(name)
+	if dir, path := fs.virtualPath(name); dir != nil {
+		if linker, ok := dir.(virtualLinker); ok {
+			return linker.Readlink(path)
+		}
+	}
+	return "", fuse.EINVAL
+}
+
 // Mkdir creates a directory, mode is ignored
 func (fs *FuseFs) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
-
-	// create a new folder on the server
-	newFolderPost := DriveItem{
-		NameInternal: filepath.Base(name),
-		Folder:       &Folder{},
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, _ := fs.virtualPath(name); dir != nil || fs.items.excluded(name) {
+		return fuse.EPERM
 	}
-	bytePayload, _ := json.Marshal(newFolderPost)
-	resp, err := Post(ChildrenPath(filepath.Dir(name)), fs.Auth, bytes.NewReader(bytePayload))
-	if err != nil {
-		log.WithFields(log.Fields{
-			"path": name,
-			"err":  err,
-		}).Error("Error during directory creation:")
-		return fuse.EREMOTEIO
+	if fs.Auth.Broken() {
+		return fuse.EROFS
+	}
+	if fs.readOnly(filepath.Dir(name)) {
+		return fuse.EACCES
 	}
+	if fs.items.pathTooLong(name, nil) && !fs.items.ignored(name, true) {
+		return fuse.Status(syscall.ENAMETOOLONG)
+	}
+	fs.deletes.settle(name)
 
-	// create the new folder locally
-	created, code := fs.Create(name, 0, mode|fuse.S_IFDIR, context)
+	created, code := fs.Create(name, syscall.O_CREAT|syscall.O_EXCL, mode|fuse.S_IFDIR, context)
 	if code != fuse.OK {
 		return code
 	}
-
-	// Now unmarshal the response into the new folder so that it has an ID
-	// (otherwise things involving this folder will fail later). Mutexes are not
-	// required here since no other thread will proceed until the directory has
-	// been created.
-	item := created.(*DriveItem)
-	oldID := item.ID()
-	json.Unmarshal(resp, item)
-
-	// Move the directory to be stored under the non-local ID.
-	//TODO: eliminate the need for renames after Create()
-	fs.items.MoveID(oldID, item.ID())
-
+	created.Release() // directories are never actually opened via Create()
+	if !fs.items.localOnly("POST", name, true, nil) {
+		// created on the server in the background, see createFolder()
+		go fs.items.createFolder(created.(*fileHandle).DriveItem, op.auth)
+	}
 	return fuse.OK
 }
 
 // Rmdir removes a directory
 func (fs *FuseFs) Rmdir(name string, context *fuse.Context) fuse.Status {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, _ := fs.virtualPath(name); dir != nil {
+		return fuse.EPERM
+	}
 
-	err := Delete(ResourcePath(name), fs.Auth)
+	item, err := fs.items.Get(name, op.auth)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"path": name,
-			"err":  err,
-		}).Error("Error during delete")
-		return fuse.EREMOTEIO
+		return fuse.ENOENT
+	}
+	if fs.Auth.Broken() {
+		return fuse.EROFS
+	}
+	if fs.readOnly(filepath.Dir(name)) {
+		return fuse.EACCES
+	}
+	fs.mutex.RLock()
+	force := fs.forceRmdir
+	fs.mutex.RUnlock()
+	if !force {
+		// deleting a folder deletes everything in it on the server too
+		empty, err := fs.items.isEmpty(item, op.auth)
+		if err != nil {
+			op.WithFields(log.Fields{
+				"path": name,
+				"err":  err,
+			}).Error("Could not check if folder is empty.")
+			return fuse.EREMOTEIO
+		}
+		if !empty {
+			return fuse.Status(syscall.ENOTEMPTY)
+		}
+	}
+
+	item.mutex.Lock()
+	local := isLocalID(item.IDInternal)
+	if local {
+		// whatever creates it on the server deletes it again once done, see
+		// remoteFolderLocked()
+		item.discarded = true
+	}
+	item.mutex.Unlock()
+	if local {
+		fs.retries.remove(retryOpMkdir, name)
+	} else if !fs.items.skipMutation("DELETE", name, nil) {
+		// sent to the server later, see deleteQueue
+		fs.deletes.add(item, name)
 	}
 
 	fs.items.Delete(name)
@@ -327,88 +708,180 @@ func (fs *FuseFs) Rmdir(name string, context *fuse.Context) fuse.Status {
 
 // Open populates a DriveItem's Data field with actual data
 func (fs *FuseFs) Open(name string, flags uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, path := fs.virtualPath(name); dir != nil {
+		return dir.Open(path, flags)
+	}
 
-	item, err := fs.items.Get(name, fs.Auth)
+	item, err := fs.items.Get(name, op.auth)
 	if err != nil {
+		if doc := fs.officeLinkItem(name); doc != nil {
+			return openOfficeLink(doc, flags)
+		}
 		// We know the file exists, GetAttr() has already been called
-		log.WithFields(log.Fields{
+		op.WithFields(log.Fields{
 			"path": name,
 			"err":  err,
 		}).Error("Error fetching item from cache")
 		return nil, fuse.EREMOTEIO
 	}
 
-	// check for if file has already been populated
-	if item.data == nil {
-		// it is unpopulated, grab from api
-		log.WithFields(log.Fields{
-			"path": name,
-		}).Info("Fetching remote content for item from API")
-		err = item.FetchContent(fs.Auth)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err":  err,
-				"id":   item.ID(),
-				"path": name,
-			}).Error("Failed to fetch remote content")
-			return nil, fuse.EREMOTEIO
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		if fs.Auth.Broken() {
+			return nil, fuse.EROFS
 		}
+		if item.ReadOnly() || fs.lockedByOffice(op, item, name) {
+			return nil, fuse.EACCES
+		}
+	}
+
+	if code = fs.hydrate(op, item, name, flags); code != fuse.OK {
+		return nil, code
 	}
-	return item, fuse.OK
+	return item.open(flags), fuse.OK
+}
+
+// lookup gets the item at name for an operation that needs it to exist. Items
+// that don't exist are ENOENT, anything else that keeps them from being fetched
+// is a problem with the connection. The item is only returned with fuse.OK.
+func (fs *FuseFs) lookup(op operation, name string) (*DriveItem, fuse.Status) {
+	item, err := fs.items.Get(name, op.auth)
+	if err == nil && item != nil {
+		return item, fuse.OK
+	}
+	if err == nil || strings.Contains(err.Error(), "does not exist") {
+		return nil, fuse.ENOENT
+	}
+	op.WithFields(log.Fields{
+		"path": name,
+		"err":  err,
+	}).Error("Error fetching item from cache")
+	return nil, fuse.EREMOTEIO
+}
+
+// hydrate makes sure the content of an item being opened is there. Files opened
+// with O_TRUNC start out empty instead, there's no point in downloading what is
+// about to be thrown away.
+func (fs *FuseFs) hydrate(op operation, item *DriveItem, name string, flags uint32) fuse.Status {
+	if flags&syscall.O_TRUNC != 0 {
+		item.discardContent()
+		return fuse.OK
+	}
+	if item.hydrated() {
+		return fuse.OK
+	}
+
+	op.WithFields(log.Fields{
+		"path": name,
+	}).Info("Fetching remote content for item from API")
+	err := item.FetchContent(op.auth)
+	if err == errMalware {
+		op.WithFields(log.Fields{
+			"path":    name,
+			"malware": item.xattrs()["malware"],
+		}).Warn("OneDrive detected malware in file, refusing to open it.")
+		return fuse.EACCES
+	} else if err == errLowMemory {
+		op.WithFields(log.Fields{
+			"path": name,
+		}).Warn("Over the memory limit, refusing to fetch content.")
+		return fuse.Status(syscall.ENOMEM)
+	} else if err != nil {
+		op.WithFields(log.Fields{
+			"err":  err,
+			"id":   item.ID(),
+			"path": name,
+		}).Error("Failed to fetch remote content")
+		return fuse.EREMOTEIO
+	}
+	return fuse.OK
 }
 
 // Create a new local file. The server doesn't have this yet.
 func (fs *FuseFs) Create(name string, flags uint32, mode uint32, context *fuse.Context) (file nodefs.File, code fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, _ := fs.virtualPath(name); dir != nil || fs.items.excluded(name) {
+		return nil, fuse.EPERM
+	}
+	if fs.Auth.Broken() {
+		return nil, fuse.EROFS
+	}
 
-	// fetch details about the new item's parent (need the ID from the remote)
-	parent, err := fs.items.Get(filepath.Dir(name), fs.Auth)
+	// The kernel looked the name up before getting here, so the parent and its
+	// children are cached. Nothing is fetched: creating a file shouldn't have to
+	// wait for the server (or fail while offline), the upload takes care of that.
+	parent, err := fs.items.Get(filepath.Dir(name), &Auth{})
 	if err != nil {
-		log.WithFields(log.Fields{
+		op.WithFields(log.Fields{
 			"path": name,
 			"err":  err,
-		}).Error("Error while fetching parent.")
-		return nil, fuse.EREMOTEIO
+		}).Error("Parent of new item is not cached.")
+		return nil, fuse.ENOENT
 	}
+	if parent.ReadOnly() {
+		return nil, fuse.EACCES
+	}
+	if fs.items.pathTooLong(name, nil) && !fs.items.ignored(name, mode&fuse.S_IFDIR != 0) {
+		return nil, fuse.Status(syscall.ENAMETOOLONG)
+	}
+	fs.deletes.settle(name)
 
-	item := NewDriveItem(filepath.Base(name), mode, parent)
-	err = fs.items.Insert(name, fs.Auth, item)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"err":  err,
-			"path": name,
-			"id":   item.ID(),
-		}).Error("Failed to insert item into cache.")
+	if existing, _ := fs.items.getChild(parent.ID(), filepath.Base(name), &Auth{}); existing != nil {
+		// the kernel only calls Create() for names it doesn't know, but the
+		// server might have a file by that name (or one differing in case)
+		if flags&syscall.O_EXCL != 0 {
+			return nil, fuse.Status(syscall.EEXIST)
+		}
+		return fs.Open(name, flags, context)
 	}
 
-	return item, fuse.OK
+	item := NewDriveItem(filepath.Base(name), mode, parent)
+	fs.items.insertChild(parent, item)
+	return item.open(flags), fuse.OK
 }
 
 // Unlink deletes a file
 func (fs *FuseFs) Unlink(name string, context *fuse.Context) (code fuse.Status) {
+	op := fs.newOp()
 	name = leadingSlash(name)
-	log.WithFields(log.Fields{"path": name}).Debug()
+	op.WithFields(log.Fields{"path": name}).Debug()
+	if dir, _ := fs.virtualPath(name); dir != nil {
+		return fuse.EPERM
+	}
 
-	item, err := fs.items.Get(name, fs.Auth)
 	// allow safely calling Unlink on items that don't actually exist
-	if err != nil && strings.Contains(err.Error(), "does not exist") {
-		return fuse.ENOENT
+	item, code := fs.lookup(op, name)
+	if code != fuse.OK {
+		return code
+	}
+	if fs.Auth.Broken() {
+		return fuse.EROFS
+	}
+	if fs.readOnly(filepath.Dir(name)) {
+		return fuse.EACCES
 	}
 
 	// if no ID, the item is local-only, and does not need to be deleted on the
 	// server
-	if !isLocalID(item.ID()) {
-		err = Delete(ResourcePath(name), fs.Auth)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"err":  err,
-				"path": name,
-			}).Error("Failed to delete item on server. Aborting op.")
-			return fuse.EREMOTEIO
+	if quota := item.quota(); isLocalID(item.ID()) && quota != nil {
+		// it never made it to the server, so doesn't count towards the quota
+		quota.addWritten(-int64(item.Size()))
+	}
+	if !isLocalID(item.ID()) && !fs.items.skipMutation("DELETE", name, nil) {
+		// sent to the server later, see deleteQueue
+		fs.deletes.add(item, name)
+	} else if isLocalID(item.ID()) {
+		item.mutex.Lock()
+		if item.uploading {
+			// the upload creating it deletes it again once done, see
+			// dropDiscarded()
+			item.discarded = true
 		}
+		item.mutex.Unlock()
 	}
 
 	fs.items.Delete(name)
@@ -6,10 +6,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
 )
 
 // does ls work and can we find the Documents/Pictures folders
@@ -81,6 +84,160 @@ func TestMkdirRmdir(t *testing.T) {
 	failOnErr(t, exec.Command("mkdir", fname).Run())
 }
 
+// New folders are created on the server in the background, see createFolder(),
+// and keep their local ID until then. Once they have their server ID they
+// should be cached under it, and lookups by path or by the local ID should keep
+// finding them the whole time.
+func TestMkdirServerID(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/server-id", nil)
+
+	if status := fs.Mkdir("/Documents/server-id", 0755, nil); status != fuse.OK {
+		t.Fatal("Could not create folder:", status)
+	}
+	item, err := fs.items.Get("/Documents/server-id", nil)
+	failOnErr(t, err)
+	localID := item.ID()
+
+	// look the folder up over and over while it is being created
+	done := make(chan struct{})
+	missed := make(chan string, 1)
+	go func() {
+		defer close(missed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if found, _ := fs.items.Get("/Documents/server-id", nil); found != item {
+				missed <- "path"
+				return
+			}
+			if fs.items.GetID(localID) != item {
+				missed <- "local ID"
+				return
+			}
+		}
+	}()
+	_, err = fs.items.remoteFolder(item, fs.Auth)
+	close(done)
+	failOnErr(t, err)
+	if by, ok := <-missed; ok {
+		t.Fatalf("Lookup by %s missed the folder while it was being created.\n", by)
+	}
+	if isLocalID(item.ID()) || fs.items.GetID(item.ID()) != item || !item.IsDir() {
+		t.Fatalf("Folder was not cached under its server ID: %s\n", item.ID())
+	}
+	if fs.items.GetID(localID) != item {
+		t.Fatal("Folder can no longer be found by its local ID.")
+	}
+	if status := fs.Mkdir("/Documents/SERVER-ID", 0755, nil); status != fuse.Status(syscall.EEXIST) {
+		t.Fatal("Creating an existing folder should fail with EEXIST, got", status)
+	}
+}
+
+// rmdir should refuse to remove folders with something in them, unless forced
+func TestRmdirNotEmpty(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	folder := mock.create(mock.child(mockRootID, "Documents"), "full", true)
+	mock.create(folder, "file.txt", false)
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+
+	// the folder's children aren't cached yet, and have to be checked for
+	if _, status := fs.GetAttr("/Documents/full", nil); status != fuse.OK {
+		t.Fatal("Folder was not found:", status)
+	}
+	if status := fs.Rmdir("/Documents/full", nil); status != fuse.Status(syscall.ENOTEMPTY) {
+		t.Fatal("Removing a folder that isn't empty should fail with ENOTEMPTY, got", status)
+	}
+	if _, status := fs.GetAttr("/Documents/full/file.txt", nil); status != fuse.OK {
+		t.Fatal("File in folder was removed:", status)
+	}
+
+	fs.forceRmdir = true
+	if status := fs.Rmdir("/Documents/full", nil); status != fuse.OK {
+		t.Fatal("Could not force removing a folder:", status)
+	}
+}
+
+// operations on items that can't be fetched should fail instead of crashing
+func TestMissingItems(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	mock.create(mock.child(mockRootID, "Documents"), "uncached", true)
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+
+	// not there
+	if status := fs.Unlink("/Documents/missing.txt", nil); status != fuse.ENOENT {
+		t.Fatal("Unlinking a missing file should fail with ENOENT, got", status)
+	}
+	if status := fs.Rename("/Documents/missing.txt", "/Documents/other.txt", nil); status != fuse.ENOENT {
+		t.Fatal("Renaming a missing file should fail with ENOENT, got", status)
+	}
+	if status := fs.Chmod("/Documents/missing.txt", 0644, nil); status != fuse.ENOENT {
+		t.Fatal("Changing the mode of a missing file should fail with ENOENT, got", status)
+	}
+
+	// can't be checked for
+	mock.server.Close()
+	name := "/Documents/uncached/file.txt"
+	if status := fs.Unlink(name, nil); status != fuse.EREMOTEIO {
+		t.Fatal("Unlinking an unreachable file should fail with EREMOTEIO, got", status)
+	}
+	if status := fs.Rename(name, "/Documents/other.txt", nil); status != fuse.EREMOTEIO {
+		t.Fatal("Renaming an unreachable file should fail with EREMOTEIO, got", status)
+	}
+	if status := fs.Chmod(name, 0644, nil); status != fuse.EREMOTEIO {
+		t.Fatal("Changing the mode of an unreachable file should fail with EREMOTEIO, got", status)
+	}
+}
+
+// "mkdir -p" should not wait on the server, the folders are created there in
+// the background with the right parents
+func TestMkdirNested(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/a", nil)
+
+	for _, path := range []string{"/Documents/a", "/Documents/a/b", "/Documents/a/b/c"} {
+		if status := fs.Mkdir(path, 0755, nil); status != fuse.OK {
+			t.Fatal("Could not create folder:", status)
+		}
+	}
+	inner, err := fs.items.Get("/Documents/a/b/c", nil)
+	failOnErr(t, err)
+	_, err = fs.items.remoteFolder(inner, fs.Auth)
+	failOnErr(t, err)
+
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	parent := mock.child(mockRootID, "Documents")
+	for _, name := range []string{"a", "b", "c"} {
+		folder := mock.child(parent.id, name)
+		if folder == nil {
+			t.Fatalf("Folder %s was not created on the server\n", name)
+		}
+		parent = folder
+	}
+	inner.mutex.RLock()
+	parentID := inner.Parent.ID
+	inner.mutex.RUnlock()
+	if inner.ID() != parent.id || parentID != parent.parent {
+		t.Fatalf("Folder was not cached under its server ID and parent: %s in %s\n",
+			inner.ID(), parentID)
+	}
+}
+
 // test that we can write to a file and read its contents back correctly
 func TestReadWrite(t *testing.T) {
 	fname := filepath.Join(TestDir, "write.txt")
@@ -94,6 +251,108 @@ func TestReadWrite(t *testing.T) {
 	}
 }
 
+// items that aren't on the server yet should be renamed locally, and created on
+// the server under their new name only
+func TestRenameLocal(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/local.txt", nil)
+
+	file, status := fs.Create("/Documents/local.txt", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	file.Write([]byte("local content"), 0)
+	if status = fs.Rename("/Documents/local.txt", "/Documents/renamed.txt", nil); status != fuse.OK {
+		t.Fatal("Could not rename local file:", status)
+	}
+	if status = fs.Mkdir("/Documents/local-dir", 0755, nil); status != fuse.OK {
+		t.Fatal("Could not create folder:", status)
+	}
+	if status = fs.Rename("/Documents/local-dir", "/Documents/renamed-dir", nil); status != fuse.OK {
+		t.Fatal("Could not rename local folder:", status)
+	}
+	mock.mutex.Lock()
+	uploaded := mock.child(mock.child(mockRootID, "Documents").id, "renamed.txt")
+	mock.mutex.Unlock()
+	if uploaded != nil {
+		t.Fatal("Renaming the file should not have uploaded it.")
+	}
+
+	item := file.(*fileHandle).DriveItem
+	file.Release()
+	for i := 0; isLocalID(item.ID()); i++ {
+		if i > 100 {
+			t.Fatal("Upload did not finish.")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	dir, err := fs.items.Get("/Documents/renamed-dir", nil)
+	failOnErr(t, err)
+	_, err = fs.items.remoteFolder(dir, fs.Auth)
+	failOnErr(t, err)
+
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	docs := mock.child(mockRootID, "Documents")
+	for _, name := range []string{"local.txt", "local-dir"} {
+		if mock.child(docs.id, name) != nil {
+			t.Fatalf("%s was created on the server under its old name.\n", name)
+		}
+	}
+	if uploaded = mock.child(docs.id, "renamed.txt"); uploaded == nil ||
+		string(uploaded.content) != "local content" {
+		t.Fatal("File was not uploaded under its new name.")
+	}
+	if mock.child(docs.id, "renamed-dir") == nil {
+		t.Fatal("Folder was not created under its new name.")
+	}
+}
+
+// saving by renaming a new file over the original should only change the
+// original's content, instead of replacing it with another file
+func TestRenameSaveOver(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	original := mock.create(docs, "save.txt", false)
+	mock.setContent(original, []byte("old content"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/save.txt", nil)
+	fs.GetAttr("/Documents/save.txt.tmp", nil)
+
+	file, status := fs.Create("/Documents/save.txt.tmp", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	file.Write([]byte("new content"), 0)
+	file.Release() // starts uploading the temporary file
+	if status = fs.Rename("/Documents/save.txt.tmp", "/Documents/save.txt", nil); status != fuse.OK {
+		t.Fatal("Could not rename file over the original:", status)
+	}
+	item, err := fs.items.Get("/Documents/save.txt", nil)
+	failOnErr(t, err)
+	if item.ID() != original.id {
+		t.Fatal("Original file was replaced by another one.")
+	}
+
+	saved := func() bool {
+		mock.mutex.Lock()
+		defer mock.mutex.Unlock()
+		return string(original.content) == "new content" &&
+			mock.child(docs.id, "save.txt.tmp") == nil
+	}
+	for i := 0; !saved(); i++ {
+		if i > 100 {
+			t.Fatal("New content was not uploaded to the original file.")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // test that we can create a file and rename it
 func TestRenameMove(t *testing.T) {
 	fname := filepath.Join(TestDir, "rename.txt")
@@ -115,6 +374,56 @@ func TestRenameMove(t *testing.T) {
 	}
 }
 
+// changing only the case of a name should rename the file, not replace it
+func TestRenameCaseOnly(t *testing.T) {
+	fname := filepath.Join(TestDir, "case-rename.txt")
+	dname := filepath.Join(TestDir, "CASE-RENAME.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("case matters\n"), 0644))
+	failOnErr(t, os.Rename(fname, dname))
+
+	entries, err := ioutil.ReadDir(TestDir)
+	failOnErr(t, err)
+	found := false
+	for _, entry := range entries {
+		if entry.Name() == "case-rename.txt" {
+			t.Fatal("File was still listed under its old name.")
+		}
+		found = found || entry.Name() == "CASE-RENAME.txt"
+	}
+	if !found {
+		t.Fatal("File was not listed under its new name.")
+	}
+	content, err := ioutil.ReadFile(dname)
+	failOnErr(t, err)
+	if string(content) != "case matters\n" {
+		t.Fatalf("Renamed file has the wrong content: %s\n", content)
+	}
+}
+
+// writes through a file descriptor should land in the file, even once it has
+// been renamed out from under it
+func TestRenameWhileOpen(t *testing.T) {
+	fname := filepath.Join(TestDir, "open-rename.txt")
+	dname := filepath.Join(TestDir, "open-renamed.txt")
+	file, err := os.Create(fname)
+	failOnErr(t, err)
+	_, err = file.WriteString("before the rename\n")
+	failOnErr(t, err)
+	failOnErr(t, os.Rename(fname, dname))
+	_, err = file.WriteString("after the rename\n")
+	failOnErr(t, err)
+	failOnErr(t, file.Close())
+
+	if _, err := os.Stat(fname); err == nil {
+		t.Fatal("File still exists under its old name.")
+	}
+	content, err := ioutil.ReadFile(dname)
+	failOnErr(t, err)
+	if string(content) != "before the rename\nafter the rename\n" {
+		t.Fatalf("Renamed file has the wrong content: %s\n", content)
+	}
+}
+
 // test that copies work as expected
 func TestCopy(t *testing.T) {
 	fname := filepath.Join(TestDir, "copy-start.txt")
@@ -274,6 +583,30 @@ func TestUploadSession(t *testing.T) {
 	}
 }
 
+// nothing written in dry-run mode should ever make it to the server
+func TestDryRun(t *testing.T) {
+	testFs.SetDryRun(true)
+	defer testFs.SetDryRun(false)
+
+	fname := filepath.Join(TestDir, "dry_run.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("not uploaded"), 0644))
+	failOnErr(t, os.Mkdir(filepath.Join(TestDir, "dry_run_dir"), 0755))
+	time.Sleep(5 * time.Second)
+
+	for _, name := range []string{"dry_run.txt", "dry_run_dir"} {
+		if _, err := GetItem("/onedriver_tests/"+name, auth); err == nil {
+			t.Fatalf("%s was created on the server in dry-run mode.\n", name)
+		}
+	}
+	contents, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(contents) != "not uploaded" {
+		t.Fatalf("Local content was not kept in dry-run mode, got \"%s\"\n", contents)
+	}
+	failOnErr(t, os.Remove(fname))
+	failOnErr(t, os.Remove(filepath.Join(TestDir, "dry_run_dir")))
+}
+
 func TestIgnoredFiles(t *testing.T) {
 	fname := filepath.Join(TestDir, ".Trash-1000")
 	_, err := os.Stat(fname)
@@ -357,3 +690,76 @@ func TestChildrenAreCasedProperly(t *testing.T) {
 			"expected \"CASE-check.txt\" in output, got %s\n", string(stdout))
 	}
 }
+
+// resolving a deep path in a cold cache should leave every folder along it with
+// its children cached
+func TestDeepPathGet(t *testing.T) {
+	deep := filepath.Join(TestDir, "deep/er/and/deeper")
+	failOnErr(t, os.MkdirAll(deep, 0755))
+	failOnErr(t, ioutil.WriteFile(filepath.Join(deep, "bottom.txt"), []byte("hi"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+
+	cache := NewCache(auth)
+	item, err := cache.Get("/onedriver_tests/deep/er/and/deeper/bottom.txt", auth)
+	failOnErr(t, err)
+	if item.Name() != "bottom.txt" {
+		t.Fatalf("Resolved the wrong item: %s\n", item.Name())
+	}
+	for _, path := range []string{"/onedriver_tests/deep", "/onedriver_tests/deep/er/and"} {
+		folder, err := cache.Get(path, &Auth{}) // no fetching allowed
+		failOnErr(t, err)
+		if !cache.childrenCached(folder.ID()) {
+			t.Errorf("Children of %s were not cached.\n", path)
+		}
+	}
+}
+
+// writes through a shared mmap() must be uploaded, even when they're made after
+// the file descriptor was closed
+func TestMmapWrite(t *testing.T) {
+	fname := filepath.Join(TestDir, "mmap.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("written before mmap"), 0644))
+
+	file, err := os.OpenFile(fname, os.O_RDWR, 0644)
+	failOnErr(t, err)
+	mapped, err := syscall.Mmap(int(file.Fd()), 0, 19,
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	failOnErr(t, err)
+	file.Close()
+	copy(mapped, "written  after")
+	failOnErr(t, syscall.Munmap(mapped))
+
+	want := "written  after mmap"
+	content, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(content) != want {
+		t.Fatalf("Mapped write was not captured, got \"%s\"\n", content)
+	}
+
+	time.Sleep(5 * time.Second) // wait for upload
+	item, err := GetItem("/onedriver_tests/mmap.txt", auth)
+	failOnErr(t, err)
+	if !item.VerifyChecksum([]byte(want)) {
+		t.Fatal("Mapped write was not uploaded.")
+	}
+}
+
+// lots of small files written at once should all make it to the server, even
+// when uploaded in batches
+func TestBatchUpload(t *testing.T) {
+	dir := filepath.Join(TestDir, "batch")
+	failOnErr(t, os.Mkdir(dir, 0755))
+	for i := 0; i < batchSize+5; i++ {
+		name := filepath.Join(dir, strconv.Itoa(i)+".txt")
+		failOnErr(t, ioutil.WriteFile(name, []byte("batched "+strconv.Itoa(i)), 0644))
+	}
+
+	time.Sleep(5 * time.Second) // wait for upload
+	for i := 0; i < batchSize+5; i++ {
+		item, err := GetItem("/onedriver_tests/batch/"+strconv.Itoa(i)+".txt", auth)
+		failOnErr(t, err)
+		if !item.VerifyChecksum([]byte("batched " + strconv.Itoa(i))) {
+			t.Fatalf("Content of batched file %d did not make it to the server.\n", i)
+		}
+	}
+}
@@ -7,13 +7,20 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/jstaf/onedriver/logger"
 	log "github.com/sirupsen/logrus"
 	mu "github.com/sasha-s/go-deadlock"
 )
 
-const graphURL = "https://graph.microsoft.com/v1.0"
+// graphURL is where requests go, tests point it at a mock server instead
+var graphURL = "https://graph.microsoft.com/v1.0"
+
+// graphTransport carries all requests to the server, swapped out to record or
+// replay traffic (see replay.go)
+var graphTransport = http.DefaultTransport
 
 // graphError is an internal struct used when decoding Graph's error messages
 type graphError struct {
@@ -23,8 +30,76 @@ type graphError struct {
 	} `json:"error"`
 }
 
+// Graph error codes that will keep failing no matter how often a request is
+// retried, and a human-readable explanation for each
+var permanentErrors = map[string]string{
+	"quotaLimitReached": "There is not enough space left on your OneDrive.",
+	"nameAlreadyExists": "An item with the same name already exists.",
+	"invalidRequest":    "The server rejected the request, the name may contain invalid characters.",
+	"accessDenied":      "You do not have permission to change this item.",
+	"notAllowed":        "This operation is not allowed for this item.",
+	"malwareDetected":   "The server detected malware in this file.",
+}
+
+// PermanentError returns an explanation and true if err is a Graph error that
+// will not go away by retrying the request.
+func PermanentError(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	for code, reason := range permanentErrors {
+		if strings.Contains(err.Error(), code) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
 // Request performs an authenticated request to Microsoft Graph
 func Request(resource string, auth *Auth, method string, content io.Reader) ([]byte, error) {
+	response, err := sendRequest(resource, auth, method, content)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, _ := readBody(throttledReader{response.Body, downloadLimiter}, response.ContentLength)
+	if response.StatusCode >= 400 {
+		return nil, responseError(body)
+	}
+	return body, nil
+}
+
+// getStream is Get, but hands back the response body to be read as it arrives
+// instead of reading it into memory first. The body must be closed.
+func getStream(resource string, auth *Auth) (io.ReadCloser, error) {
+	response, err := sendRequest(resource, auth, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 400 {
+		defer response.Body.Close()
+		body, _ := readBody(response.Body, response.ContentLength)
+		return nil, responseError(body)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{throttledReader{response.Body, downloadLimiter}, response.Body}, nil
+}
+
+// responseError turns the body of a failed request into an error
+func responseError(body []byte) error {
+	var err graphError
+	json.Unmarshal(body, &err)
+	return errors.New(err.Error.Code + ": " + err.Error.Message)
+}
+
+// sendRequest sends an authenticated request to Microsoft Graph and returns the
+// response, whatever its status
+func sendRequest(resource string, auth *Auth, method string, content io.Reader) (*http.Response, error) {
+	resource = auth.driveResource(resource)
+	op := auth.op
+	auth = auth.tokens()
 	if auth.AccessToken == "" {
 		// a catch all condition to avoid wiping our auth by accident
 		log.WithFields(log.Fields{
@@ -35,10 +110,23 @@ func Request(resource string, auth *Auth, method string, content io.Reader) ([]b
 	}
 
 	auth.Refresh()
+	if auth.Broken() {
+		return nil, errAuthBroken
+	}
 
-	client := &http.Client{}
+	client := &http.Client{Transport: graphTransport}
 	request, _ := http.NewRequest(method, graphURL+resource, content)
+	if request.ContentLength > 0 {
+		// hiding the reader behind the throttle would otherwise cause net/http
+		// to send the content with chunked encoding
+		length := request.ContentLength
+		request.Body = ioutil.NopCloser(throttledReader{content, uploadLimiter})
+		request.ContentLength = length
+	}
 	request.Header.Add("Authorization", "bearer "+auth.AccessToken)
+	if op != "" {
+		request.Header.Add("client-request-id", op)
+	}
 	switch method { // request type-specific code here
 	case "PATCH":
 		request.Header.Add("If-Match", "*")
@@ -50,19 +138,27 @@ func Request(resource string, auth *Auth, method string, content io.Reader) ([]b
 	}
 
 	response, err := client.Do(request)
+	network.observe(err)
 	if err != nil {
 		// the actual request failed
+		log.WithFields(log.Fields{
+			"op":       op,
+			"method":   method,
+			"resource": resource,
+			"err":      err,
+		}).Debug("Request failed.")
 		return nil, err
 	}
-	defer response.Body.Close()
-	body, _ := ioutil.ReadAll(response.Body)
-	if response.StatusCode >= 400 {
-		// something was wrong with the request
-		var err graphError
-		json.Unmarshal(body, &err)
-		return nil, errors.New(err.Error.Code + ": " + err.Error.Message)
-	}
-	return body, nil
+	serverClock.observe(response.Header.Get("Date"))
+	// request-id is how Microsoft finds a request on their end
+	log.WithFields(log.Fields{
+		"op":        op,
+		"method":    method,
+		"resource":  resource,
+		"status":    response.StatusCode,
+		"requestID": response.Header.Get("request-id"),
+	}).Debug()
+	return response, nil
 }
 
 // Get is a convenience wrapper around Request
@@ -112,6 +208,24 @@ func ChildrenPathID(id string) string {
 	return "/me/drive/items/" + id + "/children"
 }
 
+// the server returns 200 children per page unless asked for more, and at most 999
+const (
+	defaultPageSize = 200
+	maxPageSize     = 999
+)
+
+// childrenRequest is ChildrenPathID, but sized to fetch count children in as few
+// pages as possible
+func childrenRequest(id string, count uint32) string {
+	if count <= defaultPageSize {
+		return ChildrenPathID(id)
+	}
+	if count > maxPageSize {
+		count = maxPageSize
+	}
+	return ChildrenPathID(id) + "?$top=" + strconv.FormatUint(uint64(count), 10)
+}
+
 // GetItem fetches a DriveItem by path. Only used in special cases, like for the
 // root item.
 func GetItem(path string, auth *Auth) (*DriveItem, error) {
@@ -125,3 +239,30 @@ func GetItem(path string, auth *Auth) (*DriveItem, error) {
 	err = json.Unmarshal(body, item)
 	return item, err
 }
+
+// GetChildren fetches all of the children of the folder at path directly from
+// the server. Like GetItem(), nothing is cached.
+func GetChildren(path string, auth *Auth) ([]*DriveItem, error) {
+	return getAllPages(ChildrenPath(path), auth)
+}
+
+// getAllPages fetches every page of a list of items, following nextLinks
+func getAllPages(resource string, auth *Auth) ([]*DriveItem, error) {
+	items := make([]*DriveItem, 0)
+	for resource != "" {
+		body, err := Get(resource, auth)
+		if err != nil {
+			return items, err
+		}
+		var page driveChildren
+		if err = json.Unmarshal(body, &page); err != nil {
+			return items, err
+		}
+		for _, item := range page.Children {
+			item.mutex = &mu.RWMutex{}
+			items = append(items, item)
+		}
+		resource = strings.TrimPrefix(page.NextLink, graphURL)
+	}
+	return items, nil
+}
@@ -1,6 +1,7 @@
 package graph
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -28,3 +29,42 @@ func TestGetItem(t *testing.T) {
 		t.Fatal("We didn't return an error for a non-existent item!")
 	}
 }
+
+func TestGetChildren(t *testing.T) {
+	children, err := GetChildren("/", auth)
+	failOnErr(t, err)
+	found := false
+	for _, child := range children {
+		if child.Name() == "Documents" && child.IsDir() {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Could not find \"Documents\" folder in the children of root.")
+	}
+}
+
+// files copied without a mounted filesystem should survive the round trip
+func TestUploadDownloadFile(t *testing.T) {
+	content := []byte("uploaded without mounting")
+	_, err := UploadFile("/onedriver_tests/transfer.txt", content, time.Now(), auth)
+	failOnErr(t, err)
+	_, downloaded, err := DownloadFile("/onedriver_tests/transfer.txt", auth)
+	failOnErr(t, err)
+	if string(downloaded) != string(content) {
+		t.Fatalf("Content changed during transfer, got \"%s\"\n", downloaded)
+	}
+}
+
+// errors that retrying won't fix should be detected, transient ones should not
+func TestPermanentError(t *testing.T) {
+	if _, permanent := PermanentError(errors.New("quotaLimitReached: Insufficient Space Available")); !permanent {
+		t.Fatal("A full drive was not detected as a permanent error.")
+	}
+	if _, permanent := PermanentError(errors.New("serviceNotAvailable: try again later")); permanent {
+		t.Fatal("A temporary outage was detected as a permanent error.")
+	}
+	if _, permanent := PermanentError(nil); permanent {
+		t.Fatal("A nil error was detected as a permanent error.")
+	}
+}
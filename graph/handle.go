@@ -0,0 +1,53 @@
+package graph
+
+import (
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// fileHandle is a file opened through the filesystem. Every open() gets its own
+// handle on the item, so the item can tell when the last handle that could write
+// to it is gone, which is when its changes are uploaded.
+type fileHandle struct {
+	*DriveItem
+	writable  bool
+	appending bool // opened with O_APPEND
+}
+
+// open records that a new file handle was opened for the item, and returns it.
+// The handle can write if flags allow it.
+func (d *DriveItem) open(flags uint32) *fileHandle {
+	writable := flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0
+	d.mutex.Lock()
+	d.openHandles++
+	if writable {
+		d.writers++
+	}
+	d.mutex.Unlock()
+	return &fileHandle{
+		DriveItem: d,
+		writable:  writable,
+		appending: flags&syscall.O_APPEND != 0,
+	}
+}
+
+// Write writes data through the handle. Handles opened with O_APPEND always
+// write at the end of the file.
+func (h *fileHandle) Write(data []byte, off int64) (uint32, fuse.Status) {
+	return h.DriveItem.write(data, off, h.appending)
+}
+
+// Flush reports changes made through the handle that can't be uploaded. Nothing
+// can have been written through read-only handles.
+func (h *fileHandle) Flush() fuse.Status {
+	if !h.writable {
+		return fuse.OK
+	}
+	return h.DriveItem.Flush()
+}
+
+// Release is called when the handle is closed for good
+func (h *fileHandle) Release() {
+	h.DriveItem.release(h.writable)
+}
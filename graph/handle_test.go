@@ -0,0 +1,244 @@
+package graph
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// changes should only be uploaded once the last handle that could write is
+// released, not on every close() or when readers go away
+func TestReleaseUploads(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/handles.txt", nil) // the kernel looks it up first
+
+	writer, status := fs.Create("/Documents/handles.txt", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	reader, status := fs.Open("/Documents/handles.txt", syscall.O_RDONLY, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not open file:", status)
+	}
+	item := writer.(*fileHandle).DriveItem
+	writer.Write([]byte("some content"), 0)
+
+	pending := func() bool {
+		item.mutex.RLock()
+		defer item.mutex.RUnlock()
+		return item.hasChanges
+	}
+	if writer.Flush() != fuse.OK || reader.Flush() != fuse.OK || !pending() {
+		t.Fatal("Flushing should neither fail nor upload anything.")
+	}
+	reader.Release()
+	if !pending() {
+		t.Fatal("Releasing a reader should not upload anything.")
+	}
+	writer.Release()
+	if pending() {
+		t.Fatal("Releasing the last writer should have started the upload.")
+	}
+	for i := 0; isLocalID(item.ID()); i++ {
+		if i > 100 {
+			t.Fatal("Upload did not finish.")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// opening a file should fetch its content, unless it is about to be truncated
+func TestOpenHydrates(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	mock.setContent(mock.create(docs, "hydrate.txt", false), []byte("remote content"))
+	truncated := mock.create(docs, "truncate.txt", false)
+	mock.setContent(truncated, []byte("remote content"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+
+	file, status := fs.Open("/Documents/hydrate.txt", syscall.O_RDONLY, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not open file:", status)
+	}
+	buf := make([]byte, 64)
+	result, _ := file.Read(buf, 0)
+	if content, _ := result.Bytes(buf); string(content) != "remote content" {
+		t.Fatalf("Got \"%s\" after opening the file.\n", content)
+	}
+	file.Release()
+
+	// fetching the content would fail now
+	if _, status = fs.GetAttr("/Documents/truncate.txt", nil); status != fuse.OK {
+		t.Fatal("Could not stat file:", status)
+	}
+	mock.mutex.Lock()
+	mock.remove(truncated)
+	mock.mutex.Unlock()
+	file, status = fs.Open("/Documents/truncate.txt", syscall.O_WRONLY|syscall.O_TRUNC, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not open file:", status)
+	}
+	item := file.(*fileHandle).DriveItem
+	if item.Size() != 0 || !item.hydrated() || !item.hasChanges {
+		t.Fatal("Truncated file should be empty and changed.")
+	}
+	item.cache.Pause() // nothing to upload
+	file.Release()
+}
+
+// O_EXCL should refuse existing files, even ones differing in case, and
+// O_APPEND should always write at the end
+func TestOpenFlags(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	mock.setContent(mock.create(docs, "flags.txt", false), []byte("remote"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/FLAGS.txt", nil)
+
+	_, status := fs.Create("/Documents/FLAGS.txt", syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL, 0644, nil)
+	if status != fuse.Status(syscall.EEXIST) {
+		t.Fatal("Exclusive create of an existing file should fail with EEXIST, got", status)
+	}
+
+	file, status := fs.Create("/Documents/flags.txt", syscall.O_WRONLY|syscall.O_CREAT|syscall.O_APPEND, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not open existing file:", status)
+	}
+	item := file.(*fileHandle).DriveItem
+	item.cache.Pause() // nothing to upload
+	file.Write([]byte(" one"), 0)
+	file.Write([]byte(" two"), 3)
+	buf := make([]byte, 64)
+	result, _ := item.Read(buf, 0)
+	if content, _ := result.Bytes(buf); string(content) != "remote one two" {
+		t.Fatalf("Appends should go at the end of a file, got \"%s\"\n", content)
+	}
+	file.Release()
+
+	file, status = fs.Create("/Documents/flags.txt", syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC, 0644, nil)
+	if status != fuse.OK || item.Size() != 0 {
+		t.Fatal("Existing file should have been truncated:", status)
+	}
+	file.Release()
+}
+
+// creating files only needs what the kernel already looked up, not the server
+func TestCreateOffline(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/offline.txt", nil)
+	fs.items.Pause() // nothing to upload
+
+	mock.server.Close()
+	file, status := fs.Create("/Documents/offline.txt", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file without the server:", status)
+	}
+	file.Release()
+	if _, status = fs.GetAttr("/Documents/offline.txt", nil); status != fuse.OK {
+		t.Fatal("Created file could not be found:", status)
+	}
+}
+
+// changes made while the file is being uploaded should go up once that upload
+// is done, not in a second upload racing the first
+func TestUploadWhileUploading(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	remote := mock.create(mock.child(mockRootID, "Documents"), "busy.txt", false)
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/busy.txt", nil)
+
+	write := func(content string) {
+		file, status := fs.Open("/Documents/busy.txt", syscall.O_WRONLY|syscall.O_TRUNC, nil)
+		if status != fuse.OK {
+			t.Fatal("Could not open file:", status)
+		}
+		file.Write([]byte(content), 0)
+		file.Release()
+	}
+	item, err := fs.items.Get("/Documents/busy.txt", nil)
+	failOnErr(t, err)
+	state := func() (bool, bool) {
+		item.mutex.RLock()
+		defer item.mutex.RUnlock()
+		return item.uploading, item.hasChanges
+	}
+
+	mock.mutex.Lock() // holds the upload up on the server
+	write("first version")
+	for i := 0; ; i++ {
+		if uploading, _ := state(); uploading {
+			break
+		} else if i > 100 {
+			mock.mutex.Unlock()
+			t.Fatal("Upload did not start.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	write("second version")
+	_, pending := state()
+	mock.mutex.Unlock()
+	if !pending {
+		t.Fatal("Second upload started while the first was still running.")
+	}
+
+	for i := 0; ; i++ {
+		mock.mutex.Lock()
+		content := string(remote.content)
+		mock.mutex.Unlock()
+		if uploading, pending := state(); content == "second version" && !uploading && !pending {
+			break
+		} else if i > 100 {
+			t.Fatalf("Server ended up with \"%s\".\n", content)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// an upload should count as running from the moment it's started, not only once
+// the content has been hashed, or changes right after could start another one
+func TestUploadRightAfterUpload(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	mock.setContent(mock.create(mock.child(mockRootID, "Documents"), "quick.txt", false),
+		[]byte("remote"))
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents/quick.txt", nil)
+	item, err := fs.items.Get("/Documents/quick.txt", nil)
+	failOnErr(t, err)
+
+	write := func(content string) {
+		file, status := fs.Open("/Documents/quick.txt", syscall.O_WRONLY|syscall.O_TRUNC, nil)
+		if status != fuse.OK {
+			t.Fatal("Could not open file:", status)
+		}
+		file.Write([]byte(content), 0)
+		file.Release()
+	}
+	mock.mutex.Lock()
+	write("first version")
+	write("second version")
+	item.mutex.RLock()
+	uploading, pending := item.uploading, item.hasChanges
+	item.mutex.RUnlock()
+	mock.mutex.Unlock()
+	if !uploading || !pending {
+		t.Fatal("Second upload started before the first was done.")
+	}
+}
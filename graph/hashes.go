@@ -0,0 +1,143 @@
+package graph
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// QuickXorHash is Microsoft's hash for OneDrive for Business files (and recently
+// personal files as well). It is a 160-bit hash where each byte of input is
+// XORed into the state at a position that advances by 11 bits per byte, with the
+// total length XORed into the result at the end. See:
+// https://docs.microsoft.com/en-us/onedrive/developer/code-snippets/quickxorhash
+const (
+	quickXorWidth = 160
+	quickXorShift = 11
+)
+
+type quickXorHash struct {
+	data       [(quickXorWidth-1)/64 + 1]uint64
+	length     uint64
+	shiftSoFar int
+}
+
+// NewQuickXorHash returns a hash.Hash computing the QuickXorHash of its input.
+// Server-side hashes are reported base64-encoded, see QuickXorHash().
+func NewQuickXorHash() hash.Hash {
+	return &quickXorHash{}
+}
+
+func (q *quickXorHash) Write(p []byte) (int, error) {
+	index := q.shiftSoFar / 64
+	offset := q.shiftSoFar % 64
+	iterations := len(p)
+	if iterations > quickXorWidth {
+		iterations = quickXorWidth
+	}
+
+	for i := 0; i < iterations; i++ {
+		lastCell := index == len(q.data)-1
+		cellBits := 64
+		if lastCell {
+			cellBits = quickXorWidth % 64
+		}
+
+		if offset <= cellBits-8 {
+			for j := i; j < len(p); j += quickXorWidth {
+				q.data[index] ^= uint64(p[j]) << uint(offset)
+			}
+		} else {
+			// the byte spans two cells
+			next := index + 1
+			if lastCell {
+				next = 0
+			}
+			var xored byte
+			for j := i; j < len(p); j += quickXorWidth {
+				xored ^= p[j]
+			}
+			q.data[index] ^= uint64(xored) << uint(offset)
+			q.data[next] ^= uint64(xored) >> uint(cellBits-offset)
+		}
+
+		offset += quickXorShift
+		for offset >= cellBits {
+			if lastCell {
+				index = 0
+			} else {
+				index++
+			}
+			offset -= cellBits
+		}
+	}
+
+	q.shiftSoFar = (q.shiftSoFar + quickXorShift*(len(p)%quickXorWidth)) % quickXorWidth
+	q.length += uint64(len(p))
+	return len(p), nil
+}
+
+func (q *quickXorHash) Sum(b []byte) []byte {
+	sum := make([]byte, quickXorWidth/8)
+	for i := 0; i < len(q.data)-1; i++ {
+		binary.LittleEndian.PutUint64(sum[i*8:], q.data[i])
+	}
+	last := make([]byte, 8)
+	binary.LittleEndian.PutUint64(last, q.data[len(q.data)-1])
+	copy(sum[(len(q.data)-1)*8:], last)
+
+	length := make([]byte, 8)
+	binary.LittleEndian.PutUint64(length, q.length)
+	for i, lengthByte := range length {
+		sum[len(sum)-len(length)+i] ^= lengthByte
+	}
+	return append(b, sum...)
+}
+
+func (q *quickXorHash) Reset() {
+	*q = quickXorHash{}
+}
+
+func (q *quickXorHash) Size() int {
+	return quickXorWidth / 8
+}
+
+func (q *quickXorHash) BlockSize() int {
+	return 64
+}
+
+// QuickXorHash returns the base64-encoded QuickXorHash of content, in the same
+// format the server reports it in.
+func QuickXorHash(content []byte) string {
+	h := NewQuickXorHash()
+	h.Write(content)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// SHA1Hash returns the SHA1 hash of content, in the same format the server
+// reports it in (uppercase hex).
+func SHA1Hash(content []byte) string {
+	return fmt.Sprintf("%X", sha1.Sum(content))
+}
+
+// VerifyChecksum checks content against the hashes the server reported for this
+// item. Returns true if the hashes match or if the server did not report any
+// hashes we know how to check.
+func (d DriveItem) VerifyChecksum(content []byte) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.FileInternal == nil || d.FileInternal.Hashes == nil {
+		return true
+	}
+	hashes := d.FileInternal.Hashes
+	if hashes.QuickXorHash != "" {
+		return QuickXorHash(content) == hashes.QuickXorHash
+	}
+	if hashes.SHA1Hash != "" {
+		return strings.EqualFold(SHA1Hash(content), hashes.SHA1Hash)
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQuickXorHash(t *testing.T) {
+	if hash := QuickXorHash([]byte{}); hash != "AAAAAAAAAAAAAAAAAAAAAAAAAAA=" {
+		t.Fatalf("Wrong hash of no content: %s\n", hash)
+	}
+	// a single byte ends up unshifted at the start, with the length at byte 12
+	if hash := QuickXorHash([]byte("a")); hash != "YQAAAAAAAAAAAAAAAQAAAAAAAAA=" {
+		t.Fatalf("Wrong hash of \"a\": %s\n", hash)
+	}
+}
+
+// hashing content in pieces should give the same result as all at once
+func TestQuickXorHashChunked(t *testing.T) {
+	content := bytes.Repeat([]byte("onedriver is a native Linux client for OneDrive. "), 100)
+	h := NewQuickXorHash()
+	for i := 0; i < len(content); i += 37 {
+		end := i + 37
+		if end > len(content) {
+			end = len(content)
+		}
+		h.Write(content[i:end])
+	}
+	whole := NewQuickXorHash()
+	whole.Write(content)
+	if !bytes.Equal(h.Sum(nil), whole.Sum(nil)) {
+		t.Fatal("Hash changed when content was written in chunks.")
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	content := []byte("checksum me")
+	item := NewDriveItem("checksum.txt", 0644, nil)
+	item.FileInternal = &File{Hashes: &Hashes{SHA1Hash: SHA1Hash(content)}}
+	if !item.VerifyChecksum(content) {
+		t.Fatal("Content did not match its own SHA1 hash.")
+	}
+	item.FileInternal.Hashes.QuickXorHash = QuickXorHash([]byte("something else"))
+	if item.VerifyChecksum(content) {
+		t.Fatal("Mismatched QuickXorHash was not detected.")
+	}
+}
+
+// hashing in the background, one region at a time, must give the same result as
+// hashing everything at once
+func TestHashContent(t *testing.T) {
+	content := bytes.Repeat([]byte("onedriver"), regionSize/4)
+	item := NewDriveItem("hash.txt", 0644, nil)
+	item.data = &content
+	item.regions = &regionLocks{}
+	if hash := <-hashInBackground(item, false); hash != QuickXorHash(content) {
+		t.Fatalf("Wrong QuickXorHash: %s\n", hash)
+	}
+	if hash := <-hashInBackground(item, true); hash != SHA1Hash(content) {
+		t.Fatalf("Wrong SHA1 hash: %s\n", hash)
+	}
+}
+
+func TestUnchangedOnServer(t *testing.T) {
+	content := []byte("unchanged")
+	item := NewDriveItem("unchanged.txt", 0644, nil)
+	item.IDInternal = "remote-id"
+	item.data = &content
+	item.SizeInternal = uint64(len(content))
+	item.FileInternal = &File{Hashes: &Hashes{QuickXorHash: QuickXorHash(content)}}
+	if !item.unchangedOnServer() {
+		t.Fatal("Content with the server's hash should be unchanged.")
+	}
+	item.FileInternal.Hashes.QuickXorHash = QuickXorHash([]byte("changed"))
+	if item.unchangedOnServer() {
+		t.Fatal("Content with a different hash should be changed.")
+	}
+}
@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"runtime"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hashJob is a request to hash the content of an item
+type hashJob struct {
+	item   *DriveItem
+	sha1   bool // SHA1 instead of QuickXorHash
+	result chan string
+}
+
+// hashWorkers hash file content in the background, so that hashing big files
+// ties up neither whoever is closing them nor more than a few CPUs at once
+var hashWorkers struct {
+	once sync.Once
+	jobs chan hashJob
+}
+
+// hashInBackground queues the content of an item for hashing. The result is the
+// hash in the format the server reports it in, or "" if the content was resized
+// while being hashed.
+func hashInBackground(item *DriveItem, sha1 bool) <-chan string {
+	hashWorkers.once.Do(func() {
+		hashWorkers.jobs = make(chan hashJob)
+		for i := 0; i < runtime.NumCPU(); i++ {
+			go func() {
+				for job := range hashWorkers.jobs {
+					job.result <- job.item.hashContent(job.sha1)
+				}
+			}()
+		}
+	})
+	result := make(chan string, 1)
+	go func() { hashWorkers.jobs <- hashJob{item, sha1, result} }()
+	return result
+}
+
+// hashContent hashes an item's content one region at a time, so writes to the
+// rest of the file can go on in the meantime
+func (d *DriveItem) hashContent(useSHA1 bool) string {
+	var h hash.Hash
+	if useSHA1 {
+		h = sha1.New()
+	} else {
+		h = NewQuickXorHash()
+	}
+	d.mutex.RLock()
+	if d.data == nil {
+		d.mutex.RUnlock()
+		return ""
+	}
+	size := len(*d.data)
+	d.mutex.RUnlock()
+
+	for offset := 0; offset < size; offset += regionSize {
+		d.mutex.RLock()
+		if d.data == nil || len(*d.data) != size {
+			d.mutex.RUnlock()
+			return ""
+		}
+		end := offset + regionSize
+		if end > size {
+			end = size
+		}
+		if d.regions != nil {
+			unlock := d.regions.lock(offset, end-offset, false)
+			h.Write((*d.data)[offset:end])
+			unlock()
+		} else {
+			h.Write((*d.data)[offset:end])
+		}
+		d.mutex.RUnlock()
+	}
+	if useSHA1 {
+		return fmt.Sprintf("%X", h.Sum(nil))
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// unchangedOnServer returns true if the item's content is the same as the
+// server's copy, going by the hashes the server reported for it. Saving a file
+// without changing it then doesn't cost an upload.
+func (d *DriveItem) unchangedOnServer() bool {
+	d.mutex.RLock()
+	if isLocalID(d.IDInternal) || d.FileInternal == nil || d.FileInternal.Hashes == nil ||
+		d.data == nil || uint64(len(*d.data)) != d.SizeInternal {
+		d.mutex.RUnlock()
+		return false
+	}
+	remote := *d.FileInternal.Hashes
+	d.mutex.RUnlock()
+
+	var local string
+	switch {
+	case remote.QuickXorHash != "":
+		local = <-hashInBackground(d, false)
+		if local != remote.QuickXorHash {
+			return false
+		}
+	case remote.SHA1Hash != "":
+		local = <-hashInBackground(d, true)
+		if !strings.EqualFold(local, remote.SHA1Hash) {
+			return false
+		}
+	default:
+		return false
+	}
+	log.WithFields(log.Fields{
+		"path": d.Path(),
+	}).Debug("Content is the same as on the server, skipping upload.")
+	return true
+}
@@ -0,0 +1,86 @@
+package graph
+
+import (
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// Local IDs (see localID()) only mean something to onedriver, and must never
+// end up in requests to the server. Once an item made locally is created on the
+// server, its local ID is mapped to the ID it got there, so anything still
+// holding on to the local ID finds the item anyway. While an upload is creating
+// an item, changes that need its server ID wait for the upload instead of
+// creating the item a second time, or failing.
+
+// idMap keeps track of the server IDs of items made locally
+type idMap struct {
+	mutex    *mu.Mutex
+	remote   map[string]string        // server IDs by local ID
+	creating map[string]chan struct{} // closed once an item's creation is over
+}
+
+func newIDMap() *idMap {
+	return &idMap{
+		mutex:    &mu.Mutex{},
+		remote:   make(map[string]string),
+		creating: make(map[string]chan struct{}),
+	}
+}
+
+// assign records the server ID an item with a local ID got
+func (m *idMap) assign(localID string, remoteID string) {
+	if m == nil || !isLocalID(localID) || isLocalID(remoteID) {
+		return
+	}
+	m.mutex.Lock()
+	m.remote[localID] = remoteID
+	m.mutex.Unlock()
+}
+
+// resolve returns the server ID the item with a local ID got. Returns false if
+// it has none yet.
+func (m *idMap) resolve(localID string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	remoteID, ok := m.remote[localID]
+	return remoteID, ok
+}
+
+// startCreating records that the item with a local ID is being created on the
+// server. Returns a func to call once that's over, whether it succeeded or not.
+func (m *idMap) startCreating(localID string) func() {
+	if m == nil || !isLocalID(localID) {
+		return func() {}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.creating[localID]; ok {
+		// someone else is already on it and will say when they're done
+		return func() {}
+	}
+	done := make(chan struct{})
+	m.creating[localID] = done
+	return func() {
+		m.mutex.Lock()
+		delete(m.creating, localID)
+		m.mutex.Unlock()
+		close(done)
+	}
+}
+
+// wait waits for the item with a local ID to be created if that is under way,
+// and returns the server ID it got. Returns false if it has none.
+func (m *idMap) wait(localID string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	m.mutex.Lock()
+	done := m.creating[localID]
+	m.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+	return m.resolve(localID)
+}
@@ -0,0 +1,149 @@
+package graph
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// startUpload writes content to a new file at path and waits for its upload to
+// start while the mock server holds it up. The mock's mutex is held on return.
+func startUpload(t *testing.T, mock *mockGraph, fs *FuseFs, path string, content string) *DriveItem {
+	file, status := fs.Create(path, syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	file.Write([]byte(content), 0)
+	item, err := fs.items.Get(path, nil)
+	failOnErr(t, err)
+
+	mock.mutex.Lock()
+	file.Release()
+	for i := 0; ; i++ {
+		item.mutex.RLock()
+		uploading := item.uploading
+		item.mutex.RUnlock()
+		if uploading {
+			return item
+		} else if i > 100 {
+			mock.mutex.Unlock()
+			t.Fatal("Upload did not start.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// renaming a file that is being created by its upload should wait for the
+// upload, and rename what it created
+func TestRenameWhileCreating(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	item := startUpload(t, mock, fs, "/Documents/new.txt", "content")
+	localID := item.ID()
+
+	renamed := make(chan fuse.Status)
+	go func() {
+		renamed <- fs.Rename("/Documents/new.txt", "/Documents/renamed.txt", nil)
+	}()
+	select {
+	case status := <-renamed:
+		mock.mutex.Unlock()
+		t.Fatal("Rename did not wait for the upload, got", status)
+	case <-time.After(200 * time.Millisecond):
+	}
+	mock.mutex.Unlock()
+	if status := <-renamed; status != fuse.OK {
+		t.Fatal("Could not rename file:", status)
+	}
+
+	if fs.items.GetID(localID) != item {
+		t.Error("Item could not be found by its local ID anymore.")
+	}
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	children := mock.childrenOf(mock.child(mockRootID, "Documents").id)
+	if len(children) != 1 || children[0].name != "renamed.txt" || string(children[0].content) != "content" {
+		t.Fatal("Server should only have the renamed file:", children)
+	}
+}
+
+// deleting a file that is being created by its upload should delete what the
+// upload created
+func TestUnlinkWhileCreating(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	startUpload(t, mock, fs, "/Documents/gone.txt", "content")
+
+	status := fs.Unlink("/Documents/gone.txt", nil)
+	mock.mutex.Unlock()
+	if status != fuse.OK {
+		t.Fatal("Could not delete file:", status)
+	}
+	for i := 0; ; i++ {
+		mock.mutex.Lock()
+		children := mock.childrenOf(mock.child(mockRootID, "Documents").id)
+		mock.mutex.Unlock()
+		if i > 10 && len(children) == 0 {
+			break
+		} else if i > 100 {
+			t.Fatal("Deleted file is still on the server:", children)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// removing a folder that is being created on the server should delete what was
+// created, and not get in the way of making it again
+func TestRmdirWhileCreating(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	onServer := func() []*mockItem {
+		mock.mutex.Lock()
+		defer mock.mutex.Unlock()
+		return mock.childrenOf(mock.child(mockRootID, "Documents").id)
+	}
+
+	mock.mutex.Lock()
+	if status := fs.Mkdir("/Documents/gone", 0755, nil); status != fuse.OK {
+		mock.mutex.Unlock()
+		t.Fatal("Could not create folder:", status)
+	}
+	time.Sleep(50 * time.Millisecond) // for the folder to be sent to the server
+	status := fs.Rmdir("/Documents/gone", nil)
+	mock.mutex.Unlock()
+	if status != fuse.OK {
+		t.Fatal("Could not remove folder:", status)
+	}
+	for i := 0; ; i++ {
+		children := onServer()
+		if i > 10 && len(children) == 0 {
+			break
+		} else if i > 100 {
+			t.Fatal("Removed folder is still on the server:", children)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status := fs.Mkdir("/Documents/gone", 0755, nil); status != fuse.OK {
+		t.Fatal("Could not create folder again:", status)
+	}
+	item, err := fs.items.Get("/Documents/gone", nil)
+	failOnErr(t, err)
+	for i := 0; ; i++ {
+		children := onServer()
+		if len(children) == 1 && children[0].id == item.ID() {
+			break
+		} else if i > 100 {
+			t.Fatal("Folder made again did not make it to the server:", children)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
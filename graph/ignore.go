@@ -0,0 +1,238 @@
+package graph
+
+import (
+	"path"
+	"strings"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// name of the files holding ignore patterns for the directory they are in
+const ignoreFileName = ".onedriverignore"
+
+// ignorePattern is a single gitignore-style pattern
+type ignorePattern struct {
+	base     string   // directory the pattern is relative to
+	parts    []string // the pattern, split at "/"
+	negate   bool     // "!pattern", re-includes what an earlier pattern excluded
+	dirOnly  bool     // "pattern/", only matches directories
+	anchored bool     // has a "/" in it, so only matches relative to base
+}
+
+// parseIgnorePatterns parses the lines of a gitignore-style file. Patterns are
+// relative to the directory base.
+func parseIgnorePatterns(base string, lines []string) []ignorePattern {
+	patterns := make([]ignorePattern, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		pattern := ignorePattern{base: strings.TrimSuffix(base, "/")}
+		if line[0] == '!' {
+			pattern.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			// "\#" and "\!" are literal
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			pattern.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		pattern.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		// OneDrive is case-insensitive, so are its ignore patterns
+		pattern.parts = strings.Split(strings.ToLower(line), "/")
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// match checks if a path matches the pattern
+func (p ignorePattern) match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	path = strings.ToLower(path)
+	if !strings.HasPrefix(path, strings.ToLower(p.base)+"/") {
+		return false
+	}
+	segments := strings.Split(path[len(p.base)+1:], "/")
+	if !p.anchored {
+		return matchSegments(p.parts, segments[len(segments)-1:])
+	}
+	return matchSegments(p.parts, segments)
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches any number of segments
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			// "dir/**" matches everything inside dir, but not dir itself
+			return len(segments) > 0
+		}
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], segments[0])
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+// ignoreFile is a parsed .onedriverignore file
+type ignoreFile struct {
+	item     *DriveItem
+	modTime  uint64
+	size     uint64
+	patterns []ignorePattern
+}
+
+// ignoreRules decides which paths are ignored: kept local only, never uploaded
+// and left alone when processing changes from the server. Patterns come from
+// the config file, and from .onedriverignore files in any directory above a
+// path, with patterns in deeper directories taking precedence.
+type ignoreRules struct {
+	mutex  *mu.RWMutex
+	global []ignorePattern
+	files  map[string]ignoreFile // by ID of the directory the file is in
+}
+
+func newIgnoreRules() *ignoreRules {
+	return &ignoreRules{
+		mutex: &mu.RWMutex{},
+		files: make(map[string]ignoreFile),
+	}
+}
+
+// SetIgnorePatterns replaces the ignore patterns from the config file. They are
+// relative to the root of the filesystem.
+func (c *Cache) SetIgnorePatterns(patterns []string) {
+	parsed := parseIgnorePatterns("/", patterns)
+	c.ignore.mutex.Lock()
+	c.ignore.global = parsed
+	c.ignore.mutex.Unlock()
+}
+
+// ignoreFilePatterns returns the patterns in a directory's .onedriverignore file,
+// if it has one. Files are only read again once they change.
+func (c *Cache) ignoreFilePatterns(dirPath string) []ignorePattern {
+	dir, err := c.Get(dirPath, c.auth)
+	if err != nil || dir == nil {
+		return nil
+	}
+	children, err := c.GetChildrenID(dir.ID(), c.auth)
+	file, exists := children[foldName(ignoreFileName)]
+	if err != nil || !exists || file.IsDir() {
+		return nil
+	}
+
+	modTime, size := file.ModTime(), file.Size()
+	c.ignore.mutex.RLock()
+	parsed, exists := c.ignore.files[dir.ID()]
+	c.ignore.mutex.RUnlock()
+	if exists && parsed.item == file && parsed.modTime == modTime && parsed.size == size {
+		return parsed.patterns
+	}
+
+	file.mutex.RLock()
+	hasData := file.data != nil
+	file.mutex.RUnlock()
+	if !hasData {
+		if err := file.FetchContent(c.auth); err != nil {
+			log.WithFields(log.Fields{
+				"path": file.Path(),
+				"err":  err,
+			}).Error("Could not fetch ignore file, its patterns are not applied.")
+			return nil
+		}
+	}
+	file.mutex.RLock()
+	var content []byte
+	if file.data != nil {
+		content = make([]byte, len(*file.data))
+		file.copyContentLocked(content)
+	}
+	file.mutex.RUnlock()
+	if content == nil {
+		// evicted again already, it is parsed the next time around
+		return nil
+	}
+	lines := strings.Split(string(content), "\n")
+	parsed = ignoreFile{
+		item:     file,
+		modTime:  modTime,
+		size:     size,
+		patterns: parseIgnorePatterns(dirPath, lines),
+	}
+
+	c.ignore.mutex.Lock()
+	c.ignore.files[dir.ID()] = parsed
+	c.ignore.mutex.Unlock()
+	return parsed.patterns
+}
+
+// ignored checks if the item at path is ignored. Like with git, everything
+// inside an ignored directory is ignored too.
+func (c *Cache) ignored(path string, isDir bool) bool {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return false
+	}
+	c.ignore.mutex.RLock()
+	patterns := c.ignore.global
+	c.ignore.mutex.RUnlock()
+
+	segments := strings.Split(path, "/")[1:]
+	dir := "/"
+	for i := range segments {
+		patterns = append(patterns[:len(patterns):len(patterns)],
+			c.ignoreFilePatterns(dir)...)
+		current := dir + segments[i]
+		if matchIgnorePatterns(patterns, current, isDir || i < len(segments)-1) {
+			log.WithFields(log.Fields{"path": path}).Trace("Path is ignored.")
+			return true
+		}
+		dir = current + "/"
+	}
+	return false
+}
+
+// matchIgnorePatterns applies patterns in order, the last one to match decides
+// if the path is ignored
+func matchIgnorePatterns(patterns []ignorePattern, path string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range patterns {
+		if pattern.match(path, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// localOnly checks if a change to the item at path has to stay local, either
+// because the path is ignored or because of dry-run mode
+func (c *Cache) localOnly(method string, path string, isDir bool, fields log.Fields) bool {
+	if c.ignored(path, isDir) {
+		log.WithFields(log.Fields{
+			"method": method,
+			"path":   path,
+		}).Debug("Path is ignored, keeping change local.")
+		return true
+	}
+	return c.skipMutation(method, path, fields)
+}
@@ -0,0 +1,101 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// patterns should behave like they do in a .gitignore
+func TestIgnorePatterns(t *testing.T) {
+	global := parseIgnorePatterns("/", []string{"*.tmp", "/build/", "# comment", ""})
+	local := parseIgnorePatterns("/Projects/", []string{
+		"node_modules/", "docs/**/*.PDF", "!keep.tmp",
+	})
+	patterns := append(global, local...)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/scratch.tmp", false, true},
+		{"/Documents/scratch.TMP", false, true},
+		{"/build", true, true},
+		{"/build", false, false},
+		{"/Documents/build", true, false},
+		{"/Projects/app/node_modules", true, true},
+		{"/node_modules", true, false},
+		{"/Projects/docs/a/b/manual.pdf", false, true},
+		{"/Projects/docs/manual.pdf", false, true},
+		{"/Projects/manual.pdf", false, false},
+		{"/Projects/keep.tmp", false, false},
+		{"/Documents/keep.tmp", false, true},
+	}
+	for _, test := range tests {
+		if matchIgnorePatterns(patterns, test.path, test.isDir) != test.ignored {
+			t.Errorf("%s (dir: %v) should have been ignored: %v\n",
+				test.path, test.isDir, test.ignored)
+		}
+	}
+}
+
+// the files that never exist should be extendable with patterns from the config,
+// which can also bring back ignored ones
+func TestExtraIgnoredFiles(t *testing.T) {
+	fs := &FuseFs{mutex: &mu.RWMutex{}, ignored: ignoredPatterns(nil)}
+	if !fs.ignore("/.Trash") || !fs.ignore("/.Trash/files") || fs.ignore("/Documents/.Trash") {
+		t.Error("Builtin list of ignored files was not applied.")
+	}
+	fs.ignored = ignoredPatterns([]string{"desktop.ini", "Thumbs.db", "/.directory", "!/.hidden"})
+	tests := map[string]bool{
+		"/desktop.ini":            true,
+		"/Documents/Desktop.ini":  true,
+		"/Pictures/thumbs.db":     true,
+		"/.directory":             true,
+		"/Documents/.directory":   false,
+		"/.hidden":                false,
+		"/.DS_Store":              true,
+		"/Documents/notes.txt":    false,
+		"/Documents/desktop.ini2": false,
+	}
+	for path, ignored := range tests {
+		if fs.ignore(path) != ignored {
+			t.Errorf("%s should have been ignored: %v\n", path, ignored)
+		}
+	}
+}
+
+// files matching a .onedriverignore should never make it to the server
+func TestIgnoreFile(t *testing.T) {
+	ignoreFile := filepath.Join(TestDir, ignoreFileName)
+	failOnErr(t, ioutil.WriteFile(ignoreFile, []byte("*.ignored\nignored_dir/\n"), 0644))
+	defer os.Remove(ignoreFile)
+
+	fname := filepath.Join(TestDir, "local.ignored")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("not uploaded"), 0644))
+	defer os.Remove(fname)
+	dir := filepath.Join(TestDir, "ignored_dir")
+	failOnErr(t, os.Mkdir(dir, 0755))
+	defer os.RemoveAll(dir)
+	failOnErr(t, ioutil.WriteFile(filepath.Join(dir, "inside.txt"), []byte("local"), 0644))
+	time.Sleep(5 * time.Second)
+
+	for _, name := range []string{"local.ignored", "ignored_dir"} {
+		if _, err := GetItem("/onedriver_tests/"+name, auth); err == nil {
+			t.Fatalf("Ignored item %s was created on the server.\n", name)
+		}
+	}
+	if _, err := GetItem("/onedriver_tests/"+ignoreFileName, auth); err != nil {
+		t.Fatal("The ignore file itself should have been uploaded.")
+	}
+	contents, err := ioutil.ReadFile(fname)
+	failOnErr(t, err)
+	if string(contents) != "not uploaded" {
+		t.Fatalf("Local content of ignored file was lost, got \"%s\"\n", contents)
+	}
+}
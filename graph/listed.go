@@ -0,0 +1,63 @@
+package graph
+
+import (
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// listedTTL is how long the items of a directory listing can be looked up by
+// path without walking the tree
+const listedTTL = 5 * time.Second
+
+// listedItems remembers the items of recent directory listings by path. "ls -l"
+// and file managers follow up a listing with a stat of every item in it, which
+// this answers without walking the tree from the root for each of them. Any
+// change to the tree drops everything, since renames and deletions can make a
+// path point somewhere else.
+type listedItems struct {
+	mutex   *mu.RWMutex
+	items   map[string]*DriveItem // by folded path, see foldName()
+	expires time.Time
+}
+
+func newListedItems() *listedItems {
+	return &listedItems{mutex: &mu.RWMutex{}}
+}
+
+// add records the children of the directory at dir, keyed by their folded names
+// like GetChildrenPath() returns them
+func (l *listedItems) add(dir string, children map[string]*DriveItem) {
+	dir = foldName(strings.TrimSuffix(dir, "/"))
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.items == nil || now.After(l.expires) {
+		l.items = make(map[string]*DriveItem, len(children))
+		l.expires = now.Add(listedTTL)
+	}
+	for name, child := range children {
+		l.items[dir+"/"+name] = child
+	}
+}
+
+// get returns the item at path if it was in a recent listing
+func (l *listedItems) get(path string) *DriveItem {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if time.Now().After(l.expires) {
+		return nil
+	}
+	return l.items[foldName(path)]
+}
+
+// clear forgets all listings
+func (l *listedItems) clear() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	l.items = nil
+	l.mutex.Unlock()
+}
@@ -0,0 +1,34 @@
+package graph
+
+import (
+	"testing"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// items from a listing should be found by path until the listing expires or the
+// tree changes
+func TestListedItems(t *testing.T) {
+	l := newListedItems()
+	item := &DriveItem{IDInternal: "1", NameInternal: "Notes.txt", mutex: &mu.RWMutex{}}
+	l.add("/Documents/", map[string]*DriveItem{"notes.txt": item})
+
+	if l.get("/documents/NOTES.txt") != item {
+		t.Fatal("Listed item was not found by path.")
+	}
+	if l.get("/documents") != nil {
+		t.Fatal("Found an item that wasn't listed.")
+	}
+
+	l.clear()
+	if l.get("/documents/notes.txt") != nil {
+		t.Fatal("Listing was not forgotten after the tree changed.")
+	}
+
+	l.add("/documents", map[string]*DriveItem{"notes.txt": item})
+	l.expires = time.Now().Add(-time.Second)
+	if l.get("/documents/notes.txt") != nil {
+		t.Fatal("Expired listing was used.")
+	}
+}
@@ -0,0 +1,165 @@
+package graph
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestEntry describes one item in a manifest of a directory tree
+type ManifestEntry struct {
+	Path         string    `json:"path"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"` // file | folder
+	Size         uint64    `json:"size"`
+	Modified     time.Time `json:"modified"`
+	SHA1Hash     string    `json:"sha1Hash,omitempty"`
+	QuickXorHash string    `json:"quickXorHash,omitempty"`
+}
+
+// NewManifestEntry describes item, which is found at path
+func NewManifestEntry(item *DriveItem, path string) ManifestEntry {
+	entry := ManifestEntry{
+		Path:     path,
+		ID:       item.ID(),
+		Type:     "file",
+		Size:     item.Size(),
+		Modified: time.Unix(int64(item.ModTime()), 0).UTC(),
+	}
+	if item.IsDir() {
+		entry.Type = "folder"
+		entry.Size = 0
+	}
+	item.mutex.RLock()
+	if item.FileInternal != nil && item.FileInternal.Hashes != nil {
+		entry.SHA1Hash = item.FileInternal.Hashes.SHA1Hash
+		entry.QuickXorHash = item.FileInternal.Hashes.QuickXorHash
+	}
+	item.mutex.RUnlock()
+	return entry
+}
+
+// ManifestWriter writes manifest entries as they come, either as JSON (one
+// object per line) or as CSV with a header row.
+type ManifestWriter struct {
+	format string
+	json   *json.Encoder
+	csv    *csv.Writer
+}
+
+// NewManifestWriter creates a writer for format, one of "json" or "csv"
+func NewManifestWriter(w io.Writer, format string) (*ManifestWriter, error) {
+	switch format {
+	case "", "json":
+		return &ManifestWriter{format: "json", json: json.NewEncoder(w)}, nil
+	case "csv":
+		out := csv.NewWriter(w)
+		err := out.Write([]string{
+			"path", "id", "type", "size", "modified", "sha1Hash", "quickXorHash",
+		})
+		return &ManifestWriter{format: format, csv: out}, err
+	}
+	return nil, errors.New("unknown manifest format \"" + format + "\", must be json or csv")
+}
+
+// Write adds an entry to the manifest
+func (m *ManifestWriter) Write(entry ManifestEntry) error {
+	if m.json != nil {
+		return m.json.Encode(entry)
+	}
+	return m.csv.Write([]string{
+		entry.Path,
+		entry.ID,
+		entry.Type,
+		fmt.Sprint(entry.Size),
+		entry.Modified.Format(time.RFC3339),
+		entry.SHA1Hash,
+		entry.QuickXorHash,
+	})
+}
+
+// Flush writes out anything still buffered
+func (m *ManifestWriter) Flush() error {
+	if m.csv != nil {
+		m.csv.Flush()
+		return m.csv.Error()
+	}
+	return nil
+}
+
+// sortedByName orders items by name, so manifests of the same tree come out the
+// same every time
+func sortedByName(items []*DriveItem) []*DriveItem {
+	sort.Slice(items, func(i, j int) bool {
+		return strings.ToLower(items[i].Name()) < strings.ToLower(items[j].Name())
+	})
+	return items
+}
+
+// WalkRemote fetches every item under path from the server, parents before
+// their children, and calls fn with each of them.
+func WalkRemote(path string, auth *Auth, fn func(ManifestEntry) error) error {
+	item, err := GetItem(path, auth)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return walkRemote(item, path, auth, fn)
+}
+
+func walkRemote(item *DriveItem, path string, auth *Auth, fn func(ManifestEntry) error) error {
+	if err := fn(NewManifestEntry(item, path)); err != nil {
+		return err
+	}
+	if !item.IsDir() {
+		return nil
+	}
+	children, err := GetChildren(path, auth)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for _, child := range sortedByName(children) {
+		err = walkRemote(child, filepath.Join(path, child.Name()), auth, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WalkCached calls fn with every item under path that is in the cache, parents
+// before their children. Nothing is fetched from the server, so directories that
+// have never been listed show up without any children.
+func (c *Cache) WalkCached(path string, fn func(ManifestEntry) error) error {
+	item, err := c.Get(path, nil)
+	if err != nil {
+		return err
+	}
+	return c.walkCached(item, path, fn)
+}
+
+func (c *Cache) walkCached(item *DriveItem, path string, fn func(ManifestEntry) error) error {
+	if err := fn(NewManifestEntry(item, path)); err != nil {
+		return err
+	}
+	item.mutex.RLock()
+	children := make([]*DriveItem, 0, len(item.children))
+	for _, id := range item.children {
+		child := c.GetID(id)
+		if child != nil && !c.excluded(childPath(path, child.Name())) {
+			children = append(children, child)
+		}
+	}
+	item.mutex.RUnlock()
+	for _, child := range sortedByName(children) {
+		if err := c.walkCached(child, filepath.Join(path, child.Name()), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
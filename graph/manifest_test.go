@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// both formats should hold every field of an entry
+func TestManifestWriter(t *testing.T) {
+	entry := ManifestEntry{
+		Path:         "/Documents/notes, draft.txt",
+		ID:           "ABC!123",
+		Type:         "file",
+		Size:         42,
+		Modified:     time.Date(2020, 3, 1, 10, 22, 33, 0, time.UTC),
+		QuickXorHash: "aGFzaA==",
+	}
+
+	var out strings.Builder
+	manifest, err := NewManifestWriter(&out, "csv")
+	failOnErr(t, err)
+	failOnErr(t, manifest.Write(entry))
+	failOnErr(t, manifest.Flush())
+	expected := "path,id,type,size,modified,sha1Hash,quickXorHash\n" +
+		"\"/Documents/notes, draft.txt\",ABC!123,file,42,2020-03-01T10:22:33Z,,aGFzaA==\n"
+	if out.String() != expected {
+		t.Fatalf("Wrong CSV manifest:\n%s\n", out.String())
+	}
+
+	out.Reset()
+	manifest, err = NewManifestWriter(&out, "json")
+	failOnErr(t, err)
+	failOnErr(t, manifest.Write(entry))
+	var parsed ManifestEntry
+	failOnErr(t, json.Unmarshal([]byte(out.String()), &parsed))
+	if parsed != entry {
+		t.Fatalf("JSON manifest entry did not survive a round trip: %+v\n", parsed)
+	}
+
+	if _, err = NewManifestWriter(&out, "xml"); err == nil {
+		t.Fatal("Unknown manifest format was accepted.")
+	}
+}
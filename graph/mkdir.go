@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const retryOpMkdir = "mkdir"
+
+// errKeptLocal is returned for folders that can't be created on the server
+// because they (or a folder they're in) are kept local
+var errKeptLocal = errors.New("folder is kept local")
+
+// Folders are created locally right away, and on the server in the background,
+// so "mkdir -p" doesn't wait for a round trip per level. Until then they keep
+// their local ID. Uploads into a folder like that create it first.
+
+// createFolder creates a folder made locally on the server. Failures are
+// reported to the cache's listeners, which queues the folder to be retried.
+func (c *Cache) createFolder(item *DriveItem, auth *Auth) {
+	if _, err := c.remoteFolder(item, auth); err != nil && err != errKeptLocal {
+		log.WithFields(log.Fields{
+			"path": item.Path(),
+			"err":  err,
+		}).Error("Could not create folder on the server.")
+		item.notifyError(retryOpMkdir, err)
+	}
+}
+
+// ensureParent makes sure the folder an item is in exists on the server
+func (c *Cache) ensureParent(item *DriveItem, auth *Auth) error {
+	if c == nil {
+		return nil
+	}
+	item.mutex.RLock()
+	parentID := item.Parent.ID
+	item.mutex.RUnlock()
+	if !isLocalID(parentID) {
+		return nil
+	}
+	parent := c.GetID(parentID)
+	if parent == nil {
+		return nil
+	}
+	_, err := c.remoteFolder(parent, auth)
+	return err
+}
+
+// remoteFolder returns the server ID of a folder, creating it (and any local
+// folders it is in) on the server first if it only exists locally
+func (c *Cache) remoteFolder(item *DriveItem, auth *Auth) (string, error) {
+	if id := item.ID(); !isLocalID(id) {
+		return id, nil
+	}
+	// one at a time, so folders are neither created twice nor before the
+	// folders they are in
+	c.mkdirs.Lock()
+	defer c.mkdirs.Unlock()
+	return c.remoteFolderLocked(item, auth)
+}
+
+// remoteFolderLocked is remoteFolder(), with the mkdirs mutex held
+func (c *Cache) remoteFolderLocked(item *DriveItem, auth *Auth) (string, error) {
+	id := item.ID()
+	if !isLocalID(id) {
+		return id, nil
+	}
+	path := item.Path()
+	item.mutex.RLock()
+	parent := c.GetID(item.Parent.ID)
+	discarded := item.discarded
+	item.mutex.RUnlock()
+	if discarded {
+		// removed before it could be created, nothing left to do
+		return id, errKeptLocal
+	}
+	if c.localOnly("POST", path, true, nil) {
+		return id, errKeptLocal
+	}
+	if parent == nil {
+		return id, errors.New("parent of " + path + " is not cached")
+	}
+	if _, err := c.remoteFolderLocked(parent, auth); err != nil {
+		return id, err
+	}
+
+	resp, err := postFolder(item.Name(), parent, auth)
+	if err != nil && strings.Contains(err.Error(), "nameAlreadyExists") {
+		// created already, but the response never made it back
+		var existing *DriveItem
+		if existing, err = GetItem(filepath.Join(c.prefix, path), auth); err == nil {
+			if !existing.IsDir() {
+				return id, errors.New("a file named " + path + " already exists")
+			}
+			resp, err = json.Marshal(existing)
+		}
+	}
+	if err != nil {
+		return id, err
+	}
+
+	remote := &DriveItem{mutex: &mu.RWMutex{}}
+	if err = json.Unmarshal(resp, remote); err != nil {
+		return id, err
+	}
+	if err = c.assignID(item, id, remote.IDInternal); err != nil {
+		return id, err
+	}
+	if item.dropDiscarded(auth) {
+		// removed while it was being created
+		return remote.IDInternal, nil
+	}
+	item.mutex.Lock()
+	err = item.unmarshalLocked(resp)
+	item.mutex.Unlock()
+	item.notifyStatus()
+	return remote.IDInternal, err
+}
+
+// postFolder creates a folder named name in parent on the server
+func postFolder(name string, parent *DriveItem, auth *Auth) ([]byte, error) {
+	newFolderPost := DriveItem{
+		NameInternal: name,
+		Folder:       &Folder{},
+	}
+	bytePayload, _ := json.Marshal(newFolderPost)
+	drive, target := parent.contentTarget()
+	return Post(ChildrenPathID(target), scopedAuth(auth, drive), bytes.NewReader(bytePayload))
+}
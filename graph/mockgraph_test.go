@@ -0,0 +1,785 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockGraph is an in-memory stand-in for the parts of Microsoft Graph onedriver
+// uses: items, children, content, upload sessions, batches and deltas of a
+// single drive. Running the tests with ONEDRIVER_MOCK set mounts the filesystem
+// against one instead of a real OneDrive, so no account is needed. Anything it
+// doesn't implement fails with a "notSupported" error.
+type mockGraph struct {
+	server   *httptest.Server
+	previous string // the graphURL to go back to once closed
+
+	mutex    sync.Mutex
+	items    map[string]*mockItem
+	sessions map[string]*mockSession
+	seq      uint64 // bumped with every change, delta tokens are sequence numbers
+	expired  uint64 // delta tokens older than this have expired
+	lastID   int
+}
+
+// mockItem is an item on the mock server
+type mockItem struct {
+	id       string
+	name     string
+	parent   string // ID of the parent, "" for the root
+	folder   bool
+	content  []byte
+	modified time.Time
+	version  int    // changes with anything about the item, makes up its eTag
+	cVersion int    // changes with the content, or the children of folders
+	seq      uint64 // of the last change
+	deleted  bool   // deleted items are kept around for delta queries
+}
+
+// mockSession is an upload session on the mock server
+type mockSession struct {
+	id      string // of the item being uploaded to, "" for a new one
+	parent  string
+	name    string
+	content []byte
+	written uint64
+}
+
+const (
+	mockRootID      = "mock-root"
+	mockAccessToken = "mock-access-token"
+	mockPageSize    = 200
+)
+
+// newMockGraph starts a mock server with an empty drive and points all requests
+// at it until closed
+func newMockGraph() *mockGraph {
+	m := &mockGraph{
+		items:    make(map[string]*mockItem),
+		sessions: make(map[string]*mockSession),
+		previous: graphURL,
+	}
+	m.items[mockRootID] = &mockItem{id: mockRootID, name: "root", folder: true,
+		modified: time.Now().UTC()}
+	// the tests expect what every new account starts out with
+	m.create(m.items[mockRootID], "Documents", true)
+	intro := m.create(m.items[mockRootID], "Getting Started with Onedrive.pdf", false)
+	m.setContent(intro, []byte("%PDF-1.4\n%%EOF\n"))
+	m.server = httptest.NewServer(m)
+	graphURL = m.server.URL + "/v1.0"
+	return m
+}
+
+// close shuts the mock server down
+func (m *mockGraph) close() {
+	graphURL = m.previous
+	m.server.Close()
+}
+
+// usingMock returns whether the tests run against the mock server instead of
+// an account, see TestMain()
+func usingMock() bool {
+	return os.Getenv("ONEDRIVER_MOCK") != ""
+}
+
+// auth returns tokens the mock server accepts, and that never need refreshing
+func (m *mockGraph) auth() *Auth {
+	return &Auth{
+		AccessToken:  mockAccessToken,
+		RefreshToken: "mock-refresh-token",
+		ExpiresAt:    time.Now().Add(24 * 365 * time.Hour).Unix(),
+	}
+}
+
+// mockError is an error response in the format of the real thing
+type mockError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *mockError) write(w http.ResponseWriter) {
+	var body graphError
+	body.Error.Code, body.Error.Message = e.code, e.message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(body)
+}
+
+var (
+	errMockNotFound    = &mockError{http.StatusNotFound, "itemNotFound", "The resource could not be found."}
+	errMockExists      = &mockError{http.StatusConflict, "nameAlreadyExists", "The specified item name already exists."}
+	errMockBadRequest  = &mockError{http.StatusBadRequest, "invalidRequest", "Invalid request."}
+	errMockUnsupported = &mockError{http.StatusNotImplemented, "notSupported", "Not supported by the mock server."}
+	errMockResync      = &mockError{http.StatusGone, "resyncRequired", "Resync required."}
+)
+
+// ServeHTTP answers a request like Graph would
+func (m *mockGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if strings.HasPrefix(r.URL.Path, "/upload/") {
+		// upload URLs are pre-authenticated
+		m.serveUpload(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "bearer "+mockAccessToken {
+		(&mockError{http.StatusUnauthorized, "InvalidAuthenticationToken",
+			"Access token is empty."}).write(w)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	status, response, err := m.handle(r.Method, r.URL, r.Header.Get("Content-Range"), body)
+	if err != nil {
+		err.write(w)
+		return
+	}
+	m.writeResponse(w, status, response)
+}
+
+// writeResponse sends raw bytes as they are, anything else as JSON
+func (m *mockGraph) writeResponse(w http.ResponseWriter, status int, response interface{}) {
+	if raw, ok := response.([]byte); ok {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(status)
+		w.Write(raw)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if response != nil {
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// handle routes a request to Graph. Returns the status and body of the response.
+func (m *mockGraph) handle(method string, u *url.URL, contentRange string, body []byte) (int, interface{}, *mockError) {
+	resource := strings.TrimPrefix(u.Path, "/v1.0")
+	if strings.HasPrefix(resource, "/drives/") {
+		// there is only the one drive
+		parts := strings.SplitN(strings.TrimPrefix(resource, "/drives/"), "/", 2)
+		resource = "/me/drive"
+		if len(parts) == 2 {
+			resource += "/" + parts[1]
+		}
+	}
+	switch {
+	case resource == "/$batch" && method == "POST":
+		return m.batch(body)
+	case resource == "/me" && method == "GET":
+		return http.StatusOK, map[string]string{
+			"id":                "mock-user",
+			"displayName":       "Mock User",
+			"userPrincipalName": "mock@example.com",
+		}, nil
+	case resource == "/me/drive" && method == "GET":
+		return http.StatusOK, m.drive(), nil
+	case resource == "/me/drives" && method == "GET":
+		return http.StatusOK, map[string]interface{}{"value": []interface{}{m.drive()}}, nil
+	case strings.HasPrefix(resource, "/me/drive/"):
+		return m.handleItem(method, strings.TrimPrefix(resource, "/me/drive"), u.Query(), contentRange, body)
+	}
+	return 0, nil, errMockUnsupported
+}
+
+func (m *mockGraph) drive() map[string]interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var used uint64
+	for _, item := range m.items {
+		if !item.deleted {
+			used += uint64(len(item.content))
+		}
+	}
+	total := uint64(5 * 1024 * 1024 * 1024)
+	return map[string]interface{}{
+		"id":        "mock-drive",
+		"name":      "OneDrive",
+		"driveType": "personal",
+		"quota": DriveQuota{
+			Total:     total,
+			Used:      used,
+			Remaining: total - used,
+			State:     "normal",
+		},
+	}
+}
+
+// handleItem answers requests about items, addressed by ID or by path
+func (m *mockGraph) handleItem(method string, resource string, query url.Values, contentRange string, body []byte) (int, interface{}, *mockError) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var base *mockItem
+	switch {
+	case resource == "/root" || strings.HasPrefix(resource, "/root/") || strings.HasPrefix(resource, "/root:"):
+		base = m.items[mockRootID]
+		resource = strings.TrimPrefix(resource, "/root")
+	case strings.HasPrefix(resource, "/items/"):
+		resource = strings.TrimPrefix(resource, "/items/")
+		end := strings.IndexAny(resource, "/:")
+		if end < 0 {
+			end = len(resource)
+		}
+		base = m.items[resource[:end]]
+		resource = resource[end:]
+	default:
+		return 0, nil, errMockUnsupported
+	}
+	if base == nil || base.deleted {
+		return 0, nil, errMockNotFound
+	}
+
+	// path-based addressing, like "root:/a/b.txt:/content"
+	item, newName, addressed := base, "", false
+	if strings.HasPrefix(resource, ":") {
+		addressed = true
+		rest := strings.TrimPrefix(resource, ":")
+		path := rest
+		resource = ""
+		if end := strings.Index(rest, ":"); end >= 0 {
+			path, resource = rest[:end], rest[end+1:]
+		}
+		names := strings.Split(strings.Trim(path, "/"), "/")
+		for i, name := range names {
+			if name == "" {
+				continue
+			}
+			child := m.child(item.id, name)
+			if child == nil {
+				if i < len(names)-1 {
+					return 0, nil, errMockNotFound
+				}
+				// doesn't exist yet, only fine for creating it
+				newName = name
+				break
+			}
+			item = child
+		}
+	}
+	if newName != "" && !(method == "PUT" && resource == "/content" ||
+		method == "POST" && resource == "/createUploadSession") {
+		return 0, nil, errMockNotFound
+	}
+
+	switch {
+	case method == "GET" && resource == "":
+		return http.StatusOK, m.render(item), nil
+	case method == "GET" && resource == "/children":
+		return m.children(item, query)
+	case method == "POST" && resource == "/children":
+		return m.mkdir(item, body)
+	case method == "GET" && resource == "/content":
+		if item.folder {
+			return 0, nil, errMockBadRequest
+		}
+		return http.StatusOK, append([]byte{}, item.content...), nil
+	case method == "PUT" && resource == "/content":
+		if newName != "" {
+			item = m.create(item, newName, false)
+		} else if item.folder {
+			return 0, nil, errMockBadRequest
+		} else if addressed && query.Get("@microsoft.graph.conflictBehavior") != "replace" {
+			// uploads by path only create files, RemoteID() relies on this to
+			// not empty files that were just uploaded some other way
+			return 0, nil, errMockExists
+		}
+		m.setContent(item, body)
+		return http.StatusCreated, m.render(item), nil
+	case method == "POST" && resource == "/createUploadSession":
+		return m.createSession(item, newName, contentRange)
+	case method == "PATCH" && resource == "":
+		return m.patch(item, body)
+	case method == "DELETE" && resource == "":
+		if item.id == mockRootID {
+			return 0, nil, &mockError{http.StatusForbidden, "accessDenied", "The root can't be deleted."}
+		}
+		m.remove(item)
+		return http.StatusNoContent, nil, nil
+	case method == "GET" && resource == "/delta":
+		if item.id != mockRootID {
+			return 0, nil, errMockUnsupported
+		}
+		return m.delta(query)
+	}
+	return 0, nil, errMockUnsupported
+}
+
+// child finds a child by name like the server does, ignoring case. The mutex must
+// be held.
+func (m *mockGraph) child(parentID string, name string) *mockItem {
+	for _, item := range m.items {
+		if item.parent == parentID && !item.deleted && strings.EqualFold(item.name, name) {
+			return item
+		}
+	}
+	return nil
+}
+
+// childrenOf lists the children of a folder by name. The mutex must be held.
+func (m *mockGraph) childrenOf(parentID string) []*mockItem {
+	children := make([]*mockItem, 0)
+	for _, item := range m.items {
+		if item.parent == parentID && !item.deleted {
+			children = append(children, item)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// touch records a change to an item, and to the folder it is in. The mutex must
+// be held.
+func (m *mockGraph) touch(item *mockItem) {
+	m.seq++
+	item.seq = m.seq
+	item.version++
+	item.modified = time.Now().UTC()
+	if parent, exists := m.items[item.parent]; exists {
+		parent.version++
+		parent.cVersion++
+		parent.seq = m.seq
+	}
+}
+
+// create adds a new item to a folder. The mutex must be held.
+func (m *mockGraph) create(parent *mockItem, name string, folder bool) *mockItem {
+	m.lastID++
+	item := &mockItem{
+		id:     fmt.Sprintf("MOCK%06d", m.lastID),
+		name:   name,
+		parent: parent.id,
+		folder: folder,
+	}
+	m.items[item.id] = item
+	m.touch(item)
+	return item
+}
+
+// setContent replaces the content of a file. The mutex must be held.
+func (m *mockGraph) setContent(item *mockItem, content []byte) {
+	item.content = append([]byte{}, content...)
+	item.cVersion++
+	m.touch(item)
+}
+
+// remove deletes an item and everything in it. The mutex must be held.
+func (m *mockGraph) remove(item *mockItem) {
+	for _, child := range m.childrenOf(item.id) {
+		m.remove(child)
+	}
+	item.deleted = true
+	m.touch(item)
+}
+
+// path returns the path of an item's parent, in the format of parentReference.
+// The mutex must be held.
+func (m *mockGraph) parentPath(item *mockItem) string {
+	var names []string
+	for parent := m.items[item.parent]; parent != nil && parent.id != mockRootID; parent = m.items[parent.parent] {
+		names = append([]string{parent.name}, names...)
+	}
+	if len(names) == 0 {
+		return "/drive/root:"
+	}
+	return "/drive/root:/" + strings.Join(names, "/")
+}
+
+// render turns an item into its JSON representation. The mutex must be held.
+func (m *mockGraph) render(item *mockItem) map[string]interface{} {
+	rendered := map[string]interface{}{
+		"id":                   item.id,
+		"name":                 item.name,
+		"eTag":                 fmt.Sprintf("\"{%s},%d\"", item.id, item.version),
+		"cTag":                 fmt.Sprintf("\"c:{%s},%d\"", item.id, item.cVersion),
+		"lastModifiedDateTime": item.modified.Format(time.RFC3339),
+		"webUrl":               "https://onedrive.example.com/" + item.id,
+	}
+	if item.deleted {
+		rendered["deleted"] = map[string]string{"state": "deleted"}
+	}
+	if item.id == mockRootID {
+		rendered["root"] = map[string]string{}
+		rendered["parentReference"] = DriveItemParent{DriveID: "mock-drive"}
+	} else {
+		rendered["parentReference"] = DriveItemParent{
+			ID:      item.parent,
+			Path:    m.parentPath(item),
+			DriveID: "mock-drive",
+		}
+	}
+	if item.folder {
+		var size int
+		children := m.childrenOf(item.id)
+		for _, child := range children {
+			size += len(child.content)
+		}
+		rendered["size"] = size
+		rendered["folder"] = map[string]int{"childCount": len(children)}
+	} else {
+		rendered["size"] = len(item.content)
+		rendered["file"] = File{
+			MimeType: "application/octet-stream",
+			Hashes: &Hashes{
+				SHA1Hash:     SHA1Hash(item.content),
+				QuickXorHash: QuickXorHash(item.content),
+			},
+		}
+	}
+	return rendered
+}
+
+// page renders part of a list of items, with a nextLink to the rest. The mutex
+// must be held.
+func (m *mockGraph) page(items []*mockItem, resource string, query url.Values) map[string]interface{} {
+	top := mockPageSize
+	if requested, err := strconv.Atoi(query.Get("$top")); err == nil && requested > 0 {
+		top = requested
+	}
+	skip, _ := strconv.Atoi(query.Get("$skiptoken"))
+	if skip > len(items) {
+		skip = len(items)
+	}
+	end := skip + top
+	if end > len(items) {
+		end = len(items)
+	}
+	values := make([]interface{}, 0, end-skip)
+	for _, item := range items[skip:end] {
+		values = append(values, m.render(item))
+	}
+	page := map[string]interface{}{"value": values}
+	if end < len(items) {
+		next := url.Values{}
+		for key, value := range query {
+			next[key] = value
+		}
+		next.Set("$top", strconv.Itoa(top))
+		next.Set("$skiptoken", strconv.Itoa(end))
+		page["@odata.nextLink"] = graphURL + resource + "?" + next.Encode()
+	}
+	return page
+}
+
+func (m *mockGraph) children(item *mockItem, query url.Values) (int, interface{}, *mockError) {
+	if !item.folder {
+		return http.StatusOK, map[string]interface{}{"value": []interface{}{}}, nil
+	}
+	return http.StatusOK, m.page(m.childrenOf(item.id), "/me/drive/items/"+item.id+"/children", query), nil
+}
+
+// resolveName applies a conflictBehavior to a name taken by another item in
+// parent. Returns the name to use. The mutex must be held.
+func (m *mockGraph) resolveName(parent *mockItem, name string, behavior string, self *mockItem) (string, *mockError) {
+	existing := m.child(parent.id, name)
+	if existing == nil || existing == self {
+		return name, nil
+	}
+	switch behavior {
+	case "replace":
+		m.remove(existing)
+		return name, nil
+	case "rename":
+		for i := 1; ; i++ {
+			ext := strings.LastIndex(name, ".")
+			candidate := fmt.Sprintf("%s %d", name, i)
+			if ext > 0 {
+				candidate = fmt.Sprintf("%s %d%s", name[:ext], i, name[ext:])
+			}
+			if m.child(parent.id, candidate) == nil {
+				return candidate, nil
+			}
+		}
+	}
+	return "", errMockExists
+}
+
+func (m *mockGraph) mkdir(parent *mockItem, body []byte) (int, interface{}, *mockError) {
+	var request struct {
+		Name             string  `json:"name"`
+		Folder           *Folder `json:"folder"`
+		ConflictBehavior string  `json:"@microsoft.graph.conflictBehavior"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || request.Name == "" || !parent.folder {
+		return 0, nil, errMockBadRequest
+	}
+	name, err := m.resolveName(parent, request.Name, request.ConflictBehavior, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	item := m.create(parent, name, request.Folder != nil)
+	return http.StatusCreated, m.render(item), nil
+}
+
+func (m *mockGraph) patch(item *mockItem, body []byte) (int, interface{}, *mockError) {
+	var request struct {
+		Name             string           `json:"name"`
+		Parent           *DriveItemParent `json:"parentReference"`
+		ConflictBehavior string           `json:"@microsoft.graph.conflictBehavior"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return 0, nil, errMockBadRequest
+	}
+	parent := m.items[item.parent]
+	if request.Parent != nil && request.Parent.ID != "" {
+		parent = m.items[request.Parent.ID]
+		if parent == nil || parent.deleted || !parent.folder {
+			return 0, nil, errMockNotFound
+		}
+	}
+	name := item.name
+	if request.Name != "" {
+		name = request.Name
+	}
+	if parent != nil {
+		resolved, err := m.resolveName(parent, name, request.ConflictBehavior, item)
+		if err != nil {
+			return 0, nil, err
+		}
+		if old := m.items[item.parent]; old != nil && old != parent {
+			m.touch(old)
+		}
+		item.parent, name = parent.id, resolved
+	}
+	item.name = name
+	m.touch(item)
+	return http.StatusOK, m.render(item), nil
+}
+
+// delta answers delta queries. Tokens are the sequence number of the last change
+// already seen, "latest" skips everything that happened so far. Tokens older
+// than expired get resyncRequired, like ones the server forgot.
+func (m *mockGraph) delta(query url.Values) (int, interface{}, *mockError) {
+	token := query.Get("token")
+	if token == "latest" {
+		link := fmt.Sprintf("%s/me/drive/root/delta?token=%d", graphURL, m.seq)
+		return http.StatusOK, map[string]interface{}{
+			"value":            []interface{}{},
+			"@odata.deltaLink": link,
+		}, nil
+	}
+	since, _ := strconv.ParseUint(token, 10, 64)
+	if since < m.expired {
+		return 0, nil, errMockResync
+	}
+	changed := make([]*mockItem, 0)
+	for _, item := range m.items {
+		if item.seq > since || token == "" {
+			changed = append(changed, item)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].seq < changed[j].seq })
+	page := m.page(changed, "/me/drive/root/delta", query)
+	if _, more := page["@odata.nextLink"]; !more {
+		page["@odata.deltaLink"] = fmt.Sprintf("%s/me/drive/root/delta?token=%d", graphURL, m.seq)
+	}
+	return http.StatusOK, page, nil
+}
+
+// parseRange parses a Content-Range header like "bytes 0-99/1000"
+func parseRange(header string) (uint64, uint64, uint64, bool) {
+	var start, end, total uint64
+	_, err := fmt.Sscanf(header, "bytes %d-%d/%d", &start, &end, &total)
+	return start, end, total, err == nil && start <= end && end < total
+}
+
+func (m *mockGraph) createSession(item *mockItem, newName string, contentRange string) (int, interface{}, *mockError) {
+	session := &mockSession{}
+	if newName != "" {
+		session.parent, session.name = item.id, newName
+	} else if item.folder {
+		return 0, nil, errMockBadRequest
+	} else {
+		session.id = item.id
+	}
+	m.lastID++
+	sessionID := fmt.Sprintf("session%06d", m.lastID)
+	m.sessions[sessionID] = session
+	return http.StatusOK, map[string]interface{}{
+		"uploadUrl":          m.server.URL + "/upload/" + sessionID,
+		"expirationDateTime": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// serveUpload accepts the chunks of an upload session
+func (m *mockGraph) serveUpload(w http.ResponseWriter, r *http.Request) {
+	sessionID := strings.TrimPrefix(r.URL.Path, "/upload/")
+	m.mutex.Lock()
+	session, exists := m.sessions[sessionID]
+	if !exists {
+		m.mutex.Unlock()
+		errMockNotFound.write(w)
+		return
+	}
+	if r.Method == "DELETE" {
+		delete(m.sessions, sessionID)
+		m.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	m.mutex.Unlock()
+
+	start, end, total, ok := parseRange(r.Header.Get("Content-Range"))
+	chunk, _ := ioutil.ReadAll(r.Body)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if r.Method != "PUT" || !ok || start != session.written || uint64(len(chunk)) != end-start+1 {
+		(&mockError{http.StatusRequestedRangeNotSatisfiable, "invalidRange",
+			"The uploaded fragment doesn't fit."}).write(w)
+		return
+	}
+	if session.content == nil {
+		session.content = make([]byte, 0, total)
+	}
+	session.content = append(session.content, chunk...)
+	session.written = end + 1
+	if session.written < total {
+		m.writeResponse(w, http.StatusAccepted, map[string]interface{}{
+			"nextExpectedRanges": []string{fmt.Sprintf("%d-", session.written)},
+		})
+		return
+	}
+
+	delete(m.sessions, sessionID)
+	item := m.items[session.id]
+	if item == nil || item.deleted {
+		parent := m.items[session.parent]
+		if parent == nil || parent.deleted {
+			errMockNotFound.write(w)
+			return
+		}
+		if item = m.child(parent.id, session.name); item == nil {
+			item = m.create(parent, session.name, false)
+		}
+	}
+	m.setContent(item, session.content)
+	m.writeResponse(w, http.StatusCreated, m.render(item))
+}
+
+// batch answers each request in a JSON batch, bodies of requests that aren't
+// JSON are base64-encoded
+func (m *mockGraph) batch(body []byte) (int, interface{}, *mockError) {
+	var batch struct {
+		Requests []batchRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return 0, nil, errMockBadRequest
+	}
+	responses := make([]batchResponse, 0, len(batch.Requests))
+	for _, request := range batch.Requests {
+		content := []byte(request.Body)
+		if !strings.Contains(request.Headers["Content-Type"], "json") {
+			content, _ = base64.StdEncoding.DecodeString(request.Body)
+		}
+		u, err := url.Parse("/v1.0" + request.URL)
+		if err != nil {
+			continue
+		}
+		status, response, mockErr := m.handle(request.Method, u, "", content)
+		recorder := httptest.NewRecorder()
+		if mockErr != nil {
+			mockErr.write(recorder)
+			status = mockErr.status
+		} else {
+			m.writeResponse(recorder, status, response)
+		}
+		rendered := bytes.TrimSpace(recorder.Body.Bytes())
+		if len(rendered) == 0 || !json.Valid(rendered) {
+			rendered = []byte("{}")
+		}
+		responses = append(responses, batchResponse{
+			ID:     request.ID,
+			Status: status,
+			Body:   rendered,
+		})
+	}
+	return http.StatusOK, map[string]interface{}{"responses": responses}, nil
+}
+
+// the mock server has to behave enough like the real one for the filesystem to
+// work against it
+func TestMockGraph(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	auth := mock.auth()
+
+	root, err := GetItem("/", auth)
+	failOnErr(t, err)
+	if root.ID() != mockRootID || !root.IsDir() {
+		t.Fatalf("Wrong root item: %s\n", root.ID())
+	}
+	payload, _ := json.Marshal(DriveItem{NameInternal: "Folder", Folder: &Folder{}})
+	_, err = Post(ChildrenPathID(mockRootID), auth, bytes.NewReader(payload))
+	failOnErr(t, err)
+	if _, err = Post(ChildrenPathID(mockRootID), auth, bytes.NewReader(payload)); err == nil ||
+		!strings.Contains(err.Error(), "nameAlreadyExists") {
+		t.Fatalf("Creating a folder twice should fail: %v\n", err)
+	}
+
+	// uploads of every size through the cache
+	cache := NewCacheAt(auth, "/")
+	small := NewDriveItem("small.txt", 0644, cache.GetID(cache.root))
+	large := NewDriveItem("large.bin", 0644, cache.GetID(cache.root))
+	largeContent := bytes.Repeat([]byte("0123456789abcdef"), int(chunkSize+1024)/16)
+	for _, upload := range []struct {
+		item    *DriveItem
+		content []byte
+	}{{small, []byte("small file")}, {large, largeContent}} {
+		content := upload.content
+		upload.item.data = &content
+		upload.item.SizeInternal = uint64(len(content))
+		failOnErr(t, cache.Insert("/"+upload.item.Name(), auth, upload.item))
+		failOnErr(t, upload.item.Upload(auth))
+		if id, err := upload.item.RemoteID(auth); err != nil || isLocalID(id) {
+			t.Fatalf("%s still has a local ID after uploading.\n", upload.item.Name())
+		}
+		fetched, err := Get("/me/drive/root:/"+upload.item.Name()+":/content", auth)
+		failOnErr(t, err)
+		if !bytes.Equal(fetched, content) {
+			t.Fatalf("Content of %s changed on the way to the server.\n", upload.item.Name())
+		}
+	}
+
+	// paged listings (Documents, the intro PDF, the new folder and both uploads)
+	children, err := getAllPages(ChildrenPathID(mockRootID)+"?$top=1", auth)
+	failOnErr(t, err)
+	if len(children) != 5 {
+		t.Fatalf("Expected 5 children over several pages, got %d.\n", len(children))
+	}
+
+	// renames and deletions show up in deltas
+	body, err := Get("/me/drive/root/delta?token=latest", auth)
+	failOnErr(t, err)
+	var page struct {
+		DeltaLink string `json:"@odata.deltaLink"`
+	}
+	failOnErr(t, json.Unmarshal(body, &page))
+	failOnErr(t, patchName(small.ID(), auth, "renamed.txt"))
+	failOnErr(t, Delete("/me/drive/items/"+large.ID(), auth))
+	stream, err := getStream(strings.TrimPrefix(page.DeltaLink, graphURL), auth)
+	failOnErr(t, err)
+	defer stream.Close()
+	changed := make(map[string]*DriveItem)
+	_, _, err = decodeDeltaPage(stream, func(item *DriveItem) {
+		changed[item.IDInternal] = item
+	})
+	failOnErr(t, err)
+	if item := changed[small.ID()]; item == nil || item.Name() != "renamed.txt" {
+		t.Fatal("Rename was missing from deltas.")
+	}
+	if item := changed[large.ID()]; item == nil || item.Deleted == nil {
+		t.Fatal("Deletion was missing from deltas.")
+	}
+}
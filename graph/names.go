@@ -0,0 +1,200 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// OneDrive and SharePoint reject paths longer than this, counted in characters
+// from the root of the drive
+const (
+	maxNameLength = 255
+	maxPathLength = 400
+)
+
+// foldName returns the form names are compared in. OneDrive ignores case, so
+// "Report.docx" and "report.DOCX" are the same file. Names are always stored the
+// way they were given, folded names are only ever used to look them up.
+func foldName(name string) string {
+	return strings.ToLower(name)
+}
+
+// indexChildLocked records a child in the item's lookup index. The item must be
+// locked for writing.
+func (d *DriveItem) indexChildLocked(name string, id string) {
+	if d.childIndex == nil {
+		d.childIndex = make(map[string]string)
+	}
+	d.childIndex[foldName(name)] = id
+}
+
+// unindexChildLocked takes a child out of the item's lookup index, if it is still
+// filed under name. The item must be locked for writing.
+func (d *DriveItem) unindexChildLocked(name string, id string) {
+	if d.childIndex[foldName(name)] == id {
+		delete(d.childIndex, foldName(name))
+	}
+}
+
+// reindex rebuilds the lookup index of an item's children from scratch
+func (c *Cache) reindex(item *DriveItem) {
+	item.mutex.Lock()
+	defer item.mutex.Unlock()
+	item.childIndex = make(map[string]string, len(item.children))
+	for _, id := range item.children {
+		if child := c.GetID(id); child != nil {
+			item.childIndex[foldName(child.Name())] = id
+		}
+	}
+}
+
+// childNamed looks up a cached child of item by name, ignoring case. Returns nil
+// if item has no such child, or its children haven't been fetched.
+func (c *Cache) childNamed(item *DriveItem, name string) *DriveItem {
+	item.mutex.RLock()
+	fetched, indexed := item.children != nil, item.childIndex != nil
+	item.mutex.RUnlock()
+	if !fetched {
+		return nil
+	}
+	if !indexed {
+		c.reindex(item)
+	}
+	folded := foldName(name)
+	item.mutex.RLock()
+	id, exists := item.childIndex[folded]
+	item.mutex.RUnlock()
+	if !exists {
+		return nil
+	}
+	child := c.GetID(id)
+	if child == nil || foldName(child.Name()) != folded {
+		// renamed or deleted behind the index's back
+		c.reindex(item)
+		item.mutex.RLock()
+		id = item.childIndex[folded]
+		item.mutex.RUnlock()
+		if child = c.GetID(id); child == nil || foldName(child.Name()) != folded {
+			return nil
+		}
+	}
+	return child
+}
+
+// getChild returns the child of the item with the given ID by name, fetching
+// the item's children first if needed. Items hidden by selective sync are never
+// returned.
+func (c *Cache) getChild(id string, name string, auth *Auth) (*DriveItem, error) {
+	item := c.GetID(id)
+	if item == nil {
+		return nil, errors.New(id + " not found in cache")
+	}
+	if !c.childrenCached(id) {
+		if _, err := c.GetChildrenID(id, auth); err != nil {
+			return nil, err
+		}
+	}
+	child := c.childNamed(item, name)
+	if child == nil || c.excluded(childPath(item.Path(), child.Name())) {
+		return nil, nil
+	}
+	return child, nil
+}
+
+// updateItem updates a cached item with a freshly fetched copy of it, and deals
+// with the consequences: a rename on the server has to be reflected in the
+// lookup index of its folder, and changes conflicting with local ones have to be
+// resolved.
+func (c *Cache) updateItem(item *DriveItem, remote *DriveItem, auth *Auth) {
+	// the item stays filed under the folder it was in, even if moved
+	name := item.Name()
+	var parent *DriveItem
+	item.mutex.RLock()
+	if item.Parent != nil {
+		parent = c.GetID(item.Parent.ID)
+	}
+	item.mutex.RUnlock()
+	if item.updateMetadata(remote) {
+		go c.resolveConflict(item, remote, auth)
+	}
+	if newName := item.Name(); newName != name {
+		if parent != nil {
+			id := item.ID()
+			parent.mutex.Lock()
+			parent.unindexChildLocked(name, id)
+			parent.indexChildLocked(newName, id)
+			parent.mutex.Unlock()
+		}
+	}
+}
+
+// pathTooLong returns true if the server would reject an item at path, or the
+// cached contents of item if it were moved there, for being too long. Item may
+// be nil. Catching this early gives an ENAMETOOLONG instead of a failed upload
+// long after the fact.
+func (c *Cache) pathTooLong(path string, item *DriveItem) bool {
+	for _, name := range strings.Split(path, "/") {
+		if utf8.RuneCountInString(name) > maxNameLength {
+			return true
+		}
+	}
+	length := utf8.RuneCountInString(c.prefix + strings.TrimSuffix(path, "/"))
+	if item != nil {
+		length += c.longestWithin(item)
+	}
+	return length > maxPathLength
+}
+
+// longestWithin returns the length of the longest path relative to item among
+// everything cached inside it
+func (c *Cache) longestWithin(item *DriveItem) int {
+	item.mutex.RLock()
+	children := make([]string, len(item.children))
+	copy(children, item.children)
+	item.mutex.RUnlock()
+	longest := 0
+	for _, id := range children {
+		if child := c.GetID(id); child != nil {
+			length := 1 + utf8.RuneCountInString(child.Name()) + c.longestWithin(child)
+			if length > longest {
+				longest = length
+			}
+		}
+	}
+	return longest
+}
+
+// caseOnlyRename returns true if renaming oldPath to newPath only changes the
+// case of the name, like "readme.md" to "README.md"
+func caseOnlyRename(oldPath string, newPath string) bool {
+	oldName, newName := filepath.Base(oldPath), filepath.Base(newPath)
+	return filepath.Dir(oldPath) == filepath.Dir(newPath) && oldName != newName &&
+		foldName(oldName) == foldName(newName)
+}
+
+// renameCase changes the case of an item's name on the server. The server sees
+// both names as the same, so the item goes through a temporary name first
+// instead of risking it being taken for a rename onto itself.
+func renameCase(item *DriveItem, id string, auth *Auth, name string) error {
+	auth = item.driveAuth(auth)
+	if err := patchName(id, auth, name+".rename-"+randString(8)); err != nil {
+		return err
+	}
+	if err := patchName(id, auth, name); err != nil {
+		// better the old name than the temporary one
+		patchName(id, auth, item.Name())
+		return err
+	}
+	return nil
+}
+
+// patchName renames an item on the server
+func patchName(id string, auth *Auth, name string) error {
+	patch, _ := json.Marshal(DriveItem{NameInternal: name})
+	_, err := Patch("/me/drive/items/"+id, auth, bytes.NewReader(patch))
+	return err
+}
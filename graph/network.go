@@ -0,0 +1,123 @@
+package graph
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often a filesystem checks whether the server can be reached again
+const networkCheckInterval = 10 * time.Second
+
+// how long checking whether the server is back may take
+const networkProbeTimeout = 10 * time.Second
+
+// connectivity tracks whether the server can be reached, going by how requests
+// to it fare
+type connectivity struct {
+	mutex      sync.RWMutex
+	offline    time.Time // since when the server can't be reached, zero if it can
+	recoveries uint64    // how many times the server was reached again so far
+}
+
+var network = &connectivity{}
+
+// observe records the outcome of sending a request. err is the error from
+// sending it, not an error response, since getting any response at all means the
+// server is there.
+func (c *connectivity) observe(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		if c.offline.IsZero() {
+			c.offline = time.Now()
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warn("Lost the connection to the server, changes are kept until it is back.")
+		}
+		return
+	}
+	if c.offline.IsZero() {
+		return
+	}
+	log.WithFields(log.Fields{
+		"offline": time.Since(c.offline).Round(time.Second),
+	}).Info("The server can be reached again, resyncing.")
+	c.offline = time.Time{}
+	c.recoveries++
+}
+
+// Online returns whether the server could be reached the last time it was tried
+func (c *connectivity) Online() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.offline.IsZero()
+}
+
+// recovered returns how many times the server was reached again after losing
+// the connection to it
+func (c *connectivity) recovered() uint64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.recoveries
+}
+
+// probe checks whether the server can be reached again. Nothing else might be
+// sent for a long time while offline, with failed changes backing off further
+// with every attempt. No tokens are needed, any response will do.
+func (c *connectivity) probe() {
+	client := &http.Client{Transport: graphTransport, Timeout: networkProbeTimeout}
+	response, err := client.Get(graphURL + "/")
+	if err == nil {
+		response.Body.Close()
+	}
+	c.observe(err)
+}
+
+// watchNetwork resyncs the filesystem every time the server can be reached again
+// after the connection to it was lost, so nothing needs to be remounted after a
+// long time offline.
+func (fs *FuseFs) watchNetwork() {
+	seen := network.recovered()
+	for range time.Tick(networkCheckInterval) {
+		seen = fs.checkNetwork(seen)
+	}
+}
+
+// checkNetwork resyncs if the server was reached again since the last check, and
+// returns the number of recoveries seen so far
+func (fs *FuseFs) checkNetwork(seen uint64) uint64 {
+	if !network.Online() {
+		network.probe()
+	}
+	recovered := network.recovered()
+	if recovered != seen {
+		fs.resync()
+	}
+	return recovered
+}
+
+// resync catches up with the server after a time offline: it checks that the
+// delta link is still good, sends the changes that piled up right away instead
+// of whenever their backoff runs out, refetches the quota and starts uploading
+// anything else that changed in the meantime.
+func (fs *FuseFs) resync() {
+	if fs.items.Paused() || fs.Auth.Broken() {
+		// resuming (or logging in again) takes care of it
+		return
+	}
+	if err := fs.items.revalidateDelta(fs.Auth); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("Could not check the delta link after reconnecting.")
+	}
+	fs.retries.retryAll()
+	if err := fs.items.quota.refresh(fs.Auth); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("Could not refresh drive quota.")
+	}
+	fs.items.uploadChanges()
+}
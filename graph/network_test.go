@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// once the server is back, everything that piled up while offline should be
+// caught up with without remounting
+func TestNetworkRecovery(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	if _, err := fs.items.GetChildrenPath("/Documents", fs.Auth); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	gone := mock.create(docs, "gone.txt", false)
+	mock.create(docs, "new.txt", false)
+	mock.expired = mock.seq
+	mock.mutex.Unlock()
+	fs.items.deltaLink = "/me/drive/root/delta?token=0"
+	fs.retries.add(&retryEntry{Op: retryOpDelete, Path: "/Documents/gone.txt", ID: gone.id},
+		errors.New("network is unreachable"))
+	before := time.Now()
+
+	network.observe(errors.New("network is unreachable"))
+	if network.Online() {
+		t.Fatal("The server should count as unreachable.")
+	}
+	seen := network.recovered()
+	if fs.checkNetwork(seen) != seen+1 || !network.Online() {
+		t.Fatal("The server should have been found to be back.")
+	}
+
+	if fs.retries.count() != 0 {
+		t.Fatal("Queued changes should have been sent right away.")
+	}
+	mock.mutex.Lock()
+	deleted := gone.deleted
+	mock.mutex.Unlock()
+	if !deleted {
+		t.Fatal("Queued deletion did not reach the server.")
+	}
+	if fs.items.deltaLink != latestDelta {
+		t.Fatalf("Expired delta link should start over, got %s\n", fs.items.deltaLink)
+	}
+	children, err := fs.items.GetChildrenPath("/Documents", fs.Auth)
+	failOnErr(t, err)
+	if _, exists := children["new.txt"]; !exists {
+		t.Fatal("Listed folders should have been refreshed after the delta link expired.")
+	}
+	fs.items.quota.mutex.Lock()
+	fetched := fs.items.quota.fetched
+	fs.items.quota.mutex.Unlock()
+	if fetched.Before(before) {
+		t.Fatal("Quota should have been refreshed.")
+	}
+}
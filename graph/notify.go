@@ -0,0 +1,87 @@
+package graph
+
+import (
+	"path/filepath"
+
+	"github.com/godbus/dbus/v5"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// desktopNotifier is a SyncListener that pops up a desktop notification via
+// org.freedesktop.Notifications when an item fails to sync for a reason that
+// retrying won't fix. Each path only gets notified once until it syncs again.
+type desktopNotifier struct {
+	conn       *dbus.Conn
+	mountpoint string
+	mutex      *mu.Mutex
+	notified   map[string]bool
+}
+
+func (n *desktopNotifier) StatusChanged(path string, status string) {
+	if status == StatusSynced {
+		n.mutex.Lock()
+		delete(n.notified, path)
+		n.mutex.Unlock()
+	}
+}
+
+func (n *desktopNotifier) TransferProgress(path string, done uint64, total uint64) {}
+
+func (n *desktopNotifier) SyncError(path string, op string, err error) {
+	var summary, body string
+	if op == "cache" {
+		// the cache only reports this once each time the disk fills up
+		summary = "Local disk is full"
+		body = err.Error()
+	} else if op == "login" {
+		// only reported when the login breaks, not for every failed request
+		summary = "OneDrive login expired"
+		body = n.mountpoint + " is read-only until you log in again. " +
+			"Remount it, or run \"onedriver --auth-only\" for your default account."
+	} else {
+		reason, permanent := PermanentError(err)
+		if !permanent {
+			return
+		}
+		n.mutex.Lock()
+		if n.notified[path] {
+			n.mutex.Unlock()
+			return
+		}
+		n.notified[path] = true
+		n.mutex.Unlock()
+
+		summary = "Could not " + op + " " + filepath.Base(path)
+		body = filepath.Join(n.mountpoint, path) + "\n" + reason
+	}
+	obj := n.conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	// args are: app name, id to replace, icon, summary, body, actions, hints,
+	// and timeout (-1 is the server default)
+	call := obj.Call("org.freedesktop.Notifications.Notify", 0, "onedriver",
+		uint32(0), "dialog-error", summary, body, []string{},
+		map[string]dbus.Variant{}, int32(-1))
+	if call.Err != nil {
+		log.WithFields(log.Fields{
+			"path": path,
+			"err":  call.Err,
+		}).Warn("Could not send desktop notification.")
+	}
+}
+
+// EnableNotifications turns on desktop notifications for sync failures that
+// need the user's attention.
+func (fs *FuseFs) EnableNotifications(mountpoint string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(mountpoint)
+	fs.items.AddListener(&desktopNotifier{
+		conn:       conn,
+		mountpoint: abs,
+		mutex:      &mu.Mutex{},
+		notified:   make(map[string]bool),
+	})
+	return nil
+}
@@ -9,13 +9,16 @@ import "C"
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -36,6 +39,15 @@ type Auth struct {
 	ExpiresAt    int64  `json:"expires_at"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// set for tokens issued to another app registration, like one set up for
+	// rclone. Refresh tokens only work with the app they were issued to.
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	path         string // where the tokens are stored
+	drive        string // ID of the drive requests go to, "" for the user's own
+	account      *Auth  // whose tokens to use, when scoped to another drive
+	op           string // ID of the operation requests are made for, if any
+	broken       int32  // 1 once the tokens could not be renewed, see Broken()
 }
 
 // ToFile writes auth tokens to a file
@@ -53,37 +65,106 @@ func (a *Auth) FromFile(file string) error {
 	return json.Unmarshal(contents, a)
 }
 
-// Refresh auth tokens if expired.
+// tokenFile returns where these tokens are stored
+func (a Auth) tokenFile() string {
+	if a.path == "" {
+		return authFile
+	}
+	return a.path
+}
+
+// Refresh auth tokens if expired. If the server refuses to renew them (because
+// access was revoked or the password changed), the auth is marked as broken
+// until the user logs in again, see Broken(). If the server can't be reached, the
+// old tokens are kept and renewing them is tried again on the next request.
 func (a *Auth) Refresh() {
-	if a.ExpiresAt <= time.Now().Unix() {
+	if a.account != nil {
+		a.account.Refresh()
+		return
+	}
+	if a.ExpiresAt <= time.Now().Unix() && !a.Broken() {
 		log.Info("Auth tokens expired, attempting renewal.")
-		oldTime := a.ExpiresAt
-
-		postData := strings.NewReader("client_id=" + authClientID +
-			"&redirect_uri=" + authRedirectURL +
-			"&refresh_token=" + a.RefreshToken +
-			"&grant_type=refresh_token")
-		resp, err := http.Post(authTokenURL,
-			"application/x-www-form-urlencoded",
-			postData)
-		if err != nil {
+		err := a.renew()
+		if _, unreachable := err.(*url.Error); unreachable {
 			log.WithFields(log.Fields{
 				"err": err,
-			}).Fatal("Could not POST to renew tokens, exiting.")
+			}).Warn("Could not reach the server to renew access tokens.")
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("Failed to renew access tokens, the filesystem is read-only until you log in again.")
+			atomic.StoreInt32(&a.broken, 1)
 		}
-		defer resp.Body.Close()
+	}
+}
 
-		body, _ := ioutil.ReadAll(resp.Body)
-		json.Unmarshal(body, &a)
-		if a.ExpiresAt == oldTime {
-			a.ExpiresAt = time.Now().Unix() + a.ExpiresIn
-		}
-		if a.AccessToken == "" || a.RefreshToken == "" {
-			os.Remove(authFile)
-			log.Fatalf("Failed to renew access tokens. Response from server:\n%s\n", string(body))
+// renew exchanges the refresh token for new tokens
+func (a *Auth) renew() error {
+	params := "client_id=" + authClientID + "&redirect_uri=" + authRedirectURL
+	if a.ClientID != "" {
+		params = "client_id=" + url.QueryEscape(a.ClientID)
+		if a.ClientSecret != "" {
+			params += "&client_secret=" + url.QueryEscape(a.ClientSecret)
 		}
-		a.ToFile(authFile)
 	}
+	postData := strings.NewReader(params +
+		"&refresh_token=" + a.RefreshToken +
+		"&grant_type=refresh_token")
+	resp, err := http.Post(authTokenURL,
+		"application/x-www-form-urlencoded",
+		postData)
+	network.observe(err)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// an error response leaves the old tokens alone, so they're parsed apart
+	body, _ := ioutil.ReadAll(resp.Body)
+	var renewed Auth
+	json.Unmarshal(body, &renewed)
+	if renewed.AccessToken == "" || renewed.RefreshToken == "" {
+		return fmt.Errorf("response from server: %s", body)
+	}
+	a.AccessToken, a.RefreshToken = renewed.AccessToken, renewed.RefreshToken
+	a.ExpiresAt = renewed.ExpiresAt
+	if a.ExpiresAt == 0 {
+		a.ExpiresAt = time.Now().Unix() + renewed.ExpiresIn
+	}
+	return a.ToFile(a.tokenFile())
+}
+
+// Broken returns whether the tokens could not be renewed. Requests made with
+// broken auth fail with errAuthBroken instead of being sent.
+func (a *Auth) Broken() bool {
+	if a == nil {
+		return false
+	}
+	return atomic.LoadInt32(&a.tokens().broken) == 1
+}
+
+// errAuthBroken is returned for requests made while the tokens can't be renewed
+var errAuthBroken = errors.New("the login to OneDrive expired, log in again")
+
+// Recover checks whether there are new tokens in the token file since the auth
+// broke (from logging in again with "onedriver --auth-only"), and starts using
+// them if there are. Returns whether the auth works again.
+func (a *Auth) Recover() bool {
+	if !a.Broken() {
+		return true
+	}
+	a = a.tokens()
+	var stored Auth
+	if err := stored.FromFile(a.tokenFile()); err != nil ||
+		stored.RefreshToken == "" || stored.RefreshToken == a.RefreshToken {
+		return false
+	}
+	a.AccessToken, a.RefreshToken = stored.AccessToken, stored.RefreshToken
+	a.ExpiresAt = stored.ExpiresAt
+	atomic.StoreInt32(&a.broken, 0)
+	log.Info("Found new auth tokens, the filesystem is writable again.")
+	a.Refresh()
+	return !a.Broken()
 }
 
 // Fetch the auth code required as the first part of oauth2 authentication.
@@ -139,17 +220,67 @@ func getAuthTokens(authCode string) Auth {
 
 // Authenticate performs first-time authentication to Graph
 func Authenticate() *Auth {
+	return AuthenticateFile(authFile)
+}
+
+// AuthenticateFile is like Authenticate(), but keeps the auth tokens in file.
+// Used to stay logged in to several accounts at once.
+func AuthenticateFile(file string) *Auth {
 	var auth Auth
-	_, err := os.Stat(authFile)
+	_, err := os.Stat(file)
 	if os.IsNotExist(err) {
 		// no tokens found, gotta start oauth flow from beginning
 		code := getAuthCode()
 		auth = getAuthTokens(code)
-		auth.ToFile(authFile)
+		auth.path = file
+		os.MkdirAll(filepath.Dir(file), 0700)
+		auth.ToFile(file)
 	} else {
 		// we already have tokens, no need to force a refresh
-		auth.FromFile(authFile)
+		auth.path = file
+		auth.FromFile(file)
 		auth.Refresh()
+		if auth.Broken() {
+			// no cached filesystem to fall back on yet, log in again instead
+			os.Remove(file)
+			return AuthenticateFile(file)
+		}
 	}
 	return &auth
 }
+
+// oauth2Token is the token format of golang.org/x/oauth2, which rclone uses
+type oauth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// ImportToken stores an OAuth2 token issued to the app registration clientID
+// (with an optional secret) in file, so it can be used with AuthenticateFile.
+// The token is in the JSON format rclone keeps in its config.
+func ImportToken(token string, clientID string, clientSecret string, file string) (*Auth, error) {
+	var parsed oauth2Token
+	if err := json.Unmarshal([]byte(token), &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.RefreshToken == "" {
+		return nil, errors.New("token has no refresh token")
+	}
+	if clientID == "" {
+		return nil, errors.New("a client ID is required, " +
+			"refresh tokens only work with the app they were issued to")
+	}
+	auth := &Auth{
+		ExpiresAt:    parsed.Expiry.Unix(),
+		AccessToken:  parsed.AccessToken,
+		RefreshToken: parsed.RefreshToken,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		path:         file,
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	return auth, auth.ToFile(file)
+}
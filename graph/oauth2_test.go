@@ -1,13 +1,26 @@
 package graph
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 func TestAuthFromfile(t *testing.T) {
+	file := "auth_tokens.json"
+	if usingMock() {
+		// there is no real token file, read back the mock's instead
+		dir, _ := ioutil.TempDir("", "onedriver_auth")
+		defer os.RemoveAll(dir)
+		file = filepath.Join(dir, "auth_tokens.json")
+		mock := newMockGraph()
+		defer mock.close()
+		failOnErr(t, mock.auth().ToFile(file))
+	}
 	var auth Auth
-	auth.FromFile("auth_tokens.json")
+	auth.FromFile(file)
 	if auth.AccessToken == "" {
 		t.Fatal("Could not load auth tokens from 'auth_tokens.json'! " +
 			"Check that this file exists before running any more tests.")
@@ -15,6 +28,9 @@ func TestAuthFromfile(t *testing.T) {
 }
 
 func TestAuthRefresh(t *testing.T) {
+	if usingMock() {
+		t.Skip("Refreshing tokens takes Microsoft's login server.")
+	}
 	var auth Auth
 	auth.FromFile("auth_tokens.json")
 	auth.ExpiresAt = 0 // force an auth refresh
@@ -23,3 +39,28 @@ func TestAuthRefresh(t *testing.T) {
 		t.Fatal("Auth could not be refreshed successfully!")
 	}
 }
+
+// imported tokens should keep the app they were issued to when read back
+func TestImportToken(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_auth")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "imported.json")
+
+	token := `{"access_token":"access","token_type":"Bearer",` +
+		`"refresh_token":"refresh","expiry":"2020-03-01T10:22:33Z"}`
+	if _, err := ImportToken(token, "", "", file); err == nil {
+		t.Fatal("Token without a client ID was imported.")
+	}
+	_, err := ImportToken(token, "my-app", "secret", file)
+	failOnErr(t, err)
+
+	var auth Auth
+	failOnErr(t, auth.FromFile(file))
+	if auth.RefreshToken != "refresh" || auth.ClientID != "my-app" ||
+		auth.ClientSecret != "secret" {
+		t.Fatalf("Imported token was not stored correctly: %+v\n", auth)
+	}
+	if auth.ExpiresAt != time.Date(2020, 3, 1, 10, 22, 33, 0, time.UTC).Unix() {
+		t.Fatal("Wrong expiry time:", auth.ExpiresAt)
+	}
+}
@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// suffix of the links to Office Online that appear next to Office documents
+const officeLinkSuffix = ".desktop"
+
+// file types Office Online can open and edit
+var officeExtensions = map[string]string{
+	".doc":  "x-office-document",
+	".docx": "x-office-document",
+	".odt":  "x-office-document",
+	".xls":  "x-office-spreadsheet",
+	".xlsx": "x-office-spreadsheet",
+	".ods":  "x-office-spreadsheet",
+	".ppt":  "x-office-presentation",
+	".pptx": "x-office-presentation",
+	".odp":  "x-office-presentation",
+}
+
+// SetOfficeLinks turns links to Office Online on or off. When on, every Office
+// document gets a read-only "<name>.desktop" file next to it that opens the
+// document in the browser, for machines without an office suite. The links
+// aren't real files and never get uploaded.
+func (fs *FuseFs) SetOfficeLinks(enabled bool) {
+	fs.mutex.Lock()
+	fs.officeLinks = enabled
+	fs.mutex.Unlock()
+}
+
+func (fs *FuseFs) officeLinksEnabled() bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.officeLinks
+}
+
+// isOfficeDocument returns whether an item can be opened in Office Online
+func isOfficeDocument(item *DriveItem) bool {
+	if item.IsDir() {
+		return false
+	}
+	_, exists := officeExtensions[strings.ToLower(filepath.Ext(item.Name()))]
+	return exists
+}
+
+// officeLink generates the contents of the link to an Office document
+func officeLink(item *DriveItem) []byte {
+	icon := officeExtensions[strings.ToLower(filepath.Ext(item.Name()))]
+	return []byte(fmt.Sprintf(
+		"[Desktop Entry]\nType=Link\nName=%s\nIcon=%s\nURL=%s\n",
+		item.Name(), icon, item.WebURL()))
+}
+
+// officeLinkItem finds the document a link at path points to. Returns nil if
+// path is not a link, or links are off.
+func (fs *FuseFs) officeLinkItem(path string) *DriveItem {
+	if !strings.HasSuffix(path, officeLinkSuffix) || !fs.officeLinksEnabled() {
+		return nil
+	}
+	item, err := fs.items.Get(strings.TrimSuffix(path, officeLinkSuffix), fs.Auth)
+	if err != nil || !isOfficeDocument(item) || item.WebURL() == "" {
+		return nil
+	}
+	return item
+}
+
+// officeLinkEntries returns the directory entries of the links to any Office
+// documents among children
+func (fs *FuseFs) officeLinkEntries(children map[string]*DriveItem) []fuse.DirEntry {
+	entries := make([]fuse.DirEntry, 0)
+	if !fs.officeLinksEnabled() {
+		return entries
+	}
+	for _, child := range children {
+		if isOfficeDocument(child) && child.WebURL() != "" {
+			if _, exists := children[foldName(child.Name()+officeLinkSuffix)]; exists {
+				// a real file by that name wins
+				continue
+			}
+			entries = append(entries, fuse.DirEntry{
+				Name: child.Name() + officeLinkSuffix,
+				Mode: fuse.S_IFREG | 0444,
+			})
+		}
+	}
+	return entries
+}
+
+// officeLinkAttr returns the attributes of the link to an Office document
+func officeLinkAttr(item *DriveItem) *fuse.Attr {
+	return virtualAttr(false, uint64(len(officeLink(item))), item.ModTime())
+}
+
+// openOfficeLink opens the link to an Office document
+func openOfficeLink(item *DriveItem, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, fuse.EPERM
+	}
+	return nodefs.NewReadOnlyFile(nodefs.NewDataFile(officeLink(item))), fuse.OK
+}
@@ -0,0 +1,42 @@
+package graph
+
+import (
+	"strings"
+	"testing"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// only Office documents with a web address should get links, and never over a
+// real file
+func TestOfficeLinkEntries(t *testing.T) {
+	newItem := func(name string, url string) *DriveItem {
+		return &DriveItem{
+			NameInternal:   name,
+			WebURLInternal: url,
+			FileInternal:   &File{},
+			mutex:          &mu.RWMutex{},
+		}
+	}
+	children := map[string]*DriveItem{
+		"report.docx":         newItem("report.docx", "https://example.com/report"),
+		"budget.xlsx":         newItem("budget.xlsx", "https://example.com/budget"),
+		"budget.xlsx.desktop": newItem("budget.xlsx.desktop", "https://example.com/link"),
+		"local.pptx":          newItem("local.pptx", ""),
+		"notes.txt":           newItem("notes.txt", "https://example.com/notes"),
+	}
+	fs := &FuseFs{mutex: &mu.RWMutex{}}
+	if entries := fs.officeLinkEntries(children); len(entries) != 0 {
+		t.Fatal("Got links while they were turned off:", entries)
+	}
+
+	fs.SetOfficeLinks(true)
+	entries := fs.officeLinkEntries(children)
+	if len(entries) != 1 || entries[0].Name != "report.docx.desktop" {
+		t.Fatal("Wrong links:", entries)
+	}
+	link := string(officeLink(children["report.docx"]))
+	if !strings.Contains(link, "\nURL=https://example.com/report\n") {
+		t.Fatal("Link did not point to the document:", link)
+	}
+}
@@ -0,0 +1,71 @@
+package graph
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// While a document is open in Office, Office keeps an owner file next to it with
+// the name of whoever has it open, "~$" followed by the document's name (or by
+// all but its first two characters, for longer names). When one of those shows
+// up from the server, someone is editing the document elsewhere, and local
+// changes to it would clash with theirs. The document is then read-only here
+// until the owner file is gone again.
+
+// officeOwnerNames returns the names the owner file of a document can have
+func officeOwnerNames(name string) []string {
+	names := []string{"~$" + name}
+	if runes := []rune(name); len(runes) > 2 {
+		names = append(names, "~$"+string(runes[2:]))
+	}
+	return names
+}
+
+// officeOwner returns the owner file of a document that is open in Office
+// somewhere else, or nil if it isn't. Only what is already cached is looked at.
+func (fs *FuseFs) officeOwner(item *DriveItem) *DriveItem {
+	if !isOfficeDocument(item) {
+		return nil
+	}
+	item.mutex.RLock()
+	parentID := item.Parent.ID
+	item.mutex.RUnlock()
+	parent := fs.items.GetID(parentID)
+	if parent == nil {
+		return nil
+	}
+
+	for _, name := range officeOwnerNames(item.Name()) {
+		parent.mutex.RLock()
+		id, exists := parent.childIndex[foldName(name)]
+		parent.mutex.RUnlock()
+		if !exists {
+			continue
+		}
+		owner := fs.items.GetID(id)
+		if owner == nil || owner.IsDir() || isLocalID(owner.ID()) {
+			// not on the server, so Office was run on this machine
+			continue
+		}
+		owner.mutex.RLock()
+		openHere := owner.openHandles > 0
+		owner.mutex.RUnlock()
+		if !openHere {
+			return owner
+		}
+	}
+	return nil
+}
+
+// lockedByOffice checks whether a document can't be written to because it is
+// open in Office somewhere else
+func (fs *FuseFs) lockedByOffice(op operation, item *DriveItem, path string) bool {
+	owner := fs.officeOwner(item)
+	if owner == nil {
+		return false
+	}
+	op.WithFields(log.Fields{
+		"path":  path,
+		"owner": owner.Name(),
+	}).Info("Document is open in Office elsewhere, refusing to write to it.")
+	return true
+}
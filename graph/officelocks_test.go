@@ -0,0 +1,67 @@
+package graph
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestOfficeOwnerNames(t *testing.T) {
+	names := officeOwnerNames("document.docx")
+	if len(names) != 2 || names[0] != "~$document.docx" || names[1] != "~$cument.docx" {
+		t.Fatal("Wrong owner file names:", names)
+	}
+}
+
+// documents with an owner file from the server are open in Office elsewhere, and
+// shouldn't be written to until it's gone
+func TestOfficeLocks(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	mock.create(docs, "report.docx", false)
+	mock.create(docs, "~$port.docx", false)
+	mock.create(docs, "notes.txt", false)
+	mock.create(docs, "~$notes.txt", false)
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+
+	attr, status := fs.GetAttr("/Documents/report.docx", nil)
+	if status != fuse.OK {
+		t.Fatal("Document was not found:", status)
+	}
+	if attr.Mode&0222 != 0 {
+		t.Errorf("Locked document should be read-only, mode was %o.", attr.Mode)
+	}
+	if _, status := fs.Open("/Documents/report.docx", syscall.O_RDWR, nil); status != fuse.EACCES {
+		t.Error("Opening a locked document for writing should fail with EACCES, got", status)
+	}
+	if _, status := fs.Open("/Documents/report.docx", syscall.O_RDONLY, nil); status != fuse.OK {
+		t.Error("Could not open a locked document for reading:", status)
+	}
+
+	// only Office documents get locked
+	fs.GetAttr("/Documents/notes.txt", nil)
+	if _, status := fs.Open("/Documents/notes.txt", syscall.O_RDWR, nil); status != fuse.OK {
+		t.Error("Could not open a file that isn't an Office document:", status)
+	}
+
+	// closing the document elsewhere removes the owner file
+	fs.GetAttr("/Documents/~$port.docx", nil)
+	if status := fs.Unlink("/Documents/~$port.docx", nil); status != fuse.OK {
+		t.Fatal("Could not remove owner file:", status)
+	}
+	if _, status := fs.Open("/Documents/report.docx", syscall.O_RDWR, nil); status != fuse.OK {
+		t.Error("Could not open a document that was closed elsewhere:", status)
+	}
+
+	// an owner file made here was made by Office on this machine
+	if _, status := fs.Create("/Documents/~$report.docx", syscall.O_RDWR, 0644, nil); status != fuse.OK {
+		t.Fatal("Could not create owner file:", status)
+	}
+	if _, status := fs.Open("/Documents/report.docx", syscall.O_RDWR, nil); status != fuse.OK {
+		t.Error("A local owner file should not lock the document:", status)
+	}
+}
@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// operation is a single filesystem operation, like one Open() or Rename(). Its
+// ID is attached to everything it logs and sent along with every request it
+// makes to the server as the client-request-id header, so a single failing
+// "cp" can be followed from the FUSE call to the server's response (and
+// Microsoft can find it on their end, too).
+type operation struct {
+	*log.Entry
+	auth *Auth // makes requests on behalf of the operation
+}
+
+// opID returns a new operation ID. Graph wants a GUID for client-request-id.
+func opID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// newOp starts a new operation
+func (fs *FuseFs) newOp() operation {
+	id := opID()
+	return operation{
+		Entry: log.WithField("op", id),
+		auth:  fs.Auth.forOp(id),
+	}
+}
+
+// forOp returns auth that makes requests as part of the operation with the
+// given ID. The tokens are shared with auth.
+func (a *Auth) forOp(id string) *Auth {
+	if a == nil {
+		return nil
+	}
+	return &Auth{drive: a.drive, account: a.tokens(), op: id}
+}
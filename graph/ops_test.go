@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+// headerTransport remembers the client-request-id of every request it carries
+type headerTransport struct {
+	ids []string
+}
+
+func (h *headerTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	h.ids = append(h.ids, request.Header.Get("client-request-id"))
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// every request made for an operation should carry its ID, even when it goes to
+// another drive
+func TestOpRequestID(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	auth := mock.auth()
+	transport := &headerTransport{}
+	graphTransport = transport
+	defer func() { graphTransport = http.DefaultTransport }()
+
+	id := opID()
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Fatalf("Operation ID %q is not a GUID.\n", id)
+	}
+	opAuth := auth.forOp(id)
+	Get(ResourcePath("/"), opAuth)
+	Get(ResourcePath("/"), scopedAuth(opAuth, "b!otherdrive"))
+	Get(ResourcePath("/"), auth)
+
+	expected := []string{id, id, ""}
+	if len(transport.ids) != len(expected) {
+		t.Fatalf("Expected %d requests, saw %d\n", len(expected), len(transport.ids))
+	}
+	for i := range expected {
+		if transport.ids[i] != expected[i] {
+			t.Errorf("Request %d had ID %q, expected %q\n", i, transport.ids[i], expected[i])
+		}
+	}
+	if opAuth.tokens() != auth {
+		t.Error("Operations should use the filesystem's tokens.")
+	}
+}
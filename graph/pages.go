@@ -0,0 +1,35 @@
+package graph
+
+// The children of a folder can only be paged through by following nextLinks.
+// The $skiptoken in them is opaque, and $skip isn't supported for listing
+// children, so there is no telling where a page starts without fetching the one
+// before it, and the pages of one folder can't be fetched in parallel. What
+// keeps huge folders from taking forever is fetching them in pages as big as
+// the server allows (see childrenRequest()), and fetching the listings of
+// different folders at the same time where that's possible (see
+// prefetchPath()).
+
+// getChildrenPages fetches all children of the folder with the given ID, which
+// is expected to have count of them
+func getChildrenPages(id string, count uint32, auth *Auth) ([]*DriveItem, error) {
+	children, err := getAllPages(childrenRequest(id, count), auth)
+	if err != nil {
+		return nil, err
+	}
+	return uniqueItems(children), nil
+}
+
+// uniqueItems drops items that turned up more than once, like when something
+// was moved between pages while they were being fetched
+func uniqueItems(items []*DriveItem) []*DriveItem {
+	seen := make(map[string]bool, len(items))
+	unique := items[:0]
+	for _, item := range items {
+		if item == nil || seen[item.IDInternal] {
+			continue
+		}
+		seen[item.IDInternal] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
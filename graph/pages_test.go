@@ -0,0 +1,31 @@
+package graph
+
+import (
+	"strconv"
+	"testing"
+)
+
+// folders spanning many pages should be listed in full, without duplicates
+func TestManyChildren(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	const count = 2500
+	mock.mutex.Lock()
+	folder := mock.create(mock.child(mockRootID, "Documents"), "Camera Roll", true)
+	for i := 0; i < count; i++ {
+		mock.create(folder, "IMG_"+strconv.Itoa(i)+".jpg", false)
+	}
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+
+	children, err := fs.items.GetChildrenPath("/Documents/Camera Roll", fs.Auth)
+	failOnErr(t, err)
+	if len(children) != count {
+		t.Fatalf("Expected %d children, got %d.\n", count, len(children))
+	}
+	for i := 0; i < count; i++ {
+		if children["img_"+strconv.Itoa(i)+".jpg"] == nil {
+			t.Fatalf("IMG_%d.jpg is missing.\n", i)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package graph
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// saveJSON writes v to path as JSON. The file is written under a temporary name
+// first, so a crash or full disk never leaves a truncated file behind.
+func saveJSON(path string, v interface{}) error {
+	contents, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, contents, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadJSON reads a file written by saveJSON() into v. A missing file is not an
+// error, v is just left alone.
+func loadJSON(path string, v interface{}) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(contents, v)
+}
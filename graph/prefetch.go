@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"errors"
+	"strings"
+)
+
+// prefetchedChildren is the listing of a folder fetched ahead of time while
+// resolving a deep path
+type prefetchedChildren struct {
+	id       string // of the folder
+	children []*DriveItem
+	err      error
+}
+
+// prefetchPath starts fetching the listings of the folders along a path whose
+// children aren't cached yet, all at once instead of one level at a time.
+// Folders are looked up by their path on the server, so nothing has to wait for
+// the listing of the folder above. The listing of the folder containing
+// split[j] ends up in the returned channel at index j, for levels after the
+// first (that one is fetched as usual).
+func (c *Cache) prefetchPath(split []string, first int, auth *Auth) map[int]chan prefetchedChildren {
+	prefetched := make(map[int]chan prefetchedChildren)
+	for j := first + 1; j < len(split); j++ {
+		if c.excluded("/" + strings.Join(split[:j], "/")) {
+			// never fetch anything inside excluded folders
+			break
+		}
+		result := make(chan prefetchedChildren, 1)
+		prefetched[j] = result
+		go func(path string) {
+			result <- fetchListing(path, auth)
+		}(c.prefix + "/" + strings.Join(split[:j], "/"))
+	}
+	return prefetched
+}
+
+// fetchListing fetches the folder at path on the server and its children
+func fetchListing(path string, auth *Auth) prefetchedChildren {
+	folder, err := GetItem(path, auth)
+	if err != nil {
+		return prefetchedChildren{err: err}
+	}
+	if !folder.IsDir() || folder.RemoteItem != nil {
+		// shortcuts have to be listed through the drive they point to
+		return prefetchedChildren{err: errors.New(path + " can't be prefetched")}
+	}
+	children, err := getChildrenPages(folder.ID(), folder.ChildCount(), auth)
+	return prefetchedChildren{id: folder.ID(), children: children, err: err}
+}
+
+// usePrefetched caches a prefetched listing of the folder with the given ID,
+// unless it's no use anymore
+func (c *Cache) usePrefetched(id string, result prefetchedChildren, auth *Auth) {
+	item := c.GetID(id)
+	if result.err != nil || result.id != id || item == nil {
+		return
+	}
+	item.mutex.RLock()
+	usable := item.children == nil && item.drive == "" && item.RemoteItem == nil
+	item.mutex.RUnlock()
+	if usable {
+		c.storeChildren(item, result.children, "", auth)
+	}
+}
+
+// childrenCached checks if the children of the item with the given ID have been
+// fetched
+func (c *Cache) childrenCached(id string) bool {
+	item := c.GetID(id)
+	if item == nil {
+		return false
+	}
+	item.mutex.RLock()
+	defer item.mutex.RUnlock()
+	return item.children != nil
+}
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"encoding/json"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// how long the quota is kept for if the config doesn't say
+const defaultQuotaInterval = time.Minute
+
+// quotaCache keeps the drive's details around between refreshes, so that desktop
+// widgets polling "df" don't cost a request each time. Stale values are refreshed
+// in the background. In the meantime, files written and deleted since the last
+// refresh are accounted for locally, so the quota stays close to what the server
+// will report once the changes are uploaded.
+type quotaCache struct {
+	mutex      *mu.Mutex
+	drive      Drive
+	fetched    time.Time // zero if never fetched
+	interval   time.Duration
+	refreshing bool
+	written    int64  // how much files grew (or shrank) since the last refresh
+	deleted    uint64 // bytes moved to the recycle bin since the last refresh
+}
+
+func newQuotaCache() *quotaCache {
+	return &quotaCache{mutex: &mu.Mutex{}, interval: defaultQuotaInterval}
+}
+
+// SetQuotaInterval changes how long the drive's quota is cached for
+func (c *Cache) SetQuotaInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultQuotaInterval
+	}
+	c.quota.mutex.Lock()
+	c.quota.interval = interval
+	c.quota.mutex.Unlock()
+}
+
+// GetDrive fetches details about the drive auth points to
+func GetDrive(auth *Auth) (Drive, error) {
+	drive := Drive{}
+	body, err := Get("/me/drive", auth)
+	if err != nil {
+		return drive, err
+	}
+	err = json.Unmarshal(body, &drive)
+	return drive, err
+}
+
+// refresh refetches the drive's details. The old ones are kept if that fails.
+func (q *quotaCache) refresh(auth *Auth) error {
+	drive, err := GetDrive(auth)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.refreshing = false
+	if err != nil {
+		return err
+	}
+	q.drive = drive
+	q.fetched = time.Now()
+	// changes that haven't been uploaded yet are lost here, but uploads start
+	// as soon as files are closed, so the server is never far behind
+	q.written, q.deleted = 0, 0
+	return nil
+}
+
+// get returns the drive's details, fetching them only the first time. After
+// that, stale details are returned while they are refreshed in the background.
+func (q *quotaCache) get(auth *Auth) (Drive, error) {
+	q.mutex.Lock()
+	if q.fetched.IsZero() {
+		q.mutex.Unlock()
+		if err := q.refresh(auth); err != nil {
+			return Drive{}, err
+		}
+		q.mutex.Lock()
+	} else if time.Since(q.fetched) > q.interval && !q.refreshing {
+		q.refreshing = true
+		go func() {
+			if err := q.refresh(auth); err != nil {
+				log.WithFields(log.Fields{
+					"err": err,
+				}).Warn("Could not refresh drive quota.")
+			}
+		}()
+	}
+	drive := q.drive
+	written, deleted := q.written, q.deleted
+	q.mutex.Unlock()
+
+	drive.Quota.Used = addClamped(drive.Quota.Used, written)
+	drive.Quota.Remaining = addClamped(drive.Quota.Remaining, -written)
+	// the recycle bin counts towards the quota, deleting things frees nothing
+	drive.Quota.Deleted += deleted
+	return drive, nil
+}
+
+// addClamped adds delta to n without going below 0
+func addClamped(n uint64, delta int64) uint64 {
+	if delta < 0 && uint64(-delta) > n {
+		return 0
+	}
+	return uint64(int64(n) + delta)
+}
+
+// addWritten counts a file growing (or shrinking, if delta is negative)
+func (q *quotaCache) addWritten(delta int64) {
+	q.mutex.Lock()
+	q.written += delta
+	q.mutex.Unlock()
+}
+
+// addDeleted counts bytes moved to the recycle bin
+func (q *quotaCache) addDeleted(size uint64) {
+	q.mutex.Lock()
+	q.deleted += size
+	q.mutex.Unlock()
+}
+
+// room checks whether size more bytes still fit in the drive. If the quota hasn't
+// been fetched, it's assumed they do.
+func (q *quotaCache) room(size uint64) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.fetched.IsZero() {
+		return true
+	}
+	return addClamped(q.drive.Quota.Remaining, -q.written) >= size
+}
+
+// quota returns where changes to the item's size should be accounted for, nil if
+// nowhere (like for items in other drives, which have their own quota)
+func (d *DriveItem) quota() *quotaCache {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.quotaLocked()
+}
+
+func (d *DriveItem) quotaLocked() *quotaCache {
+	if d.cache == nil || d.drive != "" {
+		return nil
+	}
+	return d.cache.quota
+}
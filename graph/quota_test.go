@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+// cached quotas should account for changes made since they were fetched
+func TestQuotaCache(t *testing.T) {
+	q := newQuotaCache()
+	if !q.room(1 << 40) {
+		t.Error("Writes should not be refused before the quota is known.")
+	}
+	q.drive.Quota = DriveQuota{Total: 1000, Used: 400, Remaining: 600}
+	q.fetched = time.Now()
+
+	q.addWritten(150)
+	q.addWritten(-50)
+	q.addDeleted(20)
+	drive, err := q.get(nil) // fresh, so nothing is fetched
+	failOnErr(t, err)
+	if drive.Quota.Used != 500 || drive.Quota.Remaining != 500 || drive.Quota.Deleted != 20 {
+		t.Errorf("Local changes were not accounted for: %+v\n", drive.Quota)
+	}
+	if !q.room(500) || q.room(501) {
+		t.Error("Wrong amount of room left in the drive.")
+	}
+
+	q.addWritten(1000)
+	if drive, _ = q.get(nil); drive.Quota.Remaining != 0 {
+		t.Errorf("Remaining space should not wrap around: %+v\n", drive.Quota)
+	}
+	if q.drive.Quota.Used != 400 {
+		t.Error("Cached quota was modified in place.")
+	}
+}
@@ -0,0 +1,160 @@
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const recentDir = "/.recent"
+
+// how long the list of recent items is reused before being fetched again
+const recentTTL = 30 * time.Second
+
+// GetRecent fetches the items the user has used recently, most recent first.
+// Only items in the user's own drive are returned, with their paths filled in.
+func GetRecent(auth *Auth) ([]*DriveItem, error) {
+	body, err := Get("/me/drive/recent", auth)
+	if err != nil {
+		return nil, err
+	}
+	// recent items point at the actual item through their remoteItem facet,
+	// without a usable path
+	var recent struct {
+		Values []struct {
+			ID         string `json:"id"`
+			RemoteItem *struct {
+				ID string `json:"id"`
+			} `json:"remoteItem,omitempty"`
+		} `json:"value"`
+	}
+	if err = json.Unmarshal(body, &recent); err != nil {
+		return nil, err
+	}
+
+	items := make([]*DriveItem, len(recent.Values))
+	wg := sync.WaitGroup{}
+	limit := make(chan struct{}, 8) // requests in flight at once
+	for i, value := range recent.Values {
+		id := value.ID
+		if value.RemoteItem != nil {
+			id = value.RemoteItem.ID
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			body, err := Get("/me/drive/items/"+id, auth)
+			if err != nil {
+				// in someone else's drive, or deleted since
+				return
+			}
+			item := &DriveItem{mutex: &mu.RWMutex{}}
+			if json.Unmarshal(body, item) == nil {
+				items[i] = item
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	found := make([]*DriveItem, 0, len(items))
+	for _, item := range items {
+		if item != nil {
+			found = append(found, item)
+		}
+	}
+	return found, nil
+}
+
+// recentDirectory is the virtual directory at /.recent. It contains symlinks to
+// recently used files, like the "Recent" view of the OneDrive website. The links'
+// modification times are those of the files, so "ls -t" sorts them by recency.
+type recentDirectory struct {
+	fs      *FuseFs
+	mutex   *mu.Mutex
+	links   map[string]virtualLink
+	fetched time.Time
+}
+
+func newRecentDir(fs *FuseFs) *recentDirectory {
+	return &recentDirectory{fs: fs, mutex: &mu.Mutex{}}
+}
+
+// recent returns links to the recently used items
+func (r *recentDirectory) recent() (map[string]virtualLink, fuse.Status) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.links != nil && time.Since(r.fetched) < recentTTL {
+		return r.links, fuse.OK
+	}
+	items, err := GetRecent(r.fs.Auth)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Could not fetch recent items.")
+		return nil, fuse.EREMOTEIO
+	}
+	r.links = r.fs.virtualLinks(items)
+	r.fetched = time.Now()
+	return r.links, fuse.OK
+}
+
+// link finds a link by its path in the recent directory
+func (r *recentDirectory) link(path string) (virtualLink, fuse.Status) {
+	name := strings.TrimPrefix(path, "/")
+	if strings.Contains(name, "/") {
+		return virtualLink{}, fuse.ENOENT
+	}
+	links, status := r.recent()
+	if status != fuse.OK {
+		return virtualLink{}, status
+	}
+	link, exists := links[strings.ToLower(name)]
+	if !exists {
+		return virtualLink{}, fuse.ENOENT
+	}
+	return link, fuse.OK
+}
+
+func (r *recentDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	if path == "/" {
+		return virtualAttr(true, 0, uint64(time.Now().Unix())), fuse.OK
+	}
+	link, status := r.link(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return link.attr(recentDir + path), fuse.OK
+}
+
+func (r *recentDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	if path != "/" {
+		return nil, fuse.ENOTDIR
+	}
+	links, status := r.recent()
+	if status != fuse.OK {
+		return nil, status
+	}
+	return linkEntries(links), fuse.OK
+}
+
+// Open is never called for the links themselves, the kernel follows symlinks on
+// its own
+func (r *recentDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	return nil, fuse.EACCES
+}
+
+func (r *recentDirectory) Readlink(path string) (string, fuse.Status) {
+	link, status := r.link(path)
+	if status != fuse.OK {
+		return "", status
+	}
+	return link.target(recentDir + path), fuse.OK
+}
@@ -0,0 +1,29 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// everything in .recent should be a working link to a real item
+func TestRecentDir(t *testing.T) {
+	fname := filepath.Join(TestDir, "recent.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("used recently"), 0644))
+	time.Sleep(10 * time.Second) // wait for upload
+
+	dir := filepath.Join(mountLoc, recentDir)
+	entries, err := ioutil.ReadDir(dir)
+	failOnErr(t, err)
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s was not a symlink.\n", entry.Name())
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.Name())); err != nil {
+			t.Errorf("%s did not link to an existing item: %s\n", entry.Name(), err)
+		}
+	}
+}
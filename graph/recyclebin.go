@@ -0,0 +1,210 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const recycleBinDir = "/.recyclebin"
+
+// deletedItem is an entry in the recycle bin
+type deletedItem struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // where the item was before it got deleted
+	Size    uint64    `json:"size"`
+	Folder  bool      `json:"folder,omitempty"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// recycleBin is the virtual directory at /.recyclebin. Moving an item out of it
+// restores the item on the server. The Graph API can restore deleted items by
+// ID, but has no way of listing the drive's recycle bin, so only items deleted
+// through onedriver show up here. Anything else can still be restored from the
+// OneDrive website.
+type recycleBin struct {
+	fs    *FuseFs
+	mutex *mu.RWMutex
+	items []deletedItem // in order of deletion
+	path  string        // where the list of items is saved, "" if it isn't
+}
+
+func newRecycleBin(fs *FuseFs) *recycleBin {
+	return &recycleBin{fs: fs, mutex: &mu.RWMutex{}}
+}
+
+// load restores the list of deleted items saved at path, and saves it there from
+// now on
+func (r *recycleBin) load(path string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.path = path
+	return loadJSON(path, &r.items)
+}
+
+// save persists the list of deleted items, must be called with the mutex held
+func (r *recycleBin) save() {
+	if r.path == "" {
+		return
+	}
+	if err := saveJSON(r.path, r.items); err != nil {
+		log.WithFields(log.Fields{
+			"path": r.path,
+			"err":  err,
+		}).Warn("Could not save recycle bin contents.")
+	}
+}
+
+// add records an item that was just deleted on the server
+func (r *recycleBin) add(item *DriveItem, path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.items = append(r.items, deletedItem{
+		ID:      item.ID(),
+		Name:    item.Name(),
+		Path:    path,
+		Size:    item.Size(),
+		Folder:  item.IsDir(),
+		Deleted: time.Now(),
+	})
+	r.save()
+}
+
+// entries returns the deleted items by the name they appear under. Items with
+// the same name get a number appended, like "notes (2).txt".
+func (r *recycleBin) entries() map[string]deletedItem {
+	if r.fs != nil {
+		// items only show up here once they're deleted on the server
+		r.fs.deletes.flushCoalesced()
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	entries := make(map[string]deletedItem)
+	for _, item := range r.items {
+		item.Name = uniqueName(item.Name, func(name string) bool {
+			_, taken := entries[strings.ToLower(name)]
+			return taken
+		})
+		entries[strings.ToLower(item.Name)] = item
+	}
+	return entries
+}
+
+// entry finds a deleted item by its relative path in the recycle bin
+func (r *recycleBin) entry(path string) (deletedItem, bool) {
+	name := strings.TrimPrefix(path, "/")
+	if strings.Contains(name, "/") {
+		// deleted folders are shown without their contents
+		return deletedItem{}, false
+	}
+	item, exists := r.entries()[strings.ToLower(name)]
+	return item, exists
+}
+
+func (r *recycleBin) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	if path == "/" {
+		return virtualAttr(true, 0, uint64(time.Now().Unix())), fuse.OK
+	}
+	item, exists := r.entry(path)
+	if !exists {
+		return nil, fuse.ENOENT
+	}
+	return virtualAttr(item.Folder, item.Size, uint64(item.Deleted.Unix())), fuse.OK
+}
+
+func (r *recycleBin) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	if path != "/" {
+		if _, exists := r.entry(path); !exists {
+			return nil, fuse.ENOENT
+		}
+		return []fuse.DirEntry{}, fuse.OK
+	}
+	entries := r.entries()
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dirents := make([]fuse.DirEntry, 0, len(entries))
+	for _, name := range names {
+		item := entries[name]
+		mode := uint32(fuse.S_IFREG | 0444)
+		if item.Folder {
+			mode = fuse.S_IFDIR | 0555
+		}
+		dirents = append(dirents, fuse.DirEntry{Name: item.Name, Mode: mode})
+	}
+	return dirents, fuse.OK
+}
+
+// Open is not supported, the contents of deleted items can't be downloaded.
+func (r *recycleBin) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	return nil, fuse.EACCES
+}
+
+// MoveOut restores an item from the recycle bin to dest
+func (r *recycleBin) MoveOut(path string, dest string) fuse.Status {
+	item, exists := r.entry(path)
+	if !exists {
+		return fuse.ENOENT
+	}
+	fs := r.fs
+	if _, err := fs.items.Get(dest, fs.Auth); err == nil {
+		return fuse.Status(syscall.EEXIST)
+	}
+	parent, err := fs.items.Get(filepath.Dir(dest), fs.Auth)
+	if err != nil || !parent.IsDir() || isLocalID(parent.ID()) {
+		return fuse.ENOENT
+	}
+	if fs.items.skipMutation("POST", dest, log.Fields{"restore": item.Path}) {
+		return fuse.EPERM
+	}
+
+	payload, _ := json.Marshal(DriveItem{
+		NameInternal: filepath.Base(dest),
+		Parent:       &DriveItemParent{ID: parent.ID()},
+	})
+	_, err = Post("/me/drive/items/"+item.ID+"/restore", fs.Auth, bytes.NewReader(payload))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":   item.ID,
+			"path": item.Path,
+			"dest": dest,
+			"err":  err,
+		}).Error("Could not restore item from recycle bin.")
+		return fuse.EREMOTEIO
+	}
+	log.WithFields(log.Fields{
+		"path": item.Path,
+		"dest": dest,
+	}).Info("Restored item from recycle bin.")
+
+	r.mutex.Lock()
+	for i, deleted := range r.items {
+		if deleted.ID == item.ID {
+			r.items = append(r.items[:i], r.items[i+1:]...)
+			break
+		}
+	}
+	r.save()
+	r.mutex.Unlock()
+
+	// the restored item needs to show up in its new parent
+	if err = fs.items.Refresh(filepath.Dir(dest), fs.Auth); err != nil {
+		log.WithFields(log.Fields{
+			"path": filepath.Dir(dest),
+			"err":  err,
+		}).Warn("Could not refresh folder after restoring an item into it.")
+	}
+	return fuse.OK
+}
@@ -0,0 +1,43 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// items with the same name should not hide each other in the recycle bin
+func TestRecycleBinDuplicateNames(t *testing.T) {
+	bin := newRecycleBin(nil)
+	bin.items = []deletedItem{
+		{ID: "1", Name: "notes.txt", Deleted: time.Now()},
+		{ID: "2", Name: "notes.txt", Deleted: time.Now()},
+	}
+	entries := bin.entries()
+	if entries["notes.txt"].ID != "1" || entries["notes (2).txt"].ID != "2" {
+		t.Fatalf("Wrong names for items in recycle bin: %+v\n", entries)
+	}
+}
+
+// a deleted file should show up in the recycle bin, and moving it out should
+// restore it
+func TestRecycleBinRestore(t *testing.T) {
+	fname := filepath.Join(TestDir, "recycle_me.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("restore me"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+	failOnErr(t, os.Remove(fname))
+
+	binned := filepath.Join(mountLoc, recycleBinDir, "recycle_me.txt")
+	if _, err := os.Stat(binned); err != nil {
+		t.Fatal("Deleted file did not show up in the recycle bin:", err)
+	}
+	failOnErr(t, os.Rename(binned, fname))
+	if _, err := os.Stat(fname); err != nil {
+		t.Fatal("Restored file did not show up in its old location:", err)
+	}
+	if _, err := os.Stat(binned); err == nil {
+		t.Fatal("Restored file was still in the recycle bin.")
+	}
+}
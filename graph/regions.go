@@ -0,0 +1,84 @@
+package graph
+
+import (
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// regionSize is how much of a file's content one region spans
+const regionSize = 1024 * 1024
+
+// regionShards is how many locks the regions of a file share
+const regionShards = 64
+
+// regionLocks lets reads and in-place writes of different parts of a file go on
+// at the same time, while the item itself is only read locked. Anything that
+// resizes the content still needs the item's write lock. Regions map onto a
+// fixed number of locks, so regions far apart in large files can end up
+// sharing one.
+type regionLocks struct {
+	shards [regionShards]mu.RWMutex
+}
+
+// lock locks the regions spanning size bytes at offset, for writing if write is
+// set. Returns a function that unlocks them again.
+func (r *regionLocks) lock(offset int, size int, write bool) func() {
+	var locked [regionShards]bool
+	if size <= 0 {
+		size = 1
+	}
+	first, last := offset/regionSize, (offset+size-1)/regionSize
+	if last-first+1 >= regionShards {
+		first, last = 0, regionShards-1
+	}
+	for region := first; region <= last; region++ {
+		locked[region%regionShards] = true
+	}
+	// always in the same order, so two lockers can't end up waiting on each other
+	for shard := range locked {
+		if !locked[shard] {
+			continue
+		}
+		if write {
+			r.shards[shard].Lock()
+		} else {
+			r.shards[shard].RLock()
+		}
+	}
+	return func() {
+		for shard := range locked {
+			if !locked[shard] {
+				continue
+			}
+			if write {
+				r.shards[shard].Unlock()
+			} else {
+				r.shards[shard].RUnlock()
+			}
+		}
+	}
+}
+
+// writeInPlace overwrites part of the item's content without resizing it, and
+// only locks the regions written to. Returns false without writing anything if
+// data doesn't fit inside the current content, or no region locks were set up
+// yet.
+func (d *DriveItem) writeInPlace(data []byte, offset int) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.regions == nil || d.data == nil || offset+len(data) > len(*d.data) {
+		return false
+	}
+	unlock := d.regions.lock(offset, len(data), true)
+	copy((*d.data)[offset:], data)
+	unlock()
+	return true
+}
+
+// copyContentLocked copies the item's content into dst, while no in-place write
+// is going on. The item must be (read) locked.
+func (d *DriveItem) copyContentLocked(dst []byte) {
+	if d.regions != nil {
+		defer d.regions.lock(0, len(*d.data), false)()
+	}
+	copy(dst, *d.data)
+}
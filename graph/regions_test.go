@@ -0,0 +1,56 @@
+package graph
+
+import (
+	"testing"
+	"time"
+)
+
+// writes to different regions of a file shouldn't wait on each other, writes to
+// the same one should
+func TestRegionLocks(t *testing.T) {
+	var regions regionLocks
+	unlock := regions.lock(0, 10, true)
+
+	done := make(chan bool)
+	go func() {
+		regions.lock(regionSize, 10, true)()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write to another region was blocked by an unrelated write.")
+	}
+
+	go func() {
+		regions.lock(5, 10, false)()
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatal("Read of a region went ahead while it was being written.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	<-done
+}
+
+// in-place writes must keep the content intact and refuse to resize it
+func TestWriteInPlace(t *testing.T) {
+	item := NewDriveItem("file.txt", 0644, nil)
+	data := []byte("some file content")
+	item.data = &data
+	if item.writeInPlace([]byte("SOME"), 0) {
+		t.Fatal("Wrote in place before region locks were set up.")
+	}
+	item.regions = &regionLocks{}
+	if !item.writeInPlace([]byte("SOME"), 0) {
+		t.Fatal("In-place write was refused.")
+	}
+	if item.writeInPlace([]byte("contents"), 10) {
+		t.Fatal("Write past the end of the content was done in place.")
+	}
+	if string(*item.data) != "SOME file content" {
+		t.Fatalf("Got \"%s\" after writing in place.\n", *item.data)
+	}
+}
@@ -0,0 +1,128 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Items made locally have a local ID (see localID()) until they exist on the
+// server. Whatever gets them there - uploads, folders being created, or an empty
+// file being created because something needs an ID to work with - hands the new
+// ID to assignID(), so the cache is updated in one place. Creating items ahead
+// of their uploads is done one at a time with the cache's mkdirs mutex held,
+// like folders, which keeps them from being created twice, before the folders
+// they are in, or under a name they were just renamed from (see renameLocal()).
+
+// remoteID returns the server ID of an item. Items that are being uploaded for
+// the first time get theirs from the upload, anything else made locally is
+// created on the server first, see createRemote().
+func (c *Cache) remoteID(item *DriveItem, auth *Auth) (string, error) {
+	if id := item.ID(); isLocalID(id) && c != nil {
+		if remoteID, ok := c.ids.wait(id); ok {
+			return remoteID, nil
+		}
+	}
+	return c.createRemote(item, auth)
+}
+
+// createRemote creates an item on the server if it only exists locally, and
+// returns its server ID. Files are created empty, their content follows with
+// their next upload. Items that are kept local keep their local ID, and so do
+// all items if auth has no tokens.
+func (c *Cache) createRemote(item *DriveItem, auth *Auth) (string, error) {
+	id := item.ID()
+	if !isLocalID(id) || auth == nil || auth.tokens().AccessToken == "" {
+		return id, nil
+	}
+	if c == nil {
+		if item.IsDir() {
+			return id, nil
+		}
+		return c.createEmpty(item, id, auth)
+	}
+	if item.IsDir() {
+		id, err := c.remoteFolder(item, auth)
+		if err == errKeptLocal {
+			return id, nil
+		}
+		return id, err
+	}
+
+	c.mkdirs.Lock()
+	defer c.mkdirs.Unlock()
+	if id = item.ID(); !isLocalID(id) {
+		// created while waiting
+		return id, nil
+	}
+	if c.localOnly("PUT", item.Path(), false, nil) {
+		return id, nil
+	}
+	item.mutex.RLock()
+	parent := c.GetID(item.Parent.ID)
+	item.mutex.RUnlock()
+	if parent != nil {
+		if _, err := c.remoteFolderLocked(parent, auth); err != nil {
+			return id, err
+		}
+	}
+	return c.createEmpty(item, id, auth)
+}
+
+// createEmpty creates an empty file on the server for an item that only exists
+// locally, and returns its new ID
+func (c *Cache) createEmpty(item *DriveItem, localID string, auth *Auth) (string, error) {
+	resource := fmt.Sprintf("/me/drive/items/%s:/%s:/content", item.parentTarget(), item.Name())
+	resp, err := Put(resource, item.driveAuth(auth), strings.NewReader(""))
+	if err != nil && strings.Contains(err.Error(), "nameAlreadyExists") {
+		// uploads by path never replace files, so an upload of the item got
+		// there first
+		if id := item.ID(); !isLocalID(id) {
+			return id, nil
+		}
+		path := item.Path()
+		if c != nil {
+			path = filepath.Join(c.prefix, path)
+		}
+		var existing *DriveItem
+		if existing, err = GetItem(path, auth); err == nil {
+			return existing.IDInternal, c.assignID(item, localID, existing.IDInternal)
+		}
+	}
+	if err != nil {
+		return localID, err
+	}
+	return c.adopt(item, localID, resp)
+}
+
+// adopt gives an item made locally the ID it got on the server, taken from the
+// server's response to creating it
+func (c *Cache) adopt(item *DriveItem, localID string, resp []byte) (string, error) {
+	// unmarshalled separately, the rest of the response (like the size) could
+	// be older than the item
+	created := NewDriveItem(item.Name(), 0644, nil)
+	if err := json.Unmarshal(resp, created); err != nil {
+		return localID, err
+	}
+	if created.IDInternal == "" {
+		return localID, errors.New("no ID for " + item.Name() + " in the server's response")
+	}
+	return created.IDInternal, c.assignID(item, localID, created.IDInternal)
+}
+
+// assignID changes an item's ID, in the cache too if there is one
+func (c *Cache) assignID(item *DriveItem, oldID string, newID string) error {
+	if c != nil {
+		c.ids.assign(oldID, newID)
+		if c.GetID(oldID) != nil {
+			return c.MoveID(oldID, newID)
+		}
+		// deleted while it was being created
+	}
+	item.mutex.Lock()
+	item.IDInternal = newID
+	item.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,55 @@
+package graph
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// getting the ID of a file made locally should create it on the server once,
+// however many ask for it at the same time, and create its folder first
+func TestRemoteIDLocalFolder(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	if status := fs.Mkdir("/Documents/local", 0755, nil); status != fuse.OK {
+		t.Fatal("Could not create folder:", status)
+	}
+	if _, status := fs.Create("/Documents/local/file.txt", syscall.O_WRONLY|syscall.O_CREAT, 0644, nil); status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	item, err := fs.items.Get("/Documents/local/file.txt", fs.Auth)
+	failOnErr(t, err)
+
+	ids := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if ids[i], err = item.RemoteID(fs.Auth); err != nil {
+				t.Error("Could not get remote ID:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if isLocalID(id) || id != ids[0] {
+			t.Fatal("Got different or local IDs:", ids)
+		}
+	}
+
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	folder := mock.child(mock.child(mockRootID, "Documents").id, "local")
+	if folder == nil {
+		t.Fatal("Folder was not created on the server.")
+	}
+	if children := mock.childrenOf(folder.id); len(children) != 1 || children[0].id != ids[0] {
+		t.Fatal("File was not created on the server exactly once:", children)
+	}
+}
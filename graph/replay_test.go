@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tokens and email addresses should never make it into a recording
+func TestScrub(t *testing.T) {
+	tests := map[string]string{
+		`https://host/upload?tempauth=eyJ0eXAi.x-y&foo=bar`:         `https://host/upload?tempauth=REDACTED&foo=bar`,
+		`{"access_token": "EwBwA8l6", "expires_in": 3600}`:          `{"access_token": "REDACTED", "expires_in": 3600}`,
+		`{"userPrincipalName":"jane.doe@contoso.onmicrosoft.com"}`:  `{"userPrincipalName":"user@example.com"}`,
+		`{"@odata.nextLink":"https://graph.microsoft.com/v1.0/me"}`: `{"@odata.nextLink":"https://graph.microsoft.com/v1.0/me"}`,
+	}
+	for in, expected := range tests {
+		if scrubbed := scrub(in); scrubbed != expected {
+			t.Errorf("Scrubbing %s gave %s, expected %s\n", in, scrubbed, expected)
+		}
+	}
+}
+
+// a recording should play back the same responses without the server
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onedriver_replay")
+	failOnErr(t, err)
+	defer os.RemoveAll(dir)
+	defer func() { graphTransport = http.DefaultTransport }()
+	fixture := filepath.Join(dir, "traffic.json")
+
+	mock := newMockGraph()
+	auth := mock.auth()
+	RecordTraffic(fixture)
+	root, err := Get(ResourcePath("/"), auth)
+	failOnErr(t, err)
+	_, err = Post(ChildrenPath("/"), auth,
+		strings.NewReader(`{"name":"recorded","folder":{}}`))
+	failOnErr(t, err)
+	children, err := Get(ChildrenPath("/"), auth)
+	failOnErr(t, err)
+	_, failed := Get(ResourcePath("/missing"), auth)
+	if failed == nil {
+		t.Fatal("Fetching a missing item should fail.")
+	}
+	mock.close()
+
+	recording, err := ioutil.ReadFile(fixture)
+	failOnErr(t, err)
+	if bytes.Contains(recording, []byte(mockAccessToken)) {
+		t.Fatal("The access token ended up in the recording.")
+	}
+
+	failOnErr(t, ReplayTraffic(fixture))
+	replayed, err := Get(ResourcePath("/"), auth)
+	failOnErr(t, err)
+	if !bytes.Equal(replayed, root) {
+		t.Fatalf("Replayed root differs: %s != %s\n", replayed, root)
+	}
+	_, err = Post(ChildrenPath("/"), auth,
+		strings.NewReader(`{"name":"recorded","folder":{}}`))
+	failOnErr(t, err)
+	replayed, err = Get(ChildrenPath("/"), auth)
+	failOnErr(t, err)
+	if !bytes.Equal(replayed, children) {
+		t.Fatalf("Replayed children differ: %s != %s\n", replayed, children)
+	}
+	if _, err = Get(ResourcePath("/missing"), auth); err == nil || err.Error() != failed.Error() {
+		t.Fatalf("Replayed error differs: %v != %v\n", err, failed)
+	}
+
+	// each exchange is only played back once
+	if _, err = Get(ResourcePath("/"), auth); err == nil {
+		t.Fatal("A request that wasn't recorded again should fail.")
+	}
+}
@@ -0,0 +1,470 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retryOpUpload = "upload"
+	retryOpDelete = "delete"
+	retryOpRename = "rename"
+)
+
+// how long to wait before retrying a change for the first time, doubled with
+// every attempt after that
+const retryMinDelay = 30 * time.Second
+
+// the longest wait between attempts
+const retryMaxDelay = time.Hour
+
+// how many times a change is tried before giving up on it
+const retryMaxAttempts = 20
+
+// how often the queue is checked for changes that are due
+const retryTick = 10 * time.Second
+
+// errRetryBusy is returned for changes that can't be retried right now, like
+// uploads of items that are being uploaded already. They are tried again later
+// without using up an attempt.
+var errRetryBusy = errors.New("item is busy")
+
+// retryEntry is a change that failed to make it to the server
+type retryEntry struct {
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	ID        string    `json:"id,omitempty"`      // deletes only
+	Drive     string    `json:"drive,omitempty"`   // deletes only
+	Content   string    `json:"content,omitempty"` // name of the saved content, uploads only
+	Attempts  int       `json:"attempts"`
+	NextRetry time.Time `json:"nextRetry"`
+	LastError string    `json:"lastError"`
+
+	deletion  *pendingDelete // the deletion that failed, lost on restart
+	uploading bool           // if an upload of the item started since it failed
+}
+
+// retryQueue retries uploads, renames and deletions that failed for reasons that
+// may go away on their own, like the network being down, backing off further with
+// every attempt. It is saved to the cache directory, along with the content of
+// failed uploads, so changes aren't lost if onedriver stops before they could
+// be sent. Failed uploads are picked up as a SyncListener.
+type retryQueue struct {
+	fs      *FuseFs
+	mutex   *mu.Mutex
+	entries map[string]*retryEntry // by op and path
+	path    string                 // where the queue is saved, "" if it isn't
+}
+
+func newRetryQueue(fs *FuseFs) *retryQueue {
+	q := &retryQueue{
+		fs:      fs,
+		mutex:   &mu.Mutex{},
+		entries: make(map[string]*retryEntry),
+	}
+	fs.items.AddListener(q)
+	return q
+}
+
+// retryDelay returns how long to wait after a change failed attempts times
+func retryDelay(attempts int) time.Duration {
+	delay := retryMinDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// load restores the queue saved at path, and saves it there from now on
+func (q *retryQueue) load(path string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.path = path
+	var entries []*retryEntry
+	if err := loadJSON(path, &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		q.entries[entry.Op+":"+entry.Path] = entry
+		if entry.Op == retryOpDelete {
+			// still deleted, even if the server doesn't know yet
+			q.fs.items.deleting.Store(entry.ID, true)
+		}
+	}
+	return nil
+}
+
+// save persists the queue, must be called with the mutex held
+func (q *retryQueue) save() {
+	if q.path == "" {
+		return
+	}
+	entries := make([]*retryEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].NextRetry.Before(entries[j].NextRetry)
+	})
+	if err := saveJSON(q.path, entries); err != nil {
+		log.WithFields(log.Fields{
+			"path": q.path,
+			"err":  err,
+		}).Warn("Could not save retry queue.")
+	}
+}
+
+// add queues a change for retrying, or updates the queued one
+func (q *retryQueue) add(entry *retryEntry, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	key := entry.Op + ":" + entry.Path
+	if existing, ok := q.entries[key]; ok {
+		existing.LastError = err.Error()
+		existing.Content = entry.Content
+		if entry.deletion != nil {
+			existing.deletion = entry.deletion
+		}
+		q.save()
+		return
+	}
+	entry.LastError = err.Error()
+	entry.NextRetry = time.Now().Add(retryDelay(1))
+	entry.Attempts = 1
+	q.entries[key] = entry
+	q.save()
+	log.WithFields(log.Fields{
+		"op":    entry.Op,
+		"path":  entry.Path,
+		"retry": entry.NextRetry,
+	}).Warn("Change failed to reach the server, will retry.")
+}
+
+// remove takes a change out of the queue, along with its saved content
+func (q *retryQueue) remove(op string, path string) *retryEntry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	key := op + ":" + path
+	entry, ok := q.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(q.entries, key)
+	q.save()
+	if entry.Content != "" {
+		q.fs.items.uncacheContent(entry.Content)
+	}
+	return entry
+}
+
+// renamed moves the queued uploads of items at or below oldPath along with them.
+// Deletions stay where the items were deleted.
+func (q *retryQueue) renamed(oldPath string, newPath string) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var moved []*retryEntry
+	for key, entry := range q.entries {
+		if entry.Op != retryOpDelete && pathWithin(entry.Path, oldPath) {
+			delete(q.entries, key)
+			moved = append(moved, entry)
+		}
+	}
+	if len(moved) == 0 {
+		return
+	}
+	for _, entry := range moved {
+		entry.Path = newPath + entry.Path[len(oldPath):]
+		q.entries[entry.Op+":"+entry.Path] = entry
+	}
+	q.save()
+}
+
+// count returns how many changes are waiting to be retried
+func (q *retryQueue) count() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.entries)
+}
+
+// SyncError queues uploads that failed for reasons that may go away. A copy of
+// the content is kept in the disk cache until the upload goes through.
+func (q *retryQueue) SyncError(path string, op string, err error) {
+	if op != retryOpUpload && op != retryOpMkdir {
+		return
+	}
+	if _, permanent := PermanentError(err); permanent {
+		return
+	}
+	if op == retryOpMkdir {
+		q.add(&retryEntry{Op: retryOpMkdir, Path: path}, err)
+		return
+	}
+	entry := &retryEntry{Op: retryOpUpload, Path: path}
+	q.mutex.Lock()
+	if existing, ok := q.entries[retryOpUpload+":"+path]; ok {
+		entry.Content = existing.Content
+		existing.uploading = false
+	}
+	q.mutex.Unlock()
+
+	// empty auth, nothing should be fetched just for this
+	store := q.fs.items.content
+	if item, _ := q.fs.items.Get(path, &Auth{}); store != nil && item != nil {
+		item.mutex.RLock()
+		var content []byte
+		if item.data != nil {
+			content = make([]byte, len(*item.data))
+			item.copyContentLocked(content)
+		}
+		item.mutex.RUnlock()
+		if entry.Content == "" {
+			entry.Content = "pending-" + randString(20)
+		}
+		if content == nil || store.save(entry.Content, content) != nil {
+			entry.Content = ""
+		}
+	}
+	q.add(entry, err)
+}
+
+// StatusChanged drops queued uploads that went through in the meantime
+func (q *retryQueue) StatusChanged(path string, status string) {
+	switch status {
+	case StatusUploading:
+		q.mutex.Lock()
+		if entry, ok := q.entries[retryOpUpload+":"+path]; ok {
+			entry.uploading = true
+		}
+		q.mutex.Unlock()
+	case StatusSynced:
+		q.mutex.Lock()
+		entry, ok := q.entries[retryOpUpload+":"+path]
+		uploaded := ok && entry.uploading
+		q.mutex.Unlock()
+		if uploaded {
+			q.remove(retryOpUpload, path)
+		}
+	}
+}
+
+// TransferProgress is of no interest to the retry queue
+func (q *retryQueue) TransferProgress(path string, done uint64, total uint64) {}
+
+// addDelete queues a deletion that failed. Returns false if retrying won't help.
+func (q *retryQueue) addDelete(p *pendingDelete, err error) bool {
+	if q == nil {
+		return false
+	}
+	if _, permanent := PermanentError(err); permanent {
+		return false
+	}
+	p.item.mutex.RLock()
+	drive := p.item.drive
+	p.item.mutex.RUnlock()
+	q.add(&retryEntry{
+		Op:       retryOpDelete,
+		Path:     p.path,
+		ID:       p.id,
+		Drive:    drive,
+		deletion: p,
+	}, err)
+	return true
+}
+
+// run retries changes once they are due, should be called as a goroutine
+func (q *retryQueue) run() {
+	for range time.Tick(retryTick) {
+		q.retryDue()
+	}
+}
+
+// retryAll makes every queued change due and retries it, for when whatever kept
+// them from going through is known to be over
+func (q *retryQueue) retryAll() {
+	now := time.Now()
+	q.mutex.Lock()
+	for _, entry := range q.entries {
+		entry.NextRetry = now
+	}
+	q.mutex.Unlock()
+	q.retryDue()
+}
+
+// retryDue retries every change that is due
+func (q *retryQueue) retryDue() {
+	if q.fs.items.Paused() || q.fs.Auth.Broken() {
+		// a broken login would only use up attempts
+		return
+	}
+	now := time.Now()
+	q.mutex.Lock()
+	var due []*retryEntry
+	for _, entry := range q.entries {
+		if !entry.NextRetry.After(now) {
+			due = append(due, entry)
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, entry := range due {
+		err := q.retry(entry)
+		if err == nil {
+			q.resolve(entry, true)
+			continue
+		}
+		if err == errRetryBusy {
+			q.mutex.Lock()
+			entry.NextRetry = time.Now().Add(retryDelay(entry.Attempts))
+			q.save()
+			q.mutex.Unlock()
+			continue
+		}
+		_, permanent := PermanentError(err)
+		q.mutex.Lock()
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextRetry = time.Now().Add(retryDelay(entry.Attempts))
+		giveUp := permanent || entry.Attempts >= retryMaxAttempts
+		q.save()
+		q.mutex.Unlock()
+		if giveUp {
+			log.WithFields(log.Fields{
+				"op":       entry.Op,
+				"path":     entry.Path,
+				"attempts": entry.Attempts,
+				"err":      err,
+			}).Error("Giving up on change that keeps failing to reach the server.")
+			q.resolve(entry, false)
+		}
+	}
+}
+
+// retry tries to send a change to the server again
+func (q *retryQueue) retry(entry *retryEntry) error {
+	fs := q.fs
+	if entry.Op == retryOpDelete {
+		if entry.deletion != nil {
+			return fs.deleteRemote(entry.deletion.item, entry.Path)
+		}
+		return Delete("/me/drive/items/"+entry.ID, scopedAuth(fs.Auth, entry.Drive))
+	}
+	if entry.Op == retryOpMkdir {
+		return q.retryMkdir(entry.Path)
+	}
+	if entry.Op == retryOpRename {
+		return q.retryRename(entry.Path)
+	}
+
+	item, err := fs.items.Get(entry.Path, fs.Auth)
+	if err != nil {
+		return err
+	}
+	item.mutex.Lock()
+	if !item.hasChanges && item.openHandles == 0 && entry.Content != "" {
+		// onedriver was restarted since, put the content back
+		content, err := fs.items.content.load(entry.Content)
+		if err != nil {
+			item.mutex.Unlock()
+			return err
+		}
+		item.data = &content
+		item.SizeInternal = uint64(len(content))
+		item.diskID = ""
+		item.hasChanges = true
+	}
+	if !item.hasChanges {
+		// uploaded some other way, or open and waiting to be
+		item.mutex.Unlock()
+		return nil
+	}
+	if item.uploading || item.writers > 0 || fs.items.Paused() {
+		// uploaded once the running upload is done, the last writer closes
+		// the item, or uploads are resumed, see uploadLocked()
+		item.mutex.Unlock()
+		return errRetryBusy
+	}
+	item.claimUploadLocked()
+	item.mutex.Unlock()
+	return item.Upload(fs.Auth)
+}
+
+// retryMkdir creates a folder on the server again. Folders that were only made
+// locally are gone after a restart, and are made again first.
+func (q *retryQueue) retryMkdir(path string) error {
+	fs := q.fs
+	item, err := fs.items.Get(path, fs.Auth)
+	if err != nil {
+		parent, err := fs.items.Get(filepath.Dir(path), fs.Auth)
+		if err != nil {
+			return err
+		}
+		item = NewDriveItem(filepath.Base(path), fuse.S_IFDIR|0755, parent)
+		fs.items.insertChild(parent, item)
+	}
+	_, err = fs.items.remoteFolder(item, fs.Auth)
+	if err == errKeptLocal {
+		return nil
+	}
+	return err
+}
+
+// retryRename moves an item on the server to where it is in the cache, which
+// may have changed again since the rename that failed (see renamed())
+func (q *retryQueue) retryRename(path string) error {
+	fs := q.fs
+	item, err := fs.items.Get(path, fs.Auth)
+	if err != nil || isLocalID(item.ID()) {
+		// deleted since, or created under its new name when it gets uploaded
+		return nil
+	}
+	parent, err := fs.items.Get(filepath.Dir(path), fs.Auth)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.items.remoteFolder(parent, fs.Auth); err != nil {
+		return err
+	}
+	patch, _ := json.Marshal(DriveItem{
+		ConflictBehavior: "replace",
+		NameInternal:     item.Name(),
+		Parent:           &DriveItemParent{ID: item.parentTarget()},
+	})
+	_, err = Patch("/me/drive/items/"+item.ID(), item.driveAuth(fs.Auth), bytes.NewReader(patch))
+	return err
+}
+
+// resolve takes a change that went through (or was given up on) out of the queue
+func (q *retryQueue) resolve(entry *retryEntry, done bool) {
+	q.remove(entry.Op, entry.Path)
+	if entry.Op != retryOpDelete {
+		return
+	}
+	p := entry.deletion
+	if p == nil {
+		q.fs.items.deleting.Delete(entry.ID)
+		return
+	}
+	q.fs.deletes.forget(p)
+	if done {
+		if quota := p.item.quota(); quota != nil {
+			quota.addDeleted(p.size())
+		}
+	} else {
+		q.fs.deletes.restore(p, p.path)
+	}
+}
@@ -0,0 +1,131 @@
+package graph
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+func TestRetryDelay(t *testing.T) {
+	if retryDelay(1) != retryMinDelay || retryDelay(3) != 4*retryMinDelay {
+		t.Fatalf("Delay did not double with every attempt: %s, %s\n",
+			retryDelay(1), retryDelay(3))
+	}
+	if retryDelay(100) != retryMaxDelay {
+		t.Fatalf("Delay was not capped, got %s\n", retryDelay(100))
+	}
+}
+
+// failed changes must survive a restart, uploads only go once they went through
+func TestRetryQueue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_retry")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retries.json")
+
+	fs := &FuseFs{items: &Cache{listeners: newListeners()}}
+	q := newRetryQueue(fs)
+	failOnErr(t, q.load(path))
+	q.SyncError("/file.txt", retryOpUpload, errors.New("network is unreachable"))
+	q.SyncError("/full.txt", retryOpUpload, errors.New("quotaLimitReached: full"))
+	q.add(&retryEntry{Op: retryOpDelete, Path: "/gone", ID: "gone-id"},
+		errors.New("network is unreachable"))
+	if q.count() != 2 {
+		t.Fatalf("Got %d changes queued, wanted 2.\n", q.count())
+	}
+
+	reloaded := newRetryQueue(&FuseFs{items: &Cache{listeners: newListeners()}})
+	failOnErr(t, reloaded.load(path))
+	if reloaded.count() != 2 {
+		t.Fatalf("Got %d changes after reloading, wanted 2.\n", reloaded.count())
+	}
+	if _, hidden := reloaded.fs.items.deleting.Load("gone-id"); !hidden {
+		t.Fatal("Item whose deletion is being retried was not hidden.")
+	}
+	entry := reloaded.entries[retryOpUpload+":/file.txt"]
+	if entry.Attempts != 1 || entry.NextRetry.Before(time.Now()) {
+		t.Fatalf("Upload was not scheduled for a retry: %+v\n", entry)
+	}
+
+	reloaded.StatusChanged("/file.txt", StatusSynced)
+	if reloaded.count() != 2 {
+		t.Fatal("Upload was dropped without having been retried.")
+	}
+	reloaded.StatusChanged("/file.txt", StatusUploading)
+	reloaded.StatusChanged("/file.txt", StatusSynced)
+	if reloaded.count() != 1 {
+		t.Fatal("Upload that went through was not dropped.")
+	}
+}
+
+// renames that don't reach the server should be done locally anyway, and sent
+// again later
+func TestRetryRename(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	mock.mutex.Lock()
+	mock.setContent(mock.create(mock.child(mockRootID, "Documents"), "before.txt", false),
+		[]byte("content"))
+	mock.mutex.Unlock()
+	fs.GetAttr("/Documents/before.txt", nil)
+
+	defer func() { graphTransport = http.DefaultTransport }()
+	failOnErr(t, InjectFaults("5xx=1"))
+	if status := fs.Rename("/Documents/before.txt", "/after.txt", nil); status != fuse.OK {
+		t.Fatal("Rename failed:", status)
+	}
+	graphTransport = http.DefaultTransport
+	if _, err := fs.items.Get("/after.txt", nil); err != nil {
+		t.Fatal("Item was not renamed locally:", err)
+	}
+	if fs.retries.count() != 1 {
+		t.Fatalf("Got %d changes queued, wanted 1.\n", fs.retries.count())
+	}
+
+	fs.retries.retryAll()
+	if fs.retries.count() != 0 {
+		t.Fatal("Rename was not retried.")
+	}
+	mock.mutex.Lock()
+	defer mock.mutex.Unlock()
+	if mock.child(mockRootID, "after.txt") == nil ||
+		mock.child(mock.child(mockRootID, "Documents").id, "before.txt") != nil {
+		t.Fatal("Item was not renamed on the server.")
+	}
+}
+
+// retrying an upload while the item is being uploaded already should wait for
+// that upload instead of starting a second one
+func TestRetryWhileUploading(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+	item := startUpload(t, mock, fs, "/Documents/busy.txt", "content")
+	defer mock.mutex.Unlock()
+	item.mutex.Lock()
+	item.hasChanges = true
+	item.mutex.Unlock()
+
+	fs.retries.add(&retryEntry{Op: retryOpUpload, Path: "/Documents/busy.txt"},
+		errors.New("network is unreachable"))
+	fs.retries.retryAll()
+	fs.retries.mutex.Lock()
+	entry := fs.retries.entries[retryOpUpload+":/Documents/busy.txt"]
+	fs.retries.mutex.Unlock()
+	if entry == nil || entry.Attempts != 1 || entry.NextRetry.Before(time.Now()) {
+		t.Fatalf("Busy upload was not rescheduled: %+v\n", entry)
+	}
+	item.mutex.RLock()
+	defer item.mutex.RUnlock()
+	if !item.hasChanges {
+		t.Fatal("Retry started a second upload while the first was running.")
+	}
+}
@@ -0,0 +1,117 @@
+package graph
+
+import (
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Editors tend to save files by writing the new content to a temporary file and
+// renaming it over the original, so a crash halfway through never leaves a
+// half-written file behind. Done on the server, that is an upload of the
+// temporary file followed by a rename replacing the original, which loses the
+// original's ID, sharing and version history. Instead, the temporary file's
+// content becomes a change to the original, and the temporary file is dropped
+// (deleted from the server again, if its upload was already under way).
+
+// saveOver renames item over an existing file at newName by saving its content
+// to that file, if item never made it to the server. Returns false if the
+// rename has to be done as usual.
+func (fs *FuseFs) saveOver(op operation, item *DriveItem, oldName string, newName string) (fuse.Status, bool) {
+	if item.IsDir() || !isLocalID(item.ID()) || caseOnlyRename(oldName, newName) {
+		return fuse.OK, false
+	}
+	original, _ := fs.items.Get(newName, op.auth)
+	if original == nil || original == item || original.IsDir() || isLocalID(original.ID()) {
+		return fuse.OK, false
+	}
+	original.mutex.RLock()
+	originalDrive, originalWriters := original.drive, original.writers
+	original.mutex.RUnlock()
+	if originalWriters > 0 {
+		// whatever is still being written to it would mix with the new content
+		return fuse.OK, false
+	}
+
+	item.mutex.Lock()
+	if !isLocalID(item.IDInternal) || item.writers > 0 || item.data == nil ||
+		item.drive != originalDrive {
+		// uploaded in the meantime, still being written, or not in memory
+		item.mutex.Unlock()
+		return fuse.OK, false
+	}
+	content := make([]byte, len(*item.data))
+	item.copyContentLocked(content)
+	item.discarded = true
+	item.hasChanges = false
+	uploading := item.uploading
+	quota := item.quotaLocked()
+	item.mutex.Unlock()
+	if quota != nil {
+		// it never made it to the server, so doesn't count towards the quota
+		quota.addWritten(-int64(len(content)))
+	}
+
+	if uploading {
+		// stays cached by ID until the upload is done, see dropDiscarded()
+		fs.items.listed.clear()
+		fs.items.removeParent(item)
+	} else {
+		fs.items.Delete(oldName)
+	}
+	fs.retries.remove(retryOpUpload, oldName)
+	original.replaceContent(content)
+	op.WithFields(log.Fields{
+		"path": oldName,
+		"dest": newName,
+	}).Info("Saved file over existing one.")
+	return fuse.OK, true
+}
+
+// replaceContent makes content the item's new content, and uploads it unless the
+// item is still open for writing
+func (d *DriveItem) replaceContent(content []byte) {
+	d.mutex.Lock()
+	oldSize := d.SizeInternal
+	d.data = &content
+	d.diskID = ""
+	d.SizeInternal = uint64(len(content))
+	d.File = nodefs.NewDefaultFile()
+	now := serverNow()
+	d.ModTimeInternal = &now
+	d.hasChanges = true
+	quota := d.quotaLocked()
+	if d.writers == 0 {
+		d.uploadLocked()
+	}
+	d.mutex.Unlock()
+	if quota != nil {
+		quota.addWritten(int64(len(content)) - int64(oldSize))
+	}
+	d.notifyStatus()
+}
+
+// dropDiscarded takes a file whose content was saved over another one (see
+// saveOver()), or an item deleted while it was being created, out of the cache
+// for good, and off the server if it made it there. Returns false if the item
+// wasn't discarded.
+func (d *DriveItem) dropDiscarded(auth *Auth) bool {
+	d.mutex.RLock()
+	discarded, id, cache := d.discarded, d.IDInternal, d.cache
+	d.mutex.RUnlock()
+	if !discarded {
+		return false
+	}
+	if !isLocalID(id) {
+		if err := Delete("/me/drive/items/"+id, d.driveAuth(auth)); err != nil {
+			log.WithFields(log.Fields{
+				"id":  id,
+				"err": err,
+			}).Warn("Could not delete discarded item from the server.")
+		}
+	}
+	if cache != nil {
+		cache.DeleteID(id)
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package graph
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const searchDir = "/.search"
+
+// how long search results are reused before searching again
+const searchTTL = 30 * time.Second
+
+// Search finds items anywhere in the drive whose name, metadata or content
+// matches query. The items are not cached, but their paths are filled in.
+func Search(query string, auth *Auth) ([]*DriveItem, error) {
+	escaped := url.PathEscape(strings.Replace(query, "'", "''", -1))
+	items, err := getAllPages("/me/drive/root/search(q='"+escaped+"')", auth)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.Parent != nil && item.Parent.Path != "" {
+			continue
+		}
+		// search results don't always come with their parent's path
+		body, err := Get("/me/drive/items/"+item.IDInternal, auth)
+		if err != nil {
+			return nil, err
+		}
+		full := &DriveItem{}
+		if err = json.Unmarshal(body, full); err != nil {
+			return nil, err
+		}
+		item.Parent = full.Parent
+	}
+	return items, nil
+}
+
+// searchResults is a cached list of search results
+type searchResults struct {
+	links   map[string]virtualLink
+	fetched time.Time
+}
+
+// searchDirectory is the virtual directory at /.search. Each directory in it is a
+// search: listing /.search/holiday photos/ searches the drive for
+// "holiday photos" and shows the results as symlinks to the items found.
+type searchDirectory struct {
+	fs       *FuseFs
+	mutex    *mu.Mutex
+	searches map[string]searchResults // by query
+}
+
+func newSearchDir(fs *FuseFs) *searchDirectory {
+	return &searchDirectory{
+		fs:       fs,
+		mutex:    &mu.Mutex{},
+		searches: make(map[string]searchResults),
+	}
+}
+
+// search returns links to the results of a search
+func (s *searchDirectory) search(query string) (map[string]virtualLink, fuse.Status) {
+	s.mutex.Lock()
+	cached, exists := s.searches[query]
+	s.mutex.Unlock()
+	if exists && time.Since(cached.fetched) < searchTTL {
+		return cached.links, fuse.OK
+	}
+
+	items, err := Search(query, s.fs.Auth)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"query": query,
+			"err":   err,
+		}).Error("Search failed.")
+		return nil, fuse.EREMOTEIO
+	}
+	links := s.fs.virtualLinks(items)
+
+	s.mutex.Lock()
+	if len(s.searches) >= 64 {
+		s.searches = make(map[string]searchResults)
+	}
+	s.searches[query] = searchResults{links: links, fetched: time.Now()}
+	s.mutex.Unlock()
+	return links, fuse.OK
+}
+
+// result splits a path in the search directory into the query and the name of
+// one of its results, and returns the link to that result
+func (s *searchDirectory) result(path string) (virtualLink, fuse.Status) {
+	query, name := filepath.Split(strings.TrimPrefix(path, "/"))
+	query = strings.TrimSuffix(query, "/")
+	if query == "" || strings.Contains(query, "/") {
+		return virtualLink{}, fuse.ENOENT
+	}
+	links, status := s.search(query)
+	if status != fuse.OK {
+		return virtualLink{}, status
+	}
+	link, exists := links[strings.ToLower(name)]
+	if !exists {
+		return virtualLink{}, fuse.ENOENT
+	}
+	return link, fuse.OK
+}
+
+func (s *searchDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	if path == "/" || !strings.Contains(strings.TrimPrefix(path, "/"), "/") {
+		// any directory here is a search, whatever its name
+		return virtualAttr(true, 0, uint64(time.Now().Unix())), fuse.OK
+	}
+	link, status := s.result(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return link.attr(searchDir + path), fuse.OK
+}
+
+func (s *searchDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	if path == "/" {
+		// nothing useful to list, searches are made by going into a directory
+		return []fuse.DirEntry{}, fuse.OK
+	}
+	query := strings.TrimPrefix(path, "/")
+	if strings.Contains(query, "/") {
+		return nil, fuse.ENOTDIR
+	}
+	links, status := s.search(query)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return linkEntries(links), fuse.OK
+}
+
+// Open is never called for the results themselves, the kernel follows symlinks
+// on its own
+func (s *searchDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	return nil, fuse.EACCES
+}
+
+func (s *searchDirectory) Readlink(path string) (string, fuse.Status) {
+	link, status := s.result(path)
+	if status != fuse.OK {
+		return "", status
+	}
+	return link.target(searchDir + path), fuse.OK
+}
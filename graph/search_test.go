@@ -0,0 +1,37 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// search results should link back to the real items, wherever they are
+func TestLinkTarget(t *testing.T) {
+	link := virtualLink{name: "notes.txt", path: "/Documents/notes.txt"}
+	target := link.target("/.search/notes/notes.txt")
+	if target != "../../Documents/notes.txt" {
+		t.Fatal("Wrong symlink target:", target)
+	}
+}
+
+// files matching a search should show up as links in .search
+func TestSearchDir(t *testing.T) {
+	fname := filepath.Join(TestDir, "onedriver_searchable.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("find me"), 0644))
+	time.Sleep(30 * time.Second) // wait for upload and for the server to index it
+
+	link := filepath.Join(mountLoc, searchDir, "onedriver_searchable", "onedriver_searchable.txt")
+	st, err := os.Lstat(link)
+	failOnErr(t, err)
+	if st.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("Search result was not a symlink.")
+	}
+	content, err := ioutil.ReadFile(link)
+	failOnErr(t, err)
+	if string(content) != "find me" {
+		t.Fatalf("Search result did not link to the right file: \"%s\"\n", content)
+	}
+}
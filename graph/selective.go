@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"strings"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// syncFilter decides which parts of the drive the filesystem shows at all.
+// Excluded folders are hidden, and nothing inside them is ever fetched. If there
+// are included folders, only they (and the folders leading up to them) are shown.
+type syncFilter struct {
+	mutex   *mu.RWMutex
+	exclude []string // lowercased paths relative to the root of the filesystem
+	include []string // same, empty if everything is included
+}
+
+func newSyncFilter() *syncFilter {
+	return &syncFilter{mutex: &mu.RWMutex{}}
+}
+
+// cleanFilterPaths normalizes a list of paths for use in a syncFilter
+func cleanFilterPaths(paths []string) []string {
+	cleaned := make([]string, 0, len(paths))
+	for _, path := range paths {
+		path = strings.ToLower(strings.TrimSuffix(leadingSlash(path), "/"))
+		if path != "" {
+			cleaned = append(cleaned, path)
+		}
+	}
+	return cleaned
+}
+
+// SetExcluded replaces the list of excluded paths. Takes effect immediately,
+// already cached items under excluded paths are hidden as well.
+func (c *Cache) SetExcluded(paths []string) {
+	exclude := cleanFilterPaths(paths)
+	c.filter.mutex.Lock()
+	c.filter.exclude = exclude
+	c.filter.mutex.Unlock()
+	c.listed.clear()
+}
+
+// SetIncluded restricts the filesystem to the folders at paths, everything else
+// is excluded. An empty list includes everything again.
+func (c *Cache) SetIncluded(paths []string) {
+	include := cleanFilterPaths(paths)
+	c.filter.mutex.Lock()
+	c.filter.include = include
+	c.filter.mutex.Unlock()
+	c.listed.clear()
+}
+
+// isWithin checks if path is dir or inside of it
+func isWithin(path string, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// excluded checks if a path is hidden by selective sync, either because it is
+// excluded itself or because one of its parents is. With include-only filters,
+// everything that isn't in or on the way to an included folder is excluded.
+func (c *Cache) excluded(path string) bool {
+	if c.filter == nil {
+		return false
+	}
+	path = strings.ToLower(strings.TrimSuffix(path, "/"))
+	c.filter.mutex.RLock()
+	defer c.filter.mutex.RUnlock()
+	for _, exclude := range c.filter.exclude {
+		if isWithin(path, exclude) {
+			return true
+		}
+	}
+	if len(c.filter.include) == 0 || path == "" {
+		return false
+	}
+	for _, include := range c.filter.include {
+		if isWithin(path, include) || isWithin(include, path) {
+			return false
+		}
+	}
+	return true
+}
+
+// childPath joins the path of a directory and the name of one of its children
+func childPath(dir string, name string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
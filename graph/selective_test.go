@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// everything under an excluded folder is excluded, but not folders that just
+// start with the same name
+func TestExcluded(t *testing.T) {
+	cache := &Cache{filter: newSyncFilter()}
+	cache.SetExcluded([]string{"Archive/", "/Documents/Old"})
+	for path, excluded := range map[string]bool{
+		"/archive":                true,
+		"/Archive/2019/taxes.pdf": true,
+		"/Archived":               false,
+		"/Documents/old/notes":    true,
+		"/Documents":              false,
+		"/":                       false,
+	} {
+		if cache.excluded(path) != excluded {
+			t.Errorf("%s should have been excluded: %v\n", path, excluded)
+		}
+	}
+}
+
+// with include-only filters, only the included folders and the folders leading
+// up to them are shown, minus anything excluded
+func TestIncluded(t *testing.T) {
+	cache := &Cache{filter: newSyncFilter()}
+	cache.SetIncluded([]string{"/Projects/Current", "Documents"})
+	cache.SetExcluded([]string{"/Documents/Old"})
+	for path, excluded := range map[string]bool{
+		"/":                          false,
+		"/Projects":                  false,
+		"/Projects/current/app/main": false,
+		"/Projects/Archive":          true,
+		"/Documents/notes.txt":       false,
+		"/Documents/Old":             true,
+		"/Pictures":                  true,
+		"/Docs":                      true,
+	} {
+		if cache.excluded(path) != excluded {
+			t.Errorf("%s should have been excluded: %v\n", path, excluded)
+		}
+	}
+	cache.SetIncluded(nil)
+	if cache.excluded("/Pictures") {
+		t.Fatal("Clearing the include list did not include everything again.")
+	}
+}
+
+// excluded folders should disappear from the filesystem, and come back once
+// they're no longer excluded
+func TestExcludeDir(t *testing.T) {
+	dir := filepath.Join(TestDir, "excluded")
+	failOnErr(t, os.Mkdir(dir, 0755))
+	defer os.Remove(dir)
+
+	testFs.items.SetExcluded([]string{"/onedriver_tests/excluded"})
+	time.Sleep(2 * time.Second) // let the kernel forget what it knows about it
+	if _, err := os.Stat(dir); err == nil {
+		testFs.items.SetExcluded(nil)
+		t.Fatal("Excluded folder was still there.")
+	}
+	if err := os.Mkdir(dir, 0755); err == nil {
+		testFs.items.SetExcluded(nil)
+		t.Fatal("Was able to create a folder at an excluded path.")
+	}
+	testFs.items.SetExcluded(nil)
+	time.Sleep(2 * time.Second)
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatal("Folder did not come back after lifting its exclusion:", err)
+	}
+}
@@ -20,6 +20,7 @@ const (
 )
 
 var auth *Auth
+var testFs *FuseFs // the filesystem mounted for the tests
 
 // Tests are done in the main project directory with a mounted filesystem to
 // avoid having to repeatedly recreate auth_tokens.json and juggle multiple auth
@@ -31,15 +32,28 @@ func TestMain(m *testing.M) {
 	exec.Command("fusermount", "-u", mountLoc).Run()
 	os.Mkdir(mountLoc, 0755)
 
-	fusefs := NewFS()
+	var fusefs *FuseFs
+	if usingMock() {
+		// no account needed, everything happens on an in-memory mock server
+		fusefs = NewFSAt(newMockGraph().auth(), "/")
+	} else {
+		fusefs = NewFS()
+	}
+	if faults := os.Getenv("ONEDRIVER_FAULTS"); faults != "" {
+		// see how the tests cope with a flaky connection
+		if err := InjectFaults(faults); err != nil {
+			log.Fatal(err)
+		}
+	}
 	auth = fusefs.Auth
+	testFs = fusefs
 	fs := pathfs.NewPathNodeFs(fusefs, nil)
 	server, _, _ := nodefs.MountRoot(mountLoc, fs.Root(), nil)
 
 	// setup sigint handler for graceful unmount on interrupt/terminate
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go UnmountHandler(sigChan, server)
+	go UnmountHandler(sigChan, server, fusefs)
 
 	// mount fs in background thread
 	go server.Serve()
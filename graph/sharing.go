@@ -0,0 +1,292 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SharingLink is a link that gives access to an item
+// https://docs.microsoft.com/en-us/graph/api/resources/sharinglink
+type SharingLink struct {
+	Type   string `json:"type"`
+	Scope  string `json:"scope"`
+	WebURL string `json:"webUrl"`
+}
+
+// CreateLink creates a sharing link for an item, or returns the existing one if
+// the item already has a link of that type and scope. linkType is "view" or
+// "edit", scope is "anonymous" (anyone with the link) or "organization" (only
+// people in the same organization, not available on personal accounts).
+func CreateLink(id string, linkType string, scope string, auth *Auth) (*SharingLink, error) {
+	if linkType != "view" && linkType != "edit" {
+		return nil, errors.New("link type must be \"view\" or \"edit\", not \"" + linkType + "\"")
+	}
+	if scope != "anonymous" && scope != "organization" {
+		return nil, errors.New("link scope must be \"anonymous\" or \"organization\", not \"" +
+			scope + "\"")
+	}
+	payload, _ := json.Marshal(map[string]string{"type": linkType, "scope": scope})
+	body, err := Post("/me/drive/items/"+id+"/createLink", auth, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	var permission struct {
+		Link SharingLink `json:"link"`
+	}
+	if err = json.Unmarshal(body, &permission); err != nil {
+		return nil, err
+	}
+	return &permission.Link, nil
+}
+
+// Share creates a sharing link for the item at path. Defaults to an anonymous,
+// view-only link.
+func (fs *FuseFs) Share(path string, linkType string, scope string) (*SharingLink, error) {
+	if linkType == "" {
+		linkType = "view"
+	}
+	if scope == "" {
+		scope = "anonymous"
+	}
+	id, err := fs.remoteID(path)
+	if err != nil {
+		return nil, err
+	}
+	if fs.items.skipMutation("POST", path, log.Fields{"type": linkType, "scope": scope}) {
+		return nil, errors.New("not creating sharing links in dry-run mode")
+	}
+	link, err := CreateLink(id, linkType, scope, fs.Auth)
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"path":  path,
+		"type":  link.Type,
+		"scope": link.Scope,
+	}).Info("Created sharing link.")
+	return link, nil
+}
+
+// WebURL returns the address of the item at path on the OneDrive website. Only
+// the owner and people it's shared with can open it.
+func (fs *FuseFs) WebURL(path string) (string, error) {
+	if _, err := fs.remoteID(path); err != nil {
+		return "", err
+	}
+	item, _ := fs.items.Get(path, fs.Auth)
+	if url := item.WebURL(); url != "" {
+		return url, nil
+	}
+	// items fetched before onedriver kept track of web addresses
+	if err := fs.items.Refresh(path, fs.Auth); err != nil {
+		return "", err
+	}
+	if url := item.WebURL(); url != "" {
+		return url, nil
+	}
+	return "", errors.New("the server did not report a web address for " + path)
+}
+
+// Identity is a user, group or application
+type Identity struct {
+	ID          string `json:"id,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
+
+// IdentitySet is who an item is shared with. Only one of the fields is usually
+// set.
+type IdentitySet struct {
+	User        *Identity `json:"user,omitempty"`
+	Group       *Identity `json:"group,omitempty"`
+	Application *Identity `json:"application,omitempty"`
+}
+
+// Permission is a single grant of access to an item, either to specific people
+// or through a sharing link
+// https://docs.microsoft.com/en-us/graph/api/resources/permission
+type Permission struct {
+	ID            string           `json:"id"`
+	Roles         []string         `json:"roles"` // "read", "write" or "owner"
+	Link          *SharingLink     `json:"link,omitempty"`
+	GrantedTo     *IdentitySet     `json:"grantedTo,omitempty"`
+	Invitation    *Identity        `json:"invitation,omitempty"`
+	InheritedFrom *DriveItemParent `json:"inheritedFrom,omitempty"`
+}
+
+// GetPermissions fetches who an item is shared with, including any sharing links
+func GetPermissions(id string, auth *Auth) ([]Permission, error) {
+	body, err := Get("/me/drive/items/"+id+"/permissions", auth)
+	if err != nil {
+		return nil, err
+	}
+	var permissions struct {
+		Values []Permission `json:"value"`
+	}
+	err = json.Unmarshal(body, &permissions)
+	return permissions.Values, err
+}
+
+// GetUserID fetches the ID of the user the auth tokens belong to
+func GetUserID(auth *Auth) (string, error) {
+	body, err := Get("/me", auth)
+	if err != nil {
+		return "", err
+	}
+	var user Identity
+	err = json.Unmarshal(body, &user)
+	return user.ID, err
+}
+
+// readOnlyFor checks whether permissions only let user view an item. Unless the
+// user is among those the item is shared with, it's not known to be read-only.
+func readOnlyFor(permissions []Permission, user string) bool {
+	granted := false
+	for _, permission := range permissions {
+		if permission.GrantedTo == nil || permission.GrantedTo.User == nil ||
+			permission.GrantedTo.User.ID != user {
+			continue
+		}
+		granted = true
+		for _, role := range permission.Roles {
+			if role == "write" || role == "owner" {
+				return false
+			}
+		}
+	}
+	return granted
+}
+
+// userID returns the ID of the signed in user, fetching it the first time
+func (c *Cache) userID(auth *Auth) (string, error) {
+	if user, ok := c.user.Load().(string); ok {
+		return user, nil
+	}
+	user, err := GetUserID(auth)
+	if err != nil {
+		return "", err
+	}
+	c.user.Store(user)
+	return user, nil
+}
+
+// checkAccess finds out if a shortcut points to something that was shared with
+// the user view-only, in which case it and everything in it are read-only
+func (c *Cache) checkAccess(shortcut *DriveItem, auth *Auth) {
+	user, err := c.userID(auth)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Warn("Could not fetch user ID.")
+		return
+	}
+	drive, target := shortcut.contentTarget()
+	permissions, err := GetPermissions(target, scopedAuth(auth, drive))
+	if err != nil {
+		// people with view-only access can't always list permissions
+		log.WithFields(log.Fields{
+			"path": shortcut.Path(),
+			"err":  err,
+		}).Debug("Could not fetch permissions of shortcut.")
+		return
+	}
+	readOnly := readOnlyFor(permissions, user)
+	shortcut.mutex.Lock()
+	shortcut.readOnly = readOnly
+	shortcut.mutex.Unlock()
+}
+
+// readOnly checks if the item at path was shared with the user view-only.
+// Changes to it would fail once they reach the server, so they're refused
+// locally instead.
+func (fs *FuseFs) readOnly(path string) bool {
+	item, _ := fs.items.Get(path, fs.Auth)
+	return item != nil && item.ReadOnly()
+}
+
+// remoteID returns the ID of an item that exists on the server
+func (fs *FuseFs) remoteID(path string) (string, error) {
+	item, err := fs.items.Get(path, fs.Auth)
+	if err != nil {
+		return "", err
+	}
+	id := item.ID()
+	if isLocalID(id) {
+		return "", errors.New(path + " has not been uploaded to the server yet")
+	}
+	return id, nil
+}
+
+// Permissions lists who the item at path is shared with
+func (fs *FuseFs) Permissions(path string) ([]Permission, error) {
+	id, err := fs.remoteID(path)
+	if err != nil {
+		return nil, err
+	}
+	return GetPermissions(id, fs.Auth)
+}
+
+// Unshare removes a permission from the item at path. Inherited permissions can
+// only be removed from the folder they are inherited from.
+func (fs *FuseFs) Unshare(path string, permissionID string) error {
+	id, err := fs.remoteID(path)
+	if err != nil {
+		return err
+	}
+	if fs.items.skipMutation("DELETE", path, log.Fields{"permission": permissionID}) {
+		return errors.New("not removing permissions in dry-run mode")
+	}
+	if err = Delete("/me/drive/items/"+id+"/permissions/"+permissionID, fs.Auth); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path":       path,
+		"permission": permissionID,
+	}).Info("Removed permission.")
+	return nil
+}
+
+// Invite grants a person access to the item at path. role is "read" or "write".
+// They have to sign in to access the item, and are not sent an email about it.
+func (fs *FuseFs) Invite(path string, email string, role string) ([]Permission, error) {
+	if role == "" {
+		role = "read"
+	}
+	if role != "read" && role != "write" {
+		return nil, errors.New("role must be \"read\" or \"write\", not \"" + role + "\"")
+	}
+	if email == "" {
+		return nil, errors.New("an email address is required")
+	}
+	id, err := fs.remoteID(path)
+	if err != nil {
+		return nil, err
+	}
+	if fs.items.skipMutation("POST", path, log.Fields{"email": email, "role": role}) {
+		return nil, errors.New("not adding permissions in dry-run mode")
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"recipients":     []map[string]string{{"email": email}},
+		"roles":          []string{role},
+		"requireSignIn":  true,
+		"sendInvitation": false,
+	})
+	body, err := Post("/me/drive/items/"+id+"/invite", fs.Auth, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	var permissions struct {
+		Values []Permission `json:"value"`
+	}
+	if err = json.Unmarshal(body, &permissions); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"path":  path,
+		"email": email,
+		"role":  role,
+	}).Info("Shared item.")
+	return permissions.Values, nil
+}
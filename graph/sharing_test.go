@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"testing"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// bad link types and scopes should be rejected before anything is sent
+func TestCreateLinkInvalid(t *testing.T) {
+	if _, err := CreateLink("id", "delete", "anonymous", nil); err == nil {
+		t.Fatal("Invalid link type was accepted.")
+	}
+	if _, err := CreateLink("id", "view", "everyone", nil); err == nil {
+		t.Fatal("Invalid link scope was accepted.")
+	}
+}
+
+// only the user's own grants decide whether an item is read-only
+func TestReadOnlyFor(t *testing.T) {
+	grant := func(id string, role string) Permission {
+		return Permission{
+			Roles:     []string{role},
+			GrantedTo: &IdentitySet{User: &Identity{ID: id}},
+		}
+	}
+	tests := []struct {
+		permissions []Permission
+		readOnly    bool
+	}{
+		{[]Permission{grant("me", "read")}, true},
+		{[]Permission{grant("me", "write")}, false},
+		{[]Permission{grant("me", "read"), grant("me", "write")}, false},
+		{[]Permission{grant("owner", "owner"), grant("me", "read")}, true},
+		{[]Permission{grant("someone else", "read")}, false},
+		{nil, false},
+	}
+	for i, test := range tests {
+		if readOnly := readOnlyFor(test.permissions, "me"); readOnly != test.readOnly {
+			t.Errorf("Case %d: expected read-only to be %t\n", i, test.readOnly)
+		}
+	}
+
+	item := &DriveItem{mutex: &mu.RWMutex{}, FileInternal: &File{}, readOnly: true}
+	if mode := item.Mode(); mode&0222 != 0 {
+		t.Errorf("Read-only item had write bits set: %o\n", mode)
+	}
+}
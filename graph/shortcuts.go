@@ -0,0 +1,91 @@
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// RemoteItem is set on shortcuts to items in other drives, like shared folders
+// added to the user's OneDrive with "Add to my OneDrive". It describes the item
+// the shortcut points to.
+type RemoteItem struct {
+	ID           string           `json:"id"`
+	Parent       *DriveItemParent `json:"parentReference,omitempty"`
+	Folder       *Folder          `json:"folder,omitempty"`
+	FileInternal *File            `json:"file,omitempty"`
+}
+
+// Items inside a shortcut live in the other drive, but are filed under the
+// shortcut in the cache: their parent is the shortcut (or a folder below it) and
+// their paths are relative to it, while requests about them go to their own
+// drive. The helpers below translate between the two.
+
+// scopedAuth returns auth made to send requests to drive, "" being the
+// filesystem's own
+func scopedAuth(auth *Auth, drive string) *Auth {
+	if drive == "" {
+		return auth
+	}
+	return auth.ForDrive(drive)
+}
+
+// driveAuth returns auth scoped to the drive the item is in, for requests about
+// the item itself
+func (d *DriveItem) driveAuth(auth *Auth) *Auth {
+	d.mutex.RLock()
+	drive := d.drive
+	d.mutex.RUnlock()
+	return scopedAuth(auth, drive)
+}
+
+// contentTarget returns the drive and ID used for requests about an item's
+// content or children. For shortcuts, that's the item they point to.
+func (d *DriveItem) contentTarget() (string, string) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	if d.RemoteItem != nil && d.RemoteItem.ID != "" {
+		drive := d.drive
+		if d.RemoteItem.Parent != nil && d.RemoteItem.Parent.DriveID != "" {
+			drive = d.RemoteItem.Parent.DriveID
+		}
+		return drive, d.RemoteItem.ID
+	}
+	return d.drive, d.IDInternal
+}
+
+// parentTarget returns the ID of the item's parent on the server, which is not
+// the parent's ID in the cache if the parent is a shortcut
+func (d *DriveItem) parentTarget() string {
+	d.mutex.RLock()
+	parentID, cache := d.Parent.ID, d.cache
+	d.mutex.RUnlock()
+	if cache != nil {
+		if parent := cache.GetID(parentID); parent != nil {
+			_, id := parent.contentTarget()
+			return id
+		}
+	}
+	return parentID
+}
+
+// remoteParent returns the parent reference of items fetched from another drive
+// that are filed under parent, the shortcut or folder inside a shortcut they were
+// listed in
+func (c *Cache) remoteParent(parent *DriveItem, drive string) DriveItemParent {
+	return DriveItemParent{
+		ID:      parent.ID(),
+		Path:    "/drive/root:" + strings.TrimSuffix(c.prefix+parent.Path(), "/"),
+		DriveID: drive,
+	}
+}
+
+// unmarshalLocked updates an item from a server response, with the item's mutex
+// already held. Items in other drives keep their parent in the cache.
+func (d *DriveItem) unmarshalLocked(data []byte) error {
+	parent := d.Parent
+	err := json.Unmarshal(data, d)
+	if d.drive != "" {
+		d.Parent = parent
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// Shortcuts to folders in other drives should behave like folders whose
+// children are listed from the drive they point to.
+func TestShortcutTarget(t *testing.T) {
+	shortcut := &DriveItem{mutex: &mu.RWMutex{}}
+	err := json.Unmarshal([]byte(`{
+		"id": "local-shortcut",
+		"name": "Shared",
+		"parentReference": {"id": "root-id", "path": "/drive/root:"},
+		"remoteItem": {
+			"id": "remote-folder",
+			"parentReference": {"driveId": "b!other"},
+			"folder": {"childCount": 2}
+		}
+	}`), shortcut)
+	failOnErr(t, err)
+
+	if !shortcut.IsDir() {
+		t.Error("Shortcut to a folder was not a directory.")
+	}
+	drive, target := shortcut.contentTarget()
+	if drive != "b!other" || target != "remote-folder" {
+		t.Errorf("Shortcut pointed to %s/%s, expected b!other/remote-folder\n", drive, target)
+	}
+	route := scopedAuth(&Auth{}, drive).driveResource(ChildrenPathID(target))
+	if route != "/drives/b!other/items/remote-folder/children" {
+		t.Error("Children of shortcut were requested from the wrong place:", route)
+	}
+
+	plain := &DriveItem{mutex: &mu.RWMutex{}, IDInternal: "plain"}
+	if drive, target := plain.contentTarget(); drive != "" || target != "plain" {
+		t.Errorf("Regular item pointed to %s/%s\n", drive, target)
+	}
+	auth := &Auth{}
+	if scopedAuth(auth, "") != auth {
+		t.Error("Unscoped requests should use the filesystem's auth.")
+	}
+}
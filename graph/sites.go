@@ -0,0 +1,284 @@
+package graph
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const sitesDir = "/.sites"
+
+// how long lists of sites and document libraries are reused before being
+// fetched again
+const sitesTTL = 5 * time.Minute
+
+// Site is a SharePoint site
+// https://docs.microsoft.com/en-us/graph/api/resources/site
+type Site struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	WebURL      string `json:"webUrl,omitempty"`
+}
+
+// getSites fetches a list of sites from resource
+func getSites(resource string, auth *Auth) ([]Site, error) {
+	body, err := Get(resource, auth)
+	if err != nil {
+		return nil, err
+	}
+	var sites struct {
+		Values []Site `json:"value"`
+	}
+	err = json.Unmarshal(body, &sites)
+	return sites.Values, err
+}
+
+// GetFollowedSites lists the SharePoint sites the user follows. Only work and
+// school accounts have sites.
+func GetFollowedSites(auth *Auth) ([]Site, error) {
+	return getSites("/me/followedSites", auth)
+}
+
+// SearchSites finds SharePoint sites by name or description
+func SearchSites(query string, auth *Auth) ([]Site, error) {
+	return getSites("/sites?search="+url.QueryEscape(query), auth)
+}
+
+// GetSiteDrives lists the document libraries of a site
+func GetSiteDrives(siteID string, auth *Auth) ([]Drive, error) {
+	body, err := Get("/sites/"+siteID+"/drives", auth)
+	if err != nil {
+		return nil, err
+	}
+	var drives struct {
+		Values []Drive `json:"value"`
+	}
+	err = json.Unmarshal(body, &drives)
+	return drives.Values, err
+}
+
+// siteLibraries is a cached list of a site's document libraries, by lowercased
+// name
+type siteLibraries struct {
+	drives  map[string]Drive
+	fetched time.Time
+}
+
+// sitesDirectory is the virtual directory at /.sites. It lists the SharePoint
+// sites the user follows, with a directory for each of their document libraries,
+// so team files can be browsed without knowing any drive IDs. Sites that aren't
+// followed can be reached by name too, they are searched for. Libraries are read
+// only, mount one with a profile to make changes to it.
+type sitesDirectory struct {
+	fs        *FuseFs
+	mutex     *mu.Mutex
+	sites     map[string]Site // by lowercased display name
+	fetched   time.Time
+	libraries map[string]siteLibraries // by site ID
+	drives    map[string]*FuseFs       // by drive ID
+}
+
+func newSitesDir(fs *FuseFs) *sitesDirectory {
+	return &sitesDirectory{
+		fs:        fs,
+		mutex:     &mu.Mutex{},
+		libraries: make(map[string]siteLibraries),
+		drives:    make(map[string]*FuseFs),
+	}
+}
+
+// followed returns the sites the user follows, must be called with the mutex held
+func (s *sitesDirectory) followed() map[string]Site {
+	if s.sites != nil && time.Since(s.fetched) < sitesTTL {
+		return s.sites
+	}
+	sites, err := GetFollowedSites(s.fs.Auth.tokens())
+	if err != nil {
+		// personal accounts don't have sites, not worth complaining about
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Debug("Could not fetch followed sites.")
+	}
+	s.sites = make(map[string]Site)
+	for _, site := range sites {
+		name := siteName(site)
+		s.sites[strings.ToLower(name)] = site
+	}
+	s.fetched = time.Now()
+	return s.sites
+}
+
+// siteName is the name a site shows up under
+func siteName(site Site) string {
+	name := site.DisplayName
+	if name == "" {
+		name = site.Name
+	}
+	return strings.Replace(name, "/", "-", -1)
+}
+
+// site finds a site by name, searching for it if the user doesn't follow it
+func (s *sitesDirectory) site(name string) (Site, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if site, exists := s.followed()[strings.ToLower(name)]; exists {
+		return site, true
+	}
+	sites, err := SearchSites(name, s.fs.Auth.tokens())
+	if err != nil {
+		return Site{}, false
+	}
+	for _, site := range sites {
+		if strings.EqualFold(siteName(site), name) || strings.EqualFold(site.Name, name) {
+			return site, true
+		}
+	}
+	return Site{}, false
+}
+
+// library finds one of a site's document libraries by name
+func (s *sitesDirectory) library(site Site, name string) (Drive, bool) {
+	drives := s.siteDrives(site)
+	drive, exists := drives[strings.ToLower(name)]
+	return drive, exists
+}
+
+// siteDrives returns the document libraries of a site
+func (s *sitesDirectory) siteDrives(site Site) map[string]Drive {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if cached, exists := s.libraries[site.ID]; exists && time.Since(cached.fetched) < sitesTTL {
+		return cached.drives
+	}
+	fetched, err := GetSiteDrives(site.ID, s.fs.Auth.tokens())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"site": site.WebURL,
+			"err":  err,
+		}).Error("Could not fetch document libraries of site.")
+	}
+	drives := make(map[string]Drive)
+	for _, drive := range fetched {
+		drive.Name = strings.Replace(drive.Name, "/", "-", -1)
+		drives[strings.ToLower(drive.Name)] = drive
+	}
+	s.libraries[site.ID] = siteLibraries{drives: drives, fetched: time.Now()}
+	return drives
+}
+
+// driveFs returns the filesystem used to browse a document library
+func (s *sitesDirectory) driveFs(drive Drive) (*FuseFs, fuse.Status) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if fs, exists := s.drives[drive.ID]; exists {
+		return fs, fuse.OK
+	}
+	auth := s.fs.Auth.tokens().ForDrive(drive.ID)
+	cache, err := newCache(auth, "/")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"drive": drive.ID,
+			"err":   err,
+		}).Error("Could not open document library.")
+		return nil, fuse.EREMOTEIO
+	}
+	fs := newFSWithCache(auth, cache)
+	// no sites within sites
+	delete(fs.virtual, sitesDir)
+	s.drives[drive.ID] = fs
+	return fs, fuse.OK
+}
+
+// resolve splits a path in the sites directory into its site, library and the
+// path inside the library. Missing parts are left empty.
+func (s *sitesDirectory) resolve(path string) (*Site, *FuseFs, string, fuse.Status) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if parts[0] == "" {
+		return nil, nil, "", fuse.OK
+	}
+	site, exists := s.site(parts[0])
+	if !exists {
+		return nil, nil, "", fuse.ENOENT
+	}
+	if len(parts) == 1 {
+		return &site, nil, "", fuse.OK
+	}
+	drive, exists := s.library(site, parts[1])
+	if !exists {
+		return nil, nil, "", fuse.ENOENT
+	}
+	fs, status := s.driveFs(drive)
+	if status != fuse.OK {
+		return nil, nil, "", status
+	}
+	inner := "/"
+	if len(parts) == 3 {
+		inner = "/" + parts[2]
+	}
+	return &site, fs, inner, fuse.OK
+}
+
+func (s *sitesDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	_, fs, inner, status := s.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if fs == nil {
+		return virtualAttr(true, 0, uint64(time.Now().Unix())), fuse.OK
+	}
+	attr, status := fs.GetAttr(inner, nil)
+	if status == fuse.OK {
+		// libraries are read-only from here
+		attr.Mode &^= 0222
+	}
+	return attr, status
+}
+
+func (s *sitesDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	site, fs, inner, status := s.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if fs != nil {
+		return fs.OpenDir(inner, nil)
+	}
+
+	entries := make([]fuse.DirEntry, 0)
+	if site == nil {
+		s.mutex.Lock()
+		for _, followed := range s.followed() {
+			entries = append(entries, fuse.DirEntry{
+				Name: siteName(followed),
+				Mode: fuse.S_IFDIR | 0555,
+			})
+		}
+		s.mutex.Unlock()
+		return entries, fuse.OK
+	}
+	for _, drive := range s.siteDrives(*site) {
+		entries = append(entries, fuse.DirEntry{Name: drive.Name, Mode: fuse.S_IFDIR | 0555})
+	}
+	return entries, fuse.OK
+}
+
+func (s *sitesDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC) != 0 {
+		return nil, fuse.EPERM
+	}
+	_, fs, inner, status := s.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if fs == nil {
+		return nil, fuse.EISDIR
+	}
+	return fs.Open(inner, flags, nil)
+}
@@ -0,0 +1,23 @@
+package graph
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// site names can't contain slashes, and fall back to the site's short name
+func TestSiteName(t *testing.T) {
+	if name := siteName(Site{Name: "team", DisplayName: "Sales/Marketing"}); name != "Sales-Marketing" {
+		t.Fatal("Wrong name for site with slash in its name:", name)
+	}
+	if name := siteName(Site{Name: "team"}); name != "team" {
+		t.Fatal("Wrong name for site without display name:", name)
+	}
+}
+
+// personal accounts have no sites, but the folder should still be there
+func TestSitesDir(t *testing.T) {
+	_, err := ioutil.ReadDir(filepath.Join(mountLoc, sitesDir))
+	failOnErr(t, err)
+}
@@ -0,0 +1,119 @@
+package graph
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const specialDir = "/.special"
+
+// how long special folder locations are reused before being looked up again,
+// they hardly ever move
+const specialTTL = 5 * time.Minute
+
+// SpecialFolders are the names of the well-known folders OneDrive keeps track
+// of. Their actual names and locations depend on the user's language and can be
+// changed by renaming or moving them.
+// https://docs.microsoft.com/en-us/graph/api/drive-get-specialfolder
+var SpecialFolders = []string{"documents", "photos", "cameraroll", "approot", "music"}
+
+// GetSpecialFolder fetches one of the SpecialFolders by name. Fails if the
+// folder doesn't exist yet.
+func GetSpecialFolder(name string, auth *Auth) (*DriveItem, error) {
+	body, err := Get("/me/drive/special/"+name, auth)
+	item := &DriveItem{mutex: &mu.RWMutex{}}
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal(body, item)
+	return item, err
+}
+
+// specialDirectory is the virtual directory at /.special. It contains a symlink
+// to each of the SpecialFolders that exists, so that scripts can find things
+// like the camera roll without knowing what it's called.
+type specialDirectory struct {
+	fs      *FuseFs
+	mutex   *mu.Mutex
+	links   map[string]virtualLink
+	fetched time.Time
+}
+
+func newSpecialDir(fs *FuseFs) *specialDirectory {
+	return &specialDirectory{fs: fs, mutex: &mu.Mutex{}}
+}
+
+// special returns links to the special folders
+func (s *specialDirectory) special() map[string]virtualLink {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.links != nil && time.Since(s.fetched) < specialTTL {
+		return s.links
+	}
+	links := make(map[string]virtualLink)
+	for _, name := range SpecialFolders {
+		item, err := GetSpecialFolder(name, s.fs.Auth)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"name": name,
+				"err":  err,
+			}).Debug("Could not fetch special folder.")
+			continue
+		}
+		path, ok := s.fs.mountedPath(item)
+		if !ok {
+			continue
+		}
+		links[name] = virtualLink{name: name, path: path, modTime: item.ModTime()}
+	}
+	s.links = links
+	s.fetched = time.Now()
+	return links
+}
+
+// link finds a link by its path in the special directory
+func (s *specialDirectory) link(path string) (virtualLink, fuse.Status) {
+	link, exists := s.special()[strings.ToLower(strings.TrimPrefix(path, "/"))]
+	if !exists {
+		return virtualLink{}, fuse.ENOENT
+	}
+	return link, fuse.OK
+}
+
+func (s *specialDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	if path == "/" {
+		return virtualAttr(true, 0, uint64(time.Now().Unix())), fuse.OK
+	}
+	link, status := s.link(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return link.attr(specialDir + path), fuse.OK
+}
+
+func (s *specialDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	if path != "/" {
+		return nil, fuse.ENOTDIR
+	}
+	return linkEntries(s.special()), fuse.OK
+}
+
+// Open is never called for the links themselves, the kernel follows symlinks on
+// its own
+func (s *specialDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	return nil, fuse.EACCES
+}
+
+func (s *specialDirectory) Readlink(path string) (string, fuse.Status) {
+	link, status := s.link(path)
+	if status != fuse.OK {
+		return "", status
+	}
+	return link.target(specialDir + path), fuse.OK
+}
@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// items fetched outside the cache should get paths relative to the mounted folder
+func TestMountedPath(t *testing.T) {
+	fs := &FuseFs{items: &Cache{prefix: "/Projects"}}
+	item := &DriveItem{
+		NameInternal: "report.docx",
+		Parent:       &DriveItemParent{ID: "parent", Path: "/drive/root:/Projects/2020"},
+		mutex:        &mu.RWMutex{},
+	}
+	if path, ok := fs.mountedPath(item); !ok || path != "/2020/report.docx" {
+		t.Fatalf("Wrong path for item inside mounted folder: %s %v\n", path, ok)
+	}
+	item.Parent.Path = "/drive/root:/Documents"
+	if path, ok := fs.mountedPath(item); ok {
+		t.Fatal("Item outside of mounted folder had a path:", path)
+	}
+}
+
+// the documents folder always exists, so it should be in .special
+func TestSpecialDir(t *testing.T) {
+	link := filepath.Join(mountLoc, specialDir, "documents")
+	st, err := os.Stat(link)
+	failOnErr(t, err)
+	if !st.IsDir() {
+		t.Fatal("Special folder link did not point to a directory.")
+	}
+}
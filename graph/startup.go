@@ -0,0 +1,93 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// savedRootFile is where the root item is kept in the cache directory between
+// mounts
+const savedRootFile = "root.json"
+
+// rootRetryDelay is how long to wait before checking a saved root item against
+// the server again, if the server couldn't be reached
+const rootRetryDelay = 30 * time.Second
+
+// savedRoot is the root item of a filesystem, as kept on disk
+type savedRoot struct {
+	Path string     `json:"path"`
+	Item *DriveItem `json:"item"`
+}
+
+// NewFSCached is NewFSAt(), but doesn't wait on the server if the root item was
+// saved in cacheDir by an earlier mount. The saved copy is used right away and
+// checked against the server in the background, so mounts come up instantly even
+// when the network is slow or down.
+func NewFSCached(auth *Auth, root string, cacheDir string) *FuseFs {
+	rootPath := filepath.Clean("/" + root)
+	path := filepath.Join(cacheDir, savedRootFile)
+	var saved savedRoot
+	if err := loadJSON(path, &saved); err != nil {
+		log.WithFields(log.Fields{
+			"path": path,
+			"err":  err,
+		}).Warn("Could not load saved root item, fetching it from the server.")
+	}
+
+	if saved.Item == nil || saved.Path != rootPath || !saved.Item.IsDir() {
+		fs := NewFSAt(auth, rootPath)
+		saveRoot(path, rootPath, fs.items.GetID(fs.items.root))
+		return fs
+	}
+	saved.Item.mutex = &mu.RWMutex{}
+	cache := newCacheWithRoot(auth, rootPath, saved.Item)
+	go cache.validateRoot(rootPath, auth, path)
+	return newFSWithCache(auth, cache)
+}
+
+// saveRoot keeps the root item at path for the next mount
+func saveRoot(path string, rootPath string, root *DriveItem) {
+	root.mutex.RLock()
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = saveJSON(path, savedRoot{Path: rootPath, Item: root})
+	}
+	root.mutex.RUnlock()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": path,
+			"err":  err,
+		}).Warn("Could not save root item, the next mount will wait on the server.")
+	}
+}
+
+// validateRoot checks the saved root item the cache was created with against the
+// server, retrying until the server can be reached, and saves the server's copy
+// for the next mount.
+func (c *Cache) validateRoot(rootPath string, auth *Auth, savePath string) {
+	remote, err := GetItem(rootPath, auth)
+	for err != nil {
+		log.WithFields(log.Fields{
+			"root": rootPath,
+			"err":  err,
+		}).Warn("Could not check saved root item against the server, using it as is.")
+		time.Sleep(rootRetryDelay)
+		remote, err = GetItem(rootPath, auth)
+	}
+
+	if id := remote.ID(); id != c.root {
+		log.WithFields(log.Fields{
+			"root":  rootPath,
+			"saved": c.root,
+			"id":    id,
+		}).Error("Root of the filesystem was replaced on the server, " +
+			"remount to pick up the new one.")
+	} else {
+		c.GetID(c.root).updateMetadata(remote)
+	}
+	saveRoot(savePath, rootPath, remote)
+}
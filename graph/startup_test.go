@@ -0,0 +1,32 @@
+package graph
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// a saved root item should be used without asking the server
+func TestNewFSCached(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_startup")
+	defer os.RemoveAll(dir)
+	root := NewDriveItem("Documents", 0755|fuse.S_IFDIR, nil)
+	root.IDInternal = "saved-root-id"
+	root.Folder = &Folder{}
+	saveRoot(filepath.Join(dir, savedRootFile), "/Documents", root)
+
+	// no tokens, any request to the server would fail
+	fs := NewFSCached(&Auth{}, "/Documents", dir)
+	defer fs.Close()
+	if fs.items.root != "saved-root-id" {
+		t.Fatalf("Got root %s instead of the saved one.\n", fs.items.root)
+	}
+	item, err := fs.items.Get("/", fs.Auth)
+	failOnErr(t, err)
+	if item.Name() != "Documents" || !item.IsDir() {
+		t.Fatalf("Saved root item was not restored, got \"%s\".\n", item.Name())
+	}
+}
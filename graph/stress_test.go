@@ -0,0 +1,221 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// stressIterations is how many random operations each stress test performs
+func stressIterations() int {
+	if testing.Short() {
+		return 500
+	}
+	return 20000
+}
+
+// stressRand returns a random source for a stress test, logging its seed so
+// failures can be reproduced
+func stressRand(t *testing.T) *rand.Rand {
+	seed := time.Now().UnixNano()
+	t.Logf("Random seed: %d\n", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+// stressItem returns an empty file in a cache that never uploads anything
+func stressItem(t *testing.T, name string) (*Cache, *DriveItem) {
+	root := NewDriveItem("root", fuse.S_IFDIR|0755, nil)
+	cache := newCacheWithRoot(&Auth{}, "/", root)
+	cache.Pause()
+	item := NewDriveItem(name, 0644, root)
+	failOnErr(t, cache.Insert("/"+name, &Auth{}, item))
+	return cache, item
+}
+
+// readAll reads len bytes at off from an item, the way the kernel would
+func readAll(t *testing.T, item *DriveItem, off int, len int) []byte {
+	buf := make([]byte, len)
+	result, status := item.Read(buf, int64(off))
+	if status != fuse.OK {
+		t.Fatalf("Read of %d bytes at %d failed: %v\n", len, off, status)
+	}
+	content, _ := result.Bytes(buf)
+	return content
+}
+
+// random reads, writes and truncates should leave a file with the same content
+// as they would a plain byte slice
+func TestContentRandomOps(t *testing.T) {
+	t.Parallel()
+	random := stressRand(t)
+	_, item := stressItem(t, "random_ops.bin")
+	var model []byte
+
+	for i := 0; i < stressIterations(); i++ {
+		size := len(model)
+		switch op := random.Intn(10); {
+		case op < 4:
+			off := random.Intn(size + 1)
+			data := make([]byte, random.Intn(8192))
+			random.Read(data)
+			n, status := item.Write(data, int64(off))
+			if status != fuse.OK || int(n) != len(data) {
+				t.Fatalf("Write of %d bytes at %d: wrote %d, %v\n", len(data), off, n, status)
+			}
+			if end := off + len(data); end > size {
+				model = append(model[:off], data...)
+			} else {
+				copy(model[off:], data)
+			}
+		case op < 5:
+			newSize := random.Intn(size + 1)
+			if status := item.Truncate(uint64(newSize)); status != fuse.OK {
+				t.Fatalf("Truncate to %d failed: %v\n", newSize, status)
+			}
+			model = model[:newSize]
+		default:
+			off := random.Intn(size + 1)
+			length := random.Intn(16384)
+			expected := model[off:]
+			if len(expected) > length {
+				expected = expected[:length]
+			}
+			if read := readAll(t, item, off, length); !bytes.Equal(read, expected) {
+				t.Fatalf("Read of %d bytes at %d returned %d bytes, differing from "+
+					"what was written.\n", length, off, len(read))
+			}
+		}
+		if item.Size() != uint64(len(model)) {
+			t.Fatalf("Size is %d instead of %d after operation %d.\n",
+				item.Size(), len(model), i)
+		}
+	}
+	if read := readAll(t, item, 0, len(model)); !bytes.Equal(read, model) {
+		t.Fatal("Final content differs from what was written.")
+	}
+}
+
+// concurrent writes to different parts of a file shouldn't tear each other or
+// the reads going on at the same time
+func TestContentConcurrentOps(t *testing.T) {
+	t.Parallel()
+	random := stressRand(t)
+	_, item := stressItem(t, "concurrent_ops.bin")
+	const writers = 8
+	const part = 3 * regionSize / 2 // so parts share region locks
+	size := writers * part
+	item.Write(make([]byte, size), 0)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers)
+	iterations := stressIterations() / writers
+	for w := 0; w < writers; w++ {
+		seed := random.Int63()
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			for i := 0; i < iterations; i++ {
+				// each writer only ever writes its own number to its own part
+				off := random.Intn(part)
+				length := 1 + random.Intn(part-off)
+				if length > 8192 {
+					length = 8192
+				}
+				data := bytes.Repeat([]byte{byte(w + 1)}, length)
+				if _, status := item.Write(data, int64(w*part+off)); status != fuse.OK {
+					errs <- fmt.Errorf("write failed: %v", status)
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed + 1))
+			buf := make([]byte, 65536)
+			for i := 0; i < iterations; i++ {
+				off := random.Intn(size)
+				result, _ := item.Read(buf, int64(off))
+				content, _ := result.Bytes(buf)
+				if len(content) != len(buf) && off+len(content) != size {
+					errs <- fmt.Errorf("read at %d was short: %d bytes", off, len(content))
+					return
+				}
+				for j, b := range content {
+					if owner := byte((off+j)/part + 1); b != 0 && b != owner {
+						errs <- fmt.Errorf("byte %d belongs to writer %d, but was %d",
+							off+j, owner, b)
+						return
+					}
+				}
+				item.Truncate(uint64(size)) // doesn't change anything, but locks
+				item.Size()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if item.Size() != uint64(size) {
+		t.Fatalf("Size changed to %d while writing in place.\n", item.Size())
+	}
+}
+
+// renaming a file over and over while it's being written to shouldn't lose any
+// writes or leave the file behind at an old path
+func TestContentRenameWhileOpen(t *testing.T) {
+	t.Parallel()
+	random := stressRand(t)
+	cache, item := stressItem(t, "rename_0.bin")
+	root, _ := cache.Get("/", &Auth{})
+	dir := NewDriveItem("dir", fuse.S_IFDIR|0755, root)
+	failOnErr(t, cache.Insert("/dir", &Auth{}, dir))
+
+	const chunk = 1024
+	iterations := stressIterations() / 10
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < iterations; i++ {
+			data := bytes.Repeat([]byte{byte(i)}, chunk)
+			item.Write(data, int64(i*chunk))
+		}
+		done <- true
+	}()
+
+	path := "/rename_0.bin"
+	for moves := 1; ; moves++ {
+		select {
+		case <-done:
+			if found, err := cache.Get(path, &Auth{}); err != nil || found != item {
+				t.Fatalf("File went missing from %s after %d renames.\n", path, moves)
+			}
+			if item.Path() != path {
+				t.Fatalf("File thinks it's at %s, but is at %s.\n", item.Path(), path)
+			}
+			content := readAll(t, item, 0, iterations*chunk)
+			for i := 0; i < iterations; i++ {
+				if content[i*chunk] != byte(i) {
+					t.Fatalf("Write %d was lost while renaming.\n", i)
+				}
+			}
+			return
+		default:
+		}
+		newPath := fmt.Sprintf("/rename_%d.bin", moves)
+		if random.Intn(2) == 0 {
+			newPath = "/dir" + newPath
+		}
+		failOnErr(t, cache.Move(path, newPath, &Auth{}))
+		if old, _ := cache.Get(path, &Auth{}); old != nil {
+			t.Fatalf("File was still found at %s after renaming it.\n", path)
+		}
+		path = newPath
+	}
+}
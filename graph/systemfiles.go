@@ -0,0 +1,33 @@
+package graph
+
+import "strings"
+
+// Windows, and Office on it, leave files behind in synced folders that are of no
+// use anywhere else: desktop.ini with a folder's icon and view settings,
+// Thumbs.db with thumbnails of the pictures in it, and "~$" owner files marking
+// documents someone has open in Office. On request, these are hidden from
+// directory listings, to keep shared folders tidy. They still work when opened
+// by name, and aren't touched otherwise.
+
+// SetHideSystemFiles turns hiding files left behind by Windows and Office from
+// directory listings on or off
+func (fs *FuseFs) SetHideSystemFiles(enabled bool) {
+	fs.mutex.Lock()
+	fs.hideSystemFiles = enabled
+	fs.mutex.Unlock()
+}
+
+func (fs *FuseFs) systemFilesHidden() bool {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	return fs.hideSystemFiles
+}
+
+// isSystemFile returns whether a file is one left behind by Windows or Office
+func isSystemFile(name string, isDir bool) bool {
+	if isDir {
+		return false
+	}
+	lower := strings.ToLower(name)
+	return lower == "desktop.ini" || lower == "thumbs.db" || strings.HasPrefix(lower, "~$")
+}
@@ -0,0 +1,51 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// files left behind by Windows should only be hidden from listings when asked
+// to, and stay accessible by name
+func TestHideSystemFiles(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	mock.mutex.Lock()
+	docs := mock.child(mockRootID, "Documents")
+	for _, name := range []string{"desktop.ini", "Thumbs.db", "~$report.docx", "report.docx"} {
+		mock.create(docs, name, false)
+	}
+	mock.create(docs, "~$folder", true)
+	mock.mutex.Unlock()
+	fs := NewFSAt(mock.auth(), "/")
+
+	listing := func() map[string]bool {
+		entries, status := fs.OpenDir("/Documents", nil)
+		if status != fuse.OK {
+			t.Fatal("Could not list folder:", status)
+		}
+		names := make(map[string]bool)
+		for _, entry := range entries {
+			names[entry.Name] = true
+		}
+		return names
+	}
+	if names := listing(); !names["desktop.ini"] || !names["~$report.docx"] {
+		t.Fatal("Files were hidden while hiding was turned off:", names)
+	}
+
+	fs.SetHideSystemFiles(true)
+	names := listing()
+	for _, name := range []string{"desktop.ini", "Thumbs.db", "~$report.docx"} {
+		if names[name] {
+			t.Errorf("%q was not hidden.", name)
+		}
+		if _, status := fs.GetAttr("/Documents/"+name, nil); status != fuse.OK {
+			t.Errorf("%q could not be found by name: %v", name, status)
+		}
+	}
+	if !names["report.docx"] || !names["~$folder"] {
+		t.Fatal("Other items were hidden:", names)
+	}
+}
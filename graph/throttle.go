@@ -0,0 +1,72 @@
+package graph
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// the largest read a throttledReader will perform at once, keeps transfers from
+// bursting far past their limit before the limiter gets a chance to kick in
+const throttleBurst = 32 * 1024
+
+// rateLimiter is a simple bandwidth limiter shared by all transfers in one
+// direction. A limit of 0 means unlimited.
+type rateLimiter struct {
+	mutex sync.Mutex
+	limit uint64    // bytes per second
+	next  time.Time // when the next transfer is allowed to start
+}
+
+var (
+	uploadLimiter   = &rateLimiter{}
+	downloadLimiter = &rateLimiter{}
+)
+
+// SetBandwidthLimits sets the maximum upload and download rates in bytes/s for
+// all transfers. A value of 0 disables the limit. Safe to call while transfers
+// are in progress.
+func SetBandwidthLimits(upload uint64, download uint64) {
+	uploadLimiter.setLimit(upload)
+	downloadLimiter.setLimit(download)
+}
+
+func (r *rateLimiter) setLimit(limit uint64) {
+	r.mutex.Lock()
+	r.limit = limit
+	r.next = time.Time{}
+	r.mutex.Unlock()
+}
+
+// wait blocks for however long is needed to keep the transfer of n more bytes
+// under the limit
+func (r *rateLimiter) wait(n int) {
+	r.mutex.Lock()
+	if r.limit == 0 || n <= 0 {
+		r.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(time.Duration(uint64(n) * uint64(time.Second) / r.limit))
+	r.mutex.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledReader wraps a reader so that reads from it obey a rateLimiter
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleBurst {
+		p = p[:throttleBurst]
+	}
+	n, err := t.reader.Read(p)
+	t.limiter.wait(n)
+	return n, err
+}
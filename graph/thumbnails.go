@@ -0,0 +1,138 @@
+package graph
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const thumbnailsDir = "/.thumbnails"
+
+// how many thumbnails are kept in memory at most
+const maxThumbnails = 1024
+
+// GetThumbnail fetches a server-generated preview image for an item. size is
+// "small", "medium" or "large" (roughly 96, 176 and 800 pixels on the longest
+// side). Fails if the server can't generate a thumbnail for the item.
+func GetThumbnail(id string, size string, auth *Auth) ([]byte, error) {
+	return Get(fmt.Sprintf("/me/drive/items/%s/thumbnails/0/%s/content", id, size), auth)
+}
+
+// thumbnail is a cached thumbnail, along with the modification time of the item
+// it was generated from
+type thumbnail struct {
+	content []byte
+	modTime uint64
+}
+
+// thumbnailsDirectory is the virtual directory at /.thumbnails. It mirrors the
+// folders of the regular filesystem, with each file replaced by a medium sized
+// JPEG preview of it, so images can be previewed without downloading them.
+// Files the server can't make a preview of are listed, but can't be opened.
+type thumbnailsDirectory struct {
+	fs     *FuseFs
+	mutex  *mu.Mutex
+	images map[string]thumbnail // by item ID
+}
+
+func newThumbnailsDir(fs *FuseFs) *thumbnailsDirectory {
+	return &thumbnailsDirectory{
+		fs:     fs,
+		mutex:  &mu.Mutex{},
+		images: make(map[string]thumbnail),
+	}
+}
+
+// thumbnail returns the thumbnail of a file, fetching it if it isn't cached or
+// the file has changed since
+func (t *thumbnailsDirectory) thumbnail(item *DriveItem) ([]byte, fuse.Status) {
+	id := item.ID()
+	if isLocalID(id) {
+		return nil, fuse.ENOENT
+	}
+	t.mutex.Lock()
+	cached, exists := t.images[id]
+	t.mutex.Unlock()
+	if exists && cached.modTime == item.ModTime() {
+		return cached.content, fuse.OK
+	}
+
+	content, err := GetThumbnail(id, "medium", t.fs.Auth)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": item.Path(),
+			"err":  err,
+		}).Debug("Could not fetch thumbnail.")
+		return nil, fuse.ENOENT
+	}
+	t.mutex.Lock()
+	if len(t.images) >= maxThumbnails {
+		// not worth tracking which ones were used last, they're cheap to refetch
+		t.images = make(map[string]thumbnail)
+	}
+	t.images[id] = thumbnail{content: content, modTime: item.ModTime()}
+	t.mutex.Unlock()
+	return content, fuse.OK
+}
+
+func (t *thumbnailsDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	item, err := t.fs.items.Get(path, t.fs.Auth)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	if item.IsDir() {
+		return virtualAttr(true, 0, item.ModTime()), fuse.OK
+	}
+	// the size has to be right for reads to work, so the thumbnail gets fetched
+	// here already
+	content, status := t.thumbnail(item)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return virtualAttr(false, uint64(len(content)), item.ModTime()), fuse.OK
+}
+
+func (t *thumbnailsDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	item, err := t.fs.items.Get(path, t.fs.Auth)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	if !item.IsDir() {
+		return nil, fuse.ENOTDIR
+	}
+	children, err := t.fs.items.GetChildrenPath(path, t.fs.Auth)
+	if err != nil {
+		return nil, fuse.EREMOTEIO
+	}
+	entries := make([]fuse.DirEntry, 0, len(children))
+	for _, child := range children {
+		mode := uint32(fuse.S_IFREG | 0444)
+		if child.IsDir() {
+			mode = fuse.S_IFDIR | 0555
+		}
+		entries = append(entries, fuse.DirEntry{Name: child.Name(), Mode: mode})
+	}
+	return entries, fuse.OK
+}
+
+func (t *thumbnailsDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, fuse.EPERM
+	}
+	item, err := t.fs.items.Get(path, t.fs.Auth)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	if item.IsDir() {
+		return nil, fuse.EISDIR
+	}
+	content, status := t.thumbnail(item)
+	if status != fuse.OK {
+		return nil, status
+	}
+	return nodefs.NewReadOnlyFile(nodefs.NewDataFile(content)), fuse.OK
+}
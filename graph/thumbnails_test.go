@@ -0,0 +1,37 @@
+package graph
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/jpeg" // thumbnails are JPEGs
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// uploaded images should get a preview in .thumbnails
+func TestThumbnailsDir(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	for x := 0; x < 512; x++ {
+		for y := 0; y < 512; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	failOnErr(t, png.Encode(&buf, img))
+	failOnErr(t, ioutil.WriteFile(filepath.Join(TestDir, "thumbnail.png"), buf.Bytes(), 0644))
+	time.Sleep(10 * time.Second) // wait for upload and for the preview to be generated
+
+	preview, err := ioutil.ReadFile(
+		filepath.Join(mountLoc, thumbnailsDir, "onedriver_tests", "thumbnail.png"))
+	failOnErr(t, err)
+	if _, _, err = image.Decode(bytes.NewReader(preview)); err != nil {
+		t.Fatal("Thumbnail was not a valid image:", err)
+	}
+	if len(preview) >= buf.Len() {
+		t.Fatal("Thumbnail was not smaller than the original image.")
+	}
+}
@@ -0,0 +1,103 @@
+package graph
+
+// Standalone transfers for use without a mounted filesystem - nothing here
+// touches a Cache.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// DownloadFile fetches a file and its metadata from the server and verifies the
+// content against the server's checksums.
+func DownloadFile(path string, auth *Auth) (*DriveItem, []byte, error) {
+	item, err := GetItem(path, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if item.IsDir() {
+		return item, nil, errors.New(path + " is a folder")
+	}
+	if err = item.FetchContent(auth); err != nil {
+		return item, nil, err
+	}
+	content := *item.data
+	if !item.VerifyChecksum(content) {
+		return item, nil, errors.New("checksum mismatch while downloading " + path)
+	}
+	return item, content, nil
+}
+
+// UploadFile uploads content to the file at path on the server, replacing it if
+// it already exists. The parent folder must exist. Once uploaded, the file's
+// checksums on the server are verified against the content.
+func UploadFile(path string, content []byte, modTime time.Time, auth *Auth) (*DriveItem, error) {
+	dir := filepath.Dir(path)
+	parent, err := GetItem(dir, auth)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir() {
+		return nil, errors.New(dir + " is not a folder")
+	}
+
+	item := NewDriveItem(filepath.Base(path), 0644, nil)
+	existing, err := GetItem(path, auth)
+	if err == nil {
+		if existing.IsDir() {
+			return nil, errors.New(path + " is a folder")
+		}
+		item.IDInternal = existing.ID()
+	} else if !strings.Contains(err.Error(), "itemNotFound") {
+		return nil, err
+	}
+	item.Parent = &DriveItemParent{
+		ID:   parent.ID(),
+		Path: "/drive/root:" + strings.TrimSuffix(dir, "/"),
+	}
+	item.data = &content
+	item.SizeInternal = uint64(len(content))
+	item.ModTimeInternal = &modTime
+
+	if err = item.upload(auth); err != nil {
+		return item, err
+	}
+	uploaded, err := GetItem(path, auth)
+	if err != nil {
+		return item, err
+	}
+	if !uploaded.VerifyChecksum(content) {
+		return uploaded, errors.New("checksum mismatch after uploading " + path)
+	}
+	return uploaded, nil
+}
+
+// CreateFolder creates a folder on the server, or returns the existing folder if
+// there already is one at path.
+func CreateFolder(path string, auth *Auth) (*DriveItem, error) {
+	existing, err := GetItem(path, auth)
+	if err == nil {
+		if !existing.IsDir() {
+			return nil, errors.New(path + " already exists and is not a folder")
+		}
+		return existing, nil
+	}
+
+	payload, _ := json.Marshal(DriveItem{
+		NameInternal: filepath.Base(path),
+		Folder:       &Folder{},
+	})
+	resp, err := Post(ChildrenPath(filepath.Dir(path)), auth, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	folder := &DriveItem{mutex: &mu.RWMutex{}}
+	err = json.Unmarshal(resp, folder)
+	return folder, err
+}
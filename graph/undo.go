@@ -0,0 +1,294 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingDelete is an item that was deleted locally, but not on the server yet
+type pendingDelete struct {
+	item    *DriveItem
+	id      string
+	path    string
+	deleted time.Time
+	timer   *time.Timer
+	// deletions of items inside a folder are merged into the folder's, the
+	// server deletes them along with it
+	contents []*pendingDelete
+}
+
+// coalesceDelay is how long deletions are held back when there is no grace
+// period, so that "rm -r" (which deletes a folder's contents one by one before
+// the folder itself) ends up as a single request for the folder
+const coalesceDelay = 2 * time.Second
+
+// deleteQueue holds deletions back from the server for a while. Deleting a
+// folder merges the pending deletions of everything in it into one request for
+// the folder. With a grace period set, "onedriver undo" brings items back until
+// it is over, which makes a stray "rm -rf" a lot less costly. Deletions still
+// pending when the filesystem is closed are sent to the server right away.
+type deleteQueue struct {
+	fs      *FuseFs
+	mutex   *mu.Mutex
+	delay   time.Duration    // the grace period, 0 if there is none
+	pending []*pendingDelete // in order of deletion
+}
+
+func newDeleteQueue(fs *FuseFs) *deleteQueue {
+	return &deleteQueue{fs: fs, mutex: &mu.Mutex{}}
+}
+
+// setDelay changes the grace period of deletions made from now on
+func (q *deleteQueue) setDelay(delay time.Duration) {
+	q.mutex.Lock()
+	q.delay = delay
+	q.mutex.Unlock()
+}
+
+// pathWithin is isWithin(), ignoring case like OneDrive does
+func pathWithin(path string, dir string) bool {
+	return isWithin(strings.ToLower(path), strings.ToLower(dir))
+}
+
+// add deletes an item at path on the server, once it has been held back long
+// enough
+func (q *deleteQueue) add(item *DriveItem, path string) {
+	p := &pendingDelete{item: item, id: item.ID(), path: path, deleted: time.Now()}
+	// hide the item from listings refetched from the server in the meantime
+	q.fs.items.deleting.Store(p.id, true)
+	isDir := item.IsDir()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delay := q.delay
+	if delay < coalesceDelay {
+		delay = coalesceDelay
+		// keep holding back the rest of the folders being emptied, which
+		// includes anything deleted next to the folders this item is in
+		for _, pending := range q.pending {
+			if pathWithin(path, filepath.Dir(pending.path)) {
+				pending.timer.Reset(coalesceDelay)
+			}
+		}
+	}
+	if isDir {
+		kept := q.pending[:0]
+		for _, pending := range q.pending {
+			if pathWithin(pending.path, path) {
+				pending.timer.Stop()
+				p.contents = append(p.contents, pending)
+			} else {
+				kept = append(kept, pending)
+			}
+		}
+		q.pending = kept
+	}
+	p.timer = time.AfterFunc(delay, func() { q.expire(p) })
+	q.pending = append(q.pending, p)
+	log.WithFields(log.Fields{
+		"path":   path,
+		"delay":  delay,
+		"merged": len(p.contents),
+	}).Debug("Holding back deletion on server.")
+}
+
+// remove takes a deletion out of the queue, returns false if it wasn't in it
+// (because it was already sent or undone)
+func (q *deleteQueue) remove(p *pendingDelete) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i, pending := range q.pending {
+		if pending == p {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			p.timer.Stop()
+			return true
+		}
+	}
+	return false
+}
+
+// forget stops hiding a deleted item and its contents from listings
+func (q *deleteQueue) forget(p *pendingDelete) {
+	q.fs.items.deleting.Delete(p.id)
+	for _, content := range p.contents {
+		q.forget(content)
+	}
+}
+
+// expire sends a deletion to the server once it has been held back long enough
+func (q *deleteQueue) expire(p *pendingDelete) {
+	if !q.remove(p) {
+		return
+	}
+	err := q.fs.deleteRemote(p.item, p.path)
+	if err != nil && q.fs.retries.addDelete(p, err) {
+		// stays hidden from listings until the retry goes through
+		return
+	}
+	q.forget(p)
+	if quota := p.item.quota(); err == nil && quota != nil {
+		quota.addDeleted(p.size())
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": p.path,
+			"err":  err,
+		}).Error("Failed to delete item on server, restoring it locally.")
+		q.restore(p, p.path)
+	}
+}
+
+// restore puts a pending deletion back at path, along with the contents merged
+// into it
+func (q *deleteQueue) restore(p *pendingDelete, path string) error {
+	restored, err := q.fs.restoreDeleted(p.item, path)
+	if err != nil {
+		return err
+	}
+	// folders are deleted after their contents, so restore them first
+	for i := len(p.contents) - 1; i >= 0; i-- {
+		content := p.contents[i]
+		rel := content.path[len(p.path):]
+		if err := q.restore(content, restored+rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count returns how many items a pending deletion covers
+func (p *pendingDelete) count() int {
+	count := 1
+	for _, content := range p.contents {
+		count += content.count()
+	}
+	return count
+}
+
+// undo restores the items deleted at or below path whose deletion is still
+// pending, along with the folders they were in if those were deleted too.
+// Returns how many items were restored.
+func (q *deleteQueue) undo(path string) (int, error) {
+	q.mutex.Lock()
+	var undone []*pendingDelete
+	for _, p := range q.pending {
+		if path == "/" || pathWithin(p.path, path) || pathWithin(path, p.path) {
+			undone = append(undone, p)
+		}
+	}
+	q.mutex.Unlock()
+
+	count := 0
+	for i := len(undone) - 1; i >= 0; i-- {
+		p := undone[i]
+		if !q.remove(p) {
+			continue
+		}
+		q.forget(p)
+		if err := q.restore(p, p.path); err != nil {
+			return count, err
+		}
+		count += p.count()
+	}
+	return count, nil
+}
+
+// settle sends the pending deletion of the item at path right away, if there is
+// one. Must be done before something new takes its place on the server.
+func (q *deleteQueue) settle(path string) {
+	q.mutex.Lock()
+	var found *pendingDelete
+	for _, p := range q.pending {
+		if strings.EqualFold(p.path, path) {
+			found = p
+			break
+		}
+	}
+	q.mutex.Unlock()
+	if found != nil {
+		q.expire(found)
+	}
+}
+
+// flush sends every pending deletion to the server right away
+func (q *deleteQueue) flush() {
+	q.mutex.Lock()
+	pending := make([]*pendingDelete, len(q.pending))
+	copy(pending, q.pending)
+	q.mutex.Unlock()
+	for _, p := range pending {
+		q.expire(p)
+	}
+}
+
+// flushCoalesced is flush(), but only if deletions are held back just to merge
+// them, not for a grace period
+func (q *deleteQueue) flushCoalesced() {
+	q.mutex.Lock()
+	delay := q.delay
+	q.mutex.Unlock()
+	if delay < coalesceDelay {
+		q.flush()
+	}
+}
+
+// deleteRemote deletes an item on the server and records it in the recycle bin
+func (fs *FuseFs) deleteRemote(item *DriveItem, path string) error {
+	if err := Delete("/me/drive/items/"+item.ID(), item.driveAuth(fs.Auth)); err != nil {
+		return err
+	}
+	fs.bin.add(item, path)
+	return nil
+}
+
+// size returns how many bytes of files a pending deletion covers
+func (p *pendingDelete) size() uint64 {
+	var size uint64
+	if !p.item.IsDir() {
+		size = p.item.Size()
+	}
+	for _, content := range p.contents {
+		size += content.size()
+	}
+	return size
+}
+
+// restoreDeleted puts an item whose deletion never made it to the server back at
+// path. If something else took its place in the meantime, the item is renamed
+// (on the server too) so both can stay. Returns where the item ended up.
+func (fs *FuseFs) restoreDeleted(item *DriveItem, path string) (string, error) {
+	dir := filepath.Dir(path)
+	name := uniqueName(item.Name(), func(name string) bool {
+		existing, _ := fs.items.Get(filepath.Join(dir, name), fs.Auth)
+		return existing != nil
+	})
+	if name != item.Name() {
+		patch, _ := json.Marshal(DriveItem{NameInternal: name})
+		_, err := Patch("/me/drive/items/"+item.ID(), item.driveAuth(fs.Auth),
+			bytes.NewReader(patch))
+		if err != nil {
+			return path, err
+		}
+		item.SetName(name)
+	}
+	path = filepath.Join(dir, name)
+	if err := fs.items.Insert(path, fs.Auth, item); err != nil {
+		return path, err
+	}
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Info("Restored item whose deletion was pending.")
+	return path, nil
+}
+
+// Undo restores the items deleted at or below path that haven't been deleted on
+// the server yet (see the deleteDelay setting). Returns how many were restored.
+func (fs *FuseFs) Undo(path string) (int, error) {
+	return fs.deletes.undo(leadingSlash(path))
+}
@@ -0,0 +1,45 @@
+package graph
+
+import (
+	"testing"
+	"time"
+
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// deleting a folder should merge the pending deletions of its contents into a
+// single one for the folder
+func TestDeleteQueueMerge(t *testing.T) {
+	q := newDeleteQueue(&FuseFs{items: &Cache{}})
+	q.setDelay(time.Hour)
+	item := func(id string, folder bool) *DriveItem {
+		item := &DriveItem{IDInternal: id, mutex: &mu.RWMutex{}}
+		if folder {
+			item.Folder = &Folder{}
+		}
+		return item
+	}
+	q.add(item("a", false), "/Docs/Sub/a.txt")
+	q.add(item("sub", true), "/Docs/Sub")
+	q.add(item("b", false), "/Docs/b.txt")
+	q.add(item("other", false), "/Other/c.txt")
+	q.add(item("docs", true), "/docs")
+	defer func() {
+		for _, p := range q.pending {
+			p.timer.Stop()
+		}
+	}()
+
+	if len(q.pending) != 2 {
+		t.Fatalf("Expected 2 pending deletions, got %d\n", len(q.pending))
+	}
+	if q.pending[0].path != "/Other/c.txt" {
+		t.Error("Deletion outside the folder was merged into it.")
+	}
+	if docs := q.pending[1]; docs.id != "docs" || docs.count() != 4 {
+		t.Errorf("Folder deletion covered %d items, expected 4\n", docs.count())
+	}
+	if _, deleting := q.fs.items.deleting.Load("a"); !deleting {
+		t.Error("Merged item was not hidden from listings.")
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -58,11 +59,18 @@ func (d *DriveItem) createUploadSession(auth *Auth) (*UploadSession, error) {
 		},
 	})
 
-	//TODO yikes, there has to be a way to upload by ID here... cmon microsoft.
-	// (unless we can upload by id, an upload that gets mv-ed before it's
-	// finished will do weird things locally)
-	resp, err := Post(ResourcePath(d.Path())+":/createUploadSession",
-		auth, bytes.NewReader(sessionResp))
+	// Go by ID wherever possible, paths on the server don't match local paths if
+	// a folder other than the drive's root is mounted.
+	d.mutex.RLock()
+	id, name := d.IDInternal, d.NameInternal
+	d.mutex.RUnlock()
+	parentID := d.parentTarget()
+	resource := "/me/drive/items/" + id + "/createUploadSession"
+	if isLocalID(id) {
+		resource = fmt.Sprintf("/me/drive/items/%s:/%s:/createUploadSession",
+			parentID, url.PathEscape(name))
+	}
+	resp, err := Post(resource, auth, bytes.NewReader(sessionResp))
 	if err != nil {
 		return nil, err
 	}
@@ -73,7 +81,9 @@ func (d *DriveItem) createUploadSession(auth *Auth) (*UploadSession, error) {
 		return nil, err
 	}
 	snapshot := make([]byte, session.Size)
-	copy(snapshot, *d.data)
+	d.mutex.RLock()
+	d.copyContentLocked(snapshot)
+	d.mutex.RUnlock()
 	session.data = &snapshot
 	d.mutex.Lock()
 	d.uploadSession = &session
@@ -114,9 +124,11 @@ func (u UploadSession) uploadChunk(auth *Auth, offset uint64) ([]byte, int, erro
 
 	auth.Refresh()
 
-	client := &http.Client{}
-	request, _ := http.NewRequest("PUT",
-		u.UploadURL, bytes.NewReader((*u.data)[offset:end]))
+	client := &http.Client{Transport: graphTransport}
+	chunk := (*u.data)[offset:end]
+	request, _ := http.NewRequest("PUT", u.UploadURL,
+		throttledReader{bytes.NewReader(chunk), uploadLimiter})
+	request.ContentLength = int64(len(chunk))
 	// no Authorization header - it will throw a 401 if present
 	request.Header.Add("Content-Length", strconv.Itoa(int(reqChunkSize)))
 	frags := fmt.Sprintf("bytes %d-%d/%d", offset, end-1, u.Size)
@@ -134,49 +146,157 @@ func (u UploadSession) uploadChunk(auth *Auth, offset uint64) ([]byte, int, erro
 	return response, resp.StatusCode, nil
 }
 
-// Upload copies the file's contents to the server. Should only be called as a
-// goroutine, or it can potentially block for a very long time.
+// Upload copies the file's contents to the server, unless hashing them shows the
+// server already has the same content. Should only be called as a goroutine, or
+// it can potentially block for a very long time.
 func (d *DriveItem) Upload(auth *Auth) error {
-	log.WithFields(log.Fields{
-		"path": d.Path(),
-	}).Info("Uploading item")
+	d.mutex.RLock()
+	cache := d.cache
+	d.mutex.RUnlock()
+	if cache != nil && cache.localOnly("PUT", d.Path(), false, log.Fields{"size": d.Size()}) {
+		// keep the item marked as changed, it never made it to the server
+		d.mutex.Lock()
+		d.hasChanges = true
+		d.uploading = false
+		d.mutex.Unlock()
+		d.notifyStatus()
+		return nil
+	}
+	if d.dropDiscarded(auth) {
+		d.mutex.Lock()
+		d.uploading = false
+		d.mutex.Unlock()
+		return nil
+	}
+	if d.unchangedOnServer() {
+		d.mutex.Lock()
+		d.uploadFailed = false
+		d.uploading = false
+		if d.writers == 0 {
+			// changed again while it was being hashed
+			d.uploadLocked()
+		}
+		d.mutex.Unlock()
+		d.notifyStatus()
+		return nil
+	}
 
-	if d.Size() <= 4*1024*1024 { // 4MB
-		// size is small enough that we can use a single PUT request
-		id, err := d.RemoteID(auth)
-		if err != nil || isLocalID(id) {
-			d.mutex.Lock()
-			d.hasChanges = true
-			d.mutex.Unlock()
-			log.WithFields(log.Fields{
-				"err": err,
-				"path": d.Path(),
-			}).Errorf("Could not obtain remote ID for upload.")
-			return err
+	d.mutex.Lock()
+	d.uploading = true
+	id := d.IDInternal
+	d.mutex.Unlock()
+	d.notifyStatus()
+	created := func() {}
+	if cache != nil {
+		// whatever needs the ID of a new item waits for it to be uploaded
+		created = cache.ids.startCreating(id)
+	}
+
+	// folders made locally may not have made it to the server yet
+	err := cache.ensureParent(d, auth)
+	if err == nil {
+		err = d.upload(d.driveAuth(auth))
+	}
+
+	d.mutex.Lock()
+	d.uploading = false
+	d.uploadFailed = err != nil
+	changed := d.hasChanges
+	if err == nil && d.writers == 0 {
+		// changed again while it was being uploaded, see uploadLocked()
+		d.uploadLocked()
+	}
+	d.mutex.Unlock()
+	created()
+	if d.dropDiscarded(auth) {
+		// saved over another file while it was being uploaded
+		return nil
+	}
+	if err != nil {
+		d.notifyError("upload", err)
+	} else if cache != nil && cache.backupMode() {
+		if !changed {
+			// otherwise the next upload drops it
+			cache.dropUploaded(d)
 		}
+	} else if cache != nil {
+		// keep a copy on disk now that the content is known to be on the server
+		d.mutex.RLock()
+		id := d.IDInternal
+		var snapshot *[]byte
+		if d.data != nil {
+			snapshot = getBuffer(len(*d.data))
+			d.copyContentLocked(*snapshot)
+		}
+		d.mutex.RUnlock()
+		if snapshot != nil {
+			cache.cacheContent(d, id, *snapshot)
+			putBuffer(snapshot)
+		}
+	}
+	d.notifyStatus()
+	return err
+}
 
-		// creating a snapshot prevents lock contention during the actual http
-		// upload
+// uploadSimple uploads a file small enough for a single PUT request
+func (d *DriveItem) uploadSimple(auth *Auth) error {
+	// not RemoteID(), which would wait for this very upload
+	d.mutex.RLock()
+	cache := d.cache
+	d.mutex.RUnlock()
+	id, err := cache.createRemote(d, auth)
+	if err != nil || isLocalID(id) {
+		d.mutex.Lock()
+		d.hasChanges = true
+		d.mutex.Unlock()
 		log.WithFields(log.Fields{
+			"err": err,
 			"path": d.Path(),
-			"size": d.Size(),
-		}).Trace("Using simple upload strategy (size below upload session threshold).")
-		snapshot := make([]byte, d.Size()) // d.Size() will acquire a lock
-		d.mutex.RLock()
-		copy(snapshot, *d.data)
-		d.mutex.RUnlock()
+		}).Errorf("Could not obtain remote ID for upload.")
+		return err
+	}
 
-		resp, err := Put("/me/drive/items/"+id+"/content", auth,
-			bytes.NewReader(snapshot))
+	// creating a snapshot prevents lock contention during the actual http
+	// upload
+	log.WithFields(log.Fields{
+		"path": d.Path(),
+		"size": d.Size(),
+	}).Trace("Using simple upload strategy (size below upload session threshold).")
+	snapshot := getBuffer(int(d.Size())) // d.Size() will acquire a lock
+	d.mutex.RLock()
+	d.copyContentLocked(*snapshot)
+	d.mutex.RUnlock()
 
-		d.mutex.Lock()
-		defer d.mutex.Unlock()
-		if err != nil {
-			d.hasChanges = true
-			return err
+	resp, err := Put("/me/drive/items/"+id+"/content", auth,
+		bytes.NewReader(*snapshot))
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err != nil {
+		// net/http may still be reading the request body after a failed
+		// request, so the snapshot can't be reused
+		d.hasChanges = true
+		return err
+	}
+	putBuffer(snapshot)
+	// Unmarshal into existing item so we don't have to redownload file contents.
+	return d.unmarshalLocked(resp)
+}
+
+// upload does the actual work for Upload()
+func (d *DriveItem) upload(auth *Auth) error {
+	log.WithFields(log.Fields{
+		"path": d.Path(),
+	}).Info("Uploading item")
+
+	if size := d.Size(); size <= 4*1024*1024 { // 4MB
+		d.mutex.RLock()
+		cache := d.cache
+		d.mutex.RUnlock()
+		if size <= batchFileSize && cache != nil && cache.batch != nil {
+			return d.uploadBatched(cache.batch, auth)
 		}
-		// Unmarshal into existing item so we don't have to redownload file contents.
-		return json.Unmarshal(resp, d)
+		return d.uploadSimple(auth)
 	}
 
 	log.WithFields(log.Fields{
@@ -194,6 +314,7 @@ func (d *DriveItem) Upload(auth *Auth) error {
 	}
 
 	nchunks := int(math.Ceil(float64(session.Size) / float64(chunkSize)))
+	var last []byte
 	for i := 0; i < nchunks; i++ {
 		resp, status, err := session.uploadChunk(auth, uint64(i)*chunkSize)
 		if err != nil {
@@ -214,7 +335,7 @@ func (d *DriveItem) Upload(auth *Auth) error {
 				"path": d.Path(),
 				"chunk": i,
 				"nchunks": nchunks,
-			}).Error("The OneDrive server is having issues, "+
+			}).Errorf("The OneDrive server is having issues, "+
 				"retrying upload in %ds.", backoff)
 			resp, status, err = session.uploadChunk(auth, uint64(i)*chunkSize)
 			if err != nil {
@@ -248,6 +369,26 @@ func (d *DriveItem) Upload(auth *Auth) error {
 			d.hasChanges = true
 			return errors.New(string(resp))
 		}
+
+		uploaded := uint64(i+1) * chunkSize
+		if uploaded > session.Size {
+			uploaded = session.Size
+		}
+		d.notifyProgress(uploaded, session.Size)
+		last = resp
+	}
+
+	if id := d.ID(); isLocalID(id) {
+		// the response to the last chunk is the item that was created
+		d.mutex.RLock()
+		cache := d.cache
+		d.mutex.RUnlock()
+		if _, err := cache.adopt(d, id, last); err != nil {
+			log.WithFields(log.Fields{
+				"path": d.Path(),
+				"err": err,
+			}).Warn("Could not get the ID of uploaded item.")
+		}
 	}
 
 	log.WithFields(log.Fields{
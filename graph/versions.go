@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	mu "github.com/sasha-s/go-deadlock"
+	log "github.com/sirupsen/logrus"
+)
+
+const versionsDir = "/.versions"
+
+// how long a list of versions is reused before being fetched again
+const versionsTTL = 30 * time.Second
+
+// DriveItemVersion is a previous version of a file
+// https://docs.microsoft.com/en-us/graph/api/resources/driveitemversion
+type DriveItemVersion struct {
+	ID           string    `json:"id"`
+	LastModified time.Time `json:"lastModifiedDateTime"`
+	Size         uint64    `json:"size"`
+}
+
+// GetVersions fetches the versions of a file from the server, newest first. The
+// first version is the current one.
+func GetVersions(id string, auth *Auth) ([]DriveItemVersion, error) {
+	body, err := Get("/me/drive/items/"+id+"/versions", auth)
+	if err != nil {
+		return nil, err
+	}
+	var versions struct {
+		Values []DriveItemVersion `json:"value"`
+	}
+	err = json.Unmarshal(body, &versions)
+	return versions.Values, err
+}
+
+// versionName is the name a version shows up under, for example
+// "2020-03-01 10.22.33 (2.0) notes.txt"
+func versionName(name string, version DriveItemVersion) string {
+	return fmt.Sprintf("%s (%s) %s",
+		version.LastModified.Local().Format("2006-01-02 15.04.05"), version.ID, name)
+}
+
+// versionList is a cached list of a file's versions
+type versionList struct {
+	versions []DriveItemVersion
+	fetched  time.Time
+}
+
+// versionsDirectory is the virtual directory at /.versions. It mirrors the regular
+// filesystem, except that every file is a directory holding its previous
+// versions as read-only files. For example, the versions of /Documents/notes.txt
+// are in /.versions/Documents/notes.txt/.
+type versionsDirectory struct {
+	fs    *FuseFs
+	mutex *mu.Mutex
+	lists map[string]versionList // by item ID
+}
+
+func newVersionsDir(fs *FuseFs) *versionsDirectory {
+	return &versionsDirectory{
+		fs:    fs,
+		mutex: &mu.Mutex{},
+		lists: make(map[string]versionList),
+	}
+}
+
+// versions returns the versions of a file, fetching them if they are not cached
+func (v *versionsDirectory) versions(item *DriveItem) ([]DriveItemVersion, error) {
+	id := item.ID()
+	if isLocalID(id) {
+		return []DriveItemVersion{}, nil
+	}
+	v.mutex.Lock()
+	list, exists := v.lists[id]
+	v.mutex.Unlock()
+	if exists && time.Since(list.fetched) < versionsTTL {
+		return list.versions, nil
+	}
+
+	versions, err := GetVersions(id, v.fs.Auth)
+	if err != nil {
+		return nil, err
+	}
+	v.mutex.Lock()
+	v.lists[id] = versionList{versions: versions, fetched: time.Now()}
+	v.mutex.Unlock()
+	return versions, nil
+}
+
+// forget drops the cached list of versions of an item, so that it gets fetched
+// again next time
+func (v *versionsDirectory) forget(id string) {
+	v.mutex.Lock()
+	delete(v.lists, id)
+	v.mutex.Unlock()
+}
+
+// resolve finds what a path in the versions directory refers to: either a
+// regular item (version is nil), or a version of the file it's in.
+func (v *versionsDirectory) resolve(path string) (*DriveItem, *DriveItemVersion, fuse.Status) {
+	if item, err := v.fs.items.Get(path, v.fs.Auth); err == nil {
+		return item, nil, fuse.OK
+	}
+	item, err := v.fs.items.Get(filepath.Dir(path), v.fs.Auth)
+	if err != nil || item.IsDir() {
+		return nil, nil, fuse.ENOENT
+	}
+	versions, err := v.versions(item)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": item.Path(),
+			"err":  err,
+		}).Error("Could not fetch versions of file.")
+		return nil, nil, fuse.EREMOTEIO
+	}
+	name := filepath.Base(path)
+	for i, version := range versions {
+		if strings.EqualFold(versionName(item.Name(), version), name) {
+			return item, &versions[i], fuse.OK
+		}
+	}
+	return nil, nil, fuse.ENOENT
+}
+
+func (v *versionsDirectory) GetAttr(path string) (*fuse.Attr, fuse.Status) {
+	item, version, status := v.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if version == nil {
+		// regular files and folders are both shown as folders
+		return virtualAttr(true, 0, item.ModTime()), fuse.OK
+	}
+	return virtualAttr(false, version.Size, uint64(version.LastModified.Unix())), fuse.OK
+}
+
+func (v *versionsDirectory) OpenDir(path string) ([]fuse.DirEntry, fuse.Status) {
+	item, version, status := v.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if version != nil {
+		return nil, fuse.ENOTDIR
+	}
+
+	entries := make([]fuse.DirEntry, 0)
+	if item.IsDir() {
+		children, err := v.fs.items.GetChildrenPath(path, v.fs.Auth)
+		if err != nil {
+			return nil, fuse.EREMOTEIO
+		}
+		for _, child := range children {
+			entries = append(entries, fuse.DirEntry{
+				Name: child.Name(),
+				Mode: fuse.S_IFDIR | 0555,
+			})
+		}
+		return entries, fuse.OK
+	}
+
+	versions, err := v.versions(item)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": item.Path(),
+			"err":  err,
+		}).Error("Could not fetch versions of file.")
+		return nil, fuse.EREMOTEIO
+	}
+	for _, version := range versions {
+		entries = append(entries, fuse.DirEntry{
+			Name: versionName(item.Name(), version),
+			Mode: fuse.S_IFREG | 0444,
+		})
+	}
+	return entries, fuse.OK
+}
+
+// Open downloads a previous version of a file
+func (v *versionsDirectory) Open(path string, flags uint32) (nodefs.File, fuse.Status) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, fuse.EPERM
+	}
+	item, version, status := v.resolve(path)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if version == nil {
+		return nil, fuse.EISDIR
+	}
+	content, err := Get(fmt.Sprintf("/me/drive/items/%s/versions/%s/content",
+		item.ID(), version.ID), v.fs.Auth)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":    item.Path(),
+			"version": version.ID,
+			"err":     err,
+		}).Error("Could not fetch content of file version.")
+		return nil, fuse.EREMOTEIO
+	}
+	return nodefs.NewReadOnlyFile(nodefs.NewDataFile(content)), fuse.OK
+}
+
+// RestoreVersion makes a previous version of the file at path its current
+// version again. The version being replaced is kept as a version of its own.
+func (fs *FuseFs) RestoreVersion(path string, versionID string) error {
+	item, err := fs.items.Get(path, fs.Auth)
+	if err != nil {
+		return err
+	}
+	if item.IsDir() {
+		return errors.New(path + " is a directory, only files have versions")
+	}
+	id := item.ID()
+	if isLocalID(id) {
+		return errors.New(path + " has not been uploaded yet and has no versions")
+	}
+	item.mutex.RLock()
+	changed := item.hasChanges || item.uploadSession != nil
+	item.mutex.RUnlock()
+	if changed {
+		return errors.New(path + " has local changes that have not been uploaded yet")
+	}
+	if fs.items.skipMutation("POST", path, log.Fields{"version": versionID}) {
+		return nil
+	}
+
+	_, err = Post(fmt.Sprintf("/me/drive/items/%s/versions/%s/restoreVersion",
+		id, url.PathEscape(versionID)), fs.Auth, strings.NewReader(""))
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"path":    path,
+		"version": versionID,
+	}).Info("Restored previous version of file.")
+	fs.versions.forget(id)
+	// pick up the restored content's size, modification time and hashes
+	return fs.items.Refresh(path, fs.Auth)
+}
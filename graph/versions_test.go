@@ -0,0 +1,47 @@
+package graph
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// version names should sort in chronological order and keep the file's name
+func TestVersionName(t *testing.T) {
+	old := DriveItemVersion{ID: "1.0", LastModified: time.Date(2020, 3, 1, 10, 22, 33, 0, time.Local)}
+	recent := DriveItemVersion{ID: "2.0", LastModified: time.Date(2020, 11, 2, 8, 0, 0, 0, time.Local)}
+	if name := versionName("notes.txt", old); name != "2020-03-01 10.22.33 (1.0) notes.txt" {
+		t.Fatal("Wrong version name:", name)
+	}
+	if versionName("notes.txt", old) >= versionName("notes.txt", recent) {
+		t.Fatal("Version names did not sort in chronological order.")
+	}
+}
+
+// overwriting a file should leave its previous content readable in .versions
+func TestVersionsDir(t *testing.T) {
+	fname := filepath.Join(TestDir, "versioned.txt")
+	failOnErr(t, ioutil.WriteFile(fname, []byte("first version"), 0644))
+	time.Sleep(5 * time.Second) // wait for upload
+	failOnErr(t, ioutil.WriteFile(fname, []byte("second version"), 0644))
+	time.Sleep(5 * time.Second)
+
+	dir := filepath.Join(mountLoc, versionsDir, "onedriver_tests", "versioned.txt")
+	versions, err := ioutil.ReadDir(dir)
+	failOnErr(t, err)
+	if len(versions) < 2 {
+		t.Fatalf("Expected at least 2 versions of file, got %d.\n", len(versions))
+	}
+	var found bool
+	for _, version := range versions {
+		content, err := ioutil.ReadFile(filepath.Join(dir, version.Name()))
+		failOnErr(t, err)
+		if string(content) == "first version" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Previous content of file was not among its versions.")
+	}
+}
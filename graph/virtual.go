@@ -0,0 +1,161 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+)
+
+// virtualDir is a hidden directory at the root of the filesystem whose contents
+// don't exist as regular items on the server, like the recycle bin. Virtual
+// directories are not listed in the root directory, but can be accessed by name.
+// Paths passed to a virtualDir are relative to the virtual directory itself, so
+// "/" is the virtual directory. Anything not supported by a virtualDir is
+// rejected with EPERM.
+type virtualDir interface {
+	GetAttr(path string) (*fuse.Attr, fuse.Status)
+	OpenDir(path string) ([]fuse.DirEntry, fuse.Status)
+	Open(path string, flags uint32) (nodefs.File, fuse.Status)
+}
+
+// virtualMover is implemented by virtual directories that items can be moved
+// out of into the regular filesystem
+type virtualMover interface {
+	MoveOut(path string, dest string) fuse.Status
+}
+
+// virtualLinker is implemented by virtual directories containing symlinks
+type virtualLinker interface {
+	Readlink(path string) (string, fuse.Status)
+}
+
+// virtualPath returns the virtual directory containing path and the path
+// relative to it, or nil if path is not inside a virtual directory
+func (fs *FuseFs) virtualPath(path string) (virtualDir, string) {
+	for prefix, dir := range fs.virtual {
+		if path == prefix {
+			return dir, "/"
+		}
+		if strings.HasPrefix(path, prefix+"/") {
+			return dir, path[len(prefix):]
+		}
+	}
+	return nil, ""
+}
+
+// virtualAttr returns the attributes of a read-only virtual file or directory
+func virtualAttr(dir bool, size uint64, mtime uint64) *fuse.Attr {
+	attr := &fuse.Attr{
+		Size:  size,
+		Nlink: 1,
+		Atime: mtime,
+		Mtime: mtime,
+		Ctime: mtime,
+		Mode:  fuse.S_IFREG | 0444,
+		Owner: fuse.Owner{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+		},
+	}
+	if dir {
+		attr.Size = 4096
+		attr.Nlink = 2
+		attr.Mode = fuse.S_IFDIR | 0555
+	}
+	return attr
+}
+
+// virtualLink is a symlink in a virtual directory pointing at a regular item
+type virtualLink struct {
+	name    string // the name the link is listed under
+	path    string // the item's path, relative to the root of the filesystem
+	modTime uint64 // the item's modification time
+}
+
+// virtualLinks makes links to items, by lowercased name. Items outside of the
+// mounted folder are left out, and items with the same name get a number
+// appended.
+func (fs *FuseFs) virtualLinks(items []*DriveItem) map[string]virtualLink {
+	links := make(map[string]virtualLink)
+	for _, item := range items {
+		path, ok := fs.mountedPath(item)
+		if !ok {
+			continue
+		}
+		name := uniqueName(item.Name(), func(name string) bool {
+			_, taken := links[strings.ToLower(name)]
+			return taken
+		})
+		links[strings.ToLower(name)] = virtualLink{
+			name:    name,
+			path:    path,
+			modTime: item.ModTime(),
+		}
+	}
+	return links
+}
+
+// mountedPath returns the path of an item fetched without the cache relative to
+// the root of the filesystem, or false if it's outside the mounted folder (or
+// hidden by selective sync)
+func (fs *FuseFs) mountedPath(item *DriveItem) (string, bool) {
+	path, ok := fs.serverPathToMounted(item.Path())
+	if !ok || fs.items.excluded(path) {
+		return "", false
+	}
+	return path, true
+}
+
+// serverPathToMounted turns a path on the server into one relative to the root
+// of the filesystem
+func (fs *FuseFs) serverPathToMounted(path string) (string, bool) {
+	prefix := fs.items.prefix
+	if prefix == "" {
+		return path, true
+	}
+	if path == prefix {
+		return "/", true
+	}
+	if !strings.HasPrefix(path, prefix+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(path, prefix), true
+}
+
+// target returns what the link should contain when it's at linkPath. Links are
+// relative, so that they work wherever the filesystem is mounted.
+func (l virtualLink) target(linkPath string) string {
+	rel, _ := filepath.Rel(filepath.Dir(linkPath), l.path)
+	return rel
+}
+
+// attr returns the attributes of the link when it's at linkPath
+func (l virtualLink) attr(linkPath string) *fuse.Attr {
+	attr := virtualAttr(false, uint64(len(l.target(linkPath))), l.modTime)
+	attr.Mode = fuse.S_IFLNK | 0777
+	return attr
+}
+
+// linkEntries lists links as directory entries
+func linkEntries(links map[string]virtualLink) []fuse.DirEntry {
+	entries := make([]fuse.DirEntry, 0, len(links))
+	for _, link := range links {
+		entries = append(entries, fuse.DirEntry{Name: link.name, Mode: fuse.S_IFLNK | 0777})
+	}
+	return entries
+}
+
+// uniqueName makes a name unique among the names already taken by appending a
+// number to it, like "notes (2).txt"
+func uniqueName(name string, taken func(string) bool) string {
+	ext := filepath.Ext(name)
+	unique := name
+	for i := 2; taken(unique); i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	return unique
+}
@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// watchdogInterval is how often the memory watchdog checks onedriver's memory use
+const watchdogInterval = 5 * time.Second
+
+// errLowMemory is returned when fetching content while memory use is over the
+// limit
+var errLowMemory = errors.New("onedriver is over its memory limit, " +
+	"not fetching more content until it frees up")
+
+// memoryWatchdog keeps onedriver's memory use in check, so the OOM killer doesn't
+// take the mount down. Once the process' resident memory grows past the limit,
+// the content of the largest files held in memory is dropped (the disk cache
+// keeps its copy), and fetching new content is refused until memory use is
+// back under the limit. Files that are open or have changes that aren't
+// uploaded yet always stay in memory.
+type memoryWatchdog struct {
+	cache   *Cache
+	limit   uint64 // bytes of resident memory, 0 if there is no limit
+	over    int32  // 1 while memory use is over the limit
+	started int32
+}
+
+func newMemoryWatchdog(cache *Cache) *memoryWatchdog {
+	return &memoryWatchdog{cache: cache}
+}
+
+// SetMemoryLimit sets how much memory onedriver may use before it starts dropping
+// file contents from memory. 0 removes the limit.
+func (c *Cache) SetMemoryLimit(limit uint64) {
+	w := c.watchdog
+	atomic.StoreUint64(&w.limit, limit)
+	if limit == 0 {
+		atomic.StoreInt32(&w.over, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&w.started, 0, 1) {
+		go w.watch()
+	}
+}
+
+// lowMemory returns whether memory use is over the limit
+func (w *memoryWatchdog) lowMemory() bool {
+	return w != nil && atomic.LoadInt32(&w.over) == 1
+}
+
+// residentMemory returns how many bytes of memory the process has resident
+func residentMemory() (uint64, error) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0, errors.New("unexpected format of /proc/self/statm")
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	return pages * uint64(os.Getpagesize()), err
+}
+
+func (w *memoryWatchdog) watch() {
+	for range time.Tick(watchdogInterval) {
+		w.check()
+	}
+}
+
+// check drops content from memory if memory use is over the limit
+func (w *memoryWatchdog) check() {
+	limit := atomic.LoadUint64(&w.limit)
+	if limit == 0 {
+		return
+	}
+	rss, err := residentMemory()
+	if err != nil {
+		log.WithField("err", err).Error("Could not check memory use.")
+		return
+	}
+	if rss <= limit {
+		if atomic.CompareAndSwapInt32(&w.over, 1, 0) {
+			log.WithField("rss", rss).Info("Memory use is back under the limit.")
+		}
+		return
+	}
+
+	// aim a bit below the limit, or this happens again right away
+	target := rss - limit + limit/10
+	dropped, count := w.cache.dropLargest(target)
+	debug.FreeOSMemory()
+	rss, err = residentMemory()
+	over := err == nil && rss > limit
+	if over {
+		atomic.StoreInt32(&w.over, 1)
+	} else {
+		atomic.StoreInt32(&w.over, 0)
+	}
+	log.WithFields(log.Fields{
+		"limit":     limit,
+		"rss":       rss,
+		"files":     count,
+		"dropped":   dropped,
+		"stillOver": over,
+	}).Warn("Memory use went over the limit, dropped file contents from memory.")
+}
+
+// dropLargest drops the content of the largest files in memory, until at least
+// size bytes were dropped. Returns how many bytes of how many files were dropped.
+func (c *Cache) dropLargest(size uint64) (uint64, int) {
+	type inMemory struct {
+		item *DriveItem
+		size int
+	}
+	var candidates []inMemory
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		item.mutex.RLock()
+		if item.data != nil && len(*item.data) > 0 {
+			candidates = append(candidates, inMemory{item, len(*item.data)})
+		}
+		item.mutex.RUnlock()
+		return true
+	})
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].size > candidates[j].size
+	})
+
+	var dropped uint64
+	count := 0
+	for _, candidate := range candidates {
+		if dropped >= size {
+			break
+		}
+		if c.dropContent(candidate.item) {
+			candidate.item.notifyStatus()
+			dropped += uint64(candidate.size)
+			count++
+		}
+	}
+	return dropped, count
+}
@@ -0,0 +1,42 @@
+package graph
+
+import "testing"
+
+func TestResidentMemory(t *testing.T) {
+	rss, err := residentMemory()
+	failOnErr(t, err)
+	if rss == 0 {
+		t.Fatal("Resident memory can't be 0.")
+	}
+}
+
+// the largest files go first, files that are open or changed always stay
+func TestDropLargest(t *testing.T) {
+	cache := &Cache{}
+	sizes := map[string]int{"small": 10, "medium": 100, "large": 1000, "open": 5000}
+	items := make(map[string]*DriveItem)
+	for name, size := range sizes {
+		item := NewDriveItem(name, 0644, nil)
+		item.IDInternal = name
+		data := make([]byte, size)
+		item.data = &data
+		cache.metadata.Store(name, item)
+		items[name] = item
+	}
+	items["open"].opened()
+
+	dropped, count := cache.dropLargest(500)
+	if dropped != 1000 || count != 1 {
+		t.Fatalf("Dropped %d bytes of %d files, wanted just the large one.\n",
+			dropped, count)
+	}
+	if items["large"].data != nil || items["open"].data == nil {
+		t.Fatal("Dropped the wrong files.")
+	}
+
+	dropped, count = cache.dropLargest(10000)
+	if dropped != 110 || count != 2 {
+		t.Fatalf("Dropped %d bytes of %d files, wanted every file not open.\n",
+			dropped, count)
+	}
+}
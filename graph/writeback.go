@@ -0,0 +1,89 @@
+package graph
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Files are uploaded as soon as the last handle that can write to them is
+// closed, unless an upload delay is set. Then the upload waits until the file
+// has gone unchanged for that long, so a file that is saved over and over is
+// uploaded once, not after every save. Changes made in the meantime only live
+// locally, so a longer delay means less upload traffic but more to lose if the
+// machine dies. FlushUploads() doesn't wait for the delay.
+
+// SetUploadDelay changes how long uploads wait after the last change to a file.
+// Uploads that are already waiting keep their delay.
+func (c *Cache) SetUploadDelay(delay time.Duration) {
+	atomic.StoreInt64(&c.uploadDelay, int64(delay))
+}
+
+// scheduleUploadLocked delays an item's upload, if there is an upload delay.
+// Returns false if it should be uploaded right away.
+func (d *DriveItem) scheduleUploadLocked() bool {
+	delay := time.Duration(atomic.LoadInt64(&d.cache.uploadDelay))
+	if delay <= 0 {
+		return false
+	}
+	// every change starts the wait over
+	if d.uploadTimer != nil {
+		d.uploadTimer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		d.delayedUpload(timer)
+	})
+	d.uploadTimer = timer
+	return true
+}
+
+// delayedUpload starts an upload once its delay is over, unless the file was
+// changed (or deleted) since, or uploads are on hold
+func (d *DriveItem) delayedUpload(timer *time.Timer) {
+	d.mutex.RLock()
+	cache, id := d.cache, d.IDInternal
+	d.mutex.RUnlock()
+	if cache.GetID(id) != d {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.uploadTimer != timer {
+		return
+	}
+	d.uploadTimer = nil
+	// the same checks as uploadLocked(), without waiting all over again. Items
+	// open for writing get uploaded when they are closed, paused ones on resume,
+	// and ones being uploaded once the running upload is done.
+	if d.writers == 0 && d.hasChanges && !d.uploading && !cache.Paused() && !cache.DryRun() {
+		d.uploadNowLocked()
+	}
+}
+
+// FlushUploads starts uploading every changed file at or below path right away,
+// instead of once their upload delay is over. Returns the number of uploads
+// started.
+func (c *Cache) FlushUploads(path string) (int, error) {
+	if c.Paused() {
+		return 0, errors.New("uploads are paused")
+	}
+	if c.DryRun() {
+		return 0, nil
+	}
+	started := 0
+	c.metadata.Range(func(key interface{}, value interface{}) bool {
+		item := value.(*DriveItem)
+		if item.IsDir() || !pathWithin(item.Path(), path) {
+			return true
+		}
+		item.mutex.Lock()
+		if item.hasChanges && item.writers == 0 && !item.uploading {
+			item.uploadNowLocked()
+			started++
+		}
+		item.mutex.Unlock()
+		return true
+	})
+	return started, nil
+}
@@ -0,0 +1,84 @@
+package graph
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+)
+
+// writeFile writes content to a new file at path and closes it
+func writeFile(t *testing.T, fs *FuseFs, path string, content string) *DriveItem {
+	file, status := fs.Create(path, syscall.O_WRONLY|syscall.O_CREAT, 0644, nil)
+	if status != fuse.OK {
+		t.Fatal("Could not create file:", status)
+	}
+	file.Write([]byte(content), 0)
+	file.Release()
+	item, err := fs.items.Get(path, nil)
+	failOnErr(t, err)
+	return item
+}
+
+// waitUploaded waits for an item to make it to the server
+func waitUploaded(t *testing.T, item *DriveItem, timeout time.Duration) {
+	for start := time.Now(); isLocalID(item.ID()); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > timeout {
+			t.Fatal("File was not uploaded.")
+		}
+	}
+}
+
+// uploads should wait for the upload delay, unless they're flushed
+func TestUploadDelay(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+
+	fs.items.SetUploadDelay(200 * time.Millisecond)
+	item := writeFile(t, fs, "/Documents/delayed.txt", "delayed")
+	time.Sleep(100 * time.Millisecond)
+	if !isLocalID(item.ID()) {
+		t.Fatal("File was uploaded before its upload delay was over.")
+	}
+	waitUploaded(t, item, 5*time.Second)
+
+	fs.items.SetUploadDelay(time.Hour)
+	item = writeFile(t, fs, "/Documents/flushed.txt", "flushed")
+	time.Sleep(100 * time.Millisecond)
+	if !isLocalID(item.ID()) {
+		t.Fatal("File was uploaded before its upload delay was over.")
+	}
+	count, err := fs.items.FlushUploads("/")
+	failOnErr(t, err)
+	if count != 1 {
+		t.Fatalf("Expected 1 upload to be started, got %d.\n", count)
+	}
+	waitUploaded(t, item, 5*time.Second)
+	mock.mutex.Lock()
+	uploaded := mock.child(mock.child(mockRootID, "Documents").id, "flushed.txt")
+	mock.mutex.Unlock()
+	if uploaded == nil || string(uploaded.content) != "flushed" {
+		t.Error("Flushed file is not on the server.")
+	}
+}
+
+// delayed uploads shouldn't start while uploads are paused
+func TestUploadDelayPaused(t *testing.T) {
+	mock := newMockGraph()
+	defer mock.close()
+	fs := NewFSAt(mock.auth(), "/")
+	fs.GetAttr("/Documents", nil)
+
+	fs.items.SetUploadDelay(100 * time.Millisecond)
+	item := writeFile(t, fs, "/Documents/paused.txt", "paused")
+	fs.items.Pause()
+	time.Sleep(300 * time.Millisecond)
+	if !isLocalID(item.ID()) {
+		t.Fatal("Delayed upload started while uploads were paused.")
+	}
+	fs.items.Resume()
+	waitUploaded(t, item, 5*time.Second)
+}
@@ -0,0 +1,168 @@
+package graph
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	log "github.com/sirupsen/logrus"
+)
+
+// all of onedriver's extended attributes are in the user namespace, under this
+// prefix
+const xattrPrefix = "user.onedriver."
+
+// xattrs returns the extended attributes of an item, without xattrPrefix. Photo,
+// image and video metadata comes from the facets the server extracts from the
+// file, so it's available without downloading the file.
+func (d *DriveItem) xattrs() map[string]string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	attrs := map[string]string{"state": d.syncStatusLocked()}
+	if d.WebURLInternal != "" {
+		attrs["webUrl"] = d.WebURLInternal
+	}
+	if d.Folder != nil {
+		attrs["childCount"] = strconv.FormatUint(uint64(d.childCountLocked()), 10)
+	}
+	if d.Malware != nil {
+		attrs["malware"] = d.Malware.Description
+		if attrs["malware"] == "" {
+			attrs["malware"] = "detected"
+		}
+	}
+	if d.Photo != nil {
+		if d.Photo.TakenDateTime != nil {
+			attrs["photo.taken"] = d.Photo.TakenDateTime.UTC().Format(time.RFC3339)
+		}
+		if camera := strings.TrimSpace(d.Photo.CameraMake + " " + d.Photo.CameraModel); camera != "" {
+			attrs["photo.camera"] = camera
+		}
+	}
+	if d.Image != nil && d.Image.Width > 0 && d.Image.Height > 0 {
+		attrs["image.width"] = strconv.Itoa(d.Image.Width)
+		attrs["image.height"] = strconv.Itoa(d.Image.Height)
+	}
+	if d.Video != nil {
+		if d.Video.Width > 0 && d.Video.Height > 0 {
+			attrs["video.width"] = strconv.Itoa(d.Video.Width)
+			attrs["video.height"] = strconv.Itoa(d.Video.Height)
+		}
+		if d.Video.Duration > 0 {
+			// seconds, like most tools use
+			attrs["video.duration"] = strconv.FormatFloat(
+				float64(d.Video.Duration)/1000, 'f', 3, 64)
+		}
+	}
+	return attrs
+}
+
+// name of the xattr holding an item's sensitivity labels. Reading them takes a
+// request to the server, so unlike the others it is left out of ListXAttr.
+const labelsXAttr = "sensitivityLabels"
+
+// SensitivityLabel is a Microsoft Purview sensitivity label applied to a file
+// https://docs.microsoft.com/en-us/graph/api/resources/sensitivitylabelassignment
+type SensitivityLabel struct {
+	ID               string `json:"sensitivityLabelId"`
+	AssignmentMethod string `json:"assignmentMethod,omitempty"`
+	TenantID         string `json:"tenantId,omitempty"`
+}
+
+// GetSensitivityLabels fetches the sensitivity labels of a file. Only files in
+// work or school accounts can have labels.
+func GetSensitivityLabels(id string, auth *Auth) ([]SensitivityLabel, error) {
+	body, err := Post("/me/drive/items/"+id+"/extractSensitivityLabels", auth,
+		strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Labels []SensitivityLabel `json:"labels"`
+	}
+	err = json.Unmarshal(body, &result)
+	return result.Labels, err
+}
+
+// sensitivityLabels returns the IDs of an item's sensitivity labels, comma
+// separated
+func (fs *FuseFs) sensitivityLabels(item *DriveItem, op operation) ([]byte, fuse.Status) {
+	id := item.ID()
+	if item.IsDir() || isLocalID(id) {
+		return nil, fuse.ENOATTR
+	}
+	labels, err := GetSensitivityLabels(id, op.auth)
+	if err != nil {
+		op.WithFields(log.Fields{
+			"path": item.Path(),
+			"err":  err,
+		}).Debug("Could not fetch sensitivity labels.")
+		return nil, fuse.ENOATTR
+	}
+	if len(labels) == 0 {
+		return nil, fuse.ENOATTR
+	}
+	ids := make([]string, 0, len(labels))
+	for _, label := range labels {
+		ids = append(ids, label.ID)
+	}
+	return []byte(strings.Join(ids, ",")), fuse.OK
+}
+
+// GetXAttr returns one of an item's extended attributes
+func (fs *FuseFs) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	op := fs.newOp()
+	name = leadingSlash(name)
+	if dir, _ := fs.virtualPath(name); dir != nil || fs.ignore(name) {
+		return nil, fuse.ENOATTR
+	}
+	if !strings.HasPrefix(attribute, xattrPrefix) {
+		return nil, fuse.ENOATTR
+	}
+	item, err := fs.items.Get(name, op.auth)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	op.WithFields(log.Fields{
+		"path": name,
+		"attr": attribute,
+	}).Trace()
+	if attribute == xattrPrefix+labelsXAttr {
+		return fs.sensitivityLabels(item, op)
+	}
+	if attribute == xattrPrefix+completenessXAttr && item.IsDir() {
+		return []byte(fs.items.completeness(item)), fuse.OK
+	}
+	value, exists := item.xattrs()[strings.TrimPrefix(attribute, xattrPrefix)]
+	if !exists {
+		return nil, fuse.ENOATTR
+	}
+	return []byte(value), fuse.OK
+}
+
+// ListXAttr lists the extended attributes an item has
+func (fs *FuseFs) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	op := fs.newOp()
+	name = leadingSlash(name)
+	if dir, _ := fs.virtualPath(name); dir != nil || fs.ignore(name) {
+		return []string{}, fuse.OK
+	}
+	item, err := fs.items.Get(name, op.auth)
+	if err != nil {
+		return nil, fuse.ENOENT
+	}
+	attrs := item.xattrs()
+	names := make([]string, 0, len(attrs))
+	for attr := range attrs {
+		names = append(names, xattrPrefix+attr)
+	}
+	if item.IsDir() {
+		// worked out when read, see completeness()
+		names = append(names, xattrPrefix+completenessXAttr)
+	}
+	sort.Strings(names)
+	return names, fuse.OK
+}
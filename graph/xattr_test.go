@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	mu "github.com/sasha-s/go-deadlock"
+)
+
+// photo and video metadata from the server should show up as xattrs
+func TestMediaXAttrs(t *testing.T) {
+	item := &DriveItem{mutex: &mu.RWMutex{}}
+	failOnErr(t, json.Unmarshal([]byte(`{
+		"name": "IMG_0001.jpg",
+		"photo": {
+			"takenDateTime": "2019-07-04T12:30:00Z",
+			"cameraMake": "Canon",
+			"cameraModel": "EOS 80D"
+		},
+		"image": {"width": 6000, "height": 4000},
+		"video": {"duration": 61500}
+	}`), item))
+
+	attrs := item.xattrs()
+	expected := map[string]string{
+		"photo.taken":    "2019-07-04T12:30:00Z",
+		"photo.camera":   "Canon EOS 80D",
+		"image.width":    "6000",
+		"image.height":   "4000",
+		"video.duration": "61.500",
+		// no ID or file facet, so as far as onedriver knows it's a new folder
+		"state": StatusDirty,
+	}
+	for attr, value := range expected {
+		if attrs[attr] != value {
+			t.Errorf("Expected %s to be \"%s\", got \"%s\"\n", attr, value, attrs[attr])
+		}
+	}
+	if len(attrs) != len(expected) {
+		t.Errorf("Unexpected xattrs: %+v\n", attrs)
+	}
+}
+
+// files with malware in them should say so, and never be downloaded
+func TestMalware(t *testing.T) {
+	item := &DriveItem{mutex: &mu.RWMutex{}}
+	failOnErr(t, json.Unmarshal([]byte(`{
+		"id": "ABC!123",
+		"name": "invoice.pdf.exe",
+		"file": {},
+		"malware": {"description": "Trojan:Win32/Example"}
+	}`), item))
+
+	if value := item.xattrs()["malware"]; value != "Trojan:Win32/Example" {
+		t.Fatalf("Wrong malware xattr: \"%s\"\n", value)
+	}
+	if err := item.FetchContent(&Auth{}); err != errMalware {
+		t.Fatal("Content of file with malware was fetched:", err)
+	}
+}
+
+// Folders should report how many children they have without listing them.
+func TestChildCount(t *testing.T) {
+	item := &DriveItem{mutex: &mu.RWMutex{}}
+	failOnErr(t, json.Unmarshal([]byte(`{
+		"name": "Documents",
+		"folder": {"childCount": 12}
+	}`), item))
+
+	if count := item.ChildCount(); count != 12 {
+		t.Errorf("Expected 12 children, got %d\n", count)
+	}
+	if attr := item.xattrs()["childCount"]; attr != "12" {
+		t.Errorf("Expected childCount xattr to be \"12\", got \"%s\"\n", attr)
+	}
+	if nlink := item.NLink(); nlink != 14 {
+		t.Errorf("Link count of an unfetched folder should allow for 12 subfolders, got %d\n", nlink)
+	}
+
+	// once fetched, the cache knows better
+	item.children = []string{"a", "b"}
+	item.subdir = 1
+	if count := item.ChildCount(); count != 2 {
+		t.Errorf("Expected 2 cached children, got %d\n", count)
+	}
+	if nlink := item.NLink(); nlink != 3 {
+		t.Errorf("Expected a link count of 3, got %d\n", nlink)
+	}
+
+	if request := childrenRequest("id", 500); request != "/me/drive/items/id/children?$top=500" {
+		t.Error("Large folders should be fetched in fewer pages:", request)
+	}
+	if request := childrenRequest("id", 5000); request != "/me/drive/items/id/children?$top=999" {
+		t.Error("Page size should be capped:", request)
+	}
+	if request := childrenRequest("id", 12); request != ChildrenPathID("id") {
+		t.Error("Small folders should use the default page size:", request)
+	}
+}
+
+// the state xattr should follow a file through downloads and uploads
+func TestStateXAttr(t *testing.T) {
+	item := &DriveItem{mutex: &mu.RWMutex{}}
+	failOnErr(t, json.Unmarshal([]byte(`{
+		"id": "ABC!123",
+		"name": "report.docx",
+		"file": {}
+	}`), item))
+
+	content := []byte("report")
+	steps := []struct {
+		change   func()
+		expected string
+	}{
+		{func() {}, StatusCloud},
+		{func() { item.hydrating = true }, StatusHydrating},
+		{func() { item.hydrating, item.data = false, &content }, StatusSynced},
+		{func() { item.hasChanges = true }, StatusDirty},
+		{func() { item.hasChanges, item.uploading = false, true }, StatusUploading},
+		{func() { item.uploading, item.uploadFailed = false, true }, StatusError},
+		{func() { item.uploadFailed = false }, StatusSynced},
+	}
+	for i, step := range steps {
+		step.change()
+		if state := item.xattrs()["state"]; state != step.expected {
+			t.Errorf("Step %d: expected state %q, got %q\n", i, step.expected, state)
+		}
+	}
+
+	folder := NewDriveItem("new folder", fuse.S_IFDIR|0755, nil)
+	if state := folder.xattrs()["state"]; state != StatusDirty {
+		t.Errorf("A folder not yet on the server should be dirty, not %q\n", state)
+	}
+}
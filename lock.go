@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the contents of a lock file, used to tell the user who holds the lock
+type lockOwner struct {
+	PID        int    `json:"pid"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+// acquireLock makes sure that only one onedriver instance uses a cache directory
+// at a time. The lock is an flock() on a file in the cache directory, so the
+// kernel releases it if we crash. The file records the owner's PID and
+// mountpoint so we can produce a helpful error message. If force is set, a lock
+// held by another process is ignored (for recovering from a hung instance). The
+// returned file must be kept open for as long as the lock is needed.
+func acquireLock(cacheDir string, mountpoint string, force bool) (*os.File, error) {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(cacheDir, "onedriver.lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		var owner lockOwner
+		contents, _ := ioutil.ReadAll(file)
+		json.Unmarshal(contents, &owner)
+		if !force {
+			file.Close()
+			if owner.PID == 0 {
+				return nil, fmt.Errorf("cache directory %s is locked by another "+
+					"onedriver process", cacheDir)
+			}
+			return nil, fmt.Errorf("already mounted at %s by PID %d "+
+				"(cache directory %s is in use). "+
+				"Use --force if that process is hung and you are sure it is not "+
+				"using this cache anymore", owner.Mountpoint, owner.PID, cacheDir)
+		}
+		log.WithFields(log.Fields{
+			"pid":        owner.PID,
+			"mountpoint": owner.Mountpoint,
+			"path":       path,
+		}).Warn("Ignoring lock held by another process, --force was specified.")
+	}
+
+	abs, _ := filepath.Abs(mountpoint)
+	contents, _ := json.Marshal(lockOwner{PID: os.Getpid(), Mountpoint: abs})
+	file.Truncate(0)
+	file.WriteAt(contents, 0)
+	file.Sync()
+	return file, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// only one instance should be able to lock a cache directory at a time, unless
+// forced, and the lock should be free again once closed
+func TestAcquireLock(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "onedriver_lock")
+	defer os.RemoveAll(dir)
+
+	lock, err := acquireLock(dir, "/mnt/first", false)
+	if err != nil {
+		t.Fatal("Could not acquire lock:", err)
+	}
+	_, err = acquireLock(dir, "/mnt/second", false)
+	if err == nil {
+		t.Fatal("Lock was acquired twice.")
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("/mnt/first by PID %d", os.Getpid())) {
+		t.Fatal("Error did not say who holds the lock:", err)
+	}
+
+	forced, err := acquireLock(dir, "/mnt/second", true)
+	if err != nil {
+		t.Fatal("Could not force the lock:", err)
+	}
+	forced.Close()
+	lock.Close()
+
+	lock, err = acquireLock(dir, "/mnt/third", false)
+	if err != nil {
+		t.Fatal("Could not acquire lock after it was released:", err)
+	}
+	lock.Close()
+}
@@ -0,0 +1,223 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log/syslog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// journalSocket is where systemd-journald takes entries in its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// identifier is what entries are tagged with in the journal and syslog
+const identifier = "onedriver"
+
+// journalPriority maps logrus levels to syslog priorities, which the journal
+// uses as well
+var journalPriority = map[log.Level]int{
+	log.PanicLevel: 2, // crit
+	log.FatalLevel: 2,
+	log.ErrorLevel: 3, // err
+	log.WarnLevel:  4, // warning
+	log.InfoLevel:  6, // info
+	log.DebugLevel: 7, // debug
+	log.TraceLevel: 7,
+}
+
+// journalHook sends every entry to the systemd journal, with its fields as
+// journal fields, so "journalctl -p err" or "journalctl PATH=/Documents" work
+type journalHook struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+func newJournalHook() (*journalHook, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	addr := &net.UnixAddr{Name: journalSocket, Net: "unixgram"}
+	// make sure the journal is actually there, instead of finding out on the
+	// first entry
+	if _, err := os.Stat(journalSocket); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &journalHook{conn: conn, addr: addr}, nil
+}
+
+func (h *journalHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// journalField turns a logrus field name into a valid journal field name: upper
+// case letters, digits and underscores, not starting with an underscore (those
+// are reserved for fields set by the journal itself)
+func journalField(name string) string {
+	field := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	field = strings.TrimLeft(field, "_0123456789")
+	if field == "" {
+		return "FIELD"
+	}
+	return field
+}
+
+// writeJournalField appends a field in the journal's native format. Values
+// with newlines in them need the binary form, prefixed with their length.
+func writeJournalField(buf *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalEntry formats an entry in the journal's native protocol
+func journalEntry(entry *log.Entry) []byte {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", strings.TrimSuffix(entry.Message, "\n"))
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(journalPriority[entry.Level]))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", identifier)
+	if entry.HasCaller() {
+		writeJournalField(&buf, "CODE_FILE", entry.Caller.File)
+		writeJournalField(&buf, "CODE_LINE", strconv.Itoa(entry.Caller.Line))
+		writeJournalField(&buf, "CODE_FUNC", entry.Caller.Function)
+	}
+	names := make([]string, 0, len(entry.Data))
+	for name := range entry.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeJournalField(&buf, journalField(name), fmt.Sprint(entry.Data[name]))
+	}
+	return buf.Bytes()
+}
+
+func (h *journalHook) Fire(entry *log.Entry) error {
+	if !enabled(entry) {
+		return nil
+	}
+	data := journalEntry(redact(entry))
+	_, err := h.conn.WriteToUnix(data, h.addr)
+	if err == nil || !isTooLarge(err) {
+		return err
+	}
+	// too big for a datagram (goroutine dumps, usually), journald takes those
+	// as a file descriptor instead
+	file, err := ioutil.TempFile("/dev/shm", "onedriver-journal")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	_, _, err = h.conn.WriteMsgUnix(nil, syscall.UnixRights(int(file.Fd())), h.addr)
+	return err
+}
+
+// isTooLarge returns whether sending a datagram failed because of its size
+func isTooLarge(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.EMSGSIZE || sysErr.Err == syscall.ENOBUFS
+		}
+	}
+	return false
+}
+
+// syslogHook sends every entry to the system logger
+type syslogHook struct {
+	writer    *syslog.Writer
+	formatter log.Formatter
+}
+
+func newSyslogHook() (*syslogHook, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, identifier)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHook{
+		writer: writer,
+		// syslog adds its own timestamps
+		formatter: &log.TextFormatter{DisableTimestamp: true, DisableColors: true},
+	}, nil
+}
+
+func (h *syslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *syslogHook) Fire(entry *log.Entry) error {
+	if !enabled(entry) {
+		return nil
+	}
+	line, err := h.formatter.Format(redact(entry))
+	if err != nil {
+		return err
+	}
+	message := strings.TrimSuffix(string(line), "\n")
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return h.writer.Crit(message)
+	case log.ErrorLevel:
+		return h.writer.Err(message)
+	case log.WarnLevel:
+		return h.writer.Warning(message)
+	case log.InfoLevel:
+		return h.writer.Info(message)
+	default:
+		return h.writer.Debug(message)
+	}
+}
+
+// Backends are the places logs can be sent to with UseBackend().
+var Backends = []string{"stderr", "journald", "syslog"}
+
+// UseBackend sends all logs to the systemd journal ("journald"), the system
+// logger ("syslog") or stderr ("stderr", the default) from now on.
+func UseBackend(backend string) error {
+	var hook log.Hook
+	var err error
+	switch backend {
+	case "", "stderr":
+		return nil
+	case "journald":
+		hook, err = newJournalHook()
+	case "syslog":
+		hook, err = newSyslogHook()
+	default:
+		return fmt.Errorf("unknown log backend %q, must be one of: %s",
+			backend, strings.Join(Backends, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("could not log to %s: %v", backend, err)
+	}
+	log.AddHook(hook)
+	log.SetOutput(ioutil.Discard)
+	return nil
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestJournalField(t *testing.T) {
+	tests := map[string]string{
+		"path":      "PATH",
+		"calledBy":  "CALLEDBY",
+		"old-path":  "OLD_PATH",
+		"_internal": "INTERNAL",
+		"...":       "FIELD",
+	}
+	for name, expected := range tests {
+		if field := journalField(name); field != expected {
+			t.Errorf("Field %q became %q, expected %q\n", name, field, expected)
+		}
+	}
+}
+
+// entries should carry their priority, location and fields, with multi-line
+// values in the binary format
+func TestJournalEntry(t *testing.T) {
+	logger := log.New()
+	logger.SetReportCaller(true)
+	entry := &log.Entry{
+		Logger:  logger,
+		Level:   log.WarnLevel,
+		Message: "Upload failed.",
+		Data:    log.Fields{"path": "/Documents/a.txt", "err": "first\nsecond"},
+		Caller:  &runtime.Frame{File: "/src/graph/upload.go", Line: 42, Function: "Upload"},
+	}
+	data := journalEntry(entry)
+
+	for _, line := range []string{
+		"MESSAGE=Upload failed.\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=onedriver\n",
+		"CODE_FILE=/src/graph/upload.go\n",
+		"CODE_LINE=42\n",
+		"PATH=/Documents/a.txt\n",
+	} {
+		if !bytes.Contains(data, []byte(line)) {
+			t.Errorf("Entry is missing %q:\n%s\n", line, data)
+		}
+	}
+	var binaryField bytes.Buffer
+	binaryField.WriteString("ERR\n")
+	binary.Write(&binaryField, binary.LittleEndian, uint64(len("first\nsecond")))
+	binaryField.WriteString("first\nsecond\n")
+	if !bytes.Contains(data, binaryField.Bytes()) {
+		t.Errorf("Multi-line field was not in the binary format:\n%q\n", data)
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if UseBackend("carrier-pigeon") == nil {
+		t.Fatal("An unknown backend should be refused.")
+	}
+	if err := UseBackend("stderr"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// moduleFiles are the parts of onedriver that can log at their own level, and
+// the source files of the graph package that make up each of them. Anything
+// logged from elsewhere uses the global level.
+var moduleFiles = map[string][]string{
+	"fuse": {"fusefs.go", "fuse_handlers.go", "drive_item.go", "regions.go",
+		"xattr.go", "virtual.go", "officelinks.go"},
+	"graph": {"graph.go", "batch.go", "oauth2.go", "drives.go", "sites.go",
+		"search.go", "sharing.go", "versions.go", "thumbnails.go", "throttle.go",
+		"clock.go", "replay.go", "faults.go"},
+	"cache": {"cache.go", "delta.go", "names.go", "listed.go", "content.go",
+		"manifest.go", "persist.go", "prefetch.go", "selective.go", "ignore.go",
+		"conflict.go", "special.go", "recent.go", "shortcuts.go", "hashes.go",
+		"hashing.go", "startup.go"},
+	"upload": {"upload.go", "transfer.go", "retry.go", "undo.go", "recyclebin.go",
+		"quota.go"},
+}
+
+// fileModules maps source files back to the module they belong to
+var fileModules = func() map[string]string {
+	modules := make(map[string]string)
+	for module, files := range moduleFiles {
+		for _, file := range files {
+			modules[file] = module
+		}
+	}
+	return modules
+}()
+
+// levels holds the log level of each module that doesn't use the global one
+var levels = struct {
+	sync.RWMutex
+	global  log.Level
+	modules map[string]log.Level
+}{global: log.InfoLevel}
+
+// Modules returns the names of the modules that can be given their own log
+// level.
+func Modules() []string {
+	names := make([]string, 0, len(moduleFiles))
+	for name := range moduleFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetLevels sets the global log level and overrides it for the modules in
+// modules (module name to level name, see Modules()). Logrus is set to the most
+// verbose of them, ModuleFormatter() drops what a module shouldn't log.
+func SetLevels(global log.Level, modules map[string]string) error {
+	parsed := make(map[string]log.Level)
+	max := global
+	for module, level := range modules {
+		if _, exists := moduleFiles[module]; !exists {
+			return fmt.Errorf("unknown log module %q, must be one of: %s",
+				module, strings.Join(Modules(), ", "))
+		}
+		parsed[module] = StringToLevel(level)
+		if parsed[module] > max {
+			max = parsed[module]
+		}
+	}
+	levels.Lock()
+	levels.global = global
+	levels.modules = parsed
+	levels.Unlock()
+	log.SetLevel(max)
+	return nil
+}
+
+// GlobalLevel returns the log level of everything not in a module with a level
+// of its own.
+func GlobalLevel() log.Level {
+	levels.RLock()
+	defer levels.RUnlock()
+	return levels.global
+}
+
+// enabled returns whether an entry should be logged at the level of the module
+// it came from
+func enabled(entry *log.Entry) bool {
+	levels.RLock()
+	defer levels.RUnlock()
+	level := levels.global
+	if entry.HasCaller() {
+		if module, ok := fileModules[filepath.Base(entry.Caller.File)]; ok {
+			if moduleLevel, ok := levels.modules[module]; ok {
+				level = moduleLevel
+			}
+		}
+	}
+	return entry.Level <= level
+}
+
+// moduleFormatter filters out entries below the level of their module before
+// formatting the rest, without any secrets in them
+type moduleFormatter struct {
+	log.Formatter
+}
+
+func (f moduleFormatter) Format(entry *log.Entry) ([]byte, error) {
+	if !enabled(entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(redact(entry))
+}
+
+// ModuleFormatter wraps formatter so that every module logs at its own level
+// (see SetLevels()), and tokens are redacted (see SetPrivacy() for file names).
+// Needs log.SetReportCaller(true) to know where entries come from.
+func ModuleFormatter(formatter log.Formatter) log.Formatter {
+	return moduleFormatter{formatter}
+}
@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"runtime"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// each module should log at its own level, and everything else at the global one
+func TestModuleLevels(t *testing.T) {
+	defer SetLevels(log.InfoLevel, nil)
+	err := SetLevels(log.DebugLevel, map[string]string{"graph": "trace", "fuse": "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.GetLevel() != log.TraceLevel {
+		t.Fatalf("Logrus should log at the most verbose level, not %s\n", log.GetLevel())
+	}
+
+	logger := log.New()
+	logger.SetReportCaller(true)
+	tests := []struct {
+		file    string
+		level   log.Level
+		enabled bool
+	}{
+		{"/src/onedriver/graph/graph.go", log.TraceLevel, true},
+		{"/src/onedriver/graph/fusefs.go", log.InfoLevel, false},
+		{"/src/onedriver/graph/fusefs.go", log.WarnLevel, true},
+		{"/src/onedriver/graph/cache.go", log.DebugLevel, true},
+		{"/src/onedriver/graph/cache.go", log.TraceLevel, false},
+		{"/src/onedriver/main.go", log.TraceLevel, false},
+	}
+	for _, test := range tests {
+		entry := &log.Entry{
+			Logger: logger,
+			Level:  test.level,
+			Caller: &runtime.Frame{File: test.file},
+		}
+		if enabled(entry) != test.enabled {
+			t.Errorf("%s at %s: expected enabled to be %v\n", test.file, test.level, test.enabled)
+		}
+	}
+
+	if SetLevels(log.InfoLevel, map[string]string{"nope": "trace"}) == nil {
+		t.Fatal("An unknown module should be refused.")
+	}
+}
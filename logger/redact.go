@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// secrets are patterns of credentials that must never end up in a log, and what
+// they are replaced with
+var secrets = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)((?:tempauth|access_token|refresh_token|code|client_secret)=)[^&"\s]+`),
+		"${1}REDACTED"},
+	{regexp.MustCompile(`("(?:access_token|refresh_token|id_token|client_secret)"\s*:\s*")[^"]*`),
+		"${1}REDACTED"},
+}
+
+// RedactSecrets removes access tokens, refresh tokens and the like from s.
+func RedactSecrets(s string) string {
+	for _, secret := range secrets {
+		s = secret.pattern.ReplaceAllString(s, secret.replacement)
+	}
+	return s
+}
+
+// pathFields are the fields that hold paths or names of files, which privacy
+// mode hashes
+var pathFields = map[string]bool{
+	"path": true, "name": true, "dest": true, "dir": true, "root": true,
+	"file": true, "mountpoint": true, "copy": true, "query": true,
+}
+
+// paths inside other fields: paths in Graph resources ("/me/drive/root:/a/b:")
+// and absolute paths in error messages
+var (
+	resourcePath = regexp.MustCompile(`(root:)(/[^:?]*)`)
+	loosePath    = regexp.MustCompile(`(^|[\s"'(=])(/[^\s"':)]+)`)
+)
+
+var privacy = struct {
+	sync.RWMutex
+	enabled bool
+	salt    []byte
+}{}
+
+// SetPrivacy turns privacy mode on or off. In privacy mode, file names and paths
+// are replaced by hashes in logs, with each part of a path hashed separately so
+// it's still visible which files are in the same folder. The hashes are salted
+// with a random value that changes on every start, so they can't be reversed
+// by hashing likely names.
+func SetPrivacy(enabled bool) {
+	privacy.Lock()
+	defer privacy.Unlock()
+	privacy.enabled = enabled
+	if enabled && privacy.salt == nil {
+		privacy.salt = make([]byte, 16)
+		rand.Read(privacy.salt)
+	}
+}
+
+// hashName replaces a file name by its salted hash. The mutex must be held.
+func hashName(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return name
+	}
+	sum := sha256.Sum256(append(append([]byte{}, privacy.salt...), name...))
+	return hex.EncodeToString(sum[:4])
+}
+
+// hashPath hashes every part of a path. The mutex must be held.
+func hashPath(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		parts[i] = hashName(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// hashEmbedded hashes the paths inside free-form text. The mutex must be held.
+func hashEmbedded(s string) string {
+	s = resourcePath.ReplaceAllStringFunc(s, func(match string) string {
+		parts := resourcePath.FindStringSubmatch(match)
+		return parts[1] + hashPath(parts[2])
+	})
+	return loosePath.ReplaceAllStringFunc(s, func(match string) string {
+		parts := loosePath.FindStringSubmatch(match)
+		hashed := hashPath(parts[2])
+		if strings.HasPrefix(parts[2], "/me/") || strings.HasPrefix(parts[2], "/drives/") {
+			// a Graph resource, any paths were in the "root:" part
+			hashed = parts[2]
+		}
+		return parts[1] + hashed
+	})
+}
+
+// redact returns a copy of entry without any secrets in it, and with names and
+// paths hashed in privacy mode
+func redact(entry *log.Entry) *log.Entry {
+	privacy.RLock()
+	defer privacy.RUnlock()
+	redacted := *entry
+	redacted.Message = RedactSecrets(entry.Message)
+	if privacy.enabled {
+		redacted.Message = hashEmbedded(redacted.Message)
+	}
+	redacted.Data = make(log.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			value = RedactSecrets(v)
+		case error:
+			value = RedactSecrets(v.Error())
+		case []byte:
+			value = RedactSecrets(string(v))
+		case fmt.Stringer:
+			value = RedactSecrets(v.String())
+		}
+		if s, ok := value.(string); ok && privacy.enabled {
+			if pathFields[key] {
+				value = hashPath(s)
+			} else {
+				value = hashEmbedded(s)
+			}
+		}
+		redacted.Data[key] = value
+	}
+	return &redacted
+}
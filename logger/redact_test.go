@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	tests := map[string]string{
+		"Authorization: bearer EwBwA8l6BAAU.x-y":          "Authorization: bearer REDACTED",
+		"client_id=abc&refresh_token=M.R3_BAY&grant=x":    "client_id=abc&refresh_token=REDACTED&grant=x",
+		`{"access_token":"EwBwA8l6","refresh_token":"M"}`: `{"access_token":"REDACTED","refresh_token":"REDACTED"}`,
+		"nothing to see here":                             "nothing to see here",
+	}
+	for in, expected := range tests {
+		if redacted := RedactSecrets(in); redacted != expected {
+			t.Errorf("Redacting %q gave %q, expected %q\n", in, redacted, expected)
+		}
+	}
+}
+
+// tokens should always be redacted, names and paths only in privacy mode
+func TestRedactEntry(t *testing.T) {
+	defer SetPrivacy(false)
+	entry := &log.Entry{
+		Message: "Response: {\"access_token\":\"secret\"}",
+		Data: log.Fields{
+			"path": "/Documents/Taxes 2019.pdf",
+			"err":  errors.New("open /home/me/.cache/Taxes 2019.pdf: no such file"),
+			"url":  "/me/drive/root:/Documents/Taxes.pdf:/content",
+			"id":   "01BYE5RZ",
+		},
+	}
+
+	SetPrivacy(false)
+	redacted := redact(entry)
+	if strings.Contains(redacted.Message, "secret") {
+		t.Fatalf("Token was not redacted: %s\n", redacted.Message)
+	}
+	if redacted.Data["path"] != "/Documents/Taxes 2019.pdf" {
+		t.Fatalf("Path was changed outside of privacy mode: %v\n", redacted.Data["path"])
+	}
+
+	SetPrivacy(true)
+	redacted = redact(entry)
+	for key, value := range redacted.Data {
+		if strings.Contains(value.(string), "Taxes") || strings.Contains(value.(string), "Documents") {
+			t.Errorf("Name leaked in field %s: %s\n", key, value)
+		}
+	}
+	path := redacted.Data["path"].(string)
+	if strings.Count(path, "/") != 2 || !strings.HasPrefix(path, "/") {
+		t.Errorf("Hashed path lost its structure: %s\n", path)
+	}
+	if !strings.HasPrefix(redacted.Data["url"].(string), "/me/drive/root:/") ||
+		!strings.HasSuffix(redacted.Data["url"].(string), ":/content") {
+		t.Errorf("Hashed resource lost its structure: %s\n", redacted.Data["url"])
+	}
+	if redacted.Data["id"] != "01BYE5RZ" {
+		t.Error("IDs should be left alone.")
+	}
+	if again := redact(entry); again.Data["path"] != path {
+		t.Error("The same path should always hash to the same value.")
+	}
+	if entry.Data["path"] != "/Documents/Taxes 2019.pdf" {
+		t.Error("Redacting changed the original entry.")
+	}
+}
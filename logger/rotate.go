@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated once it grows past a maximum size:
+// the log is renamed to <path>.1, <path>.1 to <path>.2 and so on, with only the
+// newest few old logs kept. Long-running mounts at trace level would otherwise
+// fill up the disk without anyone noticing.
+type RotatingFile struct {
+	path     string
+	maxSize  int64 // bytes, 0 for no limit
+	maxFiles int   // old logs to keep
+	mutex    sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+// OpenRotatingFile opens the log file at path for appending, rotating it once
+// it gets bigger than maxSize bytes and keeping maxFiles old logs around.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open (re)opens the log file. The mutex must be held, if needed.
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotatedPath is where the nth old log is kept
+func (r *RotatingFile) rotatedPath(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+// rotate moves the current log out of the way and starts a new one. The mutex
+// must be held.
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	os.Remove(r.rotatedPath(r.maxFiles))
+	for n := r.maxFiles - 1; n > 0; n-- {
+		os.Rename(r.rotatedPath(n), r.rotatedPath(n+1))
+	}
+	if r.maxFiles > 0 {
+		os.Rename(r.path, r.rotatedPath(1))
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// Write appends p to the log, rotating it first if p would take it over the
+// maximum size. Entries are never split across files.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// nowhere left to write to, complain where someone might see it
+			fmt.Fprintln(os.Stderr, "Could not rotate log file:", err)
+			r.file = nil
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file, writes afterwards fail.
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
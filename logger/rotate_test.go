@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logs should be rotated once they get too big, with only the newest kept
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onedriver_logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "onedriver.log")
+
+	out, err := OpenRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := strings.Repeat("x", 59) + "\n"
+	for i := 0; i < 5; i++ {
+		if _, err := out.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out.Close()
+
+	for _, name := range []string{"onedriver.log", "onedriver.log.1", "onedriver.log.2"} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != entry {
+			t.Fatalf("%s should hold exactly one entry, got %d bytes\n", name, len(content))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Fatal("More old logs were kept than asked for.")
+	}
+
+	// reopening appends instead of starting over
+	out, err = OpenRotatingFile(path, 1000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.Write([]byte(entry))
+	out.Close()
+	if content, _ := ioutil.ReadFile(path); string(content) != entry+entry {
+		t.Fatal("Reopened log file was not appended to.")
+	}
+}
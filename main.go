@@ -2,12 +2,15 @@ package main
 
 import (
 	"fmt"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"sort"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
+	"github.com/jstaf/onedriver/config"
 	"github.com/jstaf/onedriver/graph"
 	"github.com/jstaf/onedriver/logger"
 	log "github.com/sirupsen/logrus"
@@ -24,9 +27,41 @@ specified mountpoint. Note that this is not a sync client - files are fetched
 on-demand and cached locally. Only files you actually use will be downloaded.
 
 Usage: onedriver [options] <mountpoint>
+       onedriver [options] mount <profile>
+       onedriver [options] mount-all <mountpoint>
+       onedriver [options] import-rclone <rclone remote> <mountpoint>
+       onedriver <command> [path]
+       onedriver <remote command> [remote path]
 
-Valid options:
+Commands (operate on the mounted filesystem containing path):
 `)
+	names := make([]string, 0, len(controlCommands))
+	width := 0
+	for name := range controlCommands {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-*s  %s\n", width, name, controlCommands[name].help)
+	}
+
+	fmt.Printf("\nCommands (talk to the server directly, no mount required):\n")
+	names = names[:0]
+	width = 0
+	for name, cmd := range remoteCommands {
+		names = append(names, name)
+		if len(cmd.usage) > width {
+			width = len(cmd.usage)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-*s  %s\n", width, remoteCommands[name].usage, remoteCommands[name].help)
+	}
+	fmt.Printf("\nValid options:\n")
 	flag.PrintDefaults()
 }
 
@@ -36,8 +71,32 @@ func main() {
 		"Authenticate to Onedrive and then exit. Useful for running tests.")
 	logLevel := flag.String("log", "debug", "Set logging level/verbosity. "+
 		"Can be one of: fatal, error, warn, info, trace")
+	logFile := flag.String("log-file", "", "Log to this file instead of stderr. "+
+		"It is rotated once it gets too big (see logMaxSize in the README).")
+	logBackend := flag.String("log-backend", "", "Where to send logs: stderr "+
+		"(the default), journald or syslog.")
+	logPrivacy := flag.Bool("log-privacy", false, "Hash file names and paths in "+
+		"logs, so they can be shared without giving away what's in your OneDrive.")
+	configPath := flag.StringP("config", "c", config.DefaultPath(),
+		"Path to a config file. Send SIGHUP to reload it while mounted.")
 	version := flag.BoolP("version", "v", false, "Display program version.")
 	debugOn := flag.BoolP("debug", "d", false, "Enable FUSE debug logging.")
+	noNotify := flag.Bool("no-notify", false,
+		"Don't show desktop notifications when files fail to upload.")
+	cacheDir := flag.String("cache-dir", "", "Directory to store local state in. "+
+		"Defaults to a directory under ~/.cache/onedriver/ unique to the mountpoint.")
+	force := flag.Bool("force", false, "Mount even if another onedriver instance "+
+		"holds the lock on the cache directory (only use if that instance is hung).")
+	dryRun := flag.Bool("dry-run", false, "Accept and cache all local changes, "+
+		"but never send them to the server. Changes that would have been made are logged.")
+	pprofPort := flag.Int("pprof", 0, "Serve profiling data on "+
+		"localhost:<port>/debug/pprof/ (for debugging hangs and memory use).")
+	record := flag.String("record", "", "Record all traffic with the server to "+
+		"this file (tokens and email addresses are scrubbed), to help reproduce bugs.")
+	faults := flag.String("inject-faults", "", "Make requests to the server fail "+
+		"at random, for testing. Takes rates like \"timeout=0.05,429=0.1,5xx=0.1,truncate=0.02\".")
+	showErrors := flag.Bool("errors", false, "With the status command, list the "+
+		"most recent sync errors instead of a summary.")
 	flag.BoolP("help", "h", false, "Display usage and help.")
 	flag.Usage = usage
 	flag.Parse()
@@ -53,11 +112,113 @@ func main() {
 		os.Exit(0)
 	}
 
-	log.SetLevel(logger.StringToLevel(*logLevel))
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path": *configPath,
+			"err":  err,
+		}).Fatal("Could not load config file.")
+	}
+	if conf.LogLevel != "" && !flag.CommandLine.Changed("log") {
+		*logLevel = conf.LogLevel
+	}
 	log.SetReportCaller(true)
-	log.SetFormatter(logger.LogrusFormatter())
+	log.SetFormatter(logger.ModuleFormatter(logger.LogrusFormatter()))
+	logger.SetPrivacy(*logPrivacy || conf.LogPrivacy)
+	if err := logger.SetLevels(logger.StringToLevel(*logLevel), conf.LogModules); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, ok := controlCommands[flag.Arg(0)]; ok {
+		name := flag.Arg(0)
+		if name == "status" && *showErrors {
+			name = "errors"
+		}
+		os.Exit(runControlCommand(name, flag.Args()[1:]))
+	}
+	if _, ok := remoteCommands[flag.Arg(0)]; ok {
+		os.Exit(runRemoteCommand(flag.Arg(0), flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "import-rclone" {
+		os.Exit(importRclone(*configPath, flag.Args()[1:]))
+	}
 
-	if len(flag.Args()) != 1 {
+	// commands report to the terminal, only mounts log elsewhere
+	if *logFile == "" {
+		*logFile = conf.LogFile
+	}
+	if *logBackend == "" {
+		*logBackend = conf.LogBackend
+	}
+	if *logFile != "" && *logBackend != "" && *logBackend != "stderr" {
+		log.Fatal("A log file can't be combined with the ", *logBackend, " log backend.")
+	}
+	if *logFile != "" {
+		out, err := logger.OpenRotatingFile(*logFile,
+			int64(conf.LogMaxSize)*1024*1024, conf.LogMaxFiles)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path": *logFile,
+				"err":  err,
+			}).Fatal("Could not open log file.")
+		}
+		defer out.Close()
+		log.SetOutput(out)
+	}
+	if err := logger.UseBackend(*logBackend); err != nil {
+		log.Fatal(err)
+	}
+
+	// either "onedriver <mountpoint>" with the top-level settings from the config,
+	// or "onedriver mount <profile>" to use one of its profiles, or
+	// "onedriver mount-all <mountpoint>" to mount all of them at once
+	flags := mountFlags{
+		force:      *force,
+		dryRun:     *dryRun,
+		debug:      *debugOn,
+		notify:     !*noNotify,
+		configPath: *configPath,
+	}
+	if flag.CommandLine.Changed("log") {
+		flags.logLevel = *logLevel
+	}
+	if *record != "" {
+		graph.RecordTraffic(*record)
+	}
+	if *faults != "" {
+		if err := graph.InjectFaults(*faults); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if flag.Arg(0) == "mount-all" && len(flag.Args()) == 2 {
+		log.Info("onedriver v", onedriverVersion)
+		startPprof(*pprofPort)
+		mountAll(conf, flag.Arg(1), flags)
+		return
+	}
+	mountpoint := flag.Arg(0)
+	opts := &conf.Options
+	var profileName, account, drive string
+	root := "/"
+	if flag.Arg(0) == "mount" && len(flag.Args()) == 2 {
+		profileName = flag.Arg(1)
+		profile, err := conf.Profile(profileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mountpoint, root, account, drive, opts = profile.Mountpoint, profile.Root,
+			profile.Account, profile.Drive, &profile.Options
+		if *cacheDir == "" {
+			*cacheDir = profile.CacheDir
+		}
+		level := *logLevel
+		if opts.LogLevel != "" && !flag.CommandLine.Changed("log") {
+			level = opts.LogLevel
+		}
+		if err := logger.SetLevels(logger.StringToLevel(level), opts.LogModules); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(flag.Args()) != 1 {
 		// no mountpoint provided
 		flag.Usage()
 		os.Exit(1)
@@ -65,21 +226,50 @@ func main() {
 
 	log.Info("onedriver v", onedriverVersion)
 
+	if *cacheDir == "" {
+		*cacheDir = graph.DefaultCacheDir(mountpoint)
+	}
+	lock, err := acquireLock(*cacheDir, mountpoint, *force)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Close() // also keeps the lock from being garbage collected
+	startPprof(*pprofPort)
+
 	// setup filesystem
-	fs := pathfs.NewPathNodeFs(graph.NewFS(), nil)
-	server, _, err := nodefs.MountRoot(flag.Arg(0), fs.Root(), nil)
+	auth := accounts{}.auth(account)
+	if drive != "" {
+		auth = auth.ForDrive(drive)
+	}
+	fuseFs := setupFs(auth, root, opts, *cacheDir, *dryRun)
+	fs := pathfs.NewPathNodeFs(fuseFs, nil)
+	server, _, err := nodefs.MountRoot(mountpoint, fs.Root(), nil)
 	if err != nil {
 		log.Error(err)
 		log.Fatalf("Mount failed. Is the mountpoint already in use? "+
-			"(Try running \"fusermount -u %s\")\n", flag.Arg(0))
+			"(Try running \"fusermount -u %s\")\n", mountpoint)
 	}
 	server.SetDebug(*debugOn)
+	serveFs(fuseFs, mountpoint, flags.notify, true)
 
 	// setup sigint handler for graceful unmount on interrupt
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	go graph.UnmountHandler(sigChan, server)
+	go graph.UnmountHandler(sigChan, server, fuseFs)
+
+	// reload config on sighup
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go graph.ReloadHandler(hupChan, fuseFs, *configPath, profileName, flags.logLevel)
+
+	// dump internal state to the log on sigusr1
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+	go graph.DumpHandler(usr1Chan, fuseFs)
 
 	// serve filesystem
 	server.Serve()
+	// unmounted with fusermount -u, a signal is handled by UnmountHandler()
+	fuseFs.Drain()
+	fuseFs.Close()
 }
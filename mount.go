@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/jstaf/onedriver/config"
+	"github.com/jstaf/onedriver/graph"
+	log "github.com/sirupsen/logrus"
+)
+
+// command line flags that affect how filesystems are mounted
+type mountFlags struct {
+	force      bool
+	dryRun     bool
+	debug      bool
+	notify     bool
+	configPath string
+	logLevel   string // only set if --log was given
+}
+
+// accounts keeps track of the accounts that have been logged in to, so that
+// filesystems using the same account share its tokens
+type accounts map[string]*graph.Auth
+
+// auth logs in to an account, "" being the default one
+func (a accounts) auth(account string) *graph.Auth {
+	if auth, exists := a[account]; exists {
+		return auth
+	}
+	var auth *graph.Auth
+	if account != "" {
+		auth = graph.AuthenticateFile(config.AccountAuthPath(account))
+	} else {
+		auth = graph.Authenticate()
+	}
+	a[account] = auth
+	return auth
+}
+
+// setupFs creates a filesystem for the folder at root and applies its settings
+func setupFs(auth *graph.Auth, root string, opts *config.Options, cacheDir string, dryRun bool) *graph.FuseFs {
+	fuseFs := graph.NewFSCached(auth, root, cacheDir)
+	fuseFs.ApplyConfig(opts)
+	if err := fuseFs.UseCacheDir(cacheDir); err != nil {
+		log.WithFields(log.Fields{
+			"dir": cacheDir,
+			"err": err,
+		}).Warn("Could not set up cache directory, local state will only be kept in memory.")
+	}
+	fuseFs.SetDryRun(dryRun)
+	return fuseFs
+}
+
+// serveFs starts the control socket of a mounted filesystem, and optionally its
+// D-Bus interface and desktop notifications
+func serveFs(fuseFs *graph.FuseFs, mountpoint string, notify bool, dbus bool) {
+	if err := fuseFs.ServeControl(graph.ControlSocketPath(mountpoint)); err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("Could not start control socket.")
+	}
+	if dbus {
+		if err := fuseFs.ServeDBus(mountpoint); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warn("Could not export filesystem on D-Bus, desktop integration disabled.")
+		}
+	}
+	if notify {
+		if err := fuseFs.EnableNotifications(mountpoint); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Warn("Could not enable desktop notifications.")
+		}
+	}
+}
+
+// startPprof serves profiling data on a port, if it isn't 0
+func startPprof(port int) {
+	if port <= 0 {
+		return
+	}
+	// only ever listen on localhost, profiles leak plenty of private info
+	addr := fmt.Sprintf("localhost:%d", port)
+	log.WithFields(log.Fields{"addr": addr}).Info("Serving pprof endpoint.")
+	go func() {
+		if err := http.ListenAndServe(addr, nil); err != nil {
+			log.WithFields(log.Fields{
+				"addr": addr,
+				"err":  err,
+			}).Error("pprof endpoint failed.")
+		}
+	}()
+}
+
+// mountAll mounts every profile in the config under one mountpoint, each one in
+// a directory named after the profile. The profiles' own mountpoints are not
+// used.
+func mountAll(conf *config.Config, mountpoint string, flags mountFlags) {
+	names := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+			log.Fatalf("Profile name %q can't be used as a directory name.", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		log.Fatal("No profiles to mount, add some to the config file first.")
+	}
+	sort.Strings(names)
+
+	logins := accounts{}
+	filesystems := make(map[string]*graph.FuseFs)
+	all := make([]*graph.FuseFs, 0, len(names))
+	for _, name := range names {
+		profile, err := conf.Profile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		path := filepath.Join(mountpoint, name)
+		cacheDir := profile.CacheDir
+		if cacheDir == "" {
+			cacheDir = graph.DefaultCacheDir(path)
+		}
+		lock, err := acquireLock(cacheDir, path, flags.force)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer lock.Close()
+
+		auth := logins.auth(profile.Account)
+		if profile.Drive != "" {
+			auth = auth.ForDrive(profile.Drive)
+		}
+		fuseFs := setupFs(auth, profile.Root, &profile.Options, cacheDir, flags.dryRun)
+		filesystems[name] = fuseFs
+		all = append(all, fuseFs)
+	}
+
+	server, err := graph.MountAll(mountpoint, filesystems)
+	if err != nil {
+		log.Error(err)
+		log.Fatalf("Mount failed. Is the mountpoint already in use? "+
+			"(Try running \"fusermount -u %s\")\n", mountpoint)
+	}
+	server.SetDebug(flags.debug)
+	for _, name := range names {
+		// the D-Bus interface lives at a fixed object path, so it can only be
+		// exported for one filesystem per process
+		serveFs(filesystems[name], filepath.Join(mountpoint, name), flags.notify, false)
+
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go graph.ReloadHandler(hupChan, filesystems[name], flags.configPath, name, flags.logLevel)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go graph.UnmountHandler(sigChan, server, all...)
+
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+	go graph.DumpHandler(usr1Chan, all...)
+
+	server.Serve()
+	// unmounted with fusermount -u, a signal is handled by UnmountHandler()
+	for _, fuseFs := range all {
+		fuseFs.Drain()
+		fuseFs.Close()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jstaf/onedriver/config"
+	"github.com/jstaf/onedriver/graph"
+)
+
+// importRclone sets up a profile from one of rclone's OneDrive remotes: the
+// profile and its account are named after the remote, and mount the same drive.
+// Returns the exit code.
+func importRclone(configPath string, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: onedriver import-rclone <remote> <mountpoint>")
+		return 1
+	}
+	name, mountpoint := args[0], args[1]
+	rclonePath := config.DefaultRclonePath()
+	remote, err := config.LoadRclone(rclonePath, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if abs, err := filepath.Abs(mountpoint); err == nil {
+		mountpoint = abs
+	}
+
+	// the profile goes in first, so that nothing is left behind if it exists
+	profile := config.Profile{Mountpoint: mountpoint, Account: name, Drive: remote.DriveID}
+	if err = config.AddProfile(configPath, name, profile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Added profile %q to %s.\n", name, configPath)
+
+	if remote.ClientID == "" {
+		// rclone's own app registration, whose tokens can't be refreshed by
+		// anything but rclone
+		fmt.Printf("Remote %q uses rclone's built-in app registration, so its "+
+			"login can't be shared.\nYou will be asked to log in the first time "+
+			"you run \"onedriver mount %s\".\n", name, name)
+		return 0
+	}
+	_, err = graph.ImportToken(remote.Token, remote.ClientID, remote.ClientSecret,
+		config.AccountAuthPath(name))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not import login:", err)
+		return 1
+	}
+	fmt.Printf("Imported login, mount it with \"onedriver mount %s\".\n", name)
+	return 0
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jstaf/onedriver/graph"
+)
+
+// a command that talks to the server directly, no mounted filesystem required
+type remoteCommand struct {
+	usage string
+	help  string
+	run   func(auth *graph.Auth, args []string) error
+}
+
+var remoteCommands = map[string]remoteCommand{
+	"ls":       {"ls [remote path]", "List the contents of a folder on the server.", remoteLs},
+	"stat":     {"stat <remote path>", "Show an item's metadata on the server as JSON.", remoteStat},
+	"download": {"download <remote path> [local path]", "Copy a file or folder from the server.", remoteDownload},
+	"upload":   {"upload <local path> <remote path>", "Copy a file or folder to the server.", remoteUpload},
+	"search":   {"search <query>", "Find files and folders by name or content.", remoteSearch},
+	"special":  {"special [name]", "Show where special folders like the camera roll are.", remoteSpecial},
+	"drives":   {"drives", "List the drives you have access to, with their IDs.", remoteDrives},
+	"export": {"export [remote path] [json|csv]",
+		"Print every item under a folder with its size, hashes and ID.", remoteExport},
+}
+
+// remotePath cleans up a user-supplied path on the server
+func remotePath(path string) string {
+	return filepath.Clean("/" + strings.TrimPrefix(path, "/"))
+}
+
+// runRemoteCommand authenticates and runs a remote command. Returns the exit code.
+func runRemoteCommand(name string, args []string) int {
+	auth := graph.Authenticate()
+	if err := remoteCommands[name].run(auth, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// remoteLs prints one line per child of a folder: its size, modification time
+// and name. Folder names end with a "/" like "ls -p".
+func remoteLs(auth *graph.Auth, args []string) error {
+	path := "/"
+	if len(args) > 0 {
+		path = remotePath(args[0])
+	}
+	item, err := graph.GetItem(path, auth)
+	if err != nil {
+		return err
+	}
+	if !item.IsDir() {
+		printLsLine(item, item.Name())
+		return nil
+	}
+
+	children, err := graph.GetChildren(path, auth)
+	if err != nil {
+		return err
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(children[i].Name()) < strings.ToLower(children[j].Name())
+	})
+	for _, child := range children {
+		printLsLine(child, child.Name())
+	}
+	return nil
+}
+
+// printLsLine prints an item's size and modification time followed by name
+func printLsLine(item *graph.DriveItem, name string) {
+	size := fmt.Sprint(item.Size())
+	if item.IsDir() {
+		name += "/"
+		size = "-"
+	}
+	modified := time.Unix(int64(item.ModTime()), 0).Format("2006-01-02 15:04")
+	fmt.Printf("%12s  %s  %s\n", size, modified, name)
+}
+
+// remoteSearch prints the items matching a query like remoteLs, but with their
+// full paths
+func remoteSearch(auth *graph.Auth, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: onedriver search <query>")
+	}
+	items, err := graph.Search(strings.Join(args, " "), auth)
+	if err != nil {
+		return err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return strings.ToLower(items[i].Path()) < strings.ToLower(items[j].Path())
+	})
+	for _, item := range items {
+		printLsLine(item, item.Path())
+	}
+	return nil
+}
+
+// remoteSpecial prints the path of a special folder, or of all of them
+func remoteSpecial(auth *graph.Auth, args []string) error {
+	if len(args) > 0 {
+		item, err := graph.GetSpecialFolder(strings.ToLower(args[0]), auth)
+		if err != nil {
+			return err
+		}
+		fmt.Println(item.Path())
+		return nil
+	}
+	for _, name := range graph.SpecialFolders {
+		path := "-"
+		if item, err := graph.GetSpecialFolder(name, auth); err == nil {
+			path = item.Path()
+		}
+		fmt.Printf("%-12s%s\n", name, path)
+	}
+	return nil
+}
+
+// remoteDrives prints the ID, type and name of each drive the user can access
+func remoteDrives(auth *graph.Auth, args []string) error {
+	drives, err := graph.GetDrives(auth)
+	if err != nil {
+		return err
+	}
+	for _, drive := range drives {
+		fmt.Printf("%s  %-16s  %s\n", drive.ID, drive.DriveType, drive.Name)
+	}
+	return nil
+}
+
+// remoteExport prints a manifest of everything under a folder on the server
+func remoteExport(auth *graph.Auth, args []string) error {
+	path, format := "/", "json"
+	if len(args) > 0 {
+		path = remotePath(args[0])
+	}
+	if len(args) > 1 {
+		format = args[1]
+	}
+	manifest, err := graph.NewManifestWriter(os.Stdout, format)
+	if err != nil {
+		return err
+	}
+	err = graph.WalkRemote(path, auth, manifest.Write)
+	if flushErr := manifest.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// remoteItemInfo is what "onedriver stat" prints
+type remoteItemInfo struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Path       string    `json:"path"`
+	Type       string    `json:"type"` // file | folder
+	Size       uint64    `json:"size,omitempty"`
+	Modified   time.Time `json:"modified"`
+	MimeType   string    `json:"mimeType,omitempty"`
+	ChildCount uint32    `json:"childCount,omitempty"`
+}
+
+func remoteStat(auth *graph.Auth, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: onedriver stat <remote path>")
+	}
+	item, err := graph.GetItem(remotePath(args[0]), auth)
+	if err != nil {
+		return err
+	}
+
+	info := remoteItemInfo{
+		ID:       item.ID(),
+		Name:     item.Name(),
+		Path:     item.Path(),
+		Type:     "file",
+		Modified: time.Unix(int64(item.ModTime()), 0),
+		MimeType: item.MimeType(),
+	}
+	if item.IsDir() {
+		info.Type = "folder"
+		if item.Folder != nil {
+			info.ChildCount = item.Folder.ChildCount
+		}
+	} else {
+		info.Size = item.Size()
+	}
+	out, _ := json.MarshalIndent(info, "", "  ")
+	fmt.Println(string(out))
+	return nil
+}
+
+// remoteDownload copies a file or folder from the server. Like cp, if the local
+// path is an existing directory, the item is downloaded into it.
+func remoteDownload(auth *graph.Auth, args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: onedriver download <remote path> [local path]")
+	}
+	remote := remotePath(args[0])
+	local := filepath.Base(remote)
+	if len(args) > 1 {
+		local = args[1]
+		if st, err := os.Stat(local); err == nil && st.IsDir() {
+			local = filepath.Join(local, filepath.Base(remote))
+		}
+	}
+	return download(remote, local, auth)
+}
+
+func download(remote string, local string, auth *graph.Auth) error {
+	item, err := graph.GetItem(remote, auth)
+	if err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	if item.IsDir() {
+		if err = os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+		children, err := graph.GetChildren(remote, auth)
+		if err != nil {
+			return fmt.Errorf("%s: %s", remote, err)
+		}
+		for _, child := range children {
+			err = download(filepath.Join(remote, child.Name()),
+				filepath.Join(local, child.Name()), auth)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	item, content, err := graph.DownloadFile(remote, auth)
+	if err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	if err = ioutil.WriteFile(local, content, 0644); err != nil {
+		return err
+	}
+	modTime := time.Unix(int64(item.ModTime()), 0)
+	os.Chtimes(local, modTime, modTime)
+	fmt.Printf("%s -> %s\n", remote, local)
+	return nil
+}
+
+// remoteUpload copies a local file or folder to the server. Like cp, if the
+// remote path is an existing folder, the item is uploaded into it.
+func remoteUpload(auth *graph.Auth, args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: onedriver upload <local path> <remote path>")
+	}
+	local, remote := args[0], remotePath(args[1])
+	if item, err := graph.GetItem(remote, auth); err == nil && item.IsDir() {
+		remote = filepath.Join(remote, filepath.Base(local))
+	}
+	return upload(local, remote, auth)
+}
+
+func upload(local string, remote string, auth *graph.Auth) error {
+	st, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		if _, err = graph.CreateFolder(remote, auth); err != nil {
+			return fmt.Errorf("%s: %s", remote, err)
+		}
+		entries, err := ioutil.ReadDir(local)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = upload(filepath.Join(local, entry.Name()),
+				filepath.Join(remote, entry.Name()), auth)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(local)
+	if err != nil {
+		return err
+	}
+	if _, err = graph.UploadFile(remote, content, st.ModTime(), auth); err != nil {
+		return fmt.Errorf("%s: %s", remote, err)
+	}
+	fmt.Printf("%s -> %s\n", local, remote)
+	return nil
+}